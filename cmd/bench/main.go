@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"modbus-simulator/internal/bench"
+	"modbus-simulator/internal/capture"
+	"modbus-simulator/internal/repl"
+	"modbus-simulator/internal/scan"
+)
+
+func main() {
+	var (
+		target, mix, size, addresses, jsonPath, capturePath string
+		clients                                             int
+		unit                                                uint
+		requests                                            int64
+		rate                                                float64
+		duration, timeout, progress                         time.Duration
+	)
+	flag.StringVar(&target, "target", "tcp://127.0.0.1:1502", "server: tcp://host:port, rtu-over-tcp://host:port, rtu:///dev/ttyUSB0?baud=9600 or tls://host:802?cert=&key=&ca=")
+	flag.IntVar(&clients, "clients", 1, "concurrent masters, each with its own connection")
+	flag.DurationVar(&duration, "duration", 10*time.Second, "run time, 0 to stop after --requests only")
+	flag.Int64Var(&requests, "requests", 0, "stop after this many requests, 0 for no limit")
+	flag.Float64Var(&rate, "rate", 0, "requests per second of all clients together, 0 for as fast as possible")
+	flag.StringVar(&mix, "mix", "3", "function codes with weights, e.g. 3:70,4:20,16:10 or read_holding_registers:1")
+	flag.StringVar(&size, "size", "1", "quantity per request, or a range drawn from such as 1-125")
+	flag.StringVar(&addresses, "addresses", "0-124", "address range the request blocks fall in")
+	flag.UintVar(&unit, "unit", 1, "unit (slave) id")
+	flag.DurationVar(&timeout, "timeout", time.Second, "answer timeout")
+	flag.DurationVar(&progress, "progress", time.Second, "log running totals this often, 0 to disable")
+	flag.StringVar(&jsonPath, "json", "", "write the report as JSON to this file, - for stdout instead of the table")
+	flag.StringVar(&capturePath, "capture", "", "capture every frame to this file: .pcapng for Wireshark, else a text log")
+	flag.Parse()
+
+	server, err := repl.ParseTarget(strings.Fields(target))
+	if err != nil {
+		log.Fatal(err)
+	}
+	server.Timeout = timeout
+	if unit > 255 {
+		log.Fatalf("invalid unit id %d", unit)
+	}
+	opts := bench.Options{Clients: clients, Duration: duration, Requests: requests, Rate: rate, Unit: uint8(unit), Progress: progress}
+	if opts.Mix, err = bench.ParseMix(mix); err != nil {
+		log.Fatal(err)
+	}
+	if opts.MinSize, opts.MaxSize, err = bench.ParseSize(size); err != nil {
+		log.Fatal(err)
+	}
+	start, end, err := scan.ParseAddresses(addresses)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Start, opts.Span = start, int(end)-int(start)+1
+	if capturePath != "" {
+		w, err := capture.Create(capturePath, "")
+		if err != nil {
+			log.Fatalf("capture: %v", err)
+		}
+		defer w.Close()
+		opts.Tap = w.Tap
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	report, err := bench.Run(ctx, server, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jsonPath == "-" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+		if err == nil && jsonPath != "" {
+			var f *os.File
+			if f, err = os.Create(jsonPath); err == nil {
+				err = report.WriteJSON(f)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Fatalf("write report: %v", err)
+	}
+}
@@ -1,213 +1,229 @@
 package main
 
 import (
-	"encoding/binary"
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"math"
-	"net"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
 	"strings"
 	"time"
 
-	cfgpkg "modbus-simulator/internal/config"
+	"golang.org/x/term"
 
-	mb "github.com/goburrow/modbus"
+	"modbus-simulator/internal/capture"
+	"modbus-simulator/internal/repl"
 )
 
 func main() {
-	// Load config
-	var configPath string
-	flag.StringVar(&configPath, "config", "config.toml", "Path to configuration file")
+	var (
+		target, configPath, historyPath, capturePath, execLine string
+		unit                                                   uint
+		timeout                                                time.Duration
+	)
+	flag.StringVar(&target, "target", "", "connect on start: tcp://host:port, rtu-over-tcp://host:port, rtu:///dev/ttyUSB0?baud=9600 or tls://host:802?cert=&key=&ca=")
+	flag.StringVar(&configPath, "config", "", "load point names from a collector YAML or simulator TOML config; connects when it has one server")
+	flag.UintVar(&unit, "unit", 1, "unit (slave) id addressed by reads and writes")
+	flag.DurationVar(&timeout, "timeout", time.Second, "answer timeout")
+	flag.StringVar(&historyPath, "history", defaultHistory(), "command history file, empty to keep none")
+	flag.StringVar(&capturePath, "capture", "", "capture every frame to this file: .pcapng for Wireshark, else a text log")
+	flag.StringVar(&execLine, "exec", "", "run these commands, separated by ';', and exit")
 	flag.Parse()
-
-	cfg, err := cfgpkg.Load(configPath)
-	if err != nil {
-		log.Fatalf("load config: %v", err)
-	}
-
-    // Choose settings: prefer [client] section if present, else fallback to [server]
-    settings := cfg.Server
-    if cfg.Client.Mode != "" || cfg.Client.SerialPort != "" || cfg.Client.ListenAddress != "" {
-        settings = cfg.Client
-    }
-    // Helper to open a client and return a closer
-    openClient := func() (mb.Client, func(), error) {
-        mode := strings.ToLower(settings.Mode)
-        timeout := 5 * time.Second
-        sid := uint8(1)
-        if settings.SlaveID > 0 { sid = uint8(settings.SlaveID) }
-        if mode == "rtu" || strings.TrimSpace(settings.SerialPort) != "" {
-            port := strings.TrimSpace(settings.SerialPort)
-            if port == "" { return nil, nil, fmt.Errorf("serial_port/path is required for RTU mode") }
-            if _, err := os.Stat(port); err != nil { return nil, nil, fmt.Errorf("serial port %s not ready: %v", port, err) }
-            rh := mb.NewRTUClientHandler(port)
-            if settings.BaudRate > 0 { rh.BaudRate = settings.BaudRate }
-            if settings.DataBits > 0 { rh.DataBits = settings.DataBits }
-            if settings.StopBits > 0 { rh.StopBits = settings.StopBits }
-            if p := strings.ToUpper(strings.TrimSpace(settings.Parity)); p != "" { rh.Parity = p }
-            rh.Timeout = timeout
-            rh.IdleTimeout = 100 * time.Millisecond
-            rh.SlaveId = sid
-            if err := rh.Connect(); err != nil { return nil, nil, fmt.Errorf("connect (rtu): %w", err) }
-            return mb.NewClient(rh), func(){ _ = rh.Close() }, nil
-        }
-        address := normalizeAddress(settings.ListenAddress)
-        th := mb.NewTCPClientHandler(address)
-        th.Timeout = timeout
-        th.SlaveId = sid
-        if err := th.Connect(); err != nil { return nil, nil, fmt.Errorf("connect (tcp): %w", err) }
-        return mb.NewClient(th), func(){ _ = th.Close() }, nil
-    }
-
-    client, closeClient, err := openClient()
-    if err != nil { log.Fatalf("open client: %v", err) }
-    defer closeClient()
-
-    // Determine poll interval: prefer [client].update_interval, then root, else 5s
-    intervalStr := settings.UpdateInterval
-    if intervalStr == "" {
-        intervalStr = cfg.UpdateInterval
-    }
-    poll := 5 * time.Second
-    if d, err := time.ParseDuration(strings.TrimSpace(intervalStr)); err == nil && d > 0 {
-        poll = d
-    }
-
-    ticker := time.NewTicker(poll)
-    defer ticker.Stop()
-
-    for {
-        for _, r := range cfg.Registers {
-            typeLower := strings.ToLower(r.Type)
-            switch typeLower {
-            case "holding":
-                value, err := readNumericRegister(client, r, true)
-                if err != nil {
-                    // Auto-reconnect on common serial FD/select errors
-                    es := strings.ToLower(err.Error())
-                    if strings.Contains(es, "bad file descriptor") || strings.Contains(es, "could not select") {
-                        log.Printf("warn: read error '%v', reconnecting...", err)
-                        closeClient()
-                        time.Sleep(300 * time.Millisecond)
-                        var e error
-                        client, closeClient, e = openClient()
-                        if e != nil { log.Printf("reconnect failed: %v", e); break }
-                        // retry once after reconnect
-                        if v2, e2 := readNumericRegister(client, r, true); e2 == nil {
-                            value = v2
-                            break
-                        } else {
-                            log.Printf("read holding %d after reconnect failed: %v", r.Address, e2)
-                            break
-                        }
-                    }
-                    log.Printf("read holding %d: %v", r.Address, err)
-                    continue
-                }
-                actual := applyScaleAndOffset(value, r)
-                fmt.Printf("%s (holding@%d) = %s\n", r.CSVColumn, r.Address, formatNumber(actual))
-
-            case "input":
-                value, err := readNumericRegister(client, r, false)
-                if err != nil {
-                    log.Printf("read input %d: %v", r.Address, err)
-                    continue
-                }
-                actual := applyScaleAndOffset(value, r)
-                fmt.Printf("%s (input@%d) = %s\n", r.CSVColumn, r.Address, formatNumber(actual))
-
-            case "coil":
-                data, err := client.ReadCoils(r.Address, 1)
-                if err != nil {
-                    log.Printf("read coil %d: %v", r.Address, err)
-                    continue
-                }
-                b := (len(data) > 0) && (data[0]&0x01 == 0x01)
-                fmt.Printf("%s (coil@%d) = %t\n", r.CSVColumn, r.Address, b)
-
-            case "discrete":
-                data, err := client.ReadDiscreteInputs(r.Address, 1)
-                if err != nil {
-                    log.Printf("read discrete %d: %v", r.Address, err)
-                    continue
-                }
-                b := (len(data) > 0) && (data[0]&0x01 == 0x01)
-                fmt.Printf("%s (discrete@%d) = %t\n", r.CSVColumn, r.Address, b)
-
-            default:
-                log.Printf("unknown register type: %s", r.Type)
-            }
-        }
-        <-ticker.C
-    }
-}
-
-func readNumericRegister(client mb.Client, reg cfgpkg.RegisterConfig, holding bool) (float64, error) {
-	dataType := strings.ToLower(reg.DataType)
-	if dataType == "" {
-		dataType = "uint16"
+	if unit > 255 {
+		log.Fatalf("invalid unit id %d", unit)
 	}
 
-	quantity := uint16(1)
-	if dataType == "float32" {
-		quantity = 2
+	session := repl.NewSession(os.Stdout)
+	session.Timeout = timeout
+	session.SetUnit(uint8(unit))
+	defer session.Close()
+	if capturePath != "" {
+		w, err := capture.Create(capturePath, "")
+		if err != nil {
+			log.Fatalf("capture: %v", err)
+		}
+		defer w.Close()
+		session.Tap = w.Tap
 	}
-
-	var data []byte
-	var err error
-	if holding {
-		data, err = client.ReadHoldingRegisters(reg.Address, quantity)
-	} else {
-		data, err = client.ReadInputRegisters(reg.Address, quantity)
+	if configPath != "" {
+		servers, err := session.Load(configPath)
+		if err != nil {
+			log.Fatalf("load config: %v", err)
+		}
+		if target == "" && len(servers) == 1 {
+			if err := session.Connect(servers[0]); err != nil {
+				log.Printf("connect: %v", err)
+			}
+		}
 	}
-	if err != nil {
-		return 0, err
+	if target != "" {
+		srv, err := repl.ParseTarget(strings.Fields(target))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := session.Connect(srv); err != nil {
+			log.Printf("connect: %v", err)
+		}
 	}
 
-	switch dataType {
-	case "uint16":
-		return float64(binary.BigEndian.Uint16(data)), nil
-	case "int16":
-		return float64(int16(binary.BigEndian.Uint16(data))), nil
-	case "float32":
-		if len(data) < 4 {
-			return 0, fmt.Errorf("float32 read returned %d bytes", len(data))
+	switch {
+	case execLine != "":
+		if !runScript(session, strings.Split(execLine, ";")) {
+			os.Exit(1)
+		}
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		if err := interactive(session, historyPath); err != nil {
+			log.Fatal(err)
 		}
-		bits := binary.BigEndian.Uint32(data)
-		return float64(math.Float32frombits(bits)), nil
 	default:
-		return 0, fmt.Errorf("unsupported data_type %q", reg.DataType)
+		var lines []string
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if !runScript(session, lines) {
+			os.Exit(1)
+		}
+	}
+}
+
+func defaultHistory() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
 	}
+	return filepath.Join(home, ".modbus_client_history")
 }
 
-func applyScaleAndOffset(raw float64, reg cfgpkg.RegisterConfig) float64 {
-	scale := reg.Scale
-	if scale == 0 {
-		scale = 1
+// runScript runs commands non-interactively; Ctrl-C stops a running
+// watch. It reports whether every command succeeded.
+func runScript(session *repl.Session, lines []string) bool {
+	ok := true
+	for _, line := range lines {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err := session.Exec(ctx, strings.TrimSpace(line))
+		stop()
+		if errors.Is(err, repl.ErrQuit) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			ok = false
+		}
 	}
-	return (raw - reg.Offset) / scale
+	return ok
 }
 
-func formatNumber(value float64) string {
-	return strconv.FormatFloat(value, 'f', -1, 64)
+// input is a line read from the terminal.
+type input struct {
+	line string
+	err  error
 }
 
-func normalizeAddress(addr string) string {
-	addr = strings.TrimSpace(addr)
-	if addr == "" {
-		addr = ":1503"
+// interactive runs the shell on the terminal with history and tab
+// completion. A watch runs while the next line is read: Enter or Ctrl-C
+// stops it, and a command typed meanwhile runs next.
+func interactive(session *repl.Session, historyPath string) error {
+	history, err := repl.OpenHistory(historyPath)
+	if err != nil {
+		log.Printf("history: %v", err)
+		history, _ = repl.OpenHistory("")
 	}
-	if strings.HasPrefix(addr, ":") {
-		addr = "127.0.0.1" + addr
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
 	}
-	// If it's just a port number like "1502", make it host:port
-	if _, _, err := net.SplitHostPort(addr); err != nil {
-		if !strings.Contains(addr, ":") {
-			addr = "127.0.0.1:" + addr
+	defer term.Restore(fd, state)
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, session.Prompt())
+	t.History = history
+	t.AutoCompleteCallback = session.Complete
+	session.Out = t
+	session.History = history
+	log.SetOutput(t)
+	defer log.SetOutput(os.Stderr)
+	fmt.Fprintln(t, "type help for the commands, quit or Ctrl-D to leave")
+
+	// the reader reads one line per request so that the prompt can change
+	// between commands
+	requests := make(chan struct{})
+	lines := make(chan input)
+	defer close(requests)
+	go func() {
+		for range requests {
+			line, err := t.ReadLine()
+			if errors.Is(err, term.ErrPasteIndicator) {
+				err = nil
+			}
+			lines <- input{line, err}
+		}
+	}()
+	read := func() input {
+		t.SetPrompt(session.Prompt())
+		requests <- struct{}{}
+		return <-lines
+	}
+
+	pending := false // a read is outstanding, requested while a watch ran
+	for {
+		var in input
+		if pending {
+			t.SetPrompt(session.Prompt())
+			in, pending = <-lines, false
+		} else {
+			in = read()
+		}
+		for {
+			if in.err != nil {
+				return nil // Ctrl-D or Ctrl-C at the prompt
+			}
+			line := strings.TrimSpace(in.line)
+			if !repl.IsWatch(line) {
+				err := session.Exec(context.Background(), line)
+				if errors.Is(err, repl.ErrQuit) {
+					return nil
+				}
+				if err != nil {
+					fmt.Fprintf(t, "error: %v\n", err)
+				}
+				break
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() { done <- session.Exec(ctx, line) }()
+			t.SetPrompt(session.Prompt())
+			requests <- struct{}{}
+			select {
+			case err := <-done:
+				cancel()
+				if err != nil {
+					fmt.Fprintf(t, "error: %v\n", err)
+				}
+				pending = true
+				in = input{}
+			case next := <-lines:
+				cancel()
+				if err := <-done; err != nil {
+					fmt.Fprintf(t, "error: %v\n", err)
+				}
+				if next.err != nil || strings.TrimSpace(next.line) == "" {
+					in = input{} // the watch is stopped, back to the prompt
+				} else {
+					in = next
+					continue
+				}
+			}
+			break
 		}
 	}
-	return addr
 }
@@ -16,10 +16,12 @@ func main() {
 	var storageEnabled bool
 	var storageDir string
 	var storageQueue int
+	var capturePath string
 	flag.StringVar(&cfgPath, "config", "config/config.yaml", "path to YAML config")
 	flag.BoolVar(&storageEnabled, "storage-enabled", false, "enable JSONL/CSV storage output (overrides YAML)")
 	flag.StringVar(&storageDir, "storage-dir", "", "storage output directory (overrides YAML system.storage.db_path)")
 	flag.IntVar(&storageQueue, "storage-queue", 0, "storage queue size (overrides YAML system.storage.max_queue_size)")
+	flag.StringVar(&capturePath, "capture", "", "capture every frame to this file: .pcapng for Wireshark, else a text log")
 	flag.Parse()
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -39,6 +41,7 @@ func main() {
 		StorageEnabled: storageEnabled,
 		StorageDir:     storageDir,
 		StorageQueue:   storageQueue,
+		Capture:        capturePath,
 	}
 	if err := tasks.InitAndRunCollector(ctx, opts); err != nil {
 		log.Printf("collector exited with error: %v", err)
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	"modbus-simulator/internal/conformance"
+	"modbus-simulator/internal/repl"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run returns the exit status: 1 when a check failed.
+func run() int {
+	var (
+		target, checks, jsonPath, capturePath string
+		unit, address                         uint
+		timeout                               time.Duration
+		readOnly, list                        bool
+	)
+	flag.StringVar(&target, "target", "tcp://127.0.0.1:1502", "server: tcp://host:port, rtu-over-tcp://host:port, rtu:///dev/ttyUSB0?baud=9600 or tls://host:802?cert=&key=&ca=")
+	flag.UintVar(&unit, "unit", 1, "unit (slave) id")
+	flag.UintVar(&address, "address", 0, "start of a block readable in every table: 125 registers and 2000 bits")
+	flag.DurationVar(&timeout, "timeout", time.Second, "answer timeout, also how long a check waits for silence")
+	flag.BoolVar(&readOnly, "read-only", false, "skip the checks that write")
+	flag.StringVar(&checks, "checks", "", "comma separated check ids or id prefixes to run, e.g. read-,tcp-; default all")
+	flag.BoolVar(&list, "list", false, "list the checks and exit")
+	flag.StringVar(&jsonPath, "json", "", "write the report as JSON to this file, - for stdout instead of the table")
+	flag.StringVar(&capturePath, "capture", "", "capture every frame to this file: .pcapng for Wireshark, else a text log")
+	flag.Parse()
+
+	if list {
+		for _, c := range conformance.Catalog {
+			var tags []string
+			if c.Writes {
+				tags = append(tags, "writes")
+			}
+			if c.TCP {
+				tags = append(tags, "tcp only")
+			}
+			if c.RTU {
+				tags = append(tags, "rtu only")
+			}
+			note := ""
+			if len(tags) > 0 {
+				note = " (" + strings.Join(tags, ", ") + ")"
+			}
+			fmt.Printf("%-32s %s%s\n", c.ID, c.Title, note)
+		}
+		return 0
+	}
+
+	server, err := repl.ParseTarget(strings.Fields(target))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if unit > 255 || address > 65535 {
+		log.Fatalf("invalid unit id %d or address %d", unit, address)
+	}
+	opts := conformance.Options{Unit: uint8(unit), Address: uint16(address), Timeout: timeout, ReadOnly: readOnly}
+	for _, id := range strings.Split(checks, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			opts.Checks = append(opts.Checks, id)
+		}
+	}
+	if capturePath != "" {
+		w, err := capture.Create(capturePath, "")
+		if err != nil {
+			log.Fatalf("capture: %v", err)
+		}
+		defer w.Close()
+		opts.Tap = w.Tap
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	report, err := conformance.Run(ctx, server, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if jsonPath == "-" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout)
+		if err == nil && jsonPath != "" {
+			var f *os.File
+			if f, err = os.Create(jsonPath); err == nil {
+				err = report.WriteJSON(f)
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+		}
+	}
+	if err != nil {
+		log.Fatalf("write report: %v", err)
+	}
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/scan"
+)
+
+func main() {
+	var (
+		hosts, protocol, serialPort, parity       string
+		units, addresses, tables, format, outPath string
+		capturePath                               string
+		port, baud, dataBits, stopBits, workers   int
+		stride                                    int
+		timeout                                   time.Duration
+		zeros                                     bool
+	)
+	flag.StringVar(&hosts, "hosts", "", "TCP hosts to scan: names, IPs, 192.168.1.10-20 or 192.168.1.0/24, comma separated")
+	flag.IntVar(&port, "port", 502, "TCP port of the hosts")
+	flag.StringVar(&protocol, "protocol", "modbus-tcp", "protocol of the hosts: modbus-tcp or rtu-over-tcp")
+	flag.StringVar(&serialPort, "serial", "", "serial port to scan instead of hosts, e.g. /dev/ttyUSB0")
+	flag.IntVar(&baud, "baud", 9600, "serial baud rate")
+	flag.IntVar(&dataBits, "data-bits", 8, "serial data bits")
+	flag.IntVar(&stopBits, "stop-bits", 1, "serial stop bits")
+	flag.StringVar(&parity, "parity", "N", "serial parity: N, E or O")
+	flag.StringVar(&units, "units", "1-247", "slave ids to probe, e.g. 1-10,17")
+	flag.StringVar(&addresses, "addresses", "0-9999", "address range probed in every table")
+	flag.IntVar(&stride, "stride", 1, "spacing of single-address probes in unreadable blocks; above 1 runs shorter than the stride can be missed")
+	flag.StringVar(&tables, "tables", strings.Join(scan.Tables, ","), "register tables to scan")
+	flag.DurationVar(&timeout, "timeout", 300*time.Millisecond, "answer timeout per request")
+	flag.BoolVar(&zeros, "zeros", false, "draft points for addresses reading 0 too")
+	flag.StringVar(&format, "format", "yaml", "draft format: yaml (collector config) or csv (devices_file)")
+	flag.StringVar(&outPath, "out", "", "write the draft to this file instead of stdout")
+	flag.IntVar(&workers, "workers", 16, "hosts scanned in parallel")
+	flag.StringVar(&capturePath, "capture", "", "capture every frame to this file: .pcapng for Wireshark, else a text log")
+	flag.Parse()
+
+	opts := scan.Options{Zeros: zeros, Stride: stride}
+	var err error
+	if opts.Units, err = scan.ParseUnits(units); err != nil {
+		log.Fatal(err)
+	}
+	start, end, err := scan.ParseAddresses(addresses)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Start, opts.Count = start, int(end)-int(start)+1
+	for _, t := range strings.Split(tables, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			opts.Tables = append(opts.Tables, t)
+		}
+	}
+	if format != "yaml" && format != "csv" {
+		log.Fatalf("unsupported format %q", format)
+	}
+
+	var servers []collector.ServerConfig
+	switch {
+	case serialPort != "":
+		servers = append(servers, collector.ServerConfig{
+			ServerID: "scan_serial",
+			Protocol: "modbus-rtu",
+			Timeout:  timeout,
+			Connection: collector.Connection{SerialPort: serialPort, BaudRate: baud,
+				DataBits: dataBits, StopBits: stopBits, Parity: parity},
+		})
+	case hosts != "":
+		list, err := scan.Hosts(hosts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, h := range list {
+			servers = append(servers, collector.ServerConfig{
+				ServerID:   fmt.Sprintf("scan_%s_%d", strings.NewReplacer(".", "_", ":", "_").Replace(h), port),
+				Protocol:   protocol,
+				Timeout:    timeout,
+				Connection: collector.Connection{Host: h, Port: port},
+			})
+		}
+	default:
+		log.Fatalf("nothing to scan: set --hosts or --serial")
+	}
+
+	var tap capture.Tap
+	if capturePath != "" {
+		w, err := capture.Create(capturePath, "")
+		if err != nil {
+			log.Fatalf("capture: %v", err)
+		}
+		defer w.Close()
+		tap = w.Tap
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// scan the servers in parallel, keeping their order in the draft
+	results := make([]scan.Target, len(servers))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range max(1, min(workers, len(servers))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = scanServer(ctx, servers[i], opts, tap)
+			}
+		}()
+	}
+	for i := range servers {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var targets []scan.Target
+	for _, t := range results {
+		if len(t.Units) > 0 {
+			targets = append(targets, t)
+		}
+	}
+	log.Printf("found %d server(s) with responding units", len(targets))
+
+	var out io.Writer = os.Stdout
+	if outPath != "" {
+		f, err := os.Create(outPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if format == "csv" {
+		err = scan.WriteCSV(out, targets)
+	} else {
+		err = scan.WriteYAML(out, targets)
+	}
+	if err != nil {
+		log.Fatalf("write draft: %v", err)
+	}
+}
+
+// scanServer scans one server; unreachable servers yield no units.
+func scanServer(ctx context.Context, srv collector.ServerConfig, opts scan.Options, tap capture.Tap) scan.Target {
+	t := scan.Target{Server: srv}
+	link, err := collector.OpenLink(srv, tap)
+	if err != nil {
+		if srv.Connection.SerialPort != "" {
+			log.Printf("scan %s: %v", srv.Connection.SerialPort, err)
+		}
+		return t
+	}
+	defer link.Close()
+	units, err := scan.Scan(ctx, link, opts)
+	if err != nil {
+		log.Printf("scan %s: %v", link.Address, err)
+	}
+	for _, u := range units {
+		log.Printf("scan %s: unit %d answers, %d readable range(s)", link.Address, u.Slave, len(u.Ranges))
+	}
+	t.Units = units
+	return t
+}
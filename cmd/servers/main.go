@@ -4,25 +4,24 @@ import (
 	"context"
 	"flag"
 	"log"
-	"os"
 	"os/signal"
-	"time"
 	"syscall"
+	"time"
 
 	collector "modbus-simulator/internal/collector"
-	"modbus-simulator/internal/output"
 	servermgr "modbus-simulator/internal/servermgr"
 )
 
 func main() {
 	var cfgPath string
-	var snapJSON string
-	var snapCSV string
-	var snapWait string
+	var opts servermgr.Options
 	flag.StringVar(&cfgPath, "config", "config/config.yaml", "path to YAML config for servers")
-	flag.StringVar(&snapJSON, "snapshot-json", "", "optional path to write a one-time JSON snapshot")
-	flag.StringVar(&snapCSV, "snapshot-csv", "", "optional path to write a one-time CSV snapshot")
-	flag.StringVar(&snapWait, "snapshot-wait", "3s", "wait duration before taking snapshot (e.g., 3s)")
+	flag.StringVar(&opts.SnapshotJSON, "snapshot-json", "", "optional path to write a one-time JSON snapshot")
+	flag.StringVar(&opts.SnapshotCSV, "snapshot-csv", "", "optional path to write a one-time CSV snapshot")
+	flag.DurationVar(&opts.SnapshotWait, "snapshot-wait", 3*time.Second, "wait duration before taking snapshot (e.g., 3s)")
+	flag.StringVar(&opts.API, "api", "", "listen address of the HTTP control API, e.g. 127.0.0.1:8080; a bare :8080 listens on localhost only")
+	flag.StringVar(&opts.DataDir, "data-dir", "data", "directory the control API may load CSV and replay files from")
+	flag.StringVar(&opts.Capture, "capture", "", "capture every frame to this file: .pcapng for Wireshark, else a text log")
 	flag.Parse()
 
 	rootCfg, err := collector.LoadYAML(cfgPath)
@@ -30,59 +29,11 @@ func main() {
 		log.Fatalf("load yaml config %s: %v", cfgPath, err)
 	}
 
-	mgr := servermgr.NewManager(rootCfg)
-
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer cancel()
 
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigs
-		log.Printf("shutting down servers...")
-		cancel()
-	}()
-
-	// If snapshot flags are set, run servers, wait, take snapshot, export, and exit.
-	if snapJSON != "" || snapCSV != "" {
-		// start servers in background
-		done := make(chan struct{})
-		go func() {
-			if err := mgr.Run(ctx); err != nil {
-				log.Printf("server manager exited with error: %v", err)
-			}
-			close(done)
-		}()
-
-		// wait for servers to start and first CSV write to apply
-		d, err := time.ParseDuration(snapWait)
-		if err != nil {
-			d = 3 * time.Second
-		}
-		time.Sleep(d)
-
-		snaps, err := mgr.Snapshot()
-		if err != nil {
-			log.Fatalf("snapshot error: %v", err)
-		}
-		if snapJSON != "" {
-			if err := output.WriteJSON(snapJSON, snaps); err != nil {
-				log.Fatalf("write snapshot json: %v", err)
-			}
-		}
-		if snapCSV != "" {
-			if err := output.WriteCSV(snapCSV, snaps); err != nil {
-				log.Fatalf("write snapshot csv: %v", err)
-			}
-		}
-		// stop and exit
-		cancel()
-		<-done
-		return
-	}
-
-	// Default behavior: run servers until interrupted.
-	if err := mgr.Run(ctx); err != nil {
-		log.Printf("server manager exited with error: %v", err)
+	if err := servermgr.Serve(ctx, rootCfg, opts); err != nil {
+		log.Fatalf("servers: %v", err)
 	}
+	log.Printf("servers stopped")
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"modbus-simulator/pkg/modbusdb"
+)
+
+func main() {
+	var (
+		dbPath     string
+		pretty     bool
+		timeout    time.Duration
+		points     string
+		from       string
+		to         string
+		aggs       string
+		interval   time.Duration
+		fill       string
+		resolution string
+		limit      int
+		cursor     string
+	)
+	flag.StringVar(&dbPath, "db", "./data.sqlite", "path to sqlite database file")
+	flag.BoolVar(&pretty, "pretty", true, "pretty-print JSON output")
+	flag.DurationVar(&timeout, "timeout", 30*time.Second, "context timeout for DB query")
+	flag.StringVar(&points, "points", "", "comma-separated server/device/name selectors; empty parts match anything")
+	flag.StringVar(&from, "from", "-1h", "range start: RFC3339 time or duration relative to now (e.g. -24h)")
+	flag.StringVar(&to, "to", "", "range end: RFC3339 time or relative duration (default now)")
+	flag.StringVar(&aggs, "agg", "", "comma-separated aggregations: avg,min,max,sum,count,first,last,delta (empty = raw values)")
+	flag.DurationVar(&interval, "interval", 0, "bucket width, e.g. 5m (0 = one bucket for the range)")
+	flag.StringVar(&fill, "fill", "none", "gap fill: none|null|zero|previous|linear")
+	flag.StringVar(&resolution, "resolution", "", "force source table: raw|1m|1h (default automatic)")
+	flag.IntVar(&limit, "limit", 1000, "max rows per page (0 = unlimited)")
+	flag.StringVar(&cursor, "cursor", "", "next_cursor from a previous page")
+	flag.Parse()
+
+	now := time.Now()
+	q := modbusdb.Query{
+		Interval:   interval,
+		Fill:       fill,
+		Resolution: resolution,
+		Limit:      limit,
+		Cursor:     cursor,
+	}
+	var err error
+	if q.From, err = parseTime(from, now); err != nil {
+		fatalf("parse -from: %v", err)
+	}
+	if q.To, err = parseTime(to, now); err != nil {
+		fatalf("parse -to: %v", err)
+	}
+	for _, sel := range splitList(points) {
+		parts := strings.SplitN(sel, "/", 3)
+		for len(parts) < 3 {
+			parts = append([]string{""}, parts...)
+		}
+		q.Points = append(q.Points, modbusdb.PointRef{ServerID: parts[0], DeviceID: parts[1], Name: parts[2]})
+	}
+	q.Aggs = splitList(aggs)
+
+	client, err := modbusdb.Open(dbPath)
+	if err != nil {
+		fatalf("open db: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	res, err := client.Query(ctx, q)
+	if err != nil {
+		fatalf("query: %v", err)
+	}
+
+	var out []byte
+	if pretty {
+		out, err = json.MarshalIndent(res, "", "  ")
+	} else {
+		out, err = json.Marshal(res)
+	}
+	if err != nil {
+		fatalf("marshal json: %v", err)
+	}
+	os.Stdout.Write(out)
+	os.Stdout.Write([]byte{'\n'})
+}
+
+// parseTime accepts RFC3339 or a duration relative to now; empty means now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return now, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -4,15 +4,18 @@ go 1.24.3
 
 require (
 	github.com/goburrow/modbus v0.1.0
+	github.com/goburrow/serial v0.1.0
+	github.com/yuin/gopher-lua v1.1.2
+	golang.org/x/term v0.36.0
 	gopkg.in/yaml.v3 v3.0.1
 	gorm.io/driver/sqlite v1.6.0
 	gorm.io/gorm v1.31.0
 )
 
 require (
-	github.com/goburrow/serial v0.1.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-sqlite3 v1.14.22 // indirect
+	golang.org/x/sys v0.37.0 // indirect
 	golang.org/x/text v0.20.0 // indirect
 )
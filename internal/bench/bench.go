@@ -0,0 +1,407 @@
+// Package bench load-tests Modbus servers: concurrent masters send a mix of
+// requests at a given rate and the answers are summarised as throughput,
+// latency percentiles and an error breakdown.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mb "github.com/goburrow/modbus"
+	"github.com/goburrow/serial"
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+)
+
+// Op is one function code of the request mix and its relative weight.
+type Op struct {
+	Function byte
+	Weight   int
+}
+
+// Options configures a run. The zero value runs one client reading one
+// holding register at address 0 of unit 1 for 10 seconds.
+type Options struct {
+	Clients  int           // concurrent masters, default 1
+	Duration time.Duration // run time, default 10s unless Requests is set
+	Requests int64         // stop after this many requests, 0 means no limit
+	Rate     float64       // requests per second of all clients, 0 means unpaced
+	Mix      []Op          // default read holding registers
+	Unit     uint8         // addressed unit, default 1
+	Start    uint16        // first address of the requests
+	Span     int           // addresses from Start the blocks fall in, default MaxSize
+	MinSize  int           // quantity per request, default 1
+	MaxSize  int           // default MinSize
+	Progress time.Duration // log the running totals this often, 0 disables
+	Tap      capture.Tap   // receives every frame when set
+}
+
+// Supported function codes.
+const (
+	fcReadCoils              = 0x01
+	fcReadDiscreteInputs     = 0x02
+	fcReadHoldingRegisters   = 0x03
+	fcReadInputRegisters     = 0x04
+	fcWriteSingleCoil        = 0x05
+	fcWriteSingleRegister    = 0x06
+	fcWriteMultipleCoils     = 0x0F
+	fcWriteMultipleRegisters = 0x10
+)
+
+// maxQuantity is the largest quantity one request of a function carries.
+var maxQuantity = map[byte]int{
+	fcReadCoils:              2000,
+	fcReadDiscreteInputs:     2000,
+	fcReadHoldingRegisters:   125,
+	fcReadInputRegisters:     125,
+	fcWriteSingleCoil:        1,
+	fcWriteSingleRegister:    1,
+	fcWriteMultipleCoils:     1968,
+	fcWriteMultipleRegisters: 123,
+}
+
+// ParseMix parses a request mix such as "3:70,16:20,read_coils:10": function
+// codes (decimal, 0x hex or names as capture.FunctionName prints them) with
+// optional weights, 1 by default.
+func ParseMix(s string) ([]Op, error) {
+	var ops []Op
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, weight, hasWeight := strings.Cut(part, ":")
+		op := Op{Weight: 1}
+		if hasWeight {
+			w, err := strconv.Atoi(weight)
+			if err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid weight in %q", part)
+			}
+			op.Weight = w
+		}
+		fc, err := parseFunction(name)
+		if err != nil {
+			return nil, err
+		}
+		op.Function = fc
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("empty request mix")
+	}
+	return ops, nil
+}
+
+func parseFunction(s string) (byte, error) {
+	s = strings.ToLower(strings.TrimPrefix(strings.ToLower(s), "fc"))
+	if n, err := strconv.ParseUint(s, 0, 8); err == nil {
+		if _, ok := maxQuantity[byte(n)]; ok {
+			return byte(n), nil
+		}
+		return 0, fmt.Errorf("function code %s is not supported", s)
+	}
+	for fc := range maxQuantity {
+		if capture.FunctionName(fc) == s {
+			return fc, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown function %q", s)
+}
+
+// ParseSize parses a request quantity "10" or a range "1-125".
+func ParseSize(s string) (lo, hi int, err error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(strings.TrimSpace(first)); err != nil || lo < 1 {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(last)); err != nil || hi < lo {
+			return 0, 0, fmt.Errorf("invalid size %q", s)
+		}
+	}
+	return lo, hi, nil
+}
+
+// Run runs the load test against server until opts.Duration has passed,
+// opts.Requests were sent or ctx is done. Every client opens its own
+// connection; a serial line carries a single master, so RTU runs one.
+func Run(ctx context.Context, server collector.ServerConfig, opts Options) (Report, error) {
+	opts = withDefaults(opts)
+	proto := strings.ToLower(server.Protocol)
+	if (proto == "modbus-rtu" || proto == "rtu") && opts.Clients > 1 {
+		log.Printf("bench: a serial line has a single master, running 1 client instead of %d", opts.Clients)
+		opts.Clients = 1
+	}
+
+	clients := make([]*worker, opts.Clients)
+	for i := range clients {
+		link, err := collector.OpenLink(server, opts.Tap)
+		if err != nil {
+			for _, c := range clients[:i] {
+				c.link.Close()
+			}
+			return Report{}, fmt.Errorf("client %d: %w", i+1, err)
+		}
+		clients[i] = &worker{server: server, opts: opts, link: link, rng: rand.New(rand.NewPCG(uint64(time.Now().UnixNano()), uint64(i))),
+			stats: map[byte]*counts{}}
+	}
+
+	target := clients[0].link.Address
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+	var tokens chan struct{}
+	if opts.Rate > 0 {
+		tokens = make(chan struct{}, opts.Clients)
+		go pace(ctx, opts.Rate, tokens)
+	}
+	var sent, done, failed atomic.Int64
+	if opts.Progress > 0 {
+		go progress(ctx, opts.Progress, &done, &failed)
+	}
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	for _, w := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if w.link != nil {
+					w.link.Close()
+				}
+			}()
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				if ctx.Err() != nil || opts.Requests > 0 && sent.Add(1) > opts.Requests {
+					return
+				}
+				if !w.request(ctx) {
+					failed.Add(1)
+				}
+				done.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	stats := map[byte]*counts{}
+	for _, w := range clients {
+		for fc, c := range w.stats {
+			if stats[fc] == nil {
+				stats[fc] = &counts{}
+			}
+			stats[fc].merge(c)
+		}
+	}
+	r := newReport(stats, elapsed)
+	r.Target, r.Protocol, r.Clients, r.RateLimit, r.Started = target, server.Protocol, opts.Clients, opts.Rate, started
+	return r, nil
+}
+
+func withDefaults(opts Options) Options {
+	if opts.Clients <= 0 {
+		opts.Clients = 1
+	}
+	if opts.Duration <= 0 && opts.Requests <= 0 {
+		opts.Duration = 10 * time.Second
+	}
+	if len(opts.Mix) == 0 {
+		opts.Mix = []Op{{Function: fcReadHoldingRegisters, Weight: 1}}
+	}
+	if opts.Unit == 0 {
+		opts.Unit = 1
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1
+	}
+	if opts.MaxSize < opts.MinSize {
+		opts.MaxSize = opts.MinSize
+	}
+	if opts.Span <= 0 {
+		opts.Span = opts.MaxSize
+	}
+	return opts
+}
+
+// pace hands out rate tokens per second. Tokens no client is ready for
+// are dropped, so a server that cannot keep up shows as a lower rate.
+func pace(ctx context.Context, rate float64, tokens chan<- struct{}) {
+	ticker := time.NewTicker(max(time.Duration(float64(time.Second)/rate), time.Microsecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			select {
+			case tokens <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func progress(ctx context.Context, every time.Duration, done, failed *atomic.Int64) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	started, last := time.Now(), int64(0)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n := done.Load()
+			log.Printf("bench: %s %d requests (%.0f/s), %d failed", now.Sub(started).Round(time.Second), n,
+				float64(n-last)/every.Seconds(), failed.Load())
+			last = n
+		}
+	}
+}
+
+// worker is one master with its own connection and tallies.
+type worker struct {
+	server collector.ServerConfig
+	opts   Options
+	link   *collector.Link // nil while reconnecting
+	rng    *rand.Rand
+	stats  map[byte]*counts
+}
+
+// request sends one request of the mix and records its outcome. It
+// reports whether the request got an answer, exception answers included.
+func (w *worker) request(ctx context.Context) bool {
+	fc := w.pick()
+	c := w.stats[fc]
+	if c == nil {
+		c = &counts{}
+		w.stats[fc] = c
+	}
+	c.requests++
+	if w.link == nil && !w.reconnect(ctx, c) {
+		return false
+	}
+	pdu := w.build(fc)
+	start := time.Now()
+	_, err := w.link.Send(w.opts.Unit, pdu)
+	latency := time.Since(start)
+
+	var me *mb.ModbusError
+	switch {
+	case err == nil:
+		c.ok++
+	case errors.As(err, &me):
+		c.exception(me.ExceptionCode)
+	case isTimeout(err):
+		c.timeouts++
+		w.drop()
+		return false
+	default:
+		c.failure(err)
+		w.drop()
+		return false
+	}
+	c.latencies = append(c.latencies, latency)
+	return true
+}
+
+// drop closes the connection after a timeout or transport error, so that
+// a late answer cannot be taken for the next one.
+func (w *worker) drop() {
+	w.link.Close()
+	w.link = nil
+}
+
+func (w *worker) reconnect(ctx context.Context, c *counts) bool {
+	link, err := collector.OpenLink(w.server, w.opts.Tap)
+	if err != nil {
+		c.failure(err)
+		select { // do not spin on a server that is down
+		case <-ctx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+		return false
+	}
+	c.reconnects++
+	w.link = link
+	return true
+}
+
+// pick draws a function code by weight.
+func (w *worker) pick() byte {
+	total := 0
+	for _, op := range w.opts.Mix {
+		total += op.Weight
+	}
+	if total <= 0 {
+		return w.opts.Mix[0].Function
+	}
+	n := w.rng.IntN(total)
+	for _, op := range w.opts.Mix {
+		if n < op.Weight {
+			return op.Function
+		}
+		n -= op.Weight
+	}
+	return w.opts.Mix[len(w.opts.Mix)-1].Function
+}
+
+// build makes a request of fc for a random block of the address span.
+func (w *worker) build(fc byte) mb.ProtocolDataUnit {
+	o := w.opts
+	q := min(o.MinSize+w.rng.IntN(o.MaxSize-o.MinSize+1), maxQuantity[fc], o.Span)
+	address := int(o.Start) + w.rng.IntN(o.Span-q+1)
+	address = min(address, 65536-q)
+	data := []byte{byte(address >> 8), byte(address)}
+	switch fc {
+	case fcWriteSingleCoil:
+		data = append(data, 0x00, 0x00)
+		if w.rng.IntN(2) == 1 {
+			data[2] = 0xFF
+		}
+	case fcWriteSingleRegister:
+		data = append(data, byte(w.rng.IntN(256)), byte(w.rng.IntN(256)))
+	case fcWriteMultipleCoils:
+		bits := make([]byte, (q+7)/8)
+		for i := range bits {
+			bits[i] = byte(w.rng.IntN(256))
+		}
+		data = append(data, byte(q>>8), byte(q), byte(len(bits)))
+		data = append(data, bits...)
+	case fcWriteMultipleRegisters:
+		data = append(data, byte(q>>8), byte(q), byte(2*q))
+		for range q {
+			data = append(data, byte(w.rng.IntN(256)), byte(w.rng.IntN(256)))
+		}
+	default:
+		data = append(data, byte(q>>8), byte(q))
+	}
+	return mb.ProtocolDataUnit{FunctionCode: fc, Data: data}
+}
+
+// isTimeout reports whether err is an answer timeout of a TCP connection,
+// an RTU-over-TCP stream or a serial port.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, serial.ErrTimeout) || errors.Is(err, os.ErrDeadlineExceeded)
+}
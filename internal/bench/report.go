@@ -0,0 +1,251 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	mb "github.com/goburrow/modbus"
+	"modbus-simulator/internal/capture"
+)
+
+// maxErrorKinds bounds the distinct error messages a report keeps.
+const maxErrorKinds = 20
+
+// counts tallies the requests of one function code.
+type counts struct {
+	requests, ok, timeouts, failed, reconnects int64
+	exceptions                                 map[byte]int64
+	errors                                     map[string]int64
+	latencies                                  []time.Duration // answered requests
+}
+
+func (c *counts) exception(code byte) {
+	if c.exceptions == nil {
+		c.exceptions = map[byte]int64{}
+	}
+	c.exceptions[code]++
+}
+
+func (c *counts) failure(err error) {
+	c.failed++
+	if c.errors == nil {
+		c.errors = map[string]int64{}
+	}
+	msg := err.Error()
+	if _, ok := c.errors[msg]; ok || len(c.errors) < maxErrorKinds {
+		c.errors[msg]++
+	}
+}
+
+func (c *counts) merge(o *counts) {
+	c.requests += o.requests
+	c.ok += o.ok
+	c.timeouts += o.timeouts
+	c.failed += o.failed
+	c.reconnects += o.reconnects
+	for code, n := range o.exceptions {
+		if c.exceptions == nil {
+			c.exceptions = map[byte]int64{}
+		}
+		c.exceptions[code] += n
+	}
+	for msg, n := range o.errors {
+		if c.errors == nil {
+			c.errors = map[string]int64{}
+		}
+		c.errors[msg] += n
+	}
+	c.latencies = append(c.latencies, o.latencies...)
+}
+
+func (c *counts) exceptionTotal() int64 {
+	var n int64
+	for _, v := range c.exceptions {
+		n += v
+	}
+	return n
+}
+
+// Latency summarises answer times in milliseconds. Only answered requests
+// count, exception answers included; timeouts are reported apart.
+type Latency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+func newLatency(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	slices.Sort(samples)
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	at := func(p float64) float64 { return ms(samples[min(len(samples)-1, int(p*float64(len(samples))))]) }
+	var sum time.Duration
+	for _, d := range samples {
+		sum += d
+	}
+	return Latency{
+		Min:  ms(samples[0]),
+		Mean: ms(sum / time.Duration(len(samples))),
+		P50:  at(0.50),
+		P90:  at(0.90),
+		P95:  at(0.95),
+		P99:  at(0.99),
+		Max:  ms(samples[len(samples)-1]),
+	}
+}
+
+// Stats are the outcomes of a set of requests.
+type Stats struct {
+	Requests   int64            `json:"requests"`
+	OK         int64            `json:"ok"`
+	Exceptions int64            `json:"exceptions"`
+	Timeouts   int64            `json:"timeouts"`
+	Errors     int64            `json:"errors"`
+	Latency    Latency          `json:"latency_ms"`
+	ByCode     map[string]int64 `json:"exception_codes,omitempty"` // by "0x02 illegal data address"
+}
+
+func newStats(c *counts) Stats {
+	s := Stats{Requests: c.requests, OK: c.ok, Exceptions: c.exceptionTotal(), Timeouts: c.timeouts, Errors: c.failed,
+		Latency: newLatency(c.latencies)}
+	for code, n := range c.exceptions {
+		if s.ByCode == nil {
+			s.ByCode = map[string]int64{}
+		}
+		s.ByCode[exceptionName(code)] = n
+	}
+	return s
+}
+
+// FunctionStats are the outcomes of the requests of one function code.
+type FunctionStats struct {
+	Code byte   `json:"code"`
+	Name string `json:"name"`
+	Stats
+}
+
+// Report is the result of a run, written as JSON for regression tracking.
+type Report struct {
+	Target     string           `json:"target"`
+	Protocol   string           `json:"protocol"`
+	Clients    int              `json:"clients"`
+	RateLimit  float64          `json:"rate_limit,omitempty"` // requested requests per second
+	Started    time.Time        `json:"started"`
+	Seconds    float64          `json:"duration_s"`
+	Throughput float64          `json:"throughput_rps"` // requests completed per second
+	OKRate     float64          `json:"ok_rps"`         // answered without exception per second
+	Reconnects int64            `json:"reconnects"`
+	Total      Stats            `json:"total"`
+	Functions  []FunctionStats  `json:"functions"`
+	ErrorKinds map[string]int64 `json:"error_messages,omitempty"`
+}
+
+func newReport(stats map[byte]*counts, elapsed time.Duration) Report {
+	r := Report{Seconds: elapsed.Seconds()}
+	total := &counts{}
+	codes := make([]byte, 0, len(stats))
+	for fc := range stats {
+		codes = append(codes, fc)
+	}
+	slices.Sort(codes)
+	for _, fc := range codes {
+		c := stats[fc]
+		r.Functions = append(r.Functions, FunctionStats{Code: fc, Name: capture.FunctionName(fc), Stats: newStats(c)})
+		total.merge(c)
+	}
+	r.Total = newStats(total)
+	r.Reconnects = total.reconnects
+	r.ErrorKinds = total.errors
+	if r.Seconds > 0 {
+		r.Throughput = float64(total.requests) / r.Seconds
+		r.OKRate = float64(total.ok) / r.Seconds
+	}
+	return r
+}
+
+var exceptionNames = map[byte]string{
+	mb.ExceptionCodeIllegalFunction:                    "illegal function",
+	mb.ExceptionCodeIllegalDataAddress:                 "illegal data address",
+	mb.ExceptionCodeIllegalDataValue:                   "illegal data value",
+	mb.ExceptionCodeServerDeviceFailure:                "server device failure",
+	mb.ExceptionCodeAcknowledge:                        "acknowledge",
+	mb.ExceptionCodeServerDeviceBusy:                   "server device busy",
+	mb.ExceptionCodeMemoryParityError:                  "memory parity error",
+	mb.ExceptionCodeGatewayPathUnavailable:             "gateway path unavailable",
+	mb.ExceptionCodeGatewayTargetDeviceFailedToRespond: "gateway target device failed to respond",
+}
+
+func exceptionName(code byte) string {
+	if name, ok := exceptionNames[code]; ok {
+		return fmt.Sprintf("0x%02X %s", code, name)
+	}
+	return fmt.Sprintf("0x%02X", code)
+}
+
+// WriteJSON writes r as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes r as a table for people.
+func (r Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	rate := "unpaced"
+	if r.RateLimit > 0 {
+		rate = fmt.Sprintf("rate limit %g/s", r.RateLimit)
+	}
+	t := r.Total
+	fmt.Fprintf(&b, "target      %s %s, %d client(s), %s, %.1fs\n", r.Protocol, r.Target, r.Clients, rate, r.Seconds)
+	fmt.Fprintf(&b, "requests    %d (%.1f/s), ok %d (%.1f/s), exceptions %d, timeouts %d, errors %d, reconnects %d\n",
+		t.Requests, r.Throughput, t.OK, r.OKRate, t.Exceptions, t.Timeouts, t.Errors, r.Reconnects)
+	fmt.Fprintf(&b, "latency ms  %s\n\n", latencyText(t.Latency))
+	fmt.Fprintf(&b, "%-30s %9s %9s %6s %8s %6s %8s %8s %8s\n", "function", "requests", "ok", "exc", "timeouts", "errors", "p50 ms", "p99 ms", "max ms")
+	for _, f := range r.Functions {
+		fmt.Fprintf(&b, "%-30s %9d %9d %6d %8d %6d %8.3f %8.3f %8.3f\n", fmt.Sprintf("%s (%d)", f.Name, f.Code),
+			f.Requests, f.OK, f.Exceptions, f.Timeouts, f.Errors, f.Latency.P50, f.Latency.P99, f.Latency.Max)
+	}
+	if len(t.ByCode) > 0 {
+		b.WriteString("\nexceptions\n")
+		writeCounts(&b, t.ByCode)
+	}
+	if len(r.ErrorKinds) > 0 {
+		b.WriteString("\nerrors\n")
+		writeCounts(&b, r.ErrorKinds)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func latencyText(l Latency) string {
+	return fmt.Sprintf("min %.3f  mean %.3f  p50 %.3f  p90 %.3f  p95 %.3f  p99 %.3f  max %.3f", l.Min, l.Mean, l.P50, l.P90, l.P95, l.P99, l.Max)
+}
+
+// writeCounts lists counts, most frequent first.
+func writeCounts(b *strings.Builder, m map[string]int64) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(b, "  %8d  %s\n", m[k], k)
+	}
+}
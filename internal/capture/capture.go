@@ -0,0 +1,160 @@
+// Package capture records Modbus frames as they cross the wire, as
+// human-readable logs or pcapng files Wireshark can open.
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Transports of captured frames.
+const (
+	TCP = "tcp" // Modbus TCP: MBAP header and PDU
+	RTU = "rtu" // RTU on a serial line or RTU-over-TCP: slave id, PDU and CRC
+)
+
+// Directions of captured frames.
+const (
+	Request  = "request"
+	Response = "response"
+)
+
+// Frame is one Modbus frame seen on the wire.
+type Frame struct {
+	Time      time.Time
+	Transport string // TCP or RTU
+	Direction string // Request or Response
+	Src, Dst  string // sender and receiver, host:port or a line name
+	ADU       []byte
+}
+
+// Tap receives captured frames. Frames are not modified after the call.
+type Tap func(Frame)
+
+// PDU returns the protocol data unit of the frame, nil when the frame is
+// too short.
+func (f Frame) PDU() []byte {
+	switch f.Transport {
+	case TCP:
+		if len(f.ADU) > 7 {
+			return f.ADU[7:]
+		}
+	default:
+		if len(f.ADU) > 3 {
+			return f.ADU[1 : len(f.ADU)-2]
+		}
+	}
+	return nil
+}
+
+// Info is the decoded content of a frame. Fields that do not apply are -1.
+type Info struct {
+	Unit        byte
+	Transaction int // MBAP transaction id
+	Function    byte
+	Exception   byte // exception code of an exception response
+	Address     int
+	Quantity    int
+	Value       int // single coil or register write
+	ByteCount   int
+}
+
+var functionNames = map[byte]string{
+	0x01: "read_coils",
+	0x02: "read_discrete_inputs",
+	0x03: "read_holding_registers",
+	0x04: "read_input_registers",
+	0x05: "write_single_coil",
+	0x06: "write_single_register",
+	0x0F: "write_multiple_coils",
+	0x10: "write_multiple_registers",
+	0x16: "mask_write_register",
+	0x17: "read_write_multiple_registers",
+	0x2B: "read_device_identification",
+}
+
+// FunctionName names a function code, exception responses included.
+func FunctionName(code byte) string {
+	if name, ok := functionNames[code&0x7F]; ok {
+		return name
+	}
+	return fmt.Sprintf("function_0x%02x", code&0x7F)
+}
+
+// Decode extracts unit id, function, address and quantity from a frame.
+func Decode(f Frame) Info {
+	info := Info{Transaction: -1, Address: -1, Quantity: -1, Value: -1, ByteCount: -1}
+	if len(f.ADU) > 0 {
+		info.Unit = f.ADU[0]
+	}
+	if f.Transport == TCP && len(f.ADU) >= 7 {
+		info.Transaction = int(binary.BigEndian.Uint16(f.ADU[0:2]))
+		info.Unit = f.ADU[6]
+	}
+	pdu := f.PDU()
+	if len(pdu) == 0 {
+		return info
+	}
+	info.Function = pdu[0]
+	data := pdu[1:]
+	u16 := func(i int) int {
+		if len(data) < i+2 {
+			return -1
+		}
+		return int(binary.BigEndian.Uint16(data[i:]))
+	}
+	if info.Function&0x80 != 0 {
+		if len(data) > 0 {
+			info.Exception = data[0]
+		}
+		return info
+	}
+	switch info.Function {
+	case 0x01, 0x02, 0x03, 0x04:
+		if f.Direction == Request {
+			info.Address, info.Quantity = u16(0), u16(2)
+		} else if len(data) > 0 {
+			info.ByteCount = int(data[0])
+		}
+	case 0x05, 0x06:
+		info.Address, info.Value = u16(0), u16(2)
+	case 0x0F, 0x10:
+		info.Address, info.Quantity = u16(0), u16(2)
+		if f.Direction == Request && len(data) > 4 {
+			info.ByteCount = int(data[4])
+		}
+	case 0x16:
+		info.Address = u16(0)
+	case 0x17:
+		if f.Direction == Request {
+			info.Address, info.Quantity = u16(0), u16(2)
+		} else if len(data) > 0 {
+			info.ByteCount = int(data[0])
+		}
+	}
+	return info
+}
+
+// String renders the decoded fields as key=value pairs.
+func (i Info) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "unit=%d", i.Unit)
+	if i.Transaction >= 0 {
+		fmt.Fprintf(&b, " tid=%d", i.Transaction)
+	}
+	fmt.Fprintf(&b, " fc=0x%02x %s", i.Function, FunctionName(i.Function))
+	if i.Function&0x80 != 0 {
+		fmt.Fprintf(&b, " exception=0x%02x", i.Exception)
+	}
+	for _, kv := range []struct {
+		key string
+		v   int
+	}{{"addr", i.Address}, {"qty", i.Quantity}, {"value", i.Value}, {"bytes", i.ByteCount}} {
+		if kv.v >= 0 {
+			fmt.Fprintf(&b, " %s=%d", kv.key, kv.v)
+		}
+	}
+	return b.String()
+}
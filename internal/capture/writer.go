@@ -0,0 +1,245 @@
+package capture
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Output formats.
+const (
+	FormatLog    = "log"
+	FormatPcapng = "pcapng"
+)
+
+// Writer writes captured frames to w, one line per frame or as pcapng.
+// It is safe for concurrent use; Tap is its capture function.
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	pcap   *pcapng // nil for logs
+	err    error
+}
+
+// Create writes frames to the file path in format, "log" or "pcapng";
+// empty picks pcapng for .pcapng and .pcap files, else log.
+func Create(path, format string) (*Writer, error) {
+	if format == "" {
+		format = FormatLog
+		if ext := strings.ToLower(filepath.Ext(path)); ext == ".pcapng" || ext == ".pcap" {
+			format = FormatPcapng
+		}
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	var w *Writer
+	switch strings.ToLower(format) {
+	case FormatLog:
+		w = NewLog(f)
+	case FormatPcapng:
+		w, err = NewPcapng(f)
+	default:
+		err = fmt.Errorf("unsupported capture format %q", format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w.closer = f
+	return w, nil
+}
+
+// NewLog writes one human-readable line per frame to w.
+func NewLog(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// NewPcapng writes a pcapng capture to w. Modbus TCP frames are wrapped in
+// synthetic IPv4/TCP headers; RTU frames use the LINKTYPE_USER0 interface.
+func NewPcapng(w io.Writer) (*Writer, error) {
+	p := newPcapng()
+	if _, err := w.Write(p.header()); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, pcap: p}, nil
+}
+
+// Tap writes f. The first write error is kept and returned by Close.
+func (w *Writer) Tap(f Frame) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	var b []byte
+	if w.pcap != nil {
+		b = w.pcap.packet(f)
+	} else {
+		b = []byte(FormatLine(f) + "\n")
+	}
+	_, w.err = w.w.Write(b)
+}
+
+// Close closes the file of Create and reports the first write error.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.err
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+		w.closer = nil
+	}
+	return err
+}
+
+// FormatLine renders f as one log line: time, transport, direction,
+// endpoints, decoded fields and the raw frame in hex.
+func FormatLine(f Frame) string {
+	return fmt.Sprintf("%s %s %-8s %s > %s %s [% X]",
+		f.Time.UTC().Format("2006-01-02T15:04:05.000000Z"), f.Transport, f.Direction, f.Src, f.Dst, Decode(f), f.ADU)
+}
+
+// pcapng link types of the two interfaces written in every capture.
+const (
+	linkTypeRaw   = 101 // raw IPv4, for Modbus TCP
+	linkTypeUser0 = 147 // DLT_USER0, for RTU frames
+)
+
+// pcapng encodes frames as pcapng blocks, little endian.
+type pcapng struct {
+	seq map[string]uint32 // next TCP sequence number per direction
+	id  uint16            // IPv4 identification
+}
+
+func newPcapng() *pcapng {
+	return &pcapng{seq: map[string]uint32{}}
+}
+
+// header returns the section header and the interface descriptions.
+func (p *pcapng) header() []byte {
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:], 0x1A2B3C4D) // byte-order magic
+	binary.LittleEndian.PutUint16(shb[4:], 1)          // version 1.0
+	binary.LittleEndian.PutUint64(shb[8:], ^uint64(0)) // section length unknown
+	b := block(0x0A0D0D0A, shb)
+	for _, lt := range []uint16{linkTypeRaw, linkTypeUser0} {
+		idb := make([]byte, 8)
+		binary.LittleEndian.PutUint16(idb[0:], lt)
+		b = append(b, block(1, idb)...)
+	}
+	return b
+}
+
+// packet returns the enhanced packet block of f.
+func (p *pcapng) packet(f Frame) []byte {
+	iface, data := uint32(1), f.ADU
+	if f.Transport == TCP {
+		iface, data = 0, p.tcpPacket(f)
+	}
+	us := uint64(f.Time.UnixMicro())
+	epb := make([]byte, 20, 20+len(data)+3)
+	binary.LittleEndian.PutUint32(epb[0:], iface)
+	binary.LittleEndian.PutUint32(epb[4:], uint32(us>>32))
+	binary.LittleEndian.PutUint32(epb[8:], uint32(us))
+	binary.LittleEndian.PutUint32(epb[12:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(epb[16:], uint32(len(data)))
+	epb = append(epb, data...)
+	return block(6, epb)
+}
+
+// tcpPacket wraps the ADU of f in IPv4 and TCP headers between its
+// endpoints, with sequence numbers continuing per direction.
+func (p *pcapng) tcpPacket(f Frame) []byte {
+	srcIP, srcPort := splitEndpoint(f.Src)
+	dstIP, dstPort := splitEndpoint(f.Dst)
+	fwd, rev := f.Src+">"+f.Dst, f.Dst+">"+f.Src
+	seq, ack := p.seq[fwd]+1, p.seq[rev]+1
+	p.seq[fwd] += uint32(len(f.ADU))
+
+	tcp := make([]byte, 20, 20+len(f.ADU))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = 0x18 // PSH, ACK
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	tcp = append(tcp, f.ADU...)
+	pseudo := make([]byte, 0, 12+len(tcp))
+	pseudo = append(pseudo, srcIP...)
+	pseudo = append(pseudo, dstIP...)
+	pseudo = append(pseudo, 0, 6, byte(len(tcp)>>8), byte(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], checksum(append(pseudo, tcp...)))
+
+	p.id++
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	binary.BigEndian.PutUint16(ip[4:], p.id)
+	ip[6] = 0x40 // don't fragment
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], srcIP)
+	copy(ip[16:], dstIP)
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip))
+	return append(ip, tcp...)
+}
+
+// splitEndpoint parses host:port; endpoints that are not IPv4 map to
+// 0.0.0.0.
+func splitEndpoint(addr string) (net.IP, uint16) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return net.IPv4zero.To4(), 0
+	}
+	ip := net.ParseIP(host).To4()
+	if ip == nil {
+		ip = net.IPv4zero.To4()
+	}
+	n, _ := strconv.ParseUint(port, 10, 16)
+	return ip, uint16(n)
+}
+
+// checksum is the Internet checksum of b.
+func checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+	return ^uint16(sum)
+}
+
+// block frames body, padded to 32 bits, as a pcapng block of type t.
+func block(t uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	n := uint32(12 + len(body))
+	b := make([]byte, 8, n)
+	binary.LittleEndian.PutUint32(b[0:], t)
+	binary.LittleEndian.PutUint32(b[4:], n)
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, n)
+}
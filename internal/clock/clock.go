@@ -0,0 +1,292 @@
+// Package clock provides the time source shared by the simulators and,
+// optionally, the collector scheduler: the wall clock, or a simulated clock
+// that can run faster than real time, be paused and be stepped
+// deterministically from tests.
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a source of time.
+type Clock interface {
+	Now() time.Time
+	// Every calls fn with the clock time every d until ctx is done or stop
+	// is called. The first call happens d after Every. Calls of one fn
+	// never overlap; ticks missed while fn runs are dropped.
+	Every(ctx context.Context, d time.Duration, fn func(now time.Time)) (stop func())
+}
+
+// Wall returns the real-time clock.
+func Wall() Clock { return wall{} }
+
+type wall struct{}
+
+func (wall) Now() time.Time { return time.Now() }
+
+func (wall) Every(ctx context.Context, d time.Duration, fn func(time.Time)) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	}()
+	return cancel
+}
+
+// Sim is a simulated clock. While running, simulated time advances at
+// Speed times wall time and timers fire on their own goroutines. While
+// paused, time only advances through Step, which fires due timers in
+// order on the caller's goroutine, so tests are reproducible.
+type Sim struct {
+	mu      sync.Mutex
+	base    time.Time // simulated time at wallRef
+	wallRef time.Time
+	speed   float64
+	paused  bool
+	changed chan struct{} // closed and replaced on every speed, pause or step change
+	timers  []*simTimer
+	seq     int
+}
+
+type simTimer struct {
+	clock  *Sim
+	ctx    context.Context
+	period time.Duration
+	next   time.Time
+	seq    int
+	fn     func(time.Time)
+	run    sync.Mutex // serializes fn
+	done   bool
+}
+
+// NewSim returns a running simulated clock starting at start; a zero
+// start means now. speed <= 0 means 1.
+func NewSim(start time.Time, speed float64) *Sim {
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if speed <= 0 {
+		speed = 1
+	}
+	return &Sim{base: start, wallRef: time.Now(), speed: speed, changed: make(chan struct{})}
+}
+
+// NewManual returns a paused simulated clock at start, advanced only by
+// Step and Set.
+func NewManual(start time.Time) *Sim {
+	c := NewSim(start, 1)
+	c.paused = true
+	return c
+}
+
+// Now returns the current simulated time.
+func (c *Sim) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+func (c *Sim) nowLocked() time.Time {
+	if c.paused {
+		return c.base
+	}
+	return c.base.Add(time.Duration(float64(time.Since(c.wallRef)) * c.speed))
+}
+
+// rebaseLocked folds the elapsed wall time into base before a change.
+func (c *Sim) rebaseLocked() {
+	c.base = c.nowLocked()
+	c.wallRef = time.Now()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// Speed returns the speed factor.
+func (c *Sim) Speed() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speed
+}
+
+// SetSpeed changes the speed factor from now on; speed <= 0 is ignored.
+func (c *Sim) SetSpeed(speed float64) {
+	if speed <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebaseLocked()
+	c.speed = speed
+}
+
+// Paused reports whether the clock is paused.
+func (c *Sim) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Pause stops simulated time.
+func (c *Sim) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		c.rebaseLocked()
+		c.paused = true
+	}
+}
+
+// Resume lets simulated time run again.
+func (c *Sim) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		c.rebaseLocked()
+		c.paused = false
+	}
+}
+
+// Step pauses the clock and advances it by d, calling every timer that
+// falls due on the way in time order (registration order for equal
+// deadlines) with its deadline as the time. It returns the new time.
+func (c *Sim) Step(d time.Duration) time.Time {
+	c.Pause()
+	c.mu.Lock()
+	target := c.base.Add(d)
+	c.mu.Unlock()
+	return c.advance(target)
+}
+
+// Set pauses the clock and moves it to t; timers due before t fire as in
+// Step. Moving backwards does not fire timers.
+func (c *Sim) Set(t time.Time) time.Time {
+	c.Pause()
+	return c.advance(t)
+}
+
+func (c *Sim) advance(target time.Time) time.Time {
+	for {
+		c.mu.Lock()
+		t := c.dueLocked(target)
+		if t == nil {
+			c.base = target
+			c.mu.Unlock()
+			return target
+		}
+		at := t.next
+		c.base = at
+		c.mu.Unlock()
+
+		t.fire(at, at)
+	}
+}
+
+// dueLocked returns the earliest live timer due at or before target.
+func (c *Sim) dueLocked(target time.Time) *simTimer {
+	var due *simTimer
+	for _, t := range c.timers {
+		if t.done || t.ctx.Err() != nil || t.next.After(target) {
+			continue
+		}
+		if due == nil || t.next.Before(due.next) || (t.next.Equal(due.next) && t.seq < due.seq) {
+			due = t
+		}
+	}
+	return due
+}
+
+// Every implements Clock.
+func (c *Sim) Every(ctx context.Context, d time.Duration, fn func(time.Time)) func() {
+	if d <= 0 {
+		d = time.Second
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	c.mu.Lock()
+	c.seq++
+	t := &simTimer{clock: c, ctx: ctx, period: d, next: c.nowLocked().Add(d), seq: c.seq, fn: fn}
+	c.timers = append(c.timers, t)
+	c.mu.Unlock()
+	go t.loop()
+	return cancel
+}
+
+// fire calls fn for the given deadline with the clock time now and
+// schedules the next deadline, dropping the ones that already passed. A
+// deadline already handled by a concurrent fire is skipped.
+func (t *simTimer) fire(deadline, now time.Time) {
+	t.run.Lock()
+	defer t.run.Unlock()
+	c := t.clock
+	c.mu.Lock()
+	stale := !t.next.Equal(deadline)
+	c.mu.Unlock()
+	if stale {
+		return
+	}
+	t.fn(now)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.next = deadline.Add(t.period)
+	if cur := c.nowLocked(); !t.next.After(cur) {
+		t.next = cur.Add(t.period)
+	}
+}
+
+// loop fires the timer while the clock is running.
+func (t *simTimer) loop() {
+	c := t.clock
+	for {
+		c.mu.Lock()
+		if t.ctx.Err() != nil {
+			t.done = true
+			c.removeLocked(t)
+			c.mu.Unlock()
+			return
+		}
+		var wait <-chan time.Time
+		var timer *time.Timer
+		due := false
+		now, deadline := c.nowLocked(), t.next
+		if !c.paused {
+			if !now.Before(deadline) {
+				due = true
+			} else {
+				timer = time.NewTimer(time.Duration(float64(deadline.Sub(now)) / c.speed))
+				wait = timer.C
+			}
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		if due {
+			t.fire(deadline, now)
+			continue
+		}
+		select {
+		case <-t.ctx.Done():
+		case <-changed:
+		case <-wait:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (c *Sim) removeLocked(t *simTimer) {
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].seq >= t.seq })
+	if i < len(c.timers) && c.timers[i] == t {
+		c.timers = append(c.timers[:i], c.timers[i+1:]...)
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"time"
 
 	mb "github.com/goburrow/modbus"
+	"modbus-simulator/internal/capture"
+	"modbus-simulator/internal/clock"
+	utils "modbus-simulator/internal/utils"
 )
 
 // PointValue represents a decoded reading from a point.
@@ -43,6 +46,8 @@ type Collector struct {
 	Server  ServerConfig
 	Device  Device
 	Handler ResultHandler
+	Clock   clock.Clock // schedules polls and stamps values; nil means the wall clock
+	Tap     capture.Tap // receives every frame sent and received, nil disables
 
 	// generic handler for TCP or RTU
 	handler  handlerWithConn
@@ -56,53 +61,73 @@ type handlerWithConn interface {
 	Close() error
 }
 
-// newHandler creates and configures a handler for TCP or RTU based on config.
+// newHandler creates and configures a handler for TCP, RTU, RTU-over-TCP or TLS
+// based on the server config, addressing slave.
 // It returns the handler and a human-readable address for logs.
-func (c *Collector) newHandler() (handlerWithConn, string, error) {
-	proto := strings.ToLower(strings.TrimSpace(c.Server.Protocol))
-	timeout := c.Server.Timeout
+func newHandler(server ServerConfig, slave uint8) (handlerWithConn, string, error) {
+	proto := strings.ToLower(strings.TrimSpace(server.Protocol))
+	timeout := server.Timeout
 	if timeout <= 0 {
 		timeout = 5 * time.Second
 	}
 	switch proto {
 	case "modbus-tcp", "tcp":
-		address := fmt.Sprintf("%s:%d", c.Server.Connection.Host, c.Server.Connection.Port)
+		address := fmt.Sprintf("%s:%d", server.Connection.Host, server.Connection.Port)
 		h := mb.NewTCPClientHandler(address)
 		h.Timeout = timeout
-		h.SlaveId = c.Device.SlaveID
+		h.SlaveId = slave
 		return h, address, nil
 	case "modbus-rtu", "rtu":
-		port := c.Server.Connection.SerialPort
+		port := server.Connection.SerialPort
 		if strings.TrimSpace(port) == "" {
 			return nil, "", fmt.Errorf("serial_port is required for RTU")
 		}
+		if utils.IsMemSerial(port) {
+			h := newMemSerialHandler(port, timeout, slave, server.Connection.Echo)
+			return h, port, nil
+		}
 		h := mb.NewRTUClientHandler(port)
-		if c.Server.Connection.BaudRate > 0 {
-			h.BaudRate = c.Server.Connection.BaudRate
+		if server.Connection.BaudRate > 0 {
+			h.BaudRate = server.Connection.BaudRate
 		}
-		if c.Server.Connection.DataBits > 0 {
-			h.DataBits = c.Server.Connection.DataBits
+		if server.Connection.DataBits > 0 {
+			h.DataBits = server.Connection.DataBits
 		}
-		if c.Server.Connection.StopBits > 0 {
-			h.StopBits = c.Server.Connection.StopBits
+		if server.Connection.StopBits > 0 {
+			h.StopBits = server.Connection.StopBits
 		}
-		if p := strings.ToUpper(strings.TrimSpace(c.Server.Connection.Parity)); p != "" {
+		if p := strings.ToUpper(strings.TrimSpace(server.Connection.Parity)); p != "" {
 			h.Parity = p
 		}
 		h.Timeout = timeout
-		h.SlaveId = c.Device.SlaveID
+		h.SlaveId = slave
 		return h, port, nil
+	case "rtu-over-tcp", "rtu_over_tcp", "modbus-rtu-over-tcp":
+		address := fmt.Sprintf("%s:%d", server.Connection.Host, server.Connection.Port)
+		h := newRTUOverTCPHandler(address, timeout, slave, server.Connection.Echo)
+		return h, address, nil
+	case "modbus-tls", "tls", "mbaps":
+		if server.Connection.TLS == nil {
+			return nil, "", fmt.Errorf("protocol %s requires connection.tls", server.Protocol)
+		}
+		config, err := server.Connection.TLS.ClientConfig(server.Connection.Host)
+		if err != nil {
+			return nil, "", err
+		}
+		address := fmt.Sprintf("%s:%d", server.Connection.Host, server.Connection.Port)
+		return newTLSHandler(address, timeout, slave, config), address, nil
 	default:
-		return nil, "", fmt.Errorf("protocol %s not implemented", c.Server.Protocol)
+		return nil, "", fmt.Errorf("protocol %s not implemented", server.Protocol)
 	}
 }
 
 func (c *Collector) Run(ctx context.Context) error {
 	// Build handler based on protocol
-	h, addr, err := c.newHandler()
+	h, addr, err := newHandler(c.Server, c.Device.SlaveID)
 	if err != nil {
 		return err
 	}
+	h = wrapFrames(h, c.Server, addr, c.Tap)
 	c.handler = h
 	c.connAddr = addr
 
@@ -133,24 +158,43 @@ func (c *Collector) Run(ctx context.Context) error {
 	if interval <= 0 {
 		interval = 5 * time.Second
 	}
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	// Immediate first run
+	if c.Clock == nil {
+		c.Clock = clock.Wall()
+	}
+	// Immediate first run, before the timer so polls never overlap on the
+	// client
 	if err := c.pollOnce(ctx, client); err != nil {
 		log.Printf("collector %s/%s initial poll: %v", c.Server.ServerID, c.Device.DeviceID, err)
 	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-ticker.C:
-			if err := c.pollOnce(ctx, client); err != nil {
-				log.Printf("collector %s/%s poll: %v", c.Server.ServerID, c.Device.DeviceID, err)
-			}
+	stop := c.Clock.Every(ctx, interval, func(time.Time) {
+		if err := c.pollOnce(ctx, client); err != nil {
+			log.Printf("collector %s/%s poll: %v", c.Server.ServerID, c.Device.DeviceID, err)
+		}
+	})
+	defer stop()
+
+	<-ctx.Done()
+	return nil
+}
+
+// wrapFrames taps the frames of h when tap is set and paces them on RTU
+// lines.
+func wrapFrames(h handlerWithConn, server ServerConfig, addr string, tap capture.Tap) handlerWithConn {
+	proto := strings.ToLower(strings.TrimSpace(server.Protocol))
+	rtu := proto != "modbus-tcp" && proto != "tcp" && !isTLSProtocol(proto)
+	if tap != nil {
+		transport := capture.TCP
+		if rtu {
+			transport = capture.RTU
 		}
+		h = tapHandler{h, tap, transport, addr}
+	}
+	if rtu {
+		// RTU frames keep the line's frame delay
+		h = pacedHandler{h, pacerFor(addr, frameDelay(server.Connection))}
 	}
+	return h
 }
 
 func (c *Collector) pollOnce(ctx context.Context, client mb.Client) error {
@@ -199,7 +243,7 @@ func (c *Collector) readPoint(client mb.Client, p Point) (PointValue, error) {
 		DataType:   dt,
 		ByteOrder:  bo,
 		Unit:       p.Unit,
-		Timestamp:  time.Now(),
+		Timestamp:  c.Clock.Now(),
 	}
 
 	switch rt {
@@ -252,6 +296,23 @@ func (c *Collector) readPoint(client mb.Client, p Point) (PointValue, error) {
 	}
 }
 
+// DecodeRegisters decodes the register bytes of a holding or input point
+// the way the collector does when polling it: data_type and byte_order
+// select the encoding, then scale and offset are reversed.
+func DecodeRegisters(p Point, data []byte) (PointValue, error) {
+	dt := strings.ToLower(p.DataType)
+	bo := strings.ToUpper(p.ByteOrder)
+	pv := PointValue{
+		PointName: p.Name,
+		Address:   p.Address,
+		Register:  strings.ToLower(p.RegisterType),
+		DataType:  dt,
+		ByteOrder: bo,
+		Unit:      p.Unit,
+	}
+	return decodeRegisterData(pv, data, dt, bo, p)
+}
+
 func decodeRegisterData(pv PointValue, data []byte, dt, bo string, p Point) (PointValue, error) {
 	scale := p.Scale
 	if scale == 0 {
@@ -288,7 +349,7 @@ func decodeRegisterData(pv PointValue, data []byte, dt, bo string, p Point) (Poi
 		if len(data) < 4 {
 			return pv, errors.New("insufficient data for float32")
 		}
-		b := reorder32(data[:4], bo)
+		b := Reorder32(data[:4], bo)
 		u := binary.BigEndian.Uint32(b)
 		f := math.Float32frombits(u)
 		pv.Raw = f
@@ -298,7 +359,7 @@ func decodeRegisterData(pv PointValue, data []byte, dt, bo string, p Point) (Poi
 		if len(data) < 4 {
 			return pv, errors.New("insufficient data for uint32")
 		}
-		b := reorder32(data[:4], bo)
+		b := Reorder32(data[:4], bo)
 		u := binary.BigEndian.Uint32(b)
 		pv.Raw = u
 		pv.Value = applyCalibration(float64(u))
@@ -307,7 +368,7 @@ func decodeRegisterData(pv PointValue, data []byte, dt, bo string, p Point) (Poi
 		if len(data) < 4 {
 			return pv, errors.New("insufficient data for int32")
 		}
-		b := reorder32(data[:4], bo)
+		b := Reorder32(data[:4], bo)
 		u := binary.BigEndian.Uint32(b)
 		i := int32(u)
 		pv.Raw = i
@@ -318,9 +379,10 @@ func decodeRegisterData(pv PointValue, data []byte, dt, bo string, p Point) (Poi
 	}
 }
 
-// reorder32 returns a 4-byte slice reordered per byte-order string.
+// Reorder32 returns a 4-byte slice reordered per byte-order string. Every
+// order is its own inverse, so the simulators use it to encode as well.
 // Supported orders: "ABCD" (default), "DCBA", "BADC" (byte swap within words), "CDAB" (word swap).
-func reorder32(in []byte, order string) []byte {
+func Reorder32(in []byte, order string) []byte {
 	var out [4]byte
 	if len(in) < 4 {
 		return append([]byte{}, in...)
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"gopkg.in/yaml.v3"
+	"modbus-simulator/internal/clock"
 )
 
 // Root configuration for the concurrent collector manager.
@@ -21,6 +22,9 @@ type RootConfig struct {
 	System    SystemConfig             `yaml:"system"`
 	Frequency map[string]time.Duration `yaml:"frequency"`
 	Servers   []ServerConfig           `yaml:"servers"`
+	// Templates lists device profile files or directories, relative to the
+	// config file, that devices can use besides the built-in profiles.
+	Templates []string `yaml:"templates"`
 }
 
 type SystemConfig struct {
@@ -29,45 +33,273 @@ type SystemConfig struct {
 		MaxWorkers   int  `yaml:"max_workers"`
 		MaxQueueSize int  `yaml:"max_queue_size"`
 	} `yaml:"processing"`
-	Storage struct {
-		Enabled             bool          `yaml:"enabled"`
-		FileType            string        `yaml:"file_type"`
-		DBPath              string        `yaml:"db_path"`
-		CacheTTL            time.Duration `yaml:"cache_ttl"`
-		ResultAnalysisCount int           `yaml:"result_analysis_count"`
-		MaxWorkers          int           `yaml:"max_workers"`
-		MaxQueueSize        int           `yaml:"max_queue_size"`
-		LatestSnapshot      struct {
-			Enabled  bool          `yaml:"enabled"`
-			Interval time.Duration `yaml:"interval"`
-		} `yaml:"latest_snapshot"`
-	} `yaml:"storage"`
+	Storage     StorageConfig     `yaml:"storage"`
+	Clock       ClockConfig       `yaml:"clock"`
+	Persistence PersistenceConfig `yaml:"persistence"`
+	Capture     CaptureConfig     `yaml:"capture"`
 }
 
-type ServerConfig struct {
-	ServerID    string        `yaml:"server_id"`
-	ServerName  string        `yaml:"server_name"`
-	Protocol    string        `yaml:"protocol"` // modbus-tcp | modbus-rtu
-	Connection  Connection    `yaml:"connection"`
-	Timeout     time.Duration `yaml:"timeout"`
-	RetryCount  int           `yaml:"retry_count"`
+// CaptureConfig records every Modbus frame (system.capture): the
+// simulators write <dir>/servers.<ext>, the collector <dir>/collector.<ext>.
+type CaptureConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Format  string `yaml:"format"` // log (default) | pcapng
+	Dir     string `yaml:"dir"`    // default data/capture
+}
+
+// Path returns the capture file of name, "servers" or "collector".
+func (c CaptureConfig) Path(name string) string {
+	dir := c.Dir
+	if dir == "" {
+		dir = filepath.Join("data", "capture")
+	}
+	ext := ".log"
+	if strings.EqualFold(c.Format, "pcapng") {
+		ext = ".pcapng"
+	}
+	return filepath.Join(dir, name+ext)
+}
+
+// PersistenceConfig makes the simulated servers keep their retentive
+// registers across restarts (system.persistence). State is restored at
+// startup and saved every Interval, on shutdown and, with OnWrite, after
+// every client write.
+type PersistenceConfig struct {
+	Enabled   bool             `yaml:"enabled"`
+	Backend   string           `yaml:"backend"`   // file (default) | sqlite
+	Path      string           `yaml:"path"`      // state directory or SQLite file, default data/state or data/state.db
+	Interval  time.Duration    `yaml:"interval"`  // periodic save, default 10s; negative disables
+	OnWrite   bool             `yaml:"on_write"`  // also save after every client write
+	Retentive []RetentiveRange `yaml:"retentive"` // default: the declared holding and coil points
+}
+
+// RetentiveRange selects registers that survive a restart. Without count
+// the range extends to the end of the table.
+type RetentiveRange struct {
+	Table string `yaml:"table"` // holding | input | coil | discrete
+	Start uint16 `yaml:"start"`
+	Count int    `yaml:"count"`
+}
+
+// ClockConfig sets the simulation clock (system.clock) used by the
+// simulated servers and, with Collector, by the collector scheduler.
+type ClockConfig struct {
+	Speed     float64   `yaml:"speed"`     // simulated seconds per wall second; 0 means 1
+	Start     time.Time `yaml:"start"`     // initial simulated time; zero means now
+	Collector bool      `yaml:"collector"` // poll on the simulation clock too
+}
+
+// NewClock returns the configured clock: the wall clock unless a speed or
+// start time is set.
+func (c ClockConfig) NewClock() clock.Clock {
+	if (c.Speed == 0 || c.Speed == 1) && c.Start.IsZero() {
+		return clock.Wall()
+	}
+	return clock.NewSim(c.Start, c.Speed)
+}
+
+// StorageConfig controls the collector outputs (system.storage).
+type StorageConfig struct {
+	Enabled             bool          `yaml:"enabled"`
+	FileType            string        `yaml:"file_type"`
+	DBPath              string        `yaml:"db_path"`
+	CacheTTL            time.Duration `yaml:"cache_ttl"`
+	ResultAnalysisCount int           `yaml:"result_analysis_count"`
+	MaxQueueSize        int           `yaml:"max_queue_size"`
+	BatchSize           int           `yaml:"batch_size"`     // values per sink write/transaction
+	FlushInterval       time.Duration `yaml:"flush_interval"` // max time a partial batch waits
+	LatestSnapshot      struct {
+		Enabled  bool          `yaml:"enabled"`
+		Interval time.Duration `yaml:"interval"`
+	} `yaml:"latest_snapshot"`
+	Buffer    BufferConfig    `yaml:"buffer"`
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// RetentionConfig enables the rollup and pruning job for the SQLite history
+// (system.storage.retention). Unset durations take the defaults below; a
+// negative duration keeps that table forever.
+type RetentionConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Raw      time.Duration `yaml:"raw"`       // point_values, default 168h
+	Minute   time.Duration `yaml:"rollup_1m"` // point_values_1m, default 2160h
+	Hour     time.Duration `yaml:"rollup_1h"` // point_values_1h, default forever
+	Interval time.Duration `yaml:"interval"`  // job period, default 1m
+	Lateness time.Duration `yaml:"lateness"`  // delay before a minute is rolled up, default 2m
+}
+
+// BufferConfig enables the on-disk write-ahead buffer between Storage.Handle
+// and the sinks (system.storage.buffer).
+type BufferConfig struct {
 	Enabled     bool          `yaml:"enabled"`
-	DevicesType string        `yaml:"type"`
-	DevicesFile string        `yaml:"devices_file"`
-	CSVFile     string        `yaml:"csv_file"` // CSV file for simulation data
-	Devices     []Device      `yaml:"devices"`
+	Dir         string        `yaml:"dir"`          // default: <storage dir>/wal
+	SegmentSize int64         `yaml:"segment_size"` // bytes per segment file, default 4MiB
+	MaxBytes    int64         `yaml:"max_bytes"`    // oldest segments are dropped above this, default 256MiB
+	MaxAge      time.Duration `yaml:"max_age"`      // segments older than this are dropped, default 24h
+	Sync        bool          `yaml:"sync"`         // fsync after every append
+}
+
+type ServerConfig struct {
+	ServerID    string           `yaml:"server_id"`
+	ServerName  string           `yaml:"server_name"`
+	Protocol    string           `yaml:"protocol"` // modbus-tcp | modbus-rtu | rtu-over-tcp | modbus-tls
+	Connection  Connection       `yaml:"connection"`
+	Timeout     time.Duration    `yaml:"timeout"`
+	RetryCount  int              `yaml:"retry_count"`
+	Enabled     bool             `yaml:"enabled"`
+	DevicesType string           `yaml:"type"`
+	DevicesFile string           `yaml:"devices_file"`
+	CSVFile     string           `yaml:"csv_file"` // CSV file for simulation data
+	Playback    PlaybackConfig   `yaml:"playback"` // how the simulators play csv_file or replay
+	Replay      *ReplayConfig    `yaml:"replay,omitempty"`
+	Scenario    *ScenarioConfig  `yaml:"scenario,omitempty"`
+	Retentive   []RetentiveRange `yaml:"retentive"`  // overrides system.persistence.retentive
+	SlaveMaps   bool             `yaml:"slave_maps"` // simulators keep one register map per device slave_id
+	Devices     []Device         `yaml:"devices"`
+}
+
+// PlaybackConfig controls CSV playback in the simulators. Without a
+// timestamp column one row is played per tick (the server frequency).
+type PlaybackConfig struct {
+	TimestampColumn string        `yaml:"timestamp_column"` // play rows at their recorded times
+	TimestampFormat string        `yaml:"timestamp_format"` // Go layout, unix or unix_ms; default: common layouts
+	Speed           float64       `yaml:"speed"`            // playback speed multiplier, default 1
+	Interpolation   string        `yaml:"interpolation"`    // step (default) | linear
+	Mode            string        `yaml:"mode"`             // loop (default) | once | ping_pong
+	StartOffset     time.Duration `yaml:"start_offset"`     // position in the recording to start from
+	Tick            time.Duration `yaml:"tick"`             // register update period in timestamp mode, default 1s
+}
+
+// ReplayConfig makes the simulators replay a collector recording instead of
+// csv_file. Records are matched to points by device_id and point name and
+// played at their recorded times following playback.
+type ReplayConfig struct {
+	File     string `yaml:"file"`      // collector.jsonl, collector.csv or the SQLite database
+	Format   string `yaml:"format"`    // jsonl | csv | db; default from the file extension
+	ServerID string `yaml:"server_id"` // recorded server to replay, default this server's server_id
+}
+
+// ScenarioConfig scripts a simulated plant: timeline steps at offsets from
+// the start, rules reacting to client writes and to conditions on points,
+// and process models driving points. Points are referenced as
+// "device_id/point" or by a point name that is unique on the server.
+type ScenarioConfig struct {
+	File     string               `yaml:"file"`   // YAML file with the same fields, loaded before the inline entries
+	Tick     time.Duration        `yaml:"tick"`   // evaluation and integration step, default 100ms
+	Loop     bool                 `yaml:"loop"`   // restart the timeline every period
+	Period   time.Duration        `yaml:"period"` // timeline length when looping, default the last step
+	Timeline []ScenarioStep       `yaml:"timeline"`
+	Rules    []ScenarioRule       `yaml:"rules"`
+	Models   []ProcessModelConfig `yaml:"models"`
+}
+
+// ScenarioStep runs its action once the scenario has been running for At.
+type ScenarioStep struct {
+	At             time.Duration `yaml:"at"`
+	ScenarioAction `yaml:",inline"`
+}
+
+// ScenarioAction is what a timeline step or rule does.
+type ScenarioAction struct {
+	Set        map[string]float64 `yaml:"set"`         // engineering values by point reference
+	Fault      *FaultConfig       `yaml:"fault"`       // fault to inject
+	ClearFault string             `yaml:"clear_fault"` // point reference, "comm" or "all"
+	Log        string             `yaml:"log"`         // message written to the server log
+}
+
+// ScenarioRule runs Then when a client writes OnWrite (if set) while all
+// When conditions hold. Rules without OnWrite are edge triggered: Then runs
+// once the conditions have held for For, Else once they stop holding.
+type ScenarioRule struct {
+	Name    string              `yaml:"name"`
+	OnWrite string              `yaml:"on_write"`
+	When    []ScenarioCondition `yaml:"when"`
+	For     time.Duration       `yaml:"for"`
+	Then    *ScenarioAction     `yaml:"then"`
+	Else    *ScenarioAction     `yaml:"else"`
+}
+
+// ScenarioCondition compares the current value of a point with Value.
+type ScenarioCondition struct {
+	Point string  `yaml:"point"`
+	Op    string  `yaml:"op"` // > (default) | >= | < | <= | == | !=
+	Value float64 `yaml:"value"`
+}
+
+// FaultConfig injects a point or communication fault. Point faults alter
+// every value written to the point: stuck replaces it with Value, bias adds
+// Value, noise adds Gaussian noise with standard deviation Value and freeze
+// keeps the register unchanged. Without Point the whole server fails:
+// no_response drops requests, exception answers with code Value (default 4)
+// and delay answers after Delay.
+type FaultConfig struct {
+	Point    string        `yaml:"point"`
+	Type     string        `yaml:"type"`
+	Value    float64       `yaml:"value"`
+	Delay    time.Duration `yaml:"delay"`
+	Duration time.Duration `yaml:"duration"` // cleared automatically after this long; 0 keeps it until clear_fault
+}
+
+// ProcessModelConfig is a small dynamic model whose state is written to
+// Output every tick. Inputs contribute gain*value; the output is limited to
+// [Min, Max] when Max > Min.
+//
+//	first_order: output follows Gain*(Bias+inputs) with time constant TimeConstant
+//	integrator (tank): output changes by Bias+inputs per second
+//	pid: output drives Process towards Setpoint (or SetpointPoint)
+type ProcessModelConfig struct {
+	Name          string        `yaml:"name"`
+	Type          string        `yaml:"type"`
+	Output        string        `yaml:"output"`
+	Inputs        []ModelInput  `yaml:"inputs"`
+	Bias          float64       `yaml:"bias"`
+	Gain          float64       `yaml:"gain"`          // first_order: static gain, default 1
+	TimeConstant  time.Duration `yaml:"time_constant"` // first_order: default 10s
+	Initial       float64       `yaml:"initial"`
+	Min           float64       `yaml:"min"`
+	Max           float64       `yaml:"max"`
+	Process       string        `yaml:"process"`        // pid: measured point
+	Setpoint      float64       `yaml:"setpoint"`       // pid: fixed setpoint
+	SetpointPoint string        `yaml:"setpoint_point"` // pid: point holding the setpoint
+	Kp            float64       `yaml:"kp"`
+	Ki            float64       `yaml:"ki"`      // per second
+	Kd            float64       `yaml:"kd"`      // seconds
+	Reverse       bool          `yaml:"reverse"` // pid: output rises when process is above setpoint
+}
+
+// ModelInput is one term of a process model input.
+type ModelInput struct {
+	Point string  `yaml:"point"`
+	Gain  float64 `yaml:"gain"` // default 1
 }
 
 type Connection struct {
 	// TCP
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
-	// RTU (not yet implemented)
+	// RTU
 	SerialPort string `yaml:"serial_port"`
 	BaudRate   int    `yaml:"baud_rate"`
 	DataBits   int    `yaml:"data_bits"`
 	StopBits   int    `yaml:"stop_bits"`
 	Parity     string `yaml:"parity"`
+	// Simulators only: create serial_port and its peer socat_peer as a
+	// virtual serial pair with socat before opening serial_port.
+	SpawnSocat bool   `yaml:"spawn_socat"`
+	SocatPeer  string `yaml:"socat_peer"`
+	// Simulators only, RTU line timing at baud_rate: frame_gaps delimits
+	// requests by 3.5-character silences, turnaround delays answers, echo
+	// lets every master hear all traffic and collisions loses overlapping
+	// requests of several masters.
+	FrameGaps  bool          `yaml:"frame_gaps"`
+	Turnaround time.Duration `yaml:"turnaround"`
+	Echo       bool          `yaml:"echo"`
+	Collisions bool          `yaml:"collisions"`
+	// Collector only: minimum silence between frames on an RTU line,
+	// default 3.5 characters at baud_rate; negative disables.
+	FrameDelay time.Duration `yaml:"frame_delay"`
+	// Certificates of protocol modbus-tls (Modbus/TCP Security).
+	TLS *TLSConfig `yaml:"tls,omitempty"`
 }
 
 type Device struct {
@@ -76,6 +308,13 @@ type Device struct {
 	SlaveID      uint8         `yaml:"slave_id"`
 	PollInterval time.Duration `yaml:"poll_interval"`
 	Points       []Point       `yaml:"points"`
+	// Script runs custom device behaviour in the simulators.
+	Script *ScriptConfig `yaml:"script,omitempty"`
+	// Template names the device profile the device is built from; Points
+	// then override or extend the profile points. See ApplyProfile.
+	Template      string   `yaml:"template,omitempty"`
+	AddressOffset int      `yaml:"address_offset,omitempty"` // added to the template point addresses
+	Select        []string `yaml:"select,omitempty"`         // template points to keep, default all
 }
 
 type Point struct {
@@ -87,6 +326,56 @@ type Point struct {
 	Scale        float64 `yaml:"scale"`
 	Offset       float64 `yaml:"offset"`
 	Unit         string  `yaml:"unit"`
+	// Generator drives the point in the simulators instead of CSV playback.
+	// The collector ignores it.
+	Generator *GeneratorConfig `yaml:"generator,omitempty"`
+	// Script drives the point in the simulators from a Lua script.
+	Script *ScriptConfig `yaml:"script,omitempty"`
+	// Initial is the engineering value the simulators start the point at.
+	Initial *float64 `yaml:"initial,omitempty"`
+}
+
+// ScriptConfig references a Lua script run by the simulators. The script
+// may define on_tick(t, dt), called every Tick, and on_write(name, value),
+// called after a client writes one of the device's points. A point script
+// only sees writes to its point, and a number returned by its on_tick
+// becomes the point value.
+type ScriptConfig struct {
+	File    string        `yaml:"file"`    // script file, relative to the config file
+	Code    string        `yaml:"code"`    // inline source, used when file is empty
+	Tick    time.Duration `yaml:"tick"`    // on_tick period, default 1s
+	Timeout time.Duration `yaml:"timeout"` // maximum run time of one hook call, default 100ms
+}
+
+// GeneratorConfig describes a synthetic value source for a simulated point.
+// Values are engineering values; the simulator applies scale/offset when
+// encoding them into registers, exactly like CSV values.
+type GeneratorConfig struct {
+	// constant | ramp | sine | square | sawtooth | triangle | random_walk |
+	// noise | steps | counter
+	Type      string           `yaml:"type"`
+	Value     float64          `yaml:"value"`     // constant value; start value of ramp/random_walk/counter
+	Min       float64          `yaml:"min"`       // lower bound (ramp/random_walk), or waveform low when amplitude is 0
+	Max       float64          `yaml:"max"`       // upper bound (ramp/random_walk), or waveform high when amplitude is 0
+	Amplitude float64          `yaml:"amplitude"` // waveforms: peak deviation from center
+	Center    float64          `yaml:"center"`    // waveforms: mid value
+	Period    time.Duration    `yaml:"period"`    // waveforms: cycle length, default 1m
+	Phase     float64          `yaml:"phase"`     // waveforms: start position as a fraction of the period
+	Duty      float64          `yaml:"duty"`      // square: fraction of the period spent high, default 0.5
+	Rate      float64          `yaml:"rate"`      // ramp: units per second; counter: increments per second
+	Step      float64          `yaml:"step"`      // random_walk: standard deviation of the change per second
+	StdDev    float64          `yaml:"stddev"`    // noise: standard deviation added to the source
+	Source    *GeneratorConfig `yaml:"source"`    // noise: underlying generator (default constant value)
+	Steps     []GeneratorStep  `yaml:"steps"`     // steps: sequence of values
+	Once      bool             `yaml:"once"`      // steps: hold the last value instead of looping
+	Rollover  float64          `yaml:"rollover"`  // counter: wraps to 0 when reaching this value (e.g. 65536)
+	Seed      int64            `yaml:"seed"`      // random sources: fixed seed for reproducible runs
+}
+
+// GeneratorStep is one entry of a steps generator.
+type GeneratorStep struct {
+	Value    float64       `yaml:"value"`
+	Duration time.Duration `yaml:"duration"`
 }
 
 func LoadYAML(path string) (RootConfig, error) {
@@ -105,12 +394,15 @@ func LoadYAML(path string) (RootConfig, error) {
 	if cfg.System.Processing.MaxQueueSize <= 0 {
 		cfg.System.Processing.MaxQueueSize = 1000
 	}
-	if cfg.System.Storage.MaxWorkers < 0 {
-		cfg.System.Storage.MaxWorkers = 0
-	}
 	if cfg.System.Storage.MaxQueueSize < 0 {
 		cfg.System.Storage.MaxQueueSize = 0
 	}
+	if cfg.System.Storage.BatchSize <= 0 {
+		cfg.System.Storage.BatchSize = 500
+	}
+	if cfg.System.Storage.FlushInterval <= 0 {
+		cfg.System.Storage.FlushInterval = time.Second
+	}
 	if cfg.System.Storage.CacheTTL <= 0 {
 		cfg.System.Storage.CacheTTL = time.Hour
 	}
@@ -125,8 +417,37 @@ func LoadYAML(path string) (RootConfig, error) {
 	if cfg.System.Storage.LatestSnapshot.Interval <= 0 {
 		cfg.System.Storage.LatestSnapshot.Interval = 30 * time.Second
 	}
+	// Defaults for retention job
+	if rc := &cfg.System.Storage.Retention; rc.Enabled {
+		if rc.Raw == 0 {
+			rc.Raw = 7 * 24 * time.Hour
+		}
+		if rc.Minute == 0 {
+			rc.Minute = 90 * 24 * time.Hour
+		}
+		if rc.Interval <= 0 {
+			rc.Interval = time.Minute
+		}
+		if rc.Lateness <= 0 {
+			rc.Lateness = 2 * time.Minute
+		}
+	}
+
+	if err := cfg.Resolve(filepath.Dir(path)); err != nil {
+		return RootConfig{}, err
+	}
+	return cfg, nil
+}
 
-	cfgDir := filepath.Dir(path)
+// Resolve completes the server definitions the way LoadYAML does: devices
+// are read from devices_file or expanded from their templates, and script
+// and scenario files are resolved against cfgDir. Configs built in code or
+// converted from other formats call it before use.
+func (cfg *RootConfig) Resolve(cfgDir string) error {
+	profiles, err := loadProfiles(cfg.Templates, cfgDir)
+	if err != nil {
+		return err
+	}
 	for i := range cfg.Servers {
 		srv := &cfg.Servers[i]
 		srcType := strings.ToLower(strings.TrimSpace(srv.DevicesType))
@@ -134,11 +455,11 @@ func LoadYAML(path string) (RootConfig, error) {
 		case "", "device", "devices", "points":
 			srv.DevicesType = "device"
 			if len(srv.Devices) == 0 {
-				return RootConfig{}, fmt.Errorf("server %s: devices list is empty", srv.ServerID)
+				return fmt.Errorf("server %s: devices list is empty", srv.ServerID)
 			}
 		case "csvfile", "csv":
 			if strings.TrimSpace(srv.DevicesFile) == "" {
-				return RootConfig{}, fmt.Errorf("server %s: devices_file is required for csvfile type", srv.ServerID)
+				return fmt.Errorf("server %s: devices_file is required for csvfile type", srv.ServerID)
 			}
 			csvPath := srv.DevicesFile
 			if !filepath.IsAbs(csvPath) {
@@ -146,16 +467,74 @@ func LoadYAML(path string) (RootConfig, error) {
 			}
 			devices, err := loadDevicesFromCSV(csvPath)
 			if err != nil {
-				return RootConfig{}, fmt.Errorf("server %s: %w", srv.ServerID, err)
+				return fmt.Errorf("server %s: %w", srv.ServerID, err)
 			}
 			srv.Devices = devices
 			srv.DevicesFile = csvPath
 			srv.DevicesType = "csvfile"
 		default:
-			return RootConfig{}, fmt.Errorf("server %s: unsupported devices type %q", srv.ServerID, srv.DevicesType)
+			return fmt.Errorf("server %s: unsupported devices type %q", srv.ServerID, srv.DevicesType)
+		}
+		for d := range srv.Devices {
+			dev := &srv.Devices[d]
+			if dev.Template != "" {
+				p, err := lookupProfile(dev.Template, profiles, cfgDir)
+				if err != nil {
+					return fmt.Errorf("server %s device %s: %w", srv.ServerID, dev.DeviceID, err)
+				}
+				if *dev, err = ApplyProfile(*dev, p); err != nil {
+					return fmt.Errorf("server %s: %w", srv.ServerID, err)
+				}
+			}
+			resolveScriptPath(dev.Script, cfgDir)
+			for p := range dev.Points {
+				resolveScriptPath(dev.Points[p].Script, cfgDir)
+			}
+		}
+		if sc := srv.Scenario; sc != nil && strings.TrimSpace(sc.File) != "" {
+			if err := loadScenarioFile(sc, cfgDir); err != nil {
+				return fmt.Errorf("server %s: %w", srv.ServerID, err)
+			}
 		}
 	}
-	return cfg, nil
+	return nil
+}
+
+// resolveScriptPath makes a relative script file relative to the config
+// directory.
+func resolveScriptPath(sc *ScriptConfig, cfgDir string) {
+	if sc != nil && sc.File != "" && !filepath.IsAbs(sc.File) {
+		sc.File = filepath.Join(cfgDir, sc.File)
+	}
+}
+
+// loadScenarioFile reads sc.File (relative to the config directory) and
+// puts its entries before the inline ones. Inline settings win.
+func loadScenarioFile(sc *ScenarioConfig, cfgDir string) error {
+	path := sc.File
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cfgDir, path)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("scenario file: %w", err)
+	}
+	var f ScenarioConfig
+	if err := yaml.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("scenario file %s: %w", path, err)
+	}
+	sc.File = path
+	if sc.Tick <= 0 {
+		sc.Tick = f.Tick
+	}
+	if sc.Period <= 0 {
+		sc.Period = f.Period
+	}
+	sc.Loop = sc.Loop || f.Loop
+	sc.Timeline = append(f.Timeline, sc.Timeline...)
+	sc.Rules = append(f.Rules, sc.Rules...)
+	sc.Models = append(f.Models, sc.Models...)
+	return nil
 }
 
 func loadDevicesFromCSV(path string) ([]Device, error) {
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	dbpkg "modbus-simulator/internal/db"
+	"modbus-simulator/internal/model"
+)
+
+// latestSnapshot keeps the newest value per (server, device, point) seen on
+// the collector stream and periodically upserts the changed entries into
+// latest_datas_value (system.storage.latest_snapshot).
+type latestSnapshot struct {
+	db       *dbpkg.DB
+	ownDB    bool
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[[3]string]model.LatestDataValue
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newLatestSnapshot reuses the storage DB when there is one and otherwise
+// opens the database configured by db_path.
+func newLatestSnapshot(cfg StorageConfig, db *dbpkg.DB) (*latestSnapshot, error) {
+	ls := &latestSnapshot{
+		db:       db,
+		interval: cfg.LatestSnapshot.Interval,
+		pending:  make(map[[3]string]model.LatestDataValue),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if ls.interval <= 0 {
+		ls.interval = 30 * time.Second
+	}
+	if ls.db == nil {
+		d, err := openJobDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		ls.db = d
+		ls.ownDB = true
+	}
+	go ls.run()
+	return ls, nil
+}
+
+// observe records v as the latest value of its point.
+func (l *latestSnapshot) observe(v PointValue) {
+	key := [3]string{v.ServerID, v.DeviceID, v.PointName}
+	l.mu.Lock()
+	if cur, ok := l.pending[key]; !ok || !cur.Timestamp.After(v.Timestamp) {
+		l.pending[key] = model.LatestDataValue{
+			ServerID:     v.ServerID,
+			DeviceID:     v.DeviceID,
+			Name:         v.PointName,
+			Address:      int(v.Address),
+			RegisterType: v.Register,
+			DataType:     v.DataType,
+			ByteOrder:    v.ByteOrder,
+			Unit:         v.Unit,
+			Value:        v.Value,
+			Timestamp:    v.Timestamp,
+		}
+	}
+	l.mu.Unlock()
+}
+
+func (l *latestSnapshot) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.flush(); err != nil {
+				log.Printf("latest snapshot flush failed: %v", err)
+			}
+		case <-l.stop:
+			if err := l.flush(); err != nil {
+				log.Printf("latest snapshot final flush failed: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// flush upserts every entry changed since the previous flush. On failure the
+// entries are merged back so the next tick retries them.
+func (l *latestSnapshot) flush() error {
+	l.mu.Lock()
+	if len(l.pending) == 0 {
+		l.mu.Unlock()
+		return nil
+	}
+	batch := l.pending
+	l.pending = make(map[[3]string]model.LatestDataValue, len(batch))
+	l.mu.Unlock()
+
+	rows := make([]model.LatestDataValue, 0, len(batch))
+	for _, r := range batch {
+		rows = append(rows, r)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := dbpkg.UpsertLatestValues(ctx, l.db.ORM, rows); err != nil {
+		l.mu.Lock()
+		for k, r := range batch {
+			if cur, ok := l.pending[k]; !ok || cur.Timestamp.Before(r.Timestamp) {
+				l.pending[k] = r
+			}
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Close writes the remaining entries and stops the job. It must be called
+// before the storage DB it shares is closed.
+func (l *latestSnapshot) Close() {
+	close(l.stop)
+	<-l.done
+	if l.ownDB {
+		_ = l.db.Close()
+	}
+}
+
+// openJobDB opens the database configured by db_path for background jobs
+// that run without a db storage sink.
+func openJobDB(cfg StorageConfig) (*dbpkg.DB, error) {
+	_, dbFile := storagePaths(cfg.DBPath)
+	return dbpkg.Open(dbFile)
+}
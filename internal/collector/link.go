@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"fmt"
+
+	mb "github.com/goburrow/modbus"
+	"modbus-simulator/internal/capture"
+)
+
+// Link is an open connection to a Modbus server on which every request
+// may address another slave, as scanning for devices does. A Link is not
+// safe for concurrent use.
+type Link struct {
+	Address string // server address or serial port, for logs
+
+	handler handlerWithConn // base, tapped and paced
+	base    handlerWithConn
+}
+
+// OpenLink connects to server the way a collector does, reporting every
+// frame to tap when it is set.
+func OpenLink(server ServerConfig, tap capture.Tap) (*Link, error) {
+	base, addr, err := newHandler(server, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := base.Connect(); err != nil {
+		return nil, fmt.Errorf("connect %s: %w", addr, err)
+	}
+	return &Link{Address: addr, handler: wrapFrames(base, server, addr, tap), base: base}, nil
+}
+
+// Client returns a client whose requests address slave, until the next
+// call to Client or Send selects another slave.
+func (l *Link) Client(slave uint8) mb.Client {
+	l.address(slave)
+	return mb.NewClient(l.handler)
+}
+
+// Send sends a request PDU to slave and returns the data of its answer.
+// Exception answers are returned as *mb.ModbusError, as the clients do.
+func (l *Link) Send(slave uint8, request mb.ProtocolDataUnit) ([]byte, error) {
+	l.address(slave)
+	adu, err := l.handler.Encode(&request)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.handler.Send(adu)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.handler.Verify(adu, resp); err != nil {
+		return nil, err
+	}
+	pdu, err := l.handler.Decode(resp)
+	if err != nil {
+		return nil, err
+	}
+	if pdu.FunctionCode != request.FunctionCode {
+		if pdu.FunctionCode == request.FunctionCode|0x80 && len(pdu.Data) > 0 {
+			return nil, &mb.ModbusError{FunctionCode: pdu.FunctionCode, ExceptionCode: pdu.Data[0]}
+		}
+		return nil, fmt.Errorf("modbus: response function code 0x%02x does not match request 0x%02x", pdu.FunctionCode, request.FunctionCode)
+	}
+	return pdu.Data, nil
+}
+
+// Close closes the connection.
+func (l *Link) Close() error {
+	return l.handler.Close()
+}
+
+// address makes the following requests address slave.
+func (l *Link) address(slave uint8) {
+	switch h := l.base.(type) {
+	case *mb.TCPClientHandler:
+		h.SlaveId = slave
+	case *mb.RTUClientHandler:
+		h.SlaveId = slave
+	case *rtuStreamHandler:
+		h.SlaveId = slave
+	case *tlsHandler:
+		h.SlaveId = slave
+	}
+}
@@ -7,6 +7,8 @@ import (
     "sync"
     "time"
 
+    "modbus-simulator/internal/capture"
+    "modbus-simulator/internal/clock"
     dbpkg "modbus-simulator/internal/db"
     "modbus-simulator/internal/model"
     utils "modbus-simulator/internal/utils"
@@ -18,6 +20,12 @@ import (
 type Manager struct {
     Cfg     RootConfig
     OnValue ResultHandler // optional global handler
+    // Clock schedules the collectors; nil means system.clock when its
+    // collector flag is set, else the wall clock.
+    Clock clock.Clock
+    // Tap receives every frame of every collector; nil means system.capture
+    // when enabled.
+    Tap capture.Tap
 }
 
 func (m *Manager) Run(ctx context.Context) error {
@@ -29,12 +37,7 @@ func (m *Manager) Run(ctx context.Context) error {
         switch ft {
         case "", "csv", "json", "jsonl", "json+csv", "csv+json", "both", "all",
             "db", "json+db", "db+json", "csv+db", "db+csv":
-            s, err := NewStorage(
-                m.Cfg.System.Storage.DBPath,
-                ft,
-                m.Cfg.System.Storage.MaxWorkers,
-                m.Cfg.System.Storage.MaxQueueSize,
-            )
+            s, err := NewStorage(m.Cfg.System.Storage)
             if err != nil {
                 log.Printf("storage init failed: %v (continuing without storage)", err)
             } else {
@@ -89,6 +92,56 @@ func (m *Manager) Run(ctx context.Context) error {
         }
     }
 
+    // optional latest_snapshot job: sees every value before the dedup cache.
+    // It wraps a local copy so a second Run does not wrap m.OnValue again.
+    handler := m.wrapHandler()
+    var latest *latestSnapshot
+    if m.Cfg.System.Storage.LatestSnapshot.Enabled {
+        var db *dbpkg.DB
+        if store != nil && store.enableDB {
+            db = store.db
+        }
+        ls, err := newLatestSnapshot(m.Cfg.System.Storage, db)
+        if err != nil {
+            log.Printf("latest snapshot init failed: %v (continuing without it)", err)
+        } else {
+            latest = ls
+            next := handler
+            handler = func(v PointValue) error {
+                latest.observe(v)
+                return next(v)
+            }
+        }
+    }
+
+    // optional retention job: rollups and pruning of the SQLite history
+    var retention *retentionJob
+    if m.Cfg.System.Storage.Retention.Enabled {
+        var db *dbpkg.DB
+        if store != nil && store.enableDB {
+            db = store.db
+        }
+        j, err := newRetentionJob(m.Cfg.System.Storage, db)
+        if err != nil {
+            log.Printf("retention init failed: %v (continuing without it)", err)
+        } else {
+            retention = j
+        }
+    }
+
+    // optional frame capture
+    tap := m.Tap
+    if tap == nil && m.Cfg.System.Capture.Enabled {
+        c := m.Cfg.System.Capture
+        w, err := capture.Create(c.Path("collector"), c.Format)
+        if err != nil {
+            log.Printf("capture init failed: %v (continuing without it)", err)
+        } else {
+            defer w.Close()
+            tap = w.Tap
+        }
+    }
+
     // worker limit
     maxW := m.Cfg.System.Processing.MaxWorkers
     if maxW <= 0 {
@@ -96,6 +149,14 @@ func (m *Manager) Run(ctx context.Context) error {
     }
     sem := make(chan struct{}, maxW)
 
+    clk := m.Clock
+    if clk == nil {
+        clk = clock.Wall()
+        if m.Cfg.System.Clock.Collector {
+            clk = m.Cfg.System.Clock.NewClock()
+        }
+    }
+
 	var wg sync.WaitGroup
 
 	for _, srv := range m.Cfg.Servers {
@@ -111,7 +172,9 @@ func (m *Manager) Run(ctx context.Context) error {
 			collector := &Collector{
 				Server:  srv,
 				Device:  dev,
-				Handler: m.wrapHandler(),
+				Handler: handler,
+				Clock:   clk,
+				Tap:     tap,
 			}
 
 			wg.Add(1)
@@ -141,6 +204,12 @@ func (m *Manager) Run(ctx context.Context) error {
     case <-time.After(5 * time.Second):
         log.Printf("timeout waiting for collectors to stop")
     }
+    if latest != nil {
+        latest.Close()
+    }
+    if retention != nil {
+        retention.Close()
+    }
     if storeClose != nil {
         storeClose()
     }
@@ -0,0 +1,299 @@
+package collector
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a reusable device definition (device template): the points of
+// a device model with their defaults and simulation sources. Devices refer
+// to a profile by name with template and may override the slave id, shift
+// the addresses and keep only some points.
+type Profile struct {
+	Name         string        `yaml:"name"` // default: file name without extension
+	Vendor       string        `yaml:"vendor"`
+	Description  string        `yaml:"description"`
+	SlaveID      uint8         `yaml:"slave_id"`
+	PollInterval time.Duration `yaml:"poll_interval"`
+	Defaults     PointDefaults `yaml:"defaults"`
+	Points       []Point       `yaml:"points"`
+	// Script is the device script of devices using the profile.
+	Script *ScriptConfig `yaml:"script,omitempty"`
+}
+
+// PointDefaults fills the fields a profile point leaves empty.
+type PointDefaults struct {
+	RegisterType string  `yaml:"register_type"`
+	DataType     string  `yaml:"data_type"`
+	ByteOrder    string  `yaml:"byte_order"`
+	Scale        float64 `yaml:"scale"`
+}
+
+// merge returns p with the fields that o sets.
+func merge(p, o Point) Point {
+	if o.Address != 0 {
+		p.Address = o.Address
+	}
+	if o.DataType != "" {
+		p.DataType = o.DataType
+	}
+	if o.ByteOrder != "" {
+		p.ByteOrder = o.ByteOrder
+	}
+	if o.RegisterType != "" {
+		p.RegisterType = o.RegisterType
+	}
+	if o.Scale != 0 {
+		p.Scale = o.Scale
+	}
+	if o.Offset != 0 {
+		p.Offset = o.Offset
+	}
+	if o.Unit != "" {
+		p.Unit = o.Unit
+	}
+	if o.Generator != nil {
+		p.Generator = o.Generator
+	}
+	if o.Script != nil {
+		p.Script = o.Script
+	}
+	if o.Initial != nil {
+		p.Initial = o.Initial
+	}
+	return p
+}
+
+func (d PointDefaults) apply(p Point) Point {
+	if p.RegisterType == "" {
+		p.RegisterType = d.RegisterType
+	}
+	if p.DataType == "" {
+		p.DataType = d.DataType
+	}
+	if p.ByteOrder == "" {
+		p.ByteOrder = d.ByteOrder
+	}
+	if p.Scale == 0 {
+		p.Scale = d.Scale
+	}
+	return p
+}
+
+//go:embed profiles/*.yaml
+var builtinFS embed.FS
+
+// BuiltinProfiles returns the names of the profiles shipped with the
+// program.
+func BuiltinProfiles() []string {
+	entries, _ := fs.ReadDir(builtinFS, "profiles")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinProfile returns the built-in profile name.
+func BuiltinProfile(name string) (Profile, bool) {
+	b, err := builtinFS.ReadFile("profiles/" + name + ".yaml")
+	if err != nil {
+		return Profile{}, false
+	}
+	p, err := parseProfile(b, name, "")
+	if err != nil {
+		return Profile{}, false
+	}
+	return p, true
+}
+
+// LoadProfile reads a profile file. Relative script files are resolved
+// against the profile's directory.
+func LoadProfile(file string) (Profile, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile: %w", err)
+	}
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	p, err := parseProfile(b, name, filepath.Dir(file))
+	if err != nil {
+		return Profile{}, fmt.Errorf("profile %s: %w", file, err)
+	}
+	return p, nil
+}
+
+func parseProfile(b []byte, name, dir string) (Profile, error) {
+	var p Profile
+	if err := yaml.Unmarshal(b, &p); err != nil {
+		return Profile{}, err
+	}
+	if p.Name == "" {
+		p.Name = name
+	}
+	if len(p.Points) == 0 {
+		return Profile{}, fmt.Errorf("profile %s has no points", p.Name)
+	}
+	seen := make(map[string]bool, len(p.Points))
+	for i := range p.Points {
+		pt := &p.Points[i]
+		if pt.Name == "" || seen[pt.Name] {
+			return Profile{}, fmt.Errorf("profile %s: empty or duplicate point name %q", p.Name, pt.Name)
+		}
+		seen[pt.Name] = true
+		if dir != "" {
+			resolveScriptPath(pt.Script, dir)
+		}
+	}
+	if dir != "" {
+		resolveScriptPath(p.Script, dir)
+	}
+	return p, nil
+}
+
+// loadProfiles reads the profile files listed in templates; a directory
+// contributes all its .yaml and .yml files. Relative paths are relative to
+// the config directory.
+func loadProfiles(templates []string, cfgDir string) (map[string]Profile, error) {
+	out := map[string]Profile{}
+	add := func(file string) error {
+		p, err := LoadProfile(file)
+		if err != nil {
+			return err
+		}
+		if _, dup := out[p.Name]; dup {
+			return fmt.Errorf("profile %s defined twice (%s)", p.Name, file)
+		}
+		out[p.Name] = p
+		return nil
+	}
+	for _, t := range templates {
+		if !filepath.IsAbs(t) {
+			t = filepath.Join(cfgDir, t)
+		}
+		info, err := os.Stat(t)
+		if err != nil {
+			return nil, fmt.Errorf("templates: %w", err)
+		}
+		if !info.IsDir() {
+			if err := add(t); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entries, err := os.ReadDir(t)
+		if err != nil {
+			return nil, fmt.Errorf("templates: %w", err)
+		}
+		for _, e := range entries {
+			ext := strings.ToLower(filepath.Ext(e.Name()))
+			if e.IsDir() || (ext != ".yaml" && ext != ".yml") {
+				continue
+			}
+			if err := add(filepath.Join(t, e.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// lookupProfile finds the template of a device: a profile loaded from
+// templates, a built-in profile, or a profile file relative to the config
+// directory, in that order.
+func lookupProfile(name string, profiles map[string]Profile, cfgDir string) (Profile, error) {
+	if p, ok := profiles[name]; ok {
+		return p, nil
+	}
+	if p, ok := BuiltinProfile(name); ok {
+		return p, nil
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == ".yaml" || ext == ".yml" {
+		file := name
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(cfgDir, file)
+		}
+		return LoadProfile(file)
+	}
+	return Profile{}, fmt.Errorf("unknown template %q (built-in: %s)", name, strings.Join(BuiltinProfiles(), ", "))
+}
+
+// ApplyProfile expands dev from profile p. The selected profile points
+// (all when dev.Select is empty) get the fields set by the device point of
+// the same name, then the profile defaults, and are shifted by
+// dev.AddressOffset; other device points are appended as is. Device fields
+// that are set win over the profile ones.
+func ApplyProfile(dev Device, p Profile) (Device, error) {
+	known := make(map[string]bool, len(p.Points))
+	for _, pt := range p.Points {
+		known[pt.Name] = true
+	}
+	selected := make(map[string]bool, len(dev.Select))
+	for _, name := range dev.Select {
+		if !known[name] {
+			return Device{}, fmt.Errorf("device %s: template %s has no point %q", dev.DeviceID, p.Name, name)
+		}
+		selected[name] = true
+	}
+	overrides := make(map[string]Point, len(dev.Points))
+	for _, pt := range dev.Points {
+		overrides[pt.Name] = pt
+	}
+
+	points := make([]Point, 0, len(p.Points)+len(dev.Points))
+	for _, pt := range p.Points {
+		if len(selected) > 0 && !selected[pt.Name] {
+			continue
+		}
+		if o, ok := overrides[pt.Name]; ok {
+			pt = merge(pt, o)
+		}
+		pt = p.Defaults.apply(pt)
+		addr := int(pt.Address) + dev.AddressOffset
+		if addr < 0 || addr > 0xFFFF {
+			return Device{}, fmt.Errorf("device %s: point %s address %d out of range", dev.DeviceID, pt.Name, addr)
+		}
+		pt.Address = uint16(addr)
+		// profile sources are shared by all devices using it
+		if pt.Generator != nil {
+			g := *pt.Generator
+			pt.Generator = &g
+		}
+		if pt.Script != nil {
+			sc := *pt.Script
+			pt.Script = &sc
+		}
+		points = append(points, pt)
+	}
+	for _, pt := range dev.Points {
+		if !known[pt.Name] {
+			points = append(points, pt)
+		}
+	}
+
+	dev.Points = points
+	if dev.Vendor == "" {
+		dev.Vendor = p.Vendor
+	}
+	if dev.SlaveID == 0 {
+		dev.SlaveID = p.SlaveID
+	}
+	if dev.PollInterval <= 0 {
+		dev.PollInterval = p.PollInterval
+	}
+	if dev.Script == nil && p.Script != nil {
+		sc := *p.Script
+		dev.Script = &sc
+	}
+	return dev, nil
+}
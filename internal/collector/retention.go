@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"log"
+	"time"
+
+	dbpkg "modbus-simulator/internal/db"
+)
+
+// retentionJob periodically rolls raw history up into the 1m/1h tables and
+// prunes each table past its retention (system.storage.retention).
+type retentionJob struct {
+	db     *dbpkg.DB
+	ownDB  bool
+	cfg    RetentionConfig
+	policy dbpkg.RetentionPolicy
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func newRetentionJob(cfg StorageConfig, db *dbpkg.DB) (*retentionJob, error) {
+	j := &retentionJob{
+		db:  db,
+		cfg: cfg.Retention,
+		policy: dbpkg.RetentionPolicy{
+			Raw:    max(cfg.Retention.Raw, 0),
+			Minute: max(cfg.Retention.Minute, 0),
+			Hour:   max(cfg.Retention.Hour, 0),
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if j.cfg.Interval <= 0 {
+		j.cfg.Interval = time.Minute
+	}
+	if j.db == nil {
+		d, err := openJobDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		j.db = d
+		j.ownDB = true
+	}
+	go j.run()
+	return j, nil
+}
+
+func (j *retentionJob) run() {
+	defer close(j.done)
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		j.tick()
+		select {
+		case <-ticker.C:
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+func (j *retentionJob) tick() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-j.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	now := time.Now()
+	if err := dbpkg.RunRollups(ctx, j.db.ORM, now, j.cfg.Lateness); err != nil {
+		log.Printf("retention rollup failed: %v", err)
+		return
+	}
+	res, err := dbpkg.PruneHistory(ctx, j.db.ORM, now, j.policy)
+	if err != nil {
+		log.Printf("retention prune failed: %v", err)
+		return
+	}
+	if res.Raw+res.Minute+res.Hour > 0 {
+		log.Printf("retention pruned raw=%d 1m=%d 1h=%d rows", res.Raw, res.Minute, res.Hour)
+	}
+}
+
+// Close stops the job, interrupting a running pass.
+func (j *retentionJob) Close() {
+	close(j.stop)
+	<-j.done
+	if j.ownDB {
+		_ = j.db.Close()
+	}
+}
@@ -0,0 +1,214 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	mb "github.com/goburrow/modbus"
+	utils "modbus-simulator/internal/utils"
+)
+
+// rtuStream is a line carrying RTU frames with read deadlines.
+type rtuStream interface {
+	io.ReadWriteCloser
+	SetDeadline(t time.Time) error
+}
+
+// rtuStreamHandler sends RTU frames over a stream: a TCP connection, as to
+// a serial device server, or an in-memory serial port. The embedded RTU
+// handler only frames and verifies.
+type rtuStreamHandler struct {
+	*mb.RTUClientHandler
+	address string
+	timeout time.Duration
+	echo    bool // the line returns every request before the answer
+	open    func() (rtuStream, error)
+
+	mu   sync.Mutex
+	conn rtuStream
+}
+
+func newRTUStreamHandler(address string, timeout time.Duration, slave byte, echo bool, open func() (rtuStream, error)) *rtuStreamHandler {
+	h := &rtuStreamHandler{RTUClientHandler: mb.NewRTUClientHandler(""), address: address, timeout: timeout, echo: echo, open: open}
+	h.SlaveId = slave
+	return h
+}
+
+// newRTUOverTCPHandler sends RTU frames over TCP to address.
+func newRTUOverTCPHandler(address string, timeout time.Duration, slave byte, echo bool) *rtuStreamHandler {
+	return newRTUStreamHandler(address, timeout, slave, echo, func() (rtuStream, error) {
+		return net.DialTimeout("tcp", address, timeout)
+	})
+}
+
+// newMemSerialHandler sends RTU frames over an in-memory serial port, see
+// utils.MemSerialPair.
+func newMemSerialHandler(port string, timeout time.Duration, slave byte, echo bool) *rtuStreamHandler {
+	return newRTUStreamHandler(port, timeout, slave, echo, func() (rtuStream, error) {
+		return utils.OpenMemSerial(port)
+	})
+}
+
+func (h *rtuStreamHandler) Connect() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != nil {
+		return nil
+	}
+	conn, err := h.open()
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+func (h *rtuStreamHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send writes one request frame and reads its answer; the answer length
+// follows from the function code.
+func (h *rtuStreamHandler) Send(request []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn == nil {
+		return nil, fmt.Errorf("not connected to %s", h.address)
+	}
+	if err := h.conn.SetDeadline(time.Now().Add(h.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := h.conn.Write(request); err != nil {
+		return nil, err
+	}
+	if h.echo {
+		echo := make([]byte, len(request))
+		if _, err := io.ReadFull(h.conn, echo); err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(echo, request) {
+			return nil, fmt.Errorf("echo % X does not match request", echo)
+		}
+	}
+	resp := make([]byte, 5, 256)
+	if _, err := io.ReadFull(h.conn, resp); err != nil {
+		return nil, err
+	}
+	n := 5 // exception
+	switch {
+	case resp[1]&0x80 != 0:
+	case resp[1] == 0x2B:
+		return h.readObjects(resp)
+	case resp[1] <= 0x04:
+		n = 3 + int(resp[2]) + 2
+	default:
+		n = 8
+	}
+	resp = resp[:n]
+	if _, err := io.ReadFull(h.conn, resp[5:]); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// readObjects reads the rest of a read device identification answer
+// (function 0x2B/0x0E) after its first 5 bytes; its length follows from
+// the object headers.
+func (h *rtuStreamHandler) readObjects(resp []byte) ([]byte, error) {
+	read := func(n int) error {
+		at := len(resp)
+		resp = append(resp, make([]byte, n)...)
+		_, err := io.ReadFull(h.conn, resp[at:])
+		return err
+	}
+	if err := read(3); err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(resp[7]); i++ {
+		if err := read(2); err != nil {
+			return nil, err
+		}
+		if err := read(int(resp[len(resp)-1])); err != nil {
+			return nil, err
+		}
+	}
+	if err := read(2); err != nil { // CRC
+		return nil, err
+	}
+	return resp, nil
+}
+
+// linePacer keeps a minimum silence between the frames of one RTU line
+// and lets the collectors polling devices on it take turns.
+type linePacer struct {
+	mu    sync.Mutex
+	delay time.Duration
+	last  time.Time
+}
+
+var (
+	pacersMu sync.Mutex
+	pacers   = map[string]*linePacer{}
+)
+
+// pacerFor returns the pacer shared by the collectors of line.
+func pacerFor(line string, delay time.Duration) *linePacer {
+	pacersMu.Lock()
+	defer pacersMu.Unlock()
+	p := pacers[line]
+	if p == nil {
+		p = &linePacer{}
+		pacers[line] = p
+	}
+	p.mu.Lock()
+	if delay > p.delay {
+		p.delay = delay
+	}
+	p.mu.Unlock()
+	return p
+}
+
+// frameDelay is the silence the collector keeps between frames on c:
+// frame_delay, else 3.5 characters at baud_rate (19200 by default).
+func frameDelay(c Connection) time.Duration {
+	if c.FrameDelay != 0 {
+		return max(c.FrameDelay, 0)
+	}
+	baud := c.BaudRate
+	if baud <= 0 {
+		baud = 19200
+	}
+	if baud > 19200 {
+		return 1750 * time.Microsecond
+	}
+	return time.Duration(3.5 * 11 * float64(time.Second) / float64(baud))
+}
+
+// pacedHandler sends the frames of a handler through the pacer of its line.
+type pacedHandler struct {
+	handlerWithConn
+	line *linePacer
+}
+
+func (h pacedHandler) Send(request []byte) ([]byte, error) {
+	p := h.line
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if wait := time.Until(p.last.Add(p.delay)); wait > 0 {
+		time.Sleep(wait)
+	}
+	resp, err := h.handlerWithConn.Send(request)
+	p.last = time.Now()
+	return resp, err
+}
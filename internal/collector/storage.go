@@ -7,6 +7,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	dbpkg "modbus-simulator/internal/db"
 	"modbus-simulator/internal/model"
 	"os"
@@ -16,7 +17,19 @@ import (
 	"time"
 )
 
-// Storage writes collected PointValue records to JSONL and/or CSV asynchronously.
+// storageSink is a single output (JSONL file, CSV file or SQLite).
+// write must return only after the batch is durably handed to the output,
+// because the write-ahead buffer acknowledges it afterwards.
+type storageSink interface {
+	name() string
+	write(batch []PointValue) error
+	close() error
+}
+
+// Storage writes collected PointValue records to JSONL, CSV and/or SQLite asynchronously.
+// Without a buffer, values travel through an in-memory queue; with
+// system.storage.buffer enabled they are first appended to an on-disk
+// write-ahead buffer and every sink consumes it independently.
 type Storage struct {
 	dir        string
 	q          chan PointValue
@@ -25,23 +38,20 @@ type Storage struct {
 	enableCSV  bool
 	enableDB   bool
 
-	jsonFile   *os.File
-	jsonWriter *bufio.Writer
+	sinks   []storageSink
+	runners []*sinkRunner
+	buf     *wal
+	stop    chan struct{}
 
-	csvFile   *os.File
-	csvWriter *csv.Writer
+	batchSize     int
+	flushInterval time.Duration
 
-	db     *dbpkg.DB
-	closed chan struct{}
+	db *dbpkg.DB
 }
 
-// NewStorage ensures the output directory exists, opens requested files, and starts background writers.
-func NewStorage(dbPath, fileType string, maxWorkers, maxQueue int) (*Storage, error) {
-	if dbPath == "" {
-		dbPath = "db.sqlite"
-	}
-
-	ft := strings.ToLower(strings.TrimSpace(fileType))
+// NewStorage ensures the output directory exists, opens requested outputs, and starts background writers.
+func NewStorage(cfg StorageConfig) (*Storage, error) {
+	ft := strings.ToLower(strings.TrimSpace(cfg.FileType))
 	enableJSON := false
 	enableCSV := false
 	enableDB := false
@@ -66,33 +76,32 @@ func NewStorage(dbPath, fileType string, maxWorkers, maxQueue int) (*Storage, er
 		enableCSV = true
 		enableDB = true
 	default:
-		return nil, fmt.Errorf("unsupported storage file_type %q", fileType)
+		return nil, fmt.Errorf("unsupported storage file_type %q", cfg.FileType)
 	}
 	if !enableJSON && !enableCSV && !enableDB {
 		return nil, errors.New("storage must enable at least one output")
 	}
 
-	// Determine output directory for file outputs and the database file path
-	var outDir string
-	var dbFile string
-	base := filepath.Base(dbPath)
-	if strings.Contains(base, ".") {
-		// dbPath looks like a file path (e.g., ./data.sqlite)
-		outDir = filepath.Dir(dbPath)
-		dbFile = dbPath
-	} else {
-		// dbPath is a directory
-		outDir = dbPath
-		dbFile = filepath.Join(outDir, "data.sqlite")
-	}
+	outDir, dbFile := storagePaths(cfg.DBPath)
 
 	s := &Storage{
 		dir:        outDir,
-		q:          make(chan PointValue, maxQueueIfPositive(maxQueue, 1000)),
 		enableJSON: enableJSON,
 		enableCSV:  enableCSV,
 		enableDB:   enableDB,
-		closed:     make(chan struct{}),
+		stop:       make(chan struct{}),
+
+		batchSize:     maxQueueIfPositive(cfg.BatchSize, 500),
+		flushInterval: cfg.FlushInterval,
+	}
+	if s.flushInterval <= 0 {
+		s.flushInterval = time.Second
+	}
+	fail := func(err error) (*Storage, error) {
+		for _, sk := range s.sinks {
+			_ = sk.close()
+		}
+		return nil, err
 	}
 
 	// Ensure outDir exists if we are writing JSON/CSV files
@@ -103,86 +112,83 @@ func NewStorage(dbPath, fileType string, maxWorkers, maxQueue int) (*Storage, er
 	}
 
 	if s.enableJSON {
-		jsonPath := filepath.Join(outDir, "collector.jsonl")
-		jf, err := os.OpenFile(jsonPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		sk, err := newJSONLSink(filepath.Join(outDir, "collector.jsonl"))
 		if err != nil {
-			return nil, fmt.Errorf("open json output: %w", err)
+			return fail(err)
 		}
-		s.jsonFile = jf
-		s.jsonWriter = bufio.NewWriterSize(jf, 64*1024)
+		s.sinks = append(s.sinks, sk)
 	}
 
 	if s.enableCSV {
-		csvPath := filepath.Join(outDir, "collector.csv")
-		cf, err := os.OpenFile(csvPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		sk, err := newCSVSink(filepath.Join(outDir, "collector.csv"))
 		if err != nil {
-			if s.jsonFile != nil {
-				s.jsonFile.Close()
-			}
-			return nil, fmt.Errorf("open csv output: %w", err)
-		}
-		s.csvFile = cf
-		s.csvWriter = csv.NewWriter(cf)
-		if off, _ := cf.Seek(0, os.SEEK_END); off == 0 {
-			header := []string{"timestamp", "server_id", "device_id", "connection", "slave_id", "point_name", "address", "register", "unit", "value"}
-			if err := s.csvWriter.Write(header); err != nil {
-				if s.jsonFile != nil {
-					s.jsonFile.Close()
-				}
-				cf.Close()
-				return nil, fmt.Errorf("write csv header: %w", err)
-			}
-			s.csvWriter.Flush()
-			if err := s.csvWriter.Error(); err != nil {
-				if s.jsonFile != nil {
-					s.jsonFile.Close()
-				}
-				cf.Close()
-				return nil, err
-			}
+			return fail(err)
 		}
+		s.sinks = append(s.sinks, sk)
 	}
 
 	if s.enableDB {
 		// Ensure parent directory of db file exists
 		if dir := filepath.Dir(dbFile); strings.TrimSpace(dir) != "" {
 			if err := os.MkdirAll(dir, 0o755); err != nil {
-				return nil, fmt.Errorf("mkdir %s: %w", dir, err)
+				return fail(fmt.Errorf("mkdir %s: %w", dir, err))
 			}
 		}
 		d, err := dbpkg.Open(dbFile)
 		if err != nil {
-			return nil, fmt.Errorf("open sqlite: %w", err)
+			return fail(fmt.Errorf("open sqlite: %w", err))
 		}
 		s.db = d
+		s.sinks = append(s.sinks, &dbSink{db: d})
 	}
 
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-		for v := range s.q {
-			if s.enableJSON {
-				_ = s.writeJSONL(v)
-			}
-			if s.enableCSV {
-				_ = s.writeCSV(v)
-			}
-			if s.enableDB {
-				_ = s.writeDB(v)
-			}
+	if cfg.Buffer.Enabled {
+		dir := cfg.Buffer.Dir
+		if strings.TrimSpace(dir) == "" {
+			dir = filepath.Join(outDir, "wal")
 		}
-		if s.jsonWriter != nil {
-			s.jsonWriter.Flush()
+		names := make([]string, 0, len(s.sinks))
+		for _, sk := range s.sinks {
+			names = append(names, sk.name())
 		}
-		if s.csvWriter != nil {
-			s.csvWriter.Flush()
+		buf, err := openWAL(dir, cfg.Buffer, names)
+		if err != nil {
+			return fail(fmt.Errorf("open storage buffer: %w", err))
+		}
+		s.buf = buf
+	}
+	for _, sk := range s.sinks {
+		s.runners = append(s.runners, newSinkRunner(sk, s.buf, s.stop))
+	}
+	if s.buf != nil {
+		for _, r := range s.runners {
+			s.wg.Add(1)
+			go s.runBufferedSink(r)
 		}
-		close(s.closed)
-	}()
+		return s, nil
+	}
 
+	s.q = make(chan PointValue, maxQueueIfPositive(cfg.MaxQueueSize, 1000))
+	s.wg.Add(1)
+	go s.runQueue()
 	return s, nil
 }
 
+// storagePaths derives the output directory for file outputs and the database
+// file path from system.storage.db_path.
+func storagePaths(dbPath string) (outDir, dbFile string) {
+	if dbPath == "" {
+		dbPath = "db.sqlite"
+	}
+	base := filepath.Base(dbPath)
+	if strings.Contains(base, ".") {
+		// dbPath looks like a file path (e.g., ./data.sqlite)
+		return filepath.Dir(dbPath), dbPath
+	}
+	// dbPath is a directory
+	return dbPath, filepath.Join(dbPath, "data.sqlite")
+}
+
 func maxQueueIfPositive(v, def int) int {
 	if v > 0 {
 		return v
@@ -190,108 +196,202 @@ func maxQueueIfPositive(v, def int) int {
 	return def
 }
 
-func (s *Storage) Close() {
-	close(s.q)
-	<-s.closed
-	if s.jsonFile != nil {
-		s.jsonFile.Close()
-	}
-	if s.csvFile != nil {
-		s.csvFile.Close()
+// runQueue drains the in-memory queue and hands batches to every sink once
+// batchSize values are collected or flushInterval has elapsed.
+func (s *Storage) runQueue() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]PointValue, 0, s.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		for _, r := range s.runners {
+			r.submit(batch, 0)
+		}
+		batch = make([]PointValue, 0, s.batchSize)
 	}
-	if s.db != nil {
-		_ = s.db.Close()
+	for {
+		select {
+		case v, ok := <-s.q:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, v)
+			if len(batch) >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
 	}
 }
 
-func (s *Storage) writeJSONL(v PointValue) error {
-	if s.jsonWriter == nil {
-		return nil
+// runBufferedSink feeds one sink from the write-ahead buffer, batching by size
+// or time. Batches are acknowledged in order once written, so a failing sink's
+// backlog is replayed when it recovers (or after a restart).
+func (s *Storage) runBufferedSink(r *sinkRunner) {
+	defer s.wg.Done()
+	rd := s.buf.newReader(r.sk.name())
+	defer rd.closeFile()
+
+	var pending []walEntry
+	var deadline time.Time
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		batch := make([]PointValue, len(pending))
+		for i, e := range pending {
+			batch[i] = e.v
+		}
+		r.submit(batch, pending[len(pending)-1].seq)
+		pending = nil
 	}
-	obj := map[string]any{
-		"timestamp":  v.Timestamp.Format(time.RFC3339Nano),
-		"server_id":  v.ServerID,
-		"device_id":  v.DeviceID,
-		"connection": v.Connection,
-		"slave_id":   v.SlaveID,
-		"point_name": v.PointName,
-		"address":    v.Address,
-		"register":   v.Register,
-		"data_type":  v.DataType,
-		"byte_order": v.ByteOrder,
-		"unit":       v.Unit,
-		"raw":        v.Raw,
-		"scale":      v.Scale,
-		"offset":     v.Offset,
-		"value":      v.Value,
-	}
-	b, err := json.Marshal(obj)
-	if err != nil {
-		return err
+	for {
+		wait := time.Second
+		if len(pending) > 0 {
+			wait = time.Until(deadline)
+		}
+		var entries []walEntry
+		var readErr error
+		if wait > 0 {
+			entries, readErr = rd.read(s.batchSize-len(pending), wait)
+		}
+		if len(entries) > 0 && len(pending) == 0 {
+			deadline = time.Now().Add(s.flushInterval)
+		}
+		pending = append(pending, entries...)
+		if len(pending) >= s.batchSize || (len(pending) > 0 && !time.Now().Before(deadline)) {
+			flush()
+		}
+		switch {
+		case errors.Is(readErr, errWALClosed):
+			flush()
+			return
+		case readErr != nil:
+			log.Printf("storage buffer: read for %s: %v", r.sk.name(), readErr)
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(time.Second):
+			}
+		}
 	}
-	if _, err := s.jsonWriter.Write(b); err != nil {
-		return err
+}
+
+func (s *Storage) Close() {
+	if s.buf != nil {
+		// Healthy sinks drain what is buffered; failing sinks stop retrying and
+		// keep their backlog on disk for the next start.
+		_ = s.buf.close()
+		close(s.stop)
+	} else {
+		close(s.q)
 	}
-	if _, err := s.jsonWriter.WriteString("\n"); err != nil {
-		return err
+	s.wg.Wait()
+	for _, r := range s.runners {
+		r.close()
+	}
+	for _, sk := range s.sinks {
+		if err := sk.close(); err != nil {
+			log.Printf("storage %s close: %v", sk.name(), err)
+		}
 	}
-	return nil
 }
 
-func (s *Storage) writeCSV(v PointValue) error {
-	if s.csvWriter == nil {
-		return nil
+// sinkRunner writes batches to one sink on a single goroutine, in
+// submission order: file outputs must keep their order and SQLite has a
+// single write lock. When backed by the write-ahead buffer, failed batches
+// are retried with backoff and each written batch is acknowledged.
+type sinkRunner struct {
+	sk     storageSink
+	jobs   chan sinkJob
+	wg     sync.WaitGroup
+	buf    *wal
+	stop   <-chan struct{}
+	broken bool // a batch was given up, later ones must not be acknowledged
+}
+
+type sinkJob struct {
+	values []PointValue
+	seq    uint64 // last buffer sequence in the batch (0 without buffer)
+}
+
+func newSinkRunner(sk storageSink, buf *wal, stop <-chan struct{}) *sinkRunner {
+	r := &sinkRunner{
+		sk:   sk,
+		jobs: make(chan sinkJob, 1),
+		buf:  buf,
+		stop: stop,
 	}
-	rec := []string{
-		v.Timestamp.Format(time.RFC3339Nano),
-		v.ServerID,
-		v.DeviceID,
-		v.Connection,
-		fmt.Sprintf("%d", v.SlaveID),
-		v.PointName,
-		fmt.Sprintf("%d", v.Address),
-		v.Register,
-		v.DataType,
-		v.ByteOrder,
-		v.Unit,
-		fmt.Sprintf("%g", v.Value),
-	}
-	if err := s.csvWriter.Write(rec); err != nil {
-		return err
+	r.wg.Add(1)
+	go r.work()
+	return r
+}
+
+// submit queues a batch, blocking while the previous one is still queued.
+func (r *sinkRunner) submit(values []PointValue, seq uint64) {
+	r.jobs <- sinkJob{values: values, seq: seq}
+}
+
+func (r *sinkRunner) close() {
+	close(r.jobs)
+	r.wg.Wait()
+}
+
+func (r *sinkRunner) work() {
+	defer r.wg.Done()
+	for job := range r.jobs {
+		if r.buf == nil {
+			if err := r.sk.write(job.values); err != nil {
+				log.Printf("storage %s: %v", r.sk.name(), err)
+			}
+			continue
+		}
+		if r.broken {
+			continue
+		}
+		if !r.writeWithRetry(job.values) {
+			// never acknowledge past a batch that was not written
+			r.broken = true
+			continue
+		}
+		if err := r.buf.ack(r.sk.name(), job.seq); err != nil {
+			log.Printf("storage buffer: ack %s: %v", r.sk.name(), err)
+		}
 	}
-	return nil
 }
 
-// writeDB persists a PointValue into the sqlite database.
-// It maps to the point_values table defined in internal/db/sqlite.go migrate().
-// Some columns in the schema (data_type, byte_order) are not available at runtime here;
-// we store empty strings for them, and rely on defaults for scale/offset.
-
-func (s *Storage) writeDB(v PointValue) error {
-    if s.db == nil || s.db.ORM == nil {
-        return nil
-    }
-    pv := &model.PointValue{
-        ServerID:     v.ServerID,
-        DeviceID:     v.DeviceID,
-        Name:         v.PointName,
-        Address:      int(v.Address),
-        RegisterType: v.Register,
-        DataType:     v.DataType,
-        ByteOrder:    v.ByteOrder,
-        Scale:        v.Scale,
-        Offset:       v.Offset,
-        Unit:         v.Unit,
-        Value:        v.Value,
-        Timestamp:    v.Timestamp,
-    }
-    ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-    defer cancel()
-    return s.db.SavePointValue(ctx, pv)
+// writeWithRetry keeps retrying a batch until it succeeds. It gives up (and
+// returns false) once the storage is closing, leaving the batch unacknowledged.
+func (r *sinkRunner) writeWithRetry(batch []PointValue) bool {
+	backoff := time.Second
+	for {
+		err := r.sk.write(batch)
+		if err == nil {
+			return true
+		}
+		log.Printf("storage %s: %v (retrying in %s)", r.sk.name(), err, backoff)
+		select {
+		case <-r.stop:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
 }
 
 // Handle implements ResultHandler, enqueueing values for background writers.
 func (s *Storage) Handle(v PointValue) error {
+	if s.buf != nil {
+		return s.buf.append(v)
+	}
 	// Best-effort enqueue; avoid blocking indefinitely if queue is full.
 	select {
 	case s.q <- v:
@@ -308,3 +408,155 @@ func (s *Storage) Handle(v PointValue) error {
 		}
 	}
 }
+
+// jsonlSink appends one JSON object per line to collector.jsonl.
+type jsonlSink struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+func newJSONLSink(path string) (*jsonlSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open json output: %w", err)
+	}
+	return &jsonlSink{f: f, w: bufio.NewWriterSize(f, 64*1024)}, nil
+}
+
+func (j *jsonlSink) name() string { return "jsonl" }
+
+func (j *jsonlSink) write(batch []PointValue) error {
+	for _, v := range batch {
+		obj := map[string]any{
+			"timestamp":  v.Timestamp.Format(time.RFC3339Nano),
+			"server_id":  v.ServerID,
+			"device_id":  v.DeviceID,
+			"connection": v.Connection,
+			"slave_id":   v.SlaveID,
+			"point_name": v.PointName,
+			"address":    v.Address,
+			"register":   v.Register,
+			"data_type":  v.DataType,
+			"byte_order": v.ByteOrder,
+			"unit":       v.Unit,
+			"raw":        v.Raw,
+			"scale":      v.Scale,
+			"offset":     v.Offset,
+			"value":      v.Value,
+		}
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := j.w.Write(b); err != nil {
+			return err
+		}
+		if err := j.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return j.w.Flush()
+}
+
+func (j *jsonlSink) close() error {
+	if err := j.w.Flush(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+// csvSink appends rows to collector.csv.
+type csvSink struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func newCSVSink(path string) (*csvSink, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open csv output: %w", err)
+	}
+	w := csv.NewWriter(f)
+	if off, _ := f.Seek(0, os.SEEK_END); off == 0 {
+		header := []string{"timestamp", "server_id", "device_id", "connection", "slave_id", "point_name", "address", "register", "data_type", "byte_order", "unit", "value"}
+		if err := w.Write(header); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("write csv header: %w", err)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &csvSink{f: f, w: w}, nil
+}
+
+func (c *csvSink) name() string { return "csv" }
+
+func (c *csvSink) write(batch []PointValue) error {
+	for _, v := range batch {
+		rec := []string{
+			v.Timestamp.Format(time.RFC3339Nano),
+			v.ServerID,
+			v.DeviceID,
+			v.Connection,
+			fmt.Sprintf("%d", v.SlaveID),
+			v.PointName,
+			fmt.Sprintf("%d", v.Address),
+			v.Register,
+			v.DataType,
+			v.ByteOrder,
+			v.Unit,
+			fmt.Sprintf("%g", v.Value),
+		}
+		if err := c.w.Write(rec); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvSink) close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
+
+// dbSink persists PointValues into the sqlite point_values table in batched transactions.
+type dbSink struct {
+	db *dbpkg.DB
+}
+
+func (d *dbSink) name() string { return "db" }
+
+func (d *dbSink) write(batch []PointValue) error {
+	rows := make([]model.PointValue, 0, len(batch))
+	for _, v := range batch {
+		rows = append(rows, model.PointValue{
+			ServerID:     v.ServerID,
+			DeviceID:     v.DeviceID,
+			Name:         v.PointName,
+			Address:      int(v.Address),
+			RegisterType: v.Register,
+			DataType:     v.DataType,
+			ByteOrder:    v.ByteOrder,
+			Scale:        v.Scale,
+			Offset:       v.Offset,
+			Unit:         v.Unit,
+			Value:        v.Value,
+			Timestamp:    v.Timestamp,
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// one transaction per batch: the whole batch is either stored or retried
+	return dbpkg.InsertPointValuesBatch(ctx, d.db.ORM, rows, 0)
+}
+
+func (d *dbSink) close() error { return d.db.Close() }
@@ -0,0 +1,29 @@
+package collector
+
+import (
+	"time"
+
+	"modbus-simulator/internal/capture"
+)
+
+// tapHandler reports the frames a handler sends and receives.
+type tapHandler struct {
+	handlerWithConn
+	tap       capture.Tap
+	transport string
+	remote    string
+}
+
+func (h tapHandler) Send(request []byte) ([]byte, error) {
+	h.report(capture.Request, "collector", h.remote, request)
+	resp, err := h.handlerWithConn.Send(request)
+	if err == nil {
+		h.report(capture.Response, h.remote, "collector", resp)
+	}
+	return resp, err
+}
+
+func (h tapHandler) report(direction, src, dst string, adu []byte) {
+	h.tap(capture.Frame{Time: time.Now(), Transport: h.transport, Direction: direction,
+		Src: src, Dst: dst, ADU: append([]byte(nil), adu...)})
+}
@@ -0,0 +1,190 @@
+package collector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mb "github.com/goburrow/modbus"
+)
+
+// TLSConfig holds the PEM files of Modbus/TCP Security: Modbus TCP inside
+// TLS 1.2 or later with certificates on both sides, usually on port 802.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"` // own certificate: the client's for collectors, the server's for simulators
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"` // CAs the peer certificate must chain to
+	// Collector only: name the server certificate must hold, default host.
+	ServerName string `yaml:"server_name"`
+	// Simulators only: what the role in a client certificate allows. Empty
+	// lets every client with a valid certificate do everything; otherwise
+	// clients whose role is not listed are refused every request.
+	Roles map[string]RoleConfig `yaml:"roles"`
+}
+
+// RoleConfig grants function codes and address ranges to a role.
+type RoleConfig struct {
+	// Functions are function codes (3, 0x10) or the groups "read" (01-04)
+	// and "write" (05, 06, 0F, 10).
+	Functions []string `yaml:"functions"`
+	Read      []string `yaml:"read"`  // address ranges reads may touch, such as "0-99" or "100"; empty allows all
+	Write     []string `yaml:"write"` // address ranges writes may touch
+}
+
+// isTLSProtocol reports whether proto is Modbus/TCP Security.
+func isTLSProtocol(proto string) bool {
+	switch strings.ToLower(strings.TrimSpace(proto)) {
+	case "modbus-tls", "tls", "mbaps":
+		return true
+	}
+	return false
+}
+
+// load reads the certificate and the CA pool of t. The certificate is
+// optional; pool is nil without ca_file.
+func (t *TLSConfig) load() (certs []tls.Certificate, pool *x509.CertPool, err error) {
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls certificate: %w", err)
+		}
+		certs = []tls.Certificate{cert}
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tls ca_file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, fmt.Errorf("tls ca_file %s: no certificates", t.CAFile)
+		}
+	}
+	return certs, pool, nil
+}
+
+// ClientConfig returns the TLS configuration of a client connecting to
+// host. Without ca_file the server certificate must chain to the system
+// roots.
+func (t *TLSConfig) ClientConfig(host string) (*tls.Config, error) {
+	certs, pool, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	name := t.ServerName
+	if name == "" {
+		name = host
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: certs, RootCAs: pool, ServerName: name}, nil
+}
+
+// ServerConfig returns the TLS configuration of a server requiring client
+// certificates issued by ca_file.
+func (t *TLSConfig) ServerConfig() (*tls.Config, error) {
+	if t.CertFile == "" || t.CAFile == "" {
+		return nil, errors.New("tls: cert_file, key_file and ca_file are required")
+	}
+	certs, pool, err := t.load()
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: certs, ClientCAs: pool,
+		ClientAuth: tls.RequireAndVerifyClientCert}, nil
+}
+
+// tlsHandler sends Modbus TCP frames over TLS. The embedded TCP handler
+// only frames and verifies. A failed exchange drops the connection and the
+// next request reconnects, so a late answer cannot pass for another.
+type tlsHandler struct {
+	*mb.TCPClientHandler
+	address string
+	timeout time.Duration
+	config  *tls.Config
+
+	mu   sync.Mutex
+	conn *tls.Conn
+}
+
+func newTLSHandler(address string, timeout time.Duration, slave byte, config *tls.Config) *tlsHandler {
+	h := &tlsHandler{TCPClientHandler: mb.NewTCPClientHandler(address), address: address, timeout: timeout, config: config}
+	h.SlaveId = slave
+	return h
+}
+
+func (h *tlsHandler) Connect() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.connect()
+}
+
+func (h *tlsHandler) connect() error {
+	if h.conn != nil {
+		return nil
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: h.timeout}, "tcp", h.address, h.config)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	return nil
+}
+
+func (h *tlsHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.close()
+}
+
+func (h *tlsHandler) close() error {
+	if h.conn == nil {
+		return nil
+	}
+	err := h.conn.Close()
+	h.conn = nil
+	return err
+}
+
+// Send writes one request and reads its answer; the answer length follows
+// from the MBAP header.
+func (h *tlsHandler) Send(request []byte) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.connect(); err != nil {
+		return nil, err
+	}
+	resp, err := h.exchange(request)
+	if err != nil {
+		h.close()
+	}
+	return resp, err
+}
+
+func (h *tlsHandler) exchange(request []byte) ([]byte, error) {
+	if err := h.conn.SetDeadline(time.Now().Add(h.timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := h.conn.Write(request); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, 7, 260)
+	if _, err := io.ReadFull(h.conn, resp); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(resp[4:6]))
+	if length < 2 || length > 254 {
+		return nil, fmt.Errorf("modbus: length in response header '%v' must be between 2 and 254", length)
+	}
+	resp = resp[:6+length]
+	if _, err := io.ReadFull(h.conn, resp[7:]); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,597 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The write-ahead buffer stores every PointValue handed to Storage in
+// append-only segment files before any sink sees it. Each sink reads the
+// buffer at its own pace and acknowledges what it has durably written; the
+// acknowledged sequence numbers are persisted so that unacknowledged records
+// are replayed after a restart or once a slow sink recovers.
+//
+// Record layout inside a segment (little endian):
+//
+//	length(4) crc32(4) seq(8) payload(length)
+//
+// where payload is the JSON encoding of walRecord and crc32 covers seq+payload.
+
+const (
+	walSegmentExt     = ".wal"
+	walAcksFile       = "acks.json"
+	walRecordHeader   = 16
+	walMaxRecordBytes = 1 << 20
+
+	defaultWALSegmentSize = 4 << 20
+	defaultWALMaxBytes    = 256 << 20
+	defaultWALMaxAge      = 24 * time.Hour
+)
+
+var errWALClosed = errors.New("write-ahead buffer closed")
+
+// walRecord is the on-disk form of a PointValue.
+type walRecord struct {
+	ServerID   string          `json:"server_id"`
+	DeviceID   string          `json:"device_id"`
+	Connection string          `json:"connection"`
+	SlaveID    uint8           `json:"slave_id"`
+	PointName  string          `json:"point_name"`
+	Address    uint16          `json:"address"`
+	Register   string          `json:"register"`
+	DataType   string          `json:"data_type"`
+	ByteOrder  string          `json:"byte_order"`
+	Unit       string          `json:"unit"`
+	Raw        json.RawMessage `json:"raw,omitempty"`
+	RawType    string          `json:"raw_type,omitempty"` // Go type of Raw, e.g. uint16
+	Value      float64         `json:"value"`
+	Scale      float64         `json:"scale"`
+	Offset     float64         `json:"offset"`
+	Timestamp  time.Time       `json:"timestamp"`
+}
+
+func toWALRecord(v PointValue) (walRecord, error) {
+	var raw json.RawMessage
+	var rawType string
+	if v.Raw != nil {
+		b, err := json.Marshal(v.Raw)
+		if err != nil {
+			return walRecord{}, err
+		}
+		raw, rawType = b, fmt.Sprintf("%T", v.Raw)
+	}
+	return walRecord{
+		ServerID:   v.ServerID,
+		DeviceID:   v.DeviceID,
+		Connection: v.Connection,
+		SlaveID:    v.SlaveID,
+		PointName:  v.PointName,
+		Address:    v.Address,
+		Register:   v.Register,
+		DataType:   v.DataType,
+		ByteOrder:  v.ByteOrder,
+		Unit:       v.Unit,
+		Raw:        raw,
+		RawType:    rawType,
+		Value:      v.Value,
+		Scale:      v.Scale,
+		Offset:     v.Offset,
+		Timestamp:  v.Timestamp,
+	}, nil
+}
+
+func (r walRecord) pointValue() PointValue {
+	return PointValue{
+		ServerID:   r.ServerID,
+		DeviceID:   r.DeviceID,
+		Connection: r.Connection,
+		SlaveID:    r.SlaveID,
+		PointName:  r.PointName,
+		Address:    r.Address,
+		Register:   r.Register,
+		DataType:   r.DataType,
+		ByteOrder:  r.ByteOrder,
+		Unit:       r.Unit,
+		Raw:        r.raw(),
+		Value:      r.Value,
+		Scale:      r.Scale,
+		Offset:     r.Offset,
+		Timestamp:  r.Timestamp,
+	}
+}
+
+// raw decodes Raw back into the type it was collected as. Records without
+// a known type decode as plain JSON values.
+func (r walRecord) raw() any {
+	switch r.RawType {
+	case "bool":
+		return decodeRaw[bool](r.Raw)
+	case "uint16":
+		return decodeRaw[uint16](r.Raw)
+	case "int16":
+		return decodeRaw[int16](r.Raw)
+	case "uint32":
+		return decodeRaw[uint32](r.Raw)
+	case "int32":
+		return decodeRaw[int32](r.Raw)
+	case "float32":
+		return decodeRaw[float32](r.Raw)
+	case "float64":
+		return decodeRaw[float64](r.Raw)
+	default:
+		return decodeRaw[any](r.Raw)
+	}
+}
+
+func decodeRaw[T any](b json.RawMessage) any {
+	if len(b) == 0 {
+		return nil
+	}
+	var v T
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// walEntry is a decoded record together with its sequence number.
+type walEntry struct {
+	seq uint64
+	v   PointValue
+}
+
+type walSegment struct {
+	first   uint64 // sequence number of the first record
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// wal is a segmented, append-only buffer shared by all storage sinks.
+type wal struct {
+	dir  string
+	opts BufferConfig
+
+	mu      sync.Mutex
+	notify  chan struct{} // closed and replaced on every append/close
+	segs    []*walSegment // ordered by first; the last one is active
+	active  *os.File
+	nextSeq uint64
+	acks    map[string]uint64 // sink -> highest acknowledged seq
+	closed  bool
+	dropped uint64
+}
+
+// openWAL opens (or creates) the buffer in dir, recovering existing segments.
+// sinks lists the consumers whose acknowledgements gate segment removal.
+func openWAL(dir string, opts BufferConfig, sinks []string) (*wal, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultWALSegmentSize
+	}
+	if opts.MaxBytes <= 0 {
+		opts.MaxBytes = defaultWALMaxBytes
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = defaultWALMaxAge
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir %s: %w", dir, err)
+	}
+	w := &wal{
+		dir:     dir,
+		opts:    opts,
+		notify:  make(chan struct{}),
+		acks:    make(map[string]uint64, len(sinks)),
+		nextSeq: 1,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, walSegmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, walSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		w.segs = append(w.segs, &walSegment{
+			first:   first,
+			path:    filepath.Join(dir, name),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+	sort.Slice(w.segs, func(i, j int) bool { return w.segs[i].first < w.segs[j].first })
+
+	if n := len(w.segs); n > 0 {
+		last := w.segs[n-1]
+		next, size, err := recoverSegment(last)
+		if err != nil {
+			return nil, err
+		}
+		last.size = size
+		w.nextSeq = next
+	}
+	if err := w.openActive(); err != nil {
+		return nil, err
+	}
+
+	saved, err := w.loadAcks()
+	if err != nil {
+		log.Printf("storage buffer: ignoring unreadable %s: %v", walAcksFile, err)
+	}
+	// Sinks that were never acknowledged start at the oldest buffered record;
+	// acknowledgements of sinks no longer configured are forgotten.
+	for _, name := range sinks {
+		ack, ok := saved[name]
+		if !ok && len(w.segs) > 0 {
+			ack = w.segs[0].first - 1
+		}
+		w.acks[name] = ack
+	}
+	w.mu.Lock()
+	w.truncateLocked()
+	w.mu.Unlock()
+	return w, nil
+}
+
+// recoverSegment scans a segment, truncates a torn tail and returns the next
+// sequence number and the valid size.
+func recoverSegment(seg *walSegment) (uint64, int64, error) {
+	f, err := os.OpenFile(seg.path, os.O_RDWR, 0o644)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	next := seg.first
+	var valid int64
+	br := bufio.NewReader(f)
+	for {
+		e, n, err := readWALEntry(br)
+		if err != nil {
+			break
+		}
+		valid += int64(n)
+		next = e.seq + 1
+	}
+	if valid != seg.size {
+		log.Printf("storage buffer: truncating torn tail of %s at %d bytes", filepath.Base(seg.path), valid)
+		if err := f.Truncate(valid); err != nil {
+			return 0, 0, err
+		}
+	}
+	return next, valid, nil
+}
+
+// openActive opens the last segment for appending, creating one if needed.
+func (w *wal) openActive() error {
+	if len(w.segs) == 0 || w.segs[len(w.segs)-1].size >= w.opts.SegmentSize {
+		seg := &walSegment{
+			first:   w.nextSeq,
+			path:    filepath.Join(w.dir, fmt.Sprintf("%020d%s", w.nextSeq, walSegmentExt)),
+			modTime: time.Now(),
+		}
+		w.segs = append(w.segs, seg)
+	}
+	seg := w.segs[len(w.segs)-1]
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open buffer segment: %w", err)
+	}
+	w.active = f
+	return nil
+}
+
+func (w *wal) loadAcks() (map[string]uint64, error) {
+	b, err := os.ReadFile(filepath.Join(w.dir, walAcksFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var acks map[string]uint64
+	if err := json.Unmarshal(b, &acks); err != nil {
+		return nil, err
+	}
+	return acks, nil
+}
+
+// saveAcksLocked atomically rewrites the acknowledgement file.
+func (w *wal) saveAcksLocked() error {
+	b, err := json.Marshal(w.acks)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(w.dir, walAcksFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(w.dir, walAcksFile))
+}
+
+// append durably adds v to the buffer and wakes up waiting readers.
+func (w *wal) append(v PointValue) error {
+	rec, err := toWALRecord(v)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(payload) > walMaxRecordBytes {
+		return fmt.Errorf("buffer record too large (%d bytes)", len(payload))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errWALClosed
+	}
+
+	buf := make([]byte, walRecordHeader+len(payload))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(buf[8:16], w.nextSeq)
+	copy(buf[16:], payload)
+	binary.LittleEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+
+	if _, err := w.active.Write(buf); err != nil {
+		return fmt.Errorf("append buffer: %w", err)
+	}
+	if w.opts.Sync {
+		if err := w.active.Sync(); err != nil {
+			return fmt.Errorf("sync buffer: %w", err)
+		}
+	}
+	seg := w.segs[len(w.segs)-1]
+	seg.size += int64(len(buf))
+	seg.modTime = time.Now()
+	w.nextSeq++
+
+	if seg.size >= w.opts.SegmentSize {
+		if err := w.rollLocked(); err != nil {
+			return err
+		}
+	}
+	close(w.notify)
+	w.notify = make(chan struct{})
+	return nil
+}
+
+// rollLocked closes the active segment, starts a new one and enforces caps.
+func (w *wal) rollLocked() error {
+	_ = w.active.Sync()
+	if err := w.active.Close(); err != nil {
+		return err
+	}
+	if err := w.openActive(); err != nil {
+		return err
+	}
+	w.truncateLocked()
+	return nil
+}
+
+// ack records that sink has durably written every record up to seq.
+func (w *wal) ack(sink string, seq uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq <= w.acks[sink] {
+		return nil
+	}
+	w.acks[sink] = seq
+	w.truncateLocked()
+	return w.saveAcksLocked()
+}
+
+// truncateLocked removes fully acknowledged segments, then drops the oldest
+// segments when the size or age caps are exceeded.
+func (w *wal) truncateLocked() {
+	minAck := w.nextSeq - 1
+	for _, a := range w.acks {
+		if a < minAck {
+			minAck = a
+		}
+	}
+	for len(w.segs) > 1 && w.segs[1].first-1 <= minAck {
+		w.removeOldestLocked()
+	}
+
+	var total int64
+	for _, s := range w.segs {
+		total += s.size
+	}
+	cutoff := time.Now().Add(-w.opts.MaxAge)
+	for len(w.segs) > 1 && (total > w.opts.MaxBytes || w.segs[0].modTime.Before(cutoff)) {
+		oldest := w.segs[0]
+		lost := w.segs[1].first - oldest.first
+		total -= oldest.size
+		w.removeOldestLocked()
+		w.dropped += lost
+		log.Printf("storage buffer: dropped %d records from %s (size/age cap exceeded)", lost, filepath.Base(oldest.path))
+	}
+}
+
+func (w *wal) removeOldestLocked() {
+	oldest := w.segs[0]
+	if err := os.Remove(oldest.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("storage buffer: remove %s: %v", oldest.path, err)
+	}
+	w.segs = w.segs[1:]
+}
+
+// close stops accepting appends and wakes up all readers.
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.notify)
+	_ = w.active.Sync()
+	return w.active.Close()
+}
+
+// newReader returns a cursor positioned after the last acknowledged record of sink.
+func (w *wal) newReader(sink string) *walReader {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return &walReader{w: w, sink: sink, next: w.acks[sink] + 1}
+}
+
+// walReader iterates over buffered records for a single sink.
+type walReader struct {
+	w    *wal
+	sink string
+	next uint64 // next sequence number to return
+
+	f        *os.File
+	br       *bufio.Reader
+	segFirst uint64
+}
+
+// read returns up to max records, waiting up to wait for new data.
+// It returns errWALClosed once the buffer is closed and fully consumed.
+func (r *walReader) read(max int, wait time.Duration) ([]walEntry, error) {
+	r.w.mu.Lock()
+	if len(r.w.segs) > 0 && r.next < r.w.segs[0].first {
+		log.Printf("storage buffer: sink %s skipped %d dropped records", r.sink, r.w.segs[0].first-r.next)
+		r.next = r.w.segs[0].first
+		r.closeFile()
+	}
+	upto := r.w.nextSeq
+	closed := r.w.closed
+	notify := r.w.notify
+	r.w.mu.Unlock()
+
+	if r.next >= upto {
+		if closed {
+			return nil, errWALClosed
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-notify:
+		case <-timer.C:
+		}
+		return nil, nil
+	}
+
+	out := make([]walEntry, 0, min(max, int(upto-r.next)))
+	for len(out) < max && r.next < upto {
+		if err := r.ensureFile(); err != nil {
+			return out, err
+		}
+		e, _, err := readWALEntry(r.br)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				// end of a rolled segment: continue with the next one
+				exhausted := r.segFirst
+				r.closeFile()
+				if err := r.ensureFile(); err != nil {
+					return out, err
+				}
+				if r.segFirst == exhausted {
+					r.closeFile()
+					return out, fmt.Errorf("buffer record %d missing from segment", r.next)
+				}
+				continue
+			}
+			return out, err
+		}
+		if e.seq < r.next {
+			continue
+		}
+		r.next = e.seq + 1
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+// ensureFile opens the segment that contains r.next.
+func (r *walReader) ensureFile() error {
+	r.w.mu.Lock()
+	var seg *walSegment
+	for _, s := range r.w.segs {
+		if s.first <= r.next {
+			seg = s
+		}
+	}
+	r.w.mu.Unlock()
+	if seg == nil {
+		return fmt.Errorf("buffer segment for seq %d not found", r.next)
+	}
+	if r.f != nil && r.segFirst == seg.first {
+		return nil
+	}
+	r.closeFile()
+	f, err := os.Open(seg.path)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.br = bufio.NewReaderSize(f, 64*1024)
+	r.segFirst = seg.first
+	return nil
+}
+
+func (r *walReader) closeFile() {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+		r.br = nil
+	}
+}
+
+// readWALEntry decodes one record and returns it with its encoded size.
+func readWALEntry(br *bufio.Reader) (walEntry, int, error) {
+	var hdr [walRecordHeader]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return walEntry{}, 0, io.EOF
+		}
+		return walEntry{}, 0, err
+	}
+	n := binary.LittleEndian.Uint32(hdr[0:4])
+	if n > walMaxRecordBytes {
+		return walEntry{}, 0, fmt.Errorf("corrupt buffer record length %d", n)
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return walEntry{}, 0, io.EOF
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[8:16])
+	crc.Write(payload)
+	if crc.Sum32() != binary.LittleEndian.Uint32(hdr[4:8]) {
+		return walEntry{}, 0, errors.New("corrupt buffer record checksum")
+	}
+	var rec walRecord
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return walEntry{}, 0, err
+	}
+	return walEntry{seq: binary.LittleEndian.Uint64(hdr[8:16]), v: rec.pointValue()}, walRecordHeader + int(n), nil
+}
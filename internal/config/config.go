@@ -15,6 +15,8 @@ type Config struct {
 	Registers      []RegisterConfig
 	CSVFile        string
 	UpdateInterval string
+	Script         string // Lua script for the whole register map
+	ScriptTick     string // on_tick period, default 1s
 }
 
 type ServerSettings struct {
@@ -28,6 +30,10 @@ type ServerSettings struct {
 	// Optional fields tolerated by parser (not necessarily used everywhere)
 	SlaveID        int
 	UpdateInterval string
+	// RTU line timing, see collector.Connection
+	FrameGaps  bool
+	Turnaround string
+	Echo       bool
 }
 
 type RegisterConfig struct {
@@ -37,6 +43,7 @@ type RegisterConfig struct {
 	Scale     float64
 	Offset    float64
 	DataType  string
+	Script    string // Lua script driving this register
 }
 
 func Load(path string) (Config, error) {
@@ -135,6 +142,10 @@ func assignRoot(cfg *Config, key, value string) error {
 		cfg.CSVFile = parseString(value)
 	case "update_interval":
 		cfg.UpdateInterval = parseString(value)
+	case "script":
+		cfg.Script = parseString(value)
+	case "script_tick":
+		cfg.ScriptTick = parseString(value)
 	default:
 		return fmt.Errorf("unknown key %s", key)
 	}
@@ -180,6 +191,20 @@ func assignServer(server *ServerSettings, key, value string) error {
 		server.SlaveID = int(v)
 	case "update_interval":
 		server.UpdateInterval = parseString(value)
+	case "frame_gaps":
+		v, err := strconv.ParseBool(parseString(value))
+		if err != nil {
+			return fmt.Errorf("invalid frame_gaps: %w", err)
+		}
+		server.FrameGaps = v
+	case "turnaround":
+		server.Turnaround = parseString(value)
+	case "echo":
+		v, err := strconv.ParseBool(parseString(value))
+		if err != nil {
+			return fmt.Errorf("invalid echo: %w", err)
+		}
+		server.Echo = v
 	default:
 		return fmt.Errorf("unknown server key %s", key)
 	}
@@ -212,6 +237,8 @@ func assignRegister(reg *RegisterConfig, key, value string) error {
 		reg.Offset = parsed
 	case "data_type":
 		reg.DataType = strings.ToLower(parseString(value))
+	case "script":
+		reg.Script = parseString(value)
 	default:
 		return fmt.Errorf("unknown register key %s", key)
 	}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// ServerID is the server id of the simulator described by a TOML config.
+const ServerID = "simulator"
+
+// RootConfig converts the TOML simulator config into the YAML server model
+// run by servermgr: one server with one device whose points are the
+// registers, named by csv_column. The server speaks RTU on serial_port when
+// rtu is set or mode is "rtu", else Modbus TCP on listen_address.
+func (c Config) RootConfig(rtu bool) (collector.RootConfig, error) {
+	interval, err := time.ParseDuration(c.UpdateInterval)
+	if err != nil {
+		return collector.RootConfig{}, fmt.Errorf("invalid update interval: %w", err)
+	}
+	scriptTick := time.Second
+	if c.ScriptTick != "" {
+		if scriptTick, err = time.ParseDuration(c.ScriptTick); err != nil {
+			return collector.RootConfig{}, fmt.Errorf("invalid script_tick: %w", err)
+		}
+	}
+	var turnaround time.Duration
+	if c.Server.Turnaround != "" {
+		if turnaround, err = time.ParseDuration(c.Server.Turnaround); err != nil {
+			return collector.RootConfig{}, fmt.Errorf("invalid turnaround: %w", err)
+		}
+	}
+
+	srv := collector.ServerConfig{
+		ServerID:   ServerID,
+		ServerName: "TOML simulator",
+		Protocol:   "modbus-tcp",
+		Enabled:    true,
+		CSVFile:    c.CSVFile,
+	}
+	if rtu || c.Server.Mode == "rtu" || c.Server.SerialPort != "" {
+		if c.Server.SerialPort == "" {
+			return collector.RootConfig{}, fmt.Errorf("serial_port must be set in [server] for RTU mode")
+		}
+		srv.Protocol = "modbus-rtu"
+		srv.Connection = collector.Connection{
+			SerialPort: c.Server.SerialPort,
+			BaudRate:   c.Server.BaudRate,
+			DataBits:   c.Server.DataBits,
+			StopBits:   c.Server.StopBits,
+			Parity:     c.Server.Parity,
+			FrameGaps:  c.Server.FrameGaps,
+			Turnaround: turnaround,
+			Echo:       c.Server.Echo,
+		}
+	} else {
+		host, port, err := net.SplitHostPort(c.Server.ListenAddress)
+		if err != nil {
+			return collector.RootConfig{}, fmt.Errorf("invalid listen_address: %w", err)
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return collector.RootConfig{}, fmt.Errorf("invalid listen_address port: %w", err)
+		}
+		srv.Connection = collector.Connection{Host: host, Port: n}
+	}
+
+	dev := collector.Device{DeviceID: ServerID, Vendor: "simulator", SlaveID: uint8(c.Server.SlaveID)}
+	if c.Script != "" {
+		dev.Script = &collector.ScriptConfig{File: c.Script, Tick: scriptTick}
+	}
+	for _, reg := range c.Registers {
+		switch reg.Type {
+		case "holding", "input":
+			switch reg.DataType {
+			case "", "uint16", "int16", "float32":
+			default:
+				return collector.RootConfig{}, fmt.Errorf("unsupported data_type %s for %s register", reg.DataType, reg.Type)
+			}
+		case "coil", "discrete":
+			if reg.DataType != "" {
+				return collector.RootConfig{}, fmt.Errorf("data_type not supported for %s registers", reg.Type)
+			}
+		default:
+			return collector.RootConfig{}, fmt.Errorf("unsupported register type %s", reg.Type)
+		}
+		name := reg.CSVColumn
+		if name == "" {
+			name = fmt.Sprintf("%s_%d", reg.Type, reg.Address)
+		}
+		p := collector.Point{
+			Name:         name,
+			Address:      reg.Address,
+			RegisterType: reg.Type,
+			DataType:     strings.ToLower(reg.DataType),
+			Scale:        reg.Scale,
+			Offset:       reg.Offset,
+		}
+		if reg.Script != "" {
+			p.Script = &collector.ScriptConfig{File: reg.Script, Tick: scriptTick}
+		}
+		dev.Points = append(dev.Points, p)
+	}
+	srv.Devices = []collector.Device{dev}
+
+	root := collector.RootConfig{
+		Frequency: map[string]time.Duration{ServerID: interval},
+		Servers:   []collector.ServerConfig{srv},
+	}
+	// paths in the TOML config stay relative to the working directory
+	if err := root.Resolve("."); err != nil {
+		return collector.RootConfig{}, err
+	}
+	return root, nil
+}
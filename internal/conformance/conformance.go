@@ -0,0 +1,401 @@
+// Package conformance checks that a Modbus server follows the protocol:
+// quantity limits, address range, malformed requests, byte counts and
+// exception codes, and on Modbus TCP transaction ids and pipelined
+// requests. Run it against vendor devices with cmd/conformance, or from Go
+// tests with Test.
+package conformance
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+)
+
+// Options configures a run.
+type Options struct {
+	Unit    uint8         // addressed unit, default 1
+	Address uint16        // start of a block readable in every table: 125 registers and 2000 bits
+	Timeout time.Duration // answer timeout, default 1s
+	// ReadOnly skips the checks that write. The others write back the
+	// values they read, but a live device may change them meanwhile.
+	ReadOnly bool
+	Checks   []string    // ids or id prefixes of the checks to run, default all
+	Tap      capture.Tap // receives every frame when set
+}
+
+// Statuses of a check.
+const (
+	Pass = "pass"
+	Fail = "fail"
+	Skip = "skip"
+)
+
+// Check is one entry of the catalog.
+type Check struct {
+	ID     string
+	Title  string
+	Writes bool // writes to the device
+	TCP    bool // applies to Modbus TCP framing only
+	RTU    bool // applies to RTU framing only
+	run    func(*conn, Options) error
+}
+
+// Result is the outcome of a check.
+type Result struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// errSkip marks a check that cannot run against the target.
+type errSkip string
+
+func (e errSkip) Error() string { return string(e) }
+
+// Run connects to server and runs the checks of the catalog selected by
+// opts. It fails only when the server cannot be reached at all.
+func Run(ctx context.Context, server collector.ServerConfig, opts Options) (Report, error) {
+	if opts.Unit == 0 {
+		opts.Unit = 1
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = time.Second
+	}
+	c := newConn(server, opts)
+	if err := c.open(); err != nil {
+		return Report{}, fmt.Errorf("connect: %w", err)
+	}
+	defer c.close()
+
+	r := Report{Target: c.address, Protocol: server.Protocol, Unit: opts.Unit, Started: time.Now()}
+	for _, check := range Catalog {
+		if !selected(check.ID, opts.Checks) {
+			continue
+		}
+		res := Result{ID: check.ID, Title: check.Title, Status: Pass}
+		var err error
+		switch {
+		case ctx.Err() != nil:
+			err = errSkip("cancelled")
+		case check.Writes && opts.ReadOnly:
+			err = errSkip("writes, skipped in read-only mode")
+		case check.TCP && !c.tcp:
+			err = errSkip("Modbus TCP only")
+		case check.RTU && c.tcp:
+			err = errSkip("RTU only")
+		default:
+			err = check.run(c, opts)
+		}
+		var skip errSkip
+		switch {
+		case errors.As(err, &skip):
+			res.Status, res.Detail = Skip, skip.Error()
+		case err != nil:
+			res.Status, res.Detail = Fail, err.Error()
+		}
+		r.add(res)
+	}
+	return r, nil
+}
+
+func selected(id string, only []string) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, p := range only {
+		if strings.HasPrefix(id, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Tables of the quantity and address checks.
+var tables = []struct {
+	name     string
+	read     byte
+	maxRead  int
+	bitTable bool
+}{
+	{"holding", 0x03, 125, false},
+	{"input", 0x04, 125, false},
+	{"coils", 0x01, 2000, true},
+	{"discrete", 0x02, 2000, true},
+}
+
+// Catalog lists every check in the order Run runs them.
+var Catalog = buildCatalog()
+
+func buildCatalog() []Check {
+	var checks []Check
+	for _, t := range tables {
+		checks = append(checks,
+			Check{ID: "read-" + t.name + "-max", Title: fmt.Sprintf("read %d %s (the maximum) answers %d data bytes", t.maxRead, t.name, byteCount(t.maxRead, t.bitTable)),
+				run: readCheck(t.read, t.maxRead, t.bitTable)},
+			Check{ID: "read-" + t.name + "-over-max", Title: fmt.Sprintf("read %d %s answers exception 03", t.maxRead+1, t.name),
+				run: exceptionCheck(func(o Options) []byte { return readPDU(t.read, o.Address, t.maxRead+1) }, 0x03)},
+			Check{ID: "read-" + t.name + "-zero", Title: fmt.Sprintf("read 0 %s answers exception 03", t.name),
+				run: exceptionCheck(func(o Options) []byte { return readPDU(t.read, o.Address, 0) }, 0x03)},
+			Check{ID: "read-" + t.name + "-out-of-range", Title: fmt.Sprintf("read %s 65535 and 65536 answers exception 02", t.name),
+				run: exceptionCheck(func(Options) []byte { return readPDU(t.read, 65535, 2) }, 0x02)},
+		)
+	}
+	checks = append(checks,
+		Check{ID: "write-register-echo", Title: "write single register answers an echo of the request", Writes: true, run: writeRegisterEcho},
+		Check{ID: "write-coil-echo", Title: "write single coil answers an echo of the request", Writes: true, run: writeCoilEcho},
+		Check{ID: "write-coil-bad-value", Title: "write single coil with a value other than FF00/0000 answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return pdu(0x05, o.Address, 0x1234) }, 0x03)},
+		Check{ID: "write-registers-max", Title: "write 123 registers (the maximum) answers address and quantity", Writes: true,
+			run: writeMultipleCheck(0x10, 123)},
+		Check{ID: "write-registers-over-max", Title: "write 124 registers answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return writeRegistersPDU(o.Address, 124, 248, 248) }, 0x03)},
+		Check{ID: "write-registers-zero", Title: "write 0 registers answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return writeRegistersPDU(o.Address, 0, 0, 0) }, 0x03)},
+		Check{ID: "write-registers-byte-count", Title: "write 2 registers with byte count 3 answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return writeRegistersPDU(o.Address, 2, 3, 3) }, 0x03)},
+		Check{ID: "write-registers-out-of-range", Title: "write registers 65535 and 65536 answers exception 02", Writes: true,
+			run: exceptionCheck(func(Options) []byte { return writeRegistersPDU(65535, 2, 4, 4) }, 0x02)},
+		Check{ID: "write-coils-max", Title: "write 1968 coils (the maximum) answers address and quantity", Writes: true,
+			run: writeMultipleCheck(0x0F, 1968)},
+		Check{ID: "write-coils-over-max", Title: "write 1969 coils answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return writeCoilsPDU(o.Address, 1969, 247, 247) }, 0x03)},
+		Check{ID: "write-coils-byte-count", Title: "write 10 coils with byte count 1 answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return writeCoilsPDU(o.Address, 10, 1, 1) }, 0x03)},
+		Check{ID: "malformed-read-short", Title: "read holding registers without the quantity answers exception 03",
+			run: exceptionCheck(func(o Options) []byte { return pdu(0x03, o.Address) }, 0x03)},
+		Check{ID: "malformed-write-register-long", Title: "write single register with a trailing byte answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return append(pdu(0x06, o.Address, 0), 0) }, 0x03)},
+		Check{ID: "malformed-write-registers-short", Title: "write 2 registers with 2 of the 4 data bytes answers exception 03", Writes: true,
+			run: exceptionCheck(func(o Options) []byte { return writeRegistersPDU(o.Address, 2, 4, 2) }, 0x03)},
+		Check{ID: "unsupported-function", Title: "an unassigned function code (0x09) answers exception 01",
+			run: exceptionCheck(func(Options) []byte { return []byte{0x09, 0, 0, 0, 1} }, 0x01)},
+		Check{ID: "tcp-transaction-id", Title: "answers echo the transaction id, protocol id 0 and the unit id", TCP: true, run: transactionIDs},
+		Check{ID: "tcp-pipelining", Title: "8 requests sent back to back are all answered", TCP: true, run: pipelining},
+		Check{ID: "rtu-bad-crc", Title: "a request with a bad CRC is not answered and the next one is", RTU: true, run: badCRC},
+	)
+	return checks
+}
+
+// pdu builds a request of a function code and 16-bit fields.
+func pdu(fc byte, fields ...uint16) []byte {
+	p := []byte{fc}
+	for _, f := range fields {
+		p = binary.BigEndian.AppendUint16(p, f)
+	}
+	return p
+}
+
+func readPDU(fc byte, address uint16, quantity int) []byte {
+	return pdu(fc, address, uint16(quantity))
+}
+
+// writeRegistersPDU builds a write multiple registers request with the
+// given byte count field and number of data bytes.
+func writeRegistersPDU(address uint16, quantity, count, data int) []byte {
+	return append(append(pdu(0x10, address, uint16(quantity)), byte(count)), make([]byte, data)...)
+}
+
+func writeCoilsPDU(address uint16, quantity, count, data int) []byte {
+	return append(append(pdu(0x0F, address, uint16(quantity)), byte(count)), make([]byte, data)...)
+}
+
+func byteCount(quantity int, bits bool) int {
+	if bits {
+		return (quantity + 7) / 8
+	}
+	return 2 * quantity
+}
+
+// expectException checks that resp is exception code to request fc.
+func expectException(resp []byte, fc, code byte) error {
+	switch {
+	case len(resp) == 2 && resp[0] == fc|0x80 && resp[1] == code:
+		return nil
+	case len(resp) == 2 && resp[0] == fc|0x80:
+		return fmt.Errorf("answered exception %02X, want %02X", resp[1], code)
+	case len(resp) > 0 && resp[0] == fc|0x80:
+		return fmt.Errorf("exception answer of %d bytes: % X", len(resp), resp)
+	}
+	return fmt.Errorf("answered % X, want exception %02X", clip(resp), code)
+}
+
+// exceptionCheck sends the request build makes and expects exception code.
+func exceptionCheck(build func(Options) []byte, code byte) func(*conn, Options) error {
+	return func(c *conn, o Options) error {
+		req := build(o)
+		resp, err := c.exchange(req)
+		if err != nil {
+			return err
+		}
+		return expectException(resp, req[0], code)
+	}
+}
+
+// read reads quantity values and checks the byte count of the answer.
+func read(c *conn, fc byte, address uint16, quantity int, bits bool) ([]byte, error) {
+	resp, err := c.exchange(readPDU(fc, address, quantity))
+	if err != nil {
+		return nil, err
+	}
+	want := byteCount(quantity, bits)
+	switch {
+	case len(resp) == 2 && resp[0] == fc|0x80:
+		return nil, fmt.Errorf("answered exception %02X; is the block at the start address readable?", resp[1])
+	case len(resp) < 2 || resp[0] != fc:
+		return nil, fmt.Errorf("answered % X, want function %02X", clip(resp), fc)
+	case int(resp[1]) != want:
+		return nil, fmt.Errorf("byte count %d, want %d", resp[1], want)
+	case len(resp) != 2+want:
+		return nil, fmt.Errorf("%d data bytes after byte count %d", len(resp)-2, want)
+	}
+	return resp[2:], nil
+}
+
+func readCheck(fc byte, quantity int, bits bool) func(*conn, Options) error {
+	return func(c *conn, o Options) error {
+		_, err := read(c, fc, o.Address, quantity, bits)
+		return err
+	}
+}
+
+// expectEcho checks that resp repeats the first n bytes of req.
+func expectEcho(resp, req []byte, n int) error {
+	if len(resp) == 2 && resp[0] == req[0]|0x80 {
+		return fmt.Errorf("answered exception %02X", resp[1])
+	}
+	if len(resp) != n || string(resp) != string(req[:n]) {
+		return fmt.Errorf("answered % X, want % X", clip(resp), req[:n])
+	}
+	return nil
+}
+
+func writeRegisterEcho(c *conn, o Options) error {
+	data, err := read(c, 0x03, o.Address, 1, false)
+	if err != nil {
+		return fmt.Errorf("read back: %w", err)
+	}
+	req := pdu(0x06, o.Address, binary.BigEndian.Uint16(data))
+	resp, err := c.exchange(req)
+	if err != nil {
+		return err
+	}
+	return expectEcho(resp, req, 5)
+}
+
+func writeCoilEcho(c *conn, o Options) error {
+	data, err := read(c, 0x01, o.Address, 1, true)
+	if err != nil {
+		return fmt.Errorf("read back: %w", err)
+	}
+	value := uint16(0)
+	if data[0]&1 != 0 {
+		value = 0xFF00
+	}
+	req := pdu(0x05, o.Address, value)
+	resp, err := c.exchange(req)
+	if err != nil {
+		return err
+	}
+	return expectEcho(resp, req, 5)
+}
+
+// writeMultipleCheck writes back quantity registers or coils it read and
+// expects the address and quantity in the answer.
+func writeMultipleCheck(fc byte, quantity int) func(*conn, Options) error {
+	return func(c *conn, o Options) error {
+		readFC, bits := byte(0x03), false
+		if fc == 0x0F {
+			readFC, bits = 0x01, true
+		}
+		data, err := read(c, readFC, o.Address, quantity, bits)
+		if err != nil {
+			return fmt.Errorf("read back: %w", err)
+		}
+		req := append(append(pdu(fc, o.Address, uint16(quantity)), byte(len(data))), data...)
+		resp, err := c.exchange(req)
+		if err != nil {
+			return err
+		}
+		return expectEcho(resp, req, 5)
+	}
+}
+
+func transactionIDs(c *conn, o Options) error {
+	for _, tid := range []uint16{0x0000, 0x00FF, 0xBEEF, 0xFFFF} {
+		if err := c.sendTCP(tid, readPDU(0x03, o.Address, 1)); err != nil {
+			return err
+		}
+		header, resp, err := c.receiveTCP()
+		if err != nil {
+			return err
+		}
+		if got := binary.BigEndian.Uint16(header); got != tid {
+			c.close()
+			return fmt.Errorf("request %04X answered with transaction id %04X", tid, got)
+		}
+		if len(resp) != 4 || resp[0] != 0x03 || resp[1] != 2 {
+			return fmt.Errorf("request %04X answered % X", tid, clip(resp))
+		}
+	}
+	return nil
+}
+
+// pipelining sends requests of different sizes without waiting and
+// matches the answers by transaction id, in any order.
+func pipelining(c *conn, o Options) error {
+	const n = 8
+	want := map[uint16]int{}
+	for i := range n {
+		tid := uint16(0x1000 + i)
+		want[tid] = i + 1
+		if err := c.sendTCP(tid, readPDU(0x03, o.Address, i+1)); err != nil {
+			return err
+		}
+	}
+	for range n {
+		header, resp, err := c.receiveTCP()
+		if err != nil {
+			return fmt.Errorf("after %d of %d answers: %w", n-len(want), n, err)
+		}
+		tid := binary.BigEndian.Uint16(header)
+		quantity, ok := want[tid]
+		if !ok {
+			c.close()
+			return fmt.Errorf("answer with unexpected or repeated transaction id %04X", tid)
+		}
+		delete(want, tid)
+		if len(resp) != 2+2*quantity || resp[0] != 0x03 || int(resp[1]) != 2*quantity {
+			c.close()
+			return fmt.Errorf("request %04X for %d registers answered % X", tid, quantity, clip(resp))
+		}
+	}
+	return nil
+}
+
+func badCRC(c *conn, o Options) error {
+	if err := c.sendRTU(readPDU(0x03, o.Address, 1), true); err != nil {
+		return err
+	}
+	if err := c.expectSilence(); err != nil {
+		return err
+	}
+	if _, err := read(c, 0x03, o.Address, 1, false); err != nil {
+		return fmt.Errorf("next request: %w", err)
+	}
+	return nil
+}
+
+// clip shortens long answers in messages.
+func clip(b []byte) []byte {
+	if len(b) > 16 {
+		return b[:16]
+	}
+	return b
+}
@@ -0,0 +1,309 @@
+package conformance
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/goburrow/serial"
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	utils "modbus-simulator/internal/utils"
+)
+
+// errNoAnswer means the server stayed silent until the timeout.
+var errNoAnswer = errors.New("no answer")
+
+// conn exchanges raw frames with the server, so that checks can send
+// requests a client library would refuse to build. It reconnects after a
+// timeout or a broken frame, so a late answer cannot pass for the next.
+type conn struct {
+	server  collector.ServerConfig
+	tcp     bool // Modbus TCP framing, else RTU
+	unit    byte
+	timeout time.Duration
+	tap     capture.Tap
+
+	rw      io.ReadWriteCloser
+	address string
+	nextTID uint16
+}
+
+func newConn(server collector.ServerConfig, opts Options) *conn {
+	proto := strings.ToLower(server.Protocol)
+	tcp := proto == "modbus-tcp" || proto == "tcp" || proto == "modbus-tls" || proto == "tls" || proto == "mbaps"
+	return &conn{server: server, tcp: tcp, unit: opts.Unit, timeout: opts.Timeout,
+		tap: opts.Tap, nextTID: 1}
+}
+
+func (c *conn) open() error {
+	if c.rw != nil {
+		return nil
+	}
+	s := c.server
+	switch strings.ToLower(s.Protocol) {
+	case "modbus-tcp", "tcp", "rtu-over-tcp", "rtu_over_tcp", "modbus-rtu-over-tcp":
+		c.address = net.JoinHostPort(s.Connection.Host, fmt.Sprint(s.Connection.Port))
+		nc, err := net.DialTimeout("tcp", c.address, c.timeout)
+		if err != nil {
+			return err
+		}
+		c.rw = nc
+	case "modbus-tls", "tls", "mbaps":
+		if s.Connection.TLS == nil {
+			return fmt.Errorf("protocol %s requires connection.tls", s.Protocol)
+		}
+		config, err := s.Connection.TLS.ClientConfig(s.Connection.Host)
+		if err != nil {
+			return err
+		}
+		c.address = net.JoinHostPort(s.Connection.Host, fmt.Sprint(s.Connection.Port))
+		tc, err := tls.DialWithDialer(&net.Dialer{Timeout: c.timeout}, "tcp", c.address, config)
+		if err != nil {
+			return err
+		}
+		c.rw = tc
+	case "modbus-rtu", "rtu":
+		c.address = s.Connection.SerialPort
+		port, err := utils.OpenSerial(utils.SerialParams{Address: s.Connection.SerialPort, BaudRate: s.Connection.BaudRate,
+			DataBits: s.Connection.DataBits, StopBits: s.Connection.StopBits, Parity: s.Connection.Parity, Timeout: c.timeout})
+		if err != nil {
+			return err
+		}
+		c.rw = port
+	default:
+		return fmt.Errorf("protocol %s not implemented", s.Protocol)
+	}
+	return nil
+}
+
+func (c *conn) close() {
+	if c.rw != nil {
+		c.rw.Close()
+		c.rw = nil
+	}
+}
+
+// exchange sends pdu to the unit and returns the answer PDU. On Modbus TCP
+// the answer must echo the transaction id, protocol id and unit id.
+func (c *conn) exchange(pdu []byte) ([]byte, error) {
+	if c.tcp {
+		tid := c.nextTID
+		c.nextTID++
+		if err := c.sendTCP(tid, pdu); err != nil {
+			return nil, err
+		}
+		header, resp, err := c.receiveTCP()
+		if err != nil {
+			return nil, err
+		}
+		if got := binary.BigEndian.Uint16(header); got != tid {
+			c.close()
+			return nil, fmt.Errorf("answer has transaction id %d, want %d", got, tid)
+		}
+		return resp, nil
+	}
+	if err := c.sendRTU(pdu, false); err != nil {
+		return nil, err
+	}
+	return c.receiveRTU()
+}
+
+func (c *conn) write(frame []byte) error {
+	if err := c.open(); err != nil {
+		return err
+	}
+	if d, ok := c.rw.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := c.rw.Write(frame); err != nil {
+		c.close()
+		return err
+	}
+	c.report(capture.Request, frame)
+	return nil
+}
+
+// sendTCP writes one Modbus TCP request.
+func (c *conn) sendTCP(tid uint16, pdu []byte) error {
+	frame := binary.BigEndian.AppendUint16(nil, tid)
+	frame = append(frame, 0, 0)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(pdu)+1))
+	frame = append(frame, c.unit)
+	return c.write(append(frame, pdu...))
+}
+
+// receiveTCP reads one Modbus TCP answer and checks its protocol id,
+// unit id and length; header holds the transaction id.
+func (c *conn) receiveTCP() (header, pdu []byte, err error) {
+	head := make([]byte, 7)
+	if err := c.read(head); err != nil {
+		return nil, nil, err
+	}
+	length := int(binary.BigEndian.Uint16(head[4:]))
+	if length < 2 || length > 254 {
+		c.close()
+		return nil, nil, fmt.Errorf("answer length field %d out of 2..254", length)
+	}
+	pdu = make([]byte, length-1)
+	if err := c.read(pdu); err != nil {
+		return nil, nil, err
+	}
+	c.report(capture.Response, append(append([]byte(nil), head...), pdu...))
+	if p := binary.BigEndian.Uint16(head[2:]); p != 0 {
+		return nil, nil, fmt.Errorf("answer has protocol id %d, want 0", p)
+	}
+	if head[6] != c.unit {
+		return nil, nil, fmt.Errorf("answer has unit id %d, want %d", head[6], c.unit)
+	}
+	return head, pdu, nil
+}
+
+// read fills buf before the timeout.
+func (c *conn) read(buf []byte) error {
+	if c.rw == nil {
+		return errNoAnswer
+	}
+	if d, ok := c.rw.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(time.Now().Add(c.timeout))
+	}
+	if _, err := io.ReadFull(c.rw, buf); err != nil {
+		c.close()
+		if isTimeout(err) {
+			return errNoAnswer
+		}
+		return err
+	}
+	return nil
+}
+
+// sendRTU writes one RTU request, with a corrupted CRC when badCRC is set.
+func (c *conn) sendRTU(pdu []byte, badCRC bool) error {
+	frame := append([]byte{c.unit}, pdu...)
+	crc := modbus.CRC16(frame)
+	if badCRC {
+		crc ^= 0xFFFF
+	}
+	return c.write(binary.LittleEndian.AppendUint16(frame, crc))
+}
+
+// receiveRTU reads one RTU answer and checks its slave id and CRC. The
+// frame length follows from the function code when it is known, else the
+// frame ends where the CRC matches.
+func (c *conn) receiveRTU() ([]byte, error) {
+	var frame []byte
+	buf := make([]byte, 256)
+	deadline := time.Now().Add(c.timeout)
+	for {
+		if c.rw == nil {
+			return nil, errNoAnswer
+		}
+		if d, ok := c.rw.(interface{ SetDeadline(time.Time) error }); ok {
+			d.SetDeadline(deadline)
+		}
+		n, err := c.rw.Read(buf)
+		frame = append(frame, buf[:n]...)
+		if want := rtuLength(frame); want > 0 && len(frame) >= want || want == 0 && len(frame) >= 4 && crcOK(frame) {
+			break
+		}
+		if err != nil || time.Now().After(deadline) {
+			c.close()
+			if len(frame) > 0 {
+				return nil, fmt.Errorf("incomplete answer % X", frame)
+			}
+			if err == nil || isTimeout(err) {
+				return nil, errNoAnswer
+			}
+			return nil, err
+		}
+	}
+	c.report(capture.Response, frame)
+	if want := rtuLength(frame); want > 0 && len(frame) != want {
+		c.close()
+		return nil, fmt.Errorf("answer of %d bytes, its function code gives %d: % X", len(frame), want, frame)
+	}
+	if !crcOK(frame) {
+		c.close()
+		return nil, fmt.Errorf("answer with a bad CRC: % X", frame)
+	}
+	if frame[0] != c.unit {
+		return nil, fmt.Errorf("answer from slave %d, want %d", frame[0], c.unit)
+	}
+	return frame[1 : len(frame)-2], nil
+}
+
+// expectSilence waits for the timeout and fails if anything arrives. The
+// connection stays open, so the next request shows whether the server
+// kept its framing.
+func (c *conn) expectSilence() error {
+	if c.rw == nil {
+		return errNoAnswer
+	}
+	if d, ok := c.rw.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(time.Now().Add(c.timeout))
+	}
+	buf := make([]byte, 256)
+	n, err := c.rw.Read(buf)
+	if n > 0 {
+		c.close()
+		return fmt.Errorf("answered % X", clip(buf[:n]))
+	}
+	if err != nil && !isTimeout(err) {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// rtuLength is the length of the RTU answer starting frame, 0 when its
+// function code does not tell and -1 until enough bytes are in.
+func rtuLength(frame []byte) int {
+	if len(frame) < 2 {
+		return -1
+	}
+	fc := frame[1]
+	switch {
+	case fc&0x80 != 0:
+		return 5
+	case fc >= 0x01 && fc <= 0x04:
+		if len(frame) < 3 {
+			return -1
+		}
+		return 5 + int(frame[2])
+	case fc == 0x05 || fc == 0x06 || fc == 0x0F || fc == 0x10:
+		return 8
+	}
+	return 0
+}
+
+func crcOK(frame []byte) bool {
+	n := len(frame)
+	return n >= 4 && modbus.CRC16(frame[:n-2]) == binary.LittleEndian.Uint16(frame[n-2:])
+}
+
+func (c *conn) report(direction string, adu []byte) {
+	if c.tap == nil {
+		return
+	}
+	transport, src, dst := capture.RTU, "conformance", c.address
+	if c.tcp {
+		transport = capture.TCP
+	}
+	if direction == capture.Response {
+		src, dst = dst, src
+	}
+	c.tap(capture.Frame{Time: time.Now(), Transport: transport, Direction: direction, Src: src, Dst: dst,
+		ADU: append([]byte(nil), adu...)})
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout() || errors.Is(err, serial.ErrTimeout) || errors.Is(err, os.ErrDeadlineExceeded)
+}
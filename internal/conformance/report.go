@@ -0,0 +1,58 @@
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Report is the outcome of a run.
+type Report struct {
+	Target   string    `json:"target"`
+	Protocol string    `json:"protocol"`
+	Unit     uint8     `json:"unit"`
+	Started  time.Time `json:"started"`
+	Passed   int       `json:"passed"`
+	Failed   int       `json:"failed"`
+	Skipped  int       `json:"skipped"`
+	Results  []Result  `json:"results"`
+}
+
+func (r *Report) add(res Result) {
+	switch res.Status {
+	case Pass:
+		r.Passed++
+	case Fail:
+		r.Failed++
+	default:
+		r.Skipped++
+	}
+	r.Results = append(r.Results, res)
+}
+
+// OK reports whether no check failed.
+func (r Report) OK() bool { return r.Failed == 0 }
+
+// WriteJSON writes r as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes one line per check and a summary.
+func (r Report) WriteText(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "conformance of %s %s, unit %d\n\n", r.Protocol, r.Target, r.Unit)
+	for _, res := range r.Results {
+		fmt.Fprintf(&b, "%-4s  %-32s %s\n", strings.ToUpper(res.Status), res.ID, res.Title)
+		if res.Detail != "" {
+			fmt.Fprintf(&b, "      %-32s -> %s\n", "", res.Detail)
+		}
+	}
+	fmt.Fprintf(&b, "\n%d passed, %d failed, %d skipped\n", r.Passed, r.Failed, r.Skipped)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
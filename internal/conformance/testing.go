@@ -0,0 +1,29 @@
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// Test runs the checks against server as subtests of t, one per check:
+// failed checks fail their subtest and skipped ones skip it.
+func Test(t *testing.T, server collector.ServerConfig, opts Options) Report {
+	t.Helper()
+	r, err := Run(context.Background(), server, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range r.Results {
+		t.Run(res.ID, func(t *testing.T) {
+			switch res.Status {
+			case Fail:
+				t.Errorf("%s: %s", res.Title, res.Detail)
+			case Skip:
+				t.Skip(res.Detail)
+			}
+		})
+	}
+	return r
+}
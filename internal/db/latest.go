@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"modbus-simulator/internal/model"
+)
+
+// latestConflict upserts latest_datas_value rows on their composite key.
+// Rows older than the stored one leave it alone, so imports, buffer replay
+// and late flushes never roll a latest value back.
+var latestConflict = clause.OnConflict{
+	Columns: []clause.Column{{Name: "server_id"}, {Name: "device_id"}, {Name: "name"}},
+	DoUpdates: clause.AssignmentColumns([]string{
+		"address", "register_type", "data_type", "byte_order", "unit", "value", "timestamp",
+	}),
+	Where: clause.Where{Exprs: []clause.Expression{
+		clause.Expr{SQL: "excluded.timestamp >= latest_datas_value.timestamp"},
+	}},
+}
+
+// UpsertLatestValues writes rows into latest_datas_value keyed by
+// (server_id, device_id, name), replacing the stored value of existing keys
+// unless the stored row is newer.
+func UpsertLatestValues(ctx context.Context, db *gorm.DB, rows []model.LatestDataValue) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return tx.Clauses(latestConflict).CreateInBatches(rows, 500).Error
+	})
+}
+
+// UpsertLatestFromPointValues folds point_values rows into latest_datas_value,
+// keeping the newest row per key. Rows without a server_id inherit it from
+// the devices table.
+func UpsertLatestFromPointValues(ctx context.Context, db *gorm.DB, pvs []model.PointValue) error {
+	if len(pvs) == 0 {
+		return nil
+	}
+	servers := map[string]string{}
+	newest := make(map[[3]string]model.LatestDataValue, len(pvs))
+	order := make([][3]string, 0, len(pvs))
+	for _, pv := range pvs {
+		serverID := pv.ServerID
+		if serverID == "" {
+			sid, ok := servers[pv.DeviceID]
+			if !ok {
+				var dev model.Device
+				err := db.WithContext(ctx).Select("server_id").Where("device_id = ?", pv.DeviceID).Limit(1).Find(&dev).Error
+				if err != nil {
+					return err
+				}
+				sid = dev.ServerID
+				servers[pv.DeviceID] = sid
+			}
+			serverID = sid
+		}
+		key := [3]string{serverID, pv.DeviceID, pv.Name}
+		if cur, ok := newest[key]; ok && cur.Timestamp.After(pv.Timestamp) {
+			continue
+		} else if !ok {
+			order = append(order, key)
+		}
+		newest[key] = model.LatestDataValue{
+			ServerID:     serverID,
+			DeviceID:     pv.DeviceID,
+			Name:         pv.Name,
+			Address:      pv.Address,
+			RegisterType: pv.RegisterType,
+			DataType:     pv.DataType,
+			ByteOrder:    pv.ByteOrder,
+			Unit:         pv.Unit,
+			Value:        pv.Value,
+			Timestamp:    pv.Timestamp,
+		}
+	}
+	rows := make([]model.LatestDataValue, 0, len(order))
+	for _, k := range order {
+		rows = append(rows, newest[k])
+	}
+	return UpsertLatestValues(ctx, db, rows)
+}
+
+// LatestPointsORM returns the rows of latest_datas_value, optionally filtered
+// by serverID/deviceID, ordered by name.
+func LatestPointsORM(ctx context.Context, db *gorm.DB, serverID, deviceID string) ([]PointLatest, error) {
+	q := db.WithContext(ctx).Model(&model.LatestDataValue{})
+	if serverID != "" {
+		q = q.Where("server_id = ?", serverID)
+	}
+	if deviceID != "" {
+		q = q.Where("device_id = ?", deviceID)
+	}
+	var rows []model.LatestDataValue
+	if err := q.Order("name, server_id, device_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]PointLatest, 0, len(rows))
+	for _, r := range rows {
+		out = append(out, PointLatest{
+			ServerID:     r.ServerID,
+			DeviceID:     r.DeviceID,
+			Name:         r.Name,
+			Address:      r.Address,
+			RegisterType: r.RegisterType,
+			DataType:     r.DataType,
+			ByteOrder:    r.ByteOrder,
+			Unit:         r.Unit,
+			Value:        r.Value,
+			Timestamp:    r.Timestamp,
+		})
+	}
+	return out, nil
+}
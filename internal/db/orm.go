@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"strings"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -10,16 +11,38 @@ import (
 	"modbus-simulator/internal/model"
 )
 
+// sqlitePragmas are applied to every pooled connection through the DSN:
+// WAL journaling lets readers proceed while a batch commits, NORMAL sync is
+// durable enough with WAL, busy_timeout makes concurrent writers wait instead
+// of failing with SQLITE_BUSY, and immediate transactions avoid lock upgrades.
+var sqlitePragmas = []string{
+	"_journal_mode=WAL",
+	"_synchronous=NORMAL",
+	"_busy_timeout=5000",
+	"_txlock=immediate",
+	"_cache_size=-16000",
+}
+
 // openORM opens a GORM SQLite connection with sane defaults.
 func openORM(path string) (*gorm.DB, error) {
-	return gorm.Open(sqlite.Open(path), &gorm.Config{
+	return gorm.Open(sqlite.Open(sqliteDSN(path)), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Warn),
 	})
 }
 
+// sqliteDSN appends the default pragmas unless the caller already passed
+// query parameters or asked for an in-memory database.
+func sqliteDSN(path string) string {
+	if strings.Contains(path, "?") || strings.Contains(path, ":memory:") {
+		return path
+	}
+	return path + "?" + strings.Join(sqlitePragmas, "&")
+}
+
 // migrateORM ensures the schema for all models exists.
 func migrateORM(db *gorm.DB) error {
-	return db.AutoMigrate(&model.Server{}, &model.Device{}, &model.PointValue{}, &model.LatestDataValue{})
+	return db.AutoMigrate(&model.Server{}, &model.Device{}, &model.PointValue{}, &model.LatestDataValue{},
+		&model.PointRollup1m{}, &model.PointRollup1h{}, &model.RollupState{}, &model.RollupLate{}, &model.RegisterState{})
 }
 
 // closeORM closes the underlying SQL DB associated with the GORM connection.
@@ -33,18 +56,24 @@ func closeORM(db *gorm.DB) error {
 
 // insertPointValue persists a new point value row using the provided context.
 func insertPointValue(ctx context.Context, db *gorm.DB, pv *model.PointValue) error {
-	return db.WithContext(ctx).Create(pv).Error
+	return CreatePointValue(ctx, db, pv)
 }
 
-// InsertPointValuesBatch inserts multiple point values efficiently.
+// InsertPointValuesBatch inserts multiple point values in a single transaction.
+// batchSize controls how many rows go into each INSERT statement.
 func InsertPointValuesBatch(ctx context.Context, db *gorm.DB, pvs []model.PointValue, batchSize int) error {
 	if batchSize <= 0 {
-		batchSize = 1000
+		batchSize = 500
 	}
 	if len(pvs) == 0 {
 		return nil
 	}
-	return db.WithContext(ctx).CreateInBatches(pvs, batchSize).Error
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(pvs, batchSize).Error; err != nil {
+			return err
+		}
+		return markLateRows(ctx, tx, pvs)
+	})
 }
 
 // upsertServer inserts or updates a server definition.
@@ -145,32 +174,6 @@ func ListDevicePointValues(ctx context.Context, db *gorm.DB, deviceID string, li
 	return out, nil
 }
 
-// LatestPointsORM returns the latest record per (server_id, device_id, name),
-// with optional filters for serverID/deviceID.
-func LatestPointsORM(ctx context.Context, db *gorm.DB, serverID, deviceID string) ([]PointLatest, error) {
-	// Build subquery grouped by composite key
-	sub := db.Table("point_values as p").Joins("JOIN devices d ON d.device_id = p.device_id")
-	if serverID != "" {
-		sub = sub.Where("d.server_id = ?", serverID)
-	}
-	if deviceID != "" {
-		sub = sub.Where("p.device_id = ?", deviceID)
-	}
-	sub = sub.Select("d.server_id as server_id, p.device_id as device_id, p.name as name, MAX(p.timestamp) as ts").
-		Group("d.server_id, p.device_id, p.name")
-
-	var out []PointLatest
-	q := db.WithContext(ctx).Table("point_values as p").
-		Joins("JOIN devices d ON d.device_id = p.device_id").
-		Joins("JOIN (?) as l ON l.server_id = d.server_id AND l.device_id = p.device_id AND l.name = p.name AND l.ts = p.timestamp", sub).
-		Select("d.server_id, p.device_id, p.name, p.address, p.register_type, p.data_type, p.byte_order, p.unit, COALESCE(p.value, 0.0) as value, p.timestamp").
-		Order("p.name")
-	if err := q.Scan(&out).Error; err != nil {
-		return nil, err
-	}
-	return out, nil
-}
-
 // --------------------
 // Additional CRUD helpers
 // --------------------
@@ -182,7 +185,12 @@ func UpsertServer(ctx context.Context, db *gorm.DB, s *model.Server) error {
 
 // CreatePointValue inserts a new point_values row.
 func CreatePointValue(ctx context.Context, db *gorm.DB, pv *model.PointValue) error {
-	return db.WithContext(ctx).Create(pv).Error
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(pv).Error; err != nil {
+			return err
+		}
+		return markLateRows(ctx, tx, []model.PointValue{*pv})
+	})
 }
 
 // GetPointValue retrieves a point_values row by primary ID.
@@ -0,0 +1,738 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"modbus-simulator/internal/model"
+)
+
+// Aggregation functions accepted by RangeQuery.Aggs.
+const (
+	AggAvg   = "avg"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggSum   = "sum"
+	AggCount = "count"
+	AggFirst = "first"
+	AggLast  = "last"
+	AggDelta = "delta" // counter increase, treating a decrease as a reset
+)
+
+// Gap-fill modes for empty buckets.
+const (
+	FillNone     = "none"
+	FillNull     = "null"
+	FillZero     = "zero"
+	FillPrevious = "previous"
+	FillLinear   = "linear"
+)
+
+// maxBucketsPerSeries guards against interval/range combinations that would
+// materialize an absurd number of gap-filled rows.
+const maxBucketsPerSeries = 100000
+
+// SeriesRef selects points. Empty fields match anything, so
+// {DeviceID: "d1"} selects every point of device d1.
+type SeriesRef struct {
+	ServerID string `json:"server_id"`
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+}
+
+func (s SeriesRef) less(o SeriesRef) bool {
+	if s.ServerID != o.ServerID {
+		return s.ServerID < o.ServerID
+	}
+	if s.DeviceID != o.DeviceID {
+		return s.DeviceID < o.DeviceID
+	}
+	return s.Name < o.Name
+}
+
+// RangeQuery reads the selected points over [From, To). Without Aggs the raw
+// values are returned; with Aggs the values are grouped into buckets of
+// Interval (aligned to the Unix epoch; zero means one bucket for the whole
+// range). Resolution forces the source table, empty picks the coarsest one
+// that can answer exactly. Results are ordered by series then time and paged
+// by Limit/Cursor.
+type RangeQuery struct {
+	Points     []SeriesRef
+	From       time.Time
+	To         time.Time
+	Aggs       []string
+	Interval   time.Duration
+	Fill       string
+	Resolution string
+	Limit      int
+	Cursor     string
+}
+
+// RangeRow is one raw value (Values["value"]) or one bucket with a value per
+// requested aggregation. A nil value means the bucket had no data.
+type RangeRow struct {
+	ServerID  string              `json:"server_id"`
+	DeviceID  string              `json:"device_id"`
+	Name      string              `json:"name"`
+	Timestamp time.Time           `json:"timestamp"`
+	Values    map[string]*float64 `json:"values"`
+}
+
+// RangeResult is one page of rows. NextCursor is empty on the last page.
+type RangeResult struct {
+	Resolution string     `json:"resolution"`
+	Rows       []RangeRow `json:"rows"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// rangeCursor marks the last row returned; ID only matters for raw rows.
+type rangeCursor struct {
+	Series SeriesRef `json:"s"`
+	TS     time.Time `json:"t"`
+	ID     uint      `json:"id,omitempty"`
+}
+
+func encodeCursor(c rangeCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*rangeCursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var c rangeCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return &c, nil
+}
+
+// resolutionStep is the bucket width of a rollup table.
+func resolutionStep(res string) time.Duration {
+	switch res {
+	case Resolution1m:
+		return time.Minute
+	case Resolution1h:
+		return time.Hour
+	}
+	return 0
+}
+
+func sourceTable(res string) (table, column string) {
+	switch res {
+	case Resolution1m:
+		return "point_values_1m", "bucket"
+	case Resolution1h:
+		return "point_values_1h", "bucket"
+	}
+	return "point_values", "timestamp"
+}
+
+// validate normalizes q and reports malformed requests.
+func (q *RangeQuery) validate() error {
+	if q.From.IsZero() || q.To.IsZero() || !q.From.Before(q.To) {
+		return errors.New("query needs from < to")
+	}
+	if q.Interval < 0 {
+		return errors.New("interval must not be negative")
+	}
+	for i, a := range q.Aggs {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch a {
+		case AggAvg, AggMin, AggMax, AggSum, AggCount, AggFirst, AggLast, AggDelta:
+		default:
+			return fmt.Errorf("unknown aggregation %q", a)
+		}
+		q.Aggs[i] = a
+	}
+	q.Fill = strings.ToLower(strings.TrimSpace(q.Fill))
+	switch q.Fill {
+	case "":
+		q.Fill = FillNone
+	case FillNone, FillNull, FillZero, FillPrevious, FillLinear:
+	default:
+		return fmt.Errorf("unknown fill %q", q.Fill)
+	}
+	if q.Fill != FillNone && len(q.Aggs) > 0 && q.Interval > 0 {
+		first := alignEpoch(q.From, q.Interval)
+		if n := q.To.Sub(first) / q.Interval; n > maxBucketsPerSeries {
+			return fmt.Errorf("%d buckets per series exceeds the limit of %d", n, maxBucketsPerSeries)
+		}
+	}
+	switch q.Resolution {
+	case "", "auto", ResolutionRaw, Resolution1m, Resolution1h:
+	default:
+		return fmt.Errorf("unknown resolution %q", q.Resolution)
+	}
+	return nil
+}
+
+// alignEpoch returns the start of the iv-long bucket holding ts, counting
+// buckets from the Unix epoch. time.Truncate counts from the zero time,
+// which shifts buckets such as weeks.
+func alignEpoch(ts time.Time, iv time.Duration) time.Time {
+	epoch := time.Unix(0, 0)
+	d := ts.Sub(epoch)
+	n := d / iv
+	if d%iv < 0 {
+		n--
+	}
+	return epoch.Add(n * iv).In(ts.Location())
+}
+
+// rangeSource picks the table aggregation reads from. A rollup can answer
+// exactly only if its buckets nest inside the output buckets and the range
+// boundaries fall on its bucket edges.
+func rangeSource(ctx context.Context, db *gorm.DB, q RangeQuery) (string, error) {
+	if len(q.Aggs) == 0 {
+		return ResolutionRaw, nil
+	}
+	if q.Resolution != "" && q.Resolution != "auto" {
+		return q.Resolution, nil
+	}
+	pref, err := PickResolution(ctx, db, q.From, q.To)
+	if err != nil {
+		return "", err
+	}
+	for _, res := range []string{Resolution1h, Resolution1m} {
+		if resolutionStep(res) > resolutionStep(pref) {
+			continue
+		}
+		step := resolutionStep(res)
+		if !alignEpoch(q.From, step).Equal(q.From) || !alignEpoch(q.To, step).Equal(q.To) {
+			continue
+		}
+		if q.Interval > 0 && q.Interval%step != 0 {
+			continue
+		}
+		return res, nil
+	}
+	return ResolutionRaw, nil
+}
+
+// resolveSeries expands the selectors into the concrete series that have data
+// in [from, to), sorted.
+func resolveSeries(ctx context.Context, db *gorm.DB, res string, refs []SeriesRef, from, to time.Time) ([]SeriesRef, error) {
+	table, column := sourceTable(res)
+	if len(refs) == 0 {
+		refs = []SeriesRef{{}}
+	}
+	seen := map[SeriesRef]bool{}
+	var out []SeriesRef
+	for _, r := range refs {
+		tx := db.WithContext(ctx).Table(table).Distinct("server_id", "device_id", "name").
+			Where(column+" >= ? AND "+column+" < ?", dbTime(column, from), dbTime(column, to))
+		if r.ServerID != "" {
+			tx = tx.Where("server_id = ?", r.ServerID)
+		}
+		if r.DeviceID != "" {
+			tx = tx.Where("device_id = ?", r.DeviceID)
+		}
+		if r.Name != "" {
+			tx = tx.Where("name = ?", r.Name)
+		}
+		var found []SeriesRef
+		if err := tx.Scan(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, s := range found {
+			if !seen[s] {
+				seen[s] = true
+				out = append(out, s)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].less(out[j]) })
+	return out, nil
+}
+
+// QueryRange runs q and returns one page of results.
+func QueryRange(ctx context.Context, db *gorm.DB, q RangeQuery) (*RangeResult, error) {
+	if err := q.validate(); err != nil {
+		return nil, err
+	}
+	cur, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	res, err := rangeSource(ctx, db, q)
+	if err != nil {
+		return nil, err
+	}
+	// series are discovered at the finest level so that data which has not
+	// been rolled up yet is still found
+	series, err := resolveSeries(ctx, db, ResolutionRaw, q.Points, q.From, q.To)
+	if err != nil {
+		return nil, err
+	}
+	if res != ResolutionRaw {
+		more, err := resolveSeries(ctx, db, res, q.Points, q.From, q.To)
+		if err != nil {
+			return nil, err
+		}
+		series = mergeSeries(series, more)
+	}
+
+	out := &RangeResult{Resolution: res}
+	var marks []rangeCursor // cursor of every row in out.Rows
+	for _, s := range series {
+		if cur != nil && s.less(cur.Series) {
+			continue
+		}
+		// read one row past the page to tell whether another page follows
+		remaining := 0
+		if q.Limit > 0 {
+			remaining = q.Limit + 1 - len(out.Rows)
+		}
+		var after *rangeCursor
+		if cur != nil && s == cur.Series {
+			after = cur
+		}
+		var rows []RangeRow
+		if len(q.Aggs) == 0 {
+			var rowMarks []rangeCursor
+			rows, rowMarks, err = rawRange(ctx, db, s, q, after, remaining)
+			marks = append(marks, rowMarks...)
+		} else {
+			rows, err = bucketRange(ctx, db, s, q, res, after, remaining)
+			for _, r := range rows {
+				marks = append(marks, rangeCursor{Series: s, TS: r.Timestamp})
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		out.Rows = append(out.Rows, rows...)
+		if q.Limit > 0 && len(out.Rows) > q.Limit {
+			out.Rows = out.Rows[:q.Limit]
+			out.NextCursor = encodeCursor(marks[q.Limit-1])
+			break
+		}
+	}
+	if out.Rows == nil {
+		out.Rows = []RangeRow{}
+	}
+	return out, nil
+}
+
+func mergeSeries(a, b []SeriesRef) []SeriesRef {
+	seen := make(map[SeriesRef]bool, len(a))
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			a = append(a, s)
+		}
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i].less(a[j]) })
+	return a
+}
+
+func whereSeries(tx *gorm.DB, s SeriesRef) *gorm.DB {
+	return tx.Where("server_id = ? AND device_id = ? AND name = ?", s.ServerID, s.DeviceID, s.Name)
+}
+
+// rawRange lists raw values of one series after the cursor, with the cursor
+// of each row.
+func rawRange(ctx context.Context, db *gorm.DB, s SeriesRef, q RangeQuery, after *rangeCursor, limit int) ([]RangeRow, []rangeCursor, error) {
+	tx := whereSeries(db.WithContext(ctx).Model(&model.PointValue{}), s).
+		Where("timestamp >= ? AND timestamp < ?", dbTime("timestamp", q.From), dbTime("timestamp", q.To))
+	if after != nil {
+		ts := dbTime("timestamp", after.TS)
+		tx = tx.Where("timestamp > ? OR (timestamp = ? AND id > ?)", ts, ts, after.ID)
+	}
+	tx = tx.Order("timestamp, id")
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	var pvs []model.PointValue
+	if err := tx.Find(&pvs).Error; err != nil {
+		return nil, nil, err
+	}
+	rows := make([]RangeRow, 0, len(pvs))
+	marks := make([]rangeCursor, 0, len(pvs))
+	for _, pv := range pvs {
+		v := pv.Value
+		rows = append(rows, RangeRow{
+			ServerID: s.ServerID, DeviceID: s.DeviceID, Name: s.Name,
+			Timestamp: pv.Timestamp, Values: map[string]*float64{"value": &v},
+		})
+		marks = append(marks, rangeCursor{Series: s, TS: pv.Timestamp, ID: pv.ID})
+	}
+	return rows, marks, nil
+}
+
+// rangeSample is a raw value or a rollup bucket feeding output buckets.
+type rangeSample struct {
+	ts  time.Time
+	agg model.RollupAggregate
+}
+
+// loadSamples reads one series at resolution res. Rollup tables only reach
+// their watermark, so the remainder of the range is read from the next finer
+// level.
+func loadSamples(ctx context.Context, db *gorm.DB, s SeriesRef, res string, from, to time.Time) ([]rangeSample, error) {
+	if res == ResolutionRaw {
+		var pvs []model.PointValue
+		err := whereSeries(db.WithContext(ctx).Model(&model.PointValue{}), s).
+			Select("value, timestamp").
+			Where("timestamp >= ? AND timestamp < ?", dbTime("timestamp", from), dbTime("timestamp", to)).
+			Order("timestamp, id").Find(&pvs).Error
+		if err != nil {
+			return nil, err
+		}
+		out := make([]rangeSample, 0, len(pvs))
+		for _, pv := range pvs {
+			var agg model.RollupAggregate
+			addSample(&agg, pv.Value, pv.Timestamp)
+			out = append(out, rangeSample{ts: pv.Timestamp, agg: agg})
+		}
+		return out, nil
+	}
+
+	wm, err := rollupWatermark(ctx, db, res)
+	if err != nil {
+		return nil, err
+	}
+	end := to
+	if wm.Before(end) {
+		end = wm
+	}
+	var out []rangeSample
+	if from.Before(end) {
+		table, _ := sourceTable(res)
+		var rows []model.PointRollup1m // both rollup tables share this layout
+		err := whereSeries(db.WithContext(ctx).Table(table), s).
+			Where("bucket >= ? AND bucket < ?", dbTime("bucket", from), dbTime("bucket", end)).
+			Order("bucket").Find(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rows {
+			out = append(out, rangeSample{ts: r.Bucket, agg: r.RollupAggregate})
+		}
+	}
+	if end.Before(to) {
+		if end.Before(from) {
+			end = from
+		}
+		tail, err := loadSamples(ctx, db, s, finerResolution(res), end, to)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, tail...)
+	}
+	return out, nil
+}
+
+// finerResolution is the level that holds the data a rollup has not
+// reached yet.
+func finerResolution(res string) string {
+	if res == Resolution1h {
+		return Resolution1m
+	}
+	return ResolutionRaw
+}
+
+// edgeSample returns the last sample of one series at resolution res in
+// [from, to) when last is set, else the first one; nil when there is none.
+func edgeSample(ctx context.Context, db *gorm.DB, s SeriesRef, res string, from, to time.Time, last bool) (*rangeSample, error) {
+	if !from.Before(to) {
+		return nil, nil
+	}
+	dir := ""
+	if last {
+		dir = " DESC"
+	}
+	if res == ResolutionRaw {
+		var pvs []model.PointValue
+		err := whereSeries(db.WithContext(ctx).Model(&model.PointValue{}), s).
+			Select("value, timestamp").
+			Where("timestamp >= ? AND timestamp < ?", dbTime("timestamp", from), dbTime("timestamp", to)).
+			Order("timestamp" + dir + ", id" + dir).Limit(1).Find(&pvs).Error
+		if err != nil || len(pvs) == 0 {
+			return nil, err
+		}
+		var agg model.RollupAggregate
+		addSample(&agg, pvs[0].Value, pvs[0].Timestamp)
+		return &rangeSample{ts: pvs[0].Timestamp, agg: agg}, nil
+	}
+
+	// the rollup covers [from, mid), the finer level [mid, to)
+	wm, err := rollupWatermark(ctx, db, res)
+	if err != nil {
+		return nil, err
+	}
+	mid := to
+	if wm.Before(mid) {
+		mid = wm
+	}
+	if mid.Before(from) {
+		mid = from
+	}
+	rollup := func() (*rangeSample, error) {
+		if !from.Before(mid) {
+			return nil, nil
+		}
+		table, _ := sourceTable(res)
+		var rows []model.PointRollup1m // both rollup tables share this layout
+		err := whereSeries(db.WithContext(ctx).Table(table), s).
+			Where("bucket >= ? AND bucket < ?", dbTime("bucket", from), dbTime("bucket", mid)).
+			Order("bucket" + dir).Limit(1).Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return nil, err
+		}
+		return &rangeSample{ts: rows[0].Bucket, agg: rows[0].RollupAggregate}, nil
+	}
+	finer := func() (*rangeSample, error) {
+		return edgeSample(ctx, db, s, finerResolution(res), mid, to, last)
+	}
+	first, second := rollup, finer
+	if last {
+		first, second = finer, rollup
+	}
+	if smp, err := first(); err != nil || smp != nil {
+		return smp, err
+	}
+	return second()
+}
+
+// bucketState accumulates one output bucket.
+type bucketState struct {
+	start time.Time
+	agg   model.RollupAggregate
+	delta float64
+}
+
+// bucketRange aggregates one series into output buckets, gap-fills them and
+// returns the rows after the cursor. Only the samples of the page are read:
+// from the bucket after the cursor and, when every bucket is returned,
+// until limit buckets later. The buckets around the page are looked up for
+// delta and for previous/linear fill.
+func bucketRange(ctx context.Context, db *gorm.DB, s SeriesRef, q RangeQuery, res string, after *rangeCursor, limit int) ([]RangeRow, error) {
+	bucketOf := func(ts time.Time) time.Time {
+		if q.Interval <= 0 {
+			return q.From
+		}
+		return alignEpoch(ts, q.Interval)
+	}
+	from, to := q.From, q.To
+	if after != nil {
+		if q.Interval <= 0 {
+			return nil, nil // the single bucket was on the previous page
+		}
+		if next := after.TS.Add(q.Interval); next.After(from) {
+			from = next
+		}
+	}
+	if q.Fill != FillNone && q.Interval > 0 && limit > 0 {
+		if end := alignEpoch(from, q.Interval).Add(time.Duration(limit) * q.Interval); end.Before(to) {
+			to = end
+		}
+	}
+	if !from.Before(to) {
+		return nil, nil
+	}
+
+	samples, err := loadSamples(ctx, db, s, res, from, to)
+	if err != nil {
+		return nil, err
+	}
+	withDelta := false
+	for _, a := range q.Aggs {
+		withDelta = withDelta || a == AggDelta
+	}
+	neighbors := q.Fill == FillPrevious || q.Fill == FillLinear
+	var prevLast float64
+	hasPrev := false
+	var before, beyond *bucketState // nearest non-empty buckets outside the page
+	if from.After(q.From) && (withDelta || neighbors) {
+		smp, err := edgeSample(ctx, db, s, res, q.From, from, true)
+		if err != nil {
+			return nil, err
+		}
+		if smp != nil {
+			prevLast, hasPrev = smp.agg.Last, true
+			if neighbors {
+				if before, err = loadBucket(ctx, db, s, res, bucketOf(smp.ts), q.From, from, q.Interval); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	if to.Before(q.To) && q.Fill == FillLinear {
+		smp, err := edgeSample(ctx, db, s, res, to, q.To, false)
+		if err != nil {
+			return nil, err
+		}
+		if smp != nil {
+			if beyond, err = loadBucket(ctx, db, s, res, bucketOf(smp.ts), to, q.To, q.Interval); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var buckets []*bucketState
+	for _, smp := range samples {
+		start := bucketOf(smp.ts)
+		if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(start) {
+			buckets = append(buckets, &bucketState{start: start})
+		}
+		b := buckets[len(buckets)-1]
+		// counter increase: the step from the previous sample (possibly in an
+		// earlier bucket) plus the increase inside this sample
+		if hasPrev {
+			b.delta += counterIncrease(prevLast, smp.agg.First)
+		}
+		b.delta += counterIncrease(smp.agg.First, smp.agg.Last)
+		prevLast, hasPrev = smp.agg.Last, true
+		mergeAggregate(&b.agg, smp.agg)
+	}
+
+	if q.Fill != FillNone && q.Interval > 0 {
+		buckets = fillBuckets(buckets, alignEpoch(from, q.Interval), to, q.Interval)
+	}
+
+	rows := make([]RangeRow, 0, len(buckets))
+	last, next := before, 0 // next indexes the first non-empty bucket after an empty one
+	for i, b := range buckets {
+		values := make(map[string]*float64, len(q.Aggs))
+		for _, a := range q.Aggs {
+			values[a] = bucketValue(b, a)
+		}
+		if b.agg.Count > 0 {
+			last = b
+		} else {
+			if next <= i {
+				for next = i + 1; next < len(buckets) && buckets[next].agg.Count == 0; next++ {
+				}
+			}
+			following := beyond
+			if next < len(buckets) {
+				following = buckets[next]
+			}
+			fillValues(values, q, b, last, following)
+		}
+		rows = append(rows, RangeRow{ServerID: s.ServerID, DeviceID: s.DeviceID, Name: s.Name, Timestamp: b.start, Values: values})
+		if limit > 0 && len(rows) >= limit {
+			break
+		}
+	}
+	return rows, nil
+}
+
+// loadBucket aggregates the output bucket starting at start, clipped to
+// [from, to).
+func loadBucket(ctx context.Context, db *gorm.DB, s SeriesRef, res string, start, from, to time.Time, interval time.Duration) (*bucketState, error) {
+	end := to
+	if interval > 0 && start.Add(interval).Before(end) {
+		end = start.Add(interval)
+	}
+	if start.After(from) {
+		from = start
+	}
+	samples, err := loadSamples(ctx, db, s, res, from, end)
+	if err != nil {
+		return nil, err
+	}
+	b := &bucketState{start: start}
+	for _, smp := range samples {
+		mergeAggregate(&b.agg, smp.agg)
+	}
+	return b, nil
+}
+
+// counterIncrease is b-a, or b when the counter went backwards (reset).
+func counterIncrease(a, b float64) float64 {
+	if b < a {
+		return b
+	}
+	return b - a
+}
+
+// fillBuckets inserts empty buckets so every interval in [first, to) exists.
+func fillBuckets(have []*bucketState, first, to time.Time, interval time.Duration) []*bucketState {
+	out := make([]*bucketState, 0, int(to.Sub(first)/interval)+1)
+	i := 0
+	for t := first; t.Before(to); t = t.Add(interval) {
+		if i < len(have) && have[i].start.Equal(t) {
+			out = append(out, have[i])
+			i++
+			continue
+		}
+		out = append(out, &bucketState{start: t})
+	}
+	return out
+}
+
+func bucketValue(b *bucketState, agg string) *float64 {
+	if agg == AggCount {
+		v := float64(b.agg.Count)
+		return &v
+	}
+	if b.agg.Count == 0 {
+		return nil
+	}
+	var v float64
+	switch agg {
+	case AggAvg:
+		v = b.agg.Avg
+	case AggMin:
+		v = b.agg.Min
+	case AggMax:
+		v = b.agg.Max
+	case AggSum:
+		v = b.agg.Sum
+	case AggFirst:
+		v = b.agg.First
+	case AggLast:
+		v = b.agg.Last
+	case AggDelta:
+		v = b.delta
+	}
+	return &v
+}
+
+// fillValues sets the values of the empty bucket b according to the fill
+// mode, from the nearest non-empty buckets prev and next (nil when there is
+// none). Additive aggregations (count/sum/delta) of an empty bucket are zero
+// for every mode except null.
+func fillValues(values map[string]*float64, q RangeQuery, b, prev, next *bucketState) {
+	zero := func() *float64 { v := 0.0; return &v }
+	for _, a := range q.Aggs {
+		additive := a == AggCount || a == AggSum || a == AggDelta
+		switch {
+		case q.Fill == FillNull:
+			values[a] = nil
+		case q.Fill == FillZero || additive:
+			values[a] = zero()
+		case q.Fill == FillPrevious:
+			values[a] = nil
+			if prev != nil {
+				values[a] = bucketValue(prev, a)
+			}
+		case q.Fill == FillLinear:
+			values[a] = nil
+			if prev != nil && next != nil {
+				pv, nv := *bucketValue(prev, a), *bucketValue(next, a)
+				frac := float64(b.start.Sub(prev.start)) / float64(next.start.Sub(prev.start))
+				v := pv + (nv-pv)*frac
+				values[a] = &v
+			}
+		}
+	}
+}
@@ -0,0 +1,589 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"modbus-simulator/internal/model"
+)
+
+// Rollup resolutions, also used as rollup_state keys and query resolutions.
+const (
+	ResolutionRaw = "raw"
+	Resolution1m  = "1m"
+	Resolution1h  = "1h"
+)
+
+// rollup chunk sizes bound how many rows are aggregated per transaction.
+const (
+	rawChunk    = 10 * time.Minute
+	minuteChunk = 24 * time.Hour
+	pruneBatch  = 5000
+)
+
+// RetentionPolicy says how long each resolution is kept. Zero keeps forever.
+type RetentionPolicy struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+// PruneResult reports the rows deleted per table by PruneHistory.
+type PruneResult struct {
+	Raw    int64
+	Minute int64
+	Hour   int64
+}
+
+type rollupKey struct {
+	serverID, deviceID, name string
+	bucket                   time.Time
+}
+
+var rollupConflict = clause.OnConflict{
+	Columns: []clause.Column{{Name: "server_id"}, {Name: "device_id"}, {Name: "name"}, {Name: "bucket"}},
+	DoUpdates: clause.AssignmentColumns([]string{
+		"unit", "min", "max", "avg", "sum", "first", "last", "count", "first_ts", "last_ts",
+	}),
+}
+
+// addSample folds a single raw value into agg.
+func addSample(agg *model.RollupAggregate, v float64, ts time.Time) {
+	if agg.Count == 0 {
+		*agg = model.RollupAggregate{Unit: agg.Unit, Min: v, Max: v, First: v, Last: v, FirstTS: ts, LastTS: ts}
+	} else {
+		if v < agg.Min {
+			agg.Min = v
+		}
+		if v > agg.Max {
+			agg.Max = v
+		}
+		if ts.Before(agg.FirstTS) {
+			agg.First, agg.FirstTS = v, ts
+		}
+		if !ts.Before(agg.LastTS) {
+			agg.Last, agg.LastTS = v, ts
+		}
+	}
+	agg.Sum += v
+	agg.Count++
+	agg.Avg = agg.Sum / float64(agg.Count)
+}
+
+// mergeAggregate folds a finer bucket src into agg.
+func mergeAggregate(agg *model.RollupAggregate, src model.RollupAggregate) {
+	if src.Count == 0 {
+		return
+	}
+	if agg.Count == 0 {
+		*agg = src
+		return
+	}
+	if src.Min < agg.Min {
+		agg.Min = src.Min
+	}
+	if src.Max > agg.Max {
+		agg.Max = src.Max
+	}
+	if src.FirstTS.Before(agg.FirstTS) {
+		agg.First, agg.FirstTS = src.First, src.FirstTS
+	}
+	if !src.LastTS.Before(agg.LastTS) {
+		agg.Last, agg.LastTS = src.Last, src.LastTS
+	}
+	agg.Sum += src.Sum
+	agg.Count += src.Count
+	agg.Avg = agg.Sum / float64(agg.Count)
+}
+
+// dbTime converts t to the zone the values of column are stored in. SQLite
+// compares the stored time strings, so a parameter in another zone would
+// select the wrong rows. Rollup buckets and watermarks are kept in UTC; raw
+// timestamps keep the zone they were written in, the collector's local time.
+func dbTime(column string, t time.Time) time.Time {
+	if column == "bucket" {
+		return t.UTC()
+	}
+	return t.Local()
+}
+
+// rollupWatermark returns the stored watermark of res, or zero if none.
+func rollupWatermark(ctx context.Context, db *gorm.DB, res string) (time.Time, error) {
+	var st model.RollupState
+	err := db.WithContext(ctx).Where("resolution = ?", res).Limit(1).Find(&st).Error
+	return st.Watermark.UTC(), err
+}
+
+func saveWatermark(ctx context.Context, db *gorm.DB, res string, wm time.Time) error {
+	return db.WithContext(ctx).Save(&model.RollupState{Resolution: res, Watermark: wm.UTC()}).Error
+}
+
+// oldestTimestamp returns the earliest value of column in table, or zero
+// when the table is empty. It orders instead of using MIN() so the driver
+// returns a typed timestamp.
+func oldestTimestamp(ctx context.Context, db *gorm.DB, table, column string) (time.Time, error) {
+	var out []time.Time
+	err := db.WithContext(ctx).Table(table).Order(column).Limit(1).Pluck(column, &out).Error
+	if err != nil || len(out) == 0 {
+		return time.Time{}, err
+	}
+	return out[0], nil
+}
+
+// markLateRows records the inserted rows that fall behind the 1m watermark,
+// i.e. into minutes that have already been rolled up.
+func markLateRows(ctx context.Context, tx *gorm.DB, pvs []model.PointValue) error {
+	wm, err := rollupWatermark(ctx, tx, Resolution1m)
+	if err != nil || wm.IsZero() {
+		return err
+	}
+	var late []model.RollupLate
+	for _, pv := range pvs {
+		if pv.Timestamp.Before(wm) {
+			late = append(late, model.RollupLate{PointValueID: pv.ID})
+		}
+	}
+	if len(late) == 0 {
+		return nil
+	}
+	return tx.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(late, 500).Error
+}
+
+// RunRollups aggregates raw point_values into point_values_1m and complete
+// minutes into point_values_1h. Minute buckets are closed once they are
+// older than lateness, which keeps the common case of slightly delayed
+// values cheap; rows that still arrive behind the watermark (e.g. replayed
+// from a store-and-forward buffer after an outage) are merged into the
+// buckets they belong to.
+func RunRollups(ctx context.Context, db *gorm.DB, now time.Time, lateness time.Duration) error {
+	if lateness < 0 {
+		lateness = 0
+	}
+	if err := rollupLateRows(ctx, db); err != nil {
+		return err
+	}
+	minuteEnd := now.Add(-lateness).UTC().Truncate(time.Minute)
+	wm, err := rollupWatermark(ctx, db, Resolution1m)
+	if err != nil {
+		return err
+	}
+	if wm.IsZero() {
+		if wm, err = oldestTimestamp(ctx, db, "point_values", "timestamp"); err != nil || wm.IsZero() {
+			return err
+		}
+		wm = wm.UTC().Truncate(time.Minute)
+	}
+	for wm.Before(minuteEnd) {
+		end := wm.Add(rawChunk)
+		if end.After(minuteEnd) {
+			end = minuteEnd
+		}
+		if err := rollupRawChunk(ctx, db, wm, end); err != nil {
+			return err
+		}
+		wm = end
+	}
+
+	hourEnd := wm.Truncate(time.Hour)
+	hwm, err := rollupWatermark(ctx, db, Resolution1h)
+	if err != nil {
+		return err
+	}
+	if hwm.IsZero() {
+		if hwm, err = oldestTimestamp(ctx, db, "point_values_1m", "bucket"); err != nil || hwm.IsZero() {
+			return err
+		}
+		hwm = hwm.UTC().Truncate(time.Hour)
+	}
+	for hwm.Before(hourEnd) {
+		end := hwm.Add(minuteChunk)
+		if end.After(hourEnd) {
+			end = hourEnd
+		}
+		if err := rollupMinuteChunk(ctx, db, hwm, end); err != nil {
+			return err
+		}
+		hwm = end
+	}
+	return nil
+}
+
+// rollupRawChunk aggregates point_values in [from, to) and advances the 1m
+// watermark in the same transaction.
+func rollupRawChunk(ctx context.Context, db *gorm.DB, from, to time.Time) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		rows, err := tx.Model(&model.PointValue{}).
+			Select("server_id, device_id, name, unit, value, timestamp").
+			Where("timestamp >= ? AND timestamp < ?", dbTime("timestamp", from), dbTime("timestamp", to)).
+			Rows()
+		if err != nil {
+			return err
+		}
+		buckets := map[rollupKey]*model.PointRollup1m{}
+		for rows.Next() {
+			var pv model.PointValue
+			if err := tx.ScanRows(rows, &pv); err != nil {
+				rows.Close()
+				return err
+			}
+			k := rollupKey{pv.ServerID, pv.DeviceID, pv.Name, pv.Timestamp.UTC().Truncate(time.Minute)}
+			b, ok := buckets[k]
+			if !ok {
+				b = &model.PointRollup1m{ServerID: k.serverID, DeviceID: k.deviceID, Name: k.name, Bucket: k.bucket}
+				buckets[k] = b
+			}
+			b.Unit = pv.Unit
+			addSample(&b.RollupAggregate, pv.Value, pv.Timestamp)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		if len(buckets) > 0 {
+			out := make([]model.PointRollup1m, 0, len(buckets))
+			for _, b := range buckets {
+				out = append(out, *b)
+			}
+			if err := tx.Clauses(rollupConflict).CreateInBatches(out, 500).Error; err != nil {
+				return err
+			}
+		}
+		return saveWatermark(ctx, tx, Resolution1m, to)
+	})
+}
+
+// rollupMinuteChunk merges point_values_1m buckets in [from, to) into hours
+// and advances the 1h watermark in the same transaction.
+func rollupMinuteChunk(ctx context.Context, db *gorm.DB, from, to time.Time) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var mins []model.PointRollup1m
+		if err := tx.Where("bucket >= ? AND bucket < ?", dbTime("bucket", from), dbTime("bucket", to)).Find(&mins).Error; err != nil {
+			return err
+		}
+		buckets := map[rollupKey]*model.PointRollup1h{}
+		for _, m := range mins {
+			k := rollupKey{m.ServerID, m.DeviceID, m.Name, m.Bucket.UTC().Truncate(time.Hour)}
+			b, ok := buckets[k]
+			if !ok {
+				b = &model.PointRollup1h{ServerID: k.serverID, DeviceID: k.deviceID, Name: k.name, Bucket: k.bucket}
+				buckets[k] = b
+			}
+			mergeAggregate(&b.RollupAggregate, m.RollupAggregate)
+			b.Unit = m.Unit
+		}
+		if len(buckets) > 0 {
+			out := make([]model.PointRollup1h, 0, len(buckets))
+			for _, b := range buckets {
+				out = append(out, *b)
+			}
+			if err := tx.Clauses(rollupConflict).CreateInBatches(out, 500).Error; err != nil {
+				return err
+			}
+		}
+		return saveWatermark(ctx, tx, Resolution1h, to)
+	})
+}
+
+// rollupLateRows merges the rows marked by markLateRows into their minute
+// buckets and, for hours already rolled up, into their hour buckets. Each
+// batch is merged and unmarked in one transaction, so no row counts twice.
+func rollupLateRows(ctx context.Context, db *gorm.DB) error {
+	for {
+		var ids []uint
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&model.RollupLate{}).Order("point_value_id").Limit(pruneBatch).
+				Pluck("point_value_id", &ids).Error; err != nil || len(ids) == 0 {
+				return err
+			}
+			var pvs []model.PointValue
+			if err := tx.Where("id IN ?", ids).Find(&pvs).Error; err != nil {
+				return err
+			}
+			hwm, err := rollupWatermark(ctx, tx, Resolution1h)
+			if err != nil {
+				return err
+			}
+			mins := map[rollupKey]*model.RollupAggregate{}
+			hours := map[rollupKey]*model.RollupAggregate{}
+			for _, pv := range pvs {
+				bucket := pv.Timestamp.UTC().Truncate(time.Minute)
+				foldLate(mins, rollupKey{pv.ServerID, pv.DeviceID, pv.Name, bucket}, pv)
+				if bucket.Before(hwm) {
+					foldLate(hours, rollupKey{pv.ServerID, pv.DeviceID, pv.Name, bucket.Truncate(time.Hour)}, pv)
+				}
+			}
+			if err := mergeBuckets(tx, "point_values_1m", mins); err != nil {
+				return err
+			}
+			if err := mergeBuckets(tx, "point_values_1h", hours); err != nil {
+				return err
+			}
+			return tx.Where("point_value_id IN ?", ids).Delete(&model.RollupLate{}).Error
+		})
+		if err != nil || len(ids) < pruneBatch {
+			return err
+		}
+	}
+}
+
+func foldLate(buckets map[rollupKey]*model.RollupAggregate, k rollupKey, pv model.PointValue) {
+	agg, ok := buckets[k]
+	if !ok {
+		agg = &model.RollupAggregate{}
+		buckets[k] = agg
+	}
+	addSample(agg, pv.Value, pv.Timestamp)
+	agg.Unit = pv.Unit
+}
+
+// mergeBuckets folds aggregates into the stored buckets of a rollup table.
+func mergeBuckets(tx *gorm.DB, table string, buckets map[rollupKey]*model.RollupAggregate) error {
+	for k, agg := range buckets {
+		var cur model.PointRollup1m // both rollup tables share this layout
+		if err := tx.Table(table).
+			Where("server_id = ? AND device_id = ? AND name = ? AND bucket = ?", k.serverID, k.deviceID, k.name, k.bucket).
+			Limit(1).Find(&cur).Error; err != nil {
+			return err
+		}
+		mergeAggregate(&cur.RollupAggregate, *agg)
+		cur.Unit = agg.Unit
+		row := model.PointRollup1m{ServerID: k.serverID, DeviceID: k.deviceID, Name: k.name, Bucket: k.bucket, RollupAggregate: cur.RollupAggregate}
+		if err := tx.Table(table).Clauses(rollupConflict).Create(&row).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneHistory deletes rows older than the policy allows. Raw rows and minute
+// buckets are only deleted once they have been rolled up into the next
+// resolution, so pruning never loses data that has no aggregate yet.
+func PruneHistory(ctx context.Context, db *gorm.DB, now time.Time, p RetentionPolicy) (PruneResult, error) {
+	var res PruneResult
+	if p.Raw > 0 {
+		wm, err := rollupWatermark(ctx, db, Resolution1m)
+		if err != nil {
+			return res, err
+		}
+		if cutoff := minTime(now.Add(-p.Raw), wm); !cutoff.IsZero() {
+			if res.Raw, err = pruneTable(ctx, db, "point_values", "timestamp", cutoff,
+				"SELECT point_value_id FROM rollup_late"); err != nil {
+				return res, err
+			}
+		}
+	}
+	if p.Minute > 0 {
+		wm, err := rollupWatermark(ctx, db, Resolution1h)
+		if err != nil {
+			return res, err
+		}
+		if cutoff := minTime(now.Add(-p.Minute), wm); !cutoff.IsZero() {
+			if res.Minute, err = pruneTable(ctx, db, "point_values_1m", "bucket", cutoff, ""); err != nil {
+				return res, err
+			}
+		}
+	}
+	if p.Hour > 0 {
+		var err error
+		if res.Hour, err = pruneTable(ctx, db, "point_values_1h", "bucket", now.Add(-p.Hour), ""); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// minTime returns the earlier of a and b; a zero b (no watermark) wins.
+func minTime(a, b time.Time) time.Time {
+	if b.IsZero() || b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// pruneTable deletes rows with column < cutoff in small batches so the
+// write lock is released between batches. Rows whose id is selected by the
+// keep subquery, if any, are left alone.
+func pruneTable(ctx context.Context, db *gorm.DB, table, column string, cutoff time.Time, keep string) (int64, error) {
+	where := column + " < ?"
+	if keep != "" {
+		where += " AND id NOT IN (" + keep + ")"
+	}
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+		r := db.WithContext(ctx).Exec(
+			"DELETE FROM "+table+" WHERE id IN (SELECT id FROM "+table+" WHERE "+where+" LIMIT ?)",
+			dbTime(column, cutoff), pruneBatch)
+		if r.Error != nil {
+			return total, r.Error
+		}
+		total += r.RowsAffected
+		if r.RowsAffected < pruneBatch {
+			return total, nil
+		}
+	}
+}
+
+// HistoryQuery selects the history of one or more points over [From, To).
+// A zero From means the beginning, a zero To means now. Resolution is
+// ResolutionRaw, Resolution1m, Resolution1h or empty for automatic choice.
+type HistoryQuery struct {
+	ServerID   string
+	DeviceID   string
+	Name       string
+	From       time.Time
+	To         time.Time
+	Resolution string
+	Limit      int
+}
+
+// HistoryPoint is one raw value or one rollup bucket. For raw values all
+// statistics equal the value and Count is 1.
+type HistoryPoint struct {
+	ServerID  string    `json:"server_id"`
+	DeviceID  string    `json:"device_id"`
+	Name      string    `json:"name"`
+	Unit      string    `json:"unit"`
+	Timestamp time.Time `json:"timestamp"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	First     float64   `json:"first"`
+	Last      float64   `json:"last"`
+	Count     int64     `json:"count"`
+}
+
+// HistoryResult carries the resolution actually used with the points,
+// ordered by timestamp.
+type HistoryResult struct {
+	Resolution string         `json:"resolution"`
+	Points     []HistoryPoint `json:"points"`
+}
+
+// autoResolution picks the finest resolution whose span limit covers the
+// requested range.
+func autoResolution(from, to time.Time) string {
+	span := to.Sub(from)
+	switch {
+	case !from.IsZero() && span <= 6*time.Hour:
+		return ResolutionRaw
+	case !from.IsZero() && span <= 14*24*time.Hour:
+		return Resolution1m
+	default:
+		return Resolution1h
+	}
+}
+
+// PickResolution returns the resolution QueryHistory uses for [from, to):
+// the span decides the preferred resolution, then coarser ones are used if
+// the preferred table has already been pruned past from.
+func PickResolution(ctx context.Context, db *gorm.DB, from, to time.Time) (string, error) {
+	if to.IsZero() {
+		to = time.Now()
+	}
+	pref := autoResolution(from, to)
+	if from.IsZero() {
+		return pref, nil
+	}
+	order := []struct{ res, table, column string }{
+		{ResolutionRaw, "point_values", "timestamp"},
+		{Resolution1m, "point_values_1m", "bucket"},
+		{Resolution1h, "point_values_1h", "bucket"},
+	}
+	started := false
+	for _, o := range order {
+		if o.res == pref {
+			started = true
+		}
+		if !started {
+			continue
+		}
+		oldest, err := oldestTimestamp(ctx, db, o.table, o.column)
+		if err != nil {
+			return "", err
+		}
+		if !oldest.IsZero() && !oldest.After(from) {
+			return o.res, nil
+		}
+	}
+	return pref, nil
+}
+
+// QueryHistory returns the history of the selected points at the requested
+// (or automatically chosen) resolution.
+func QueryHistory(ctx context.Context, db *gorm.DB, q HistoryQuery) (*HistoryResult, error) {
+	res := q.Resolution
+	if res == "" || res == "auto" {
+		var err error
+		if res, err = PickResolution(ctx, db, q.From, q.To); err != nil {
+			return nil, err
+		}
+	}
+	var table, column string
+	switch res {
+	case ResolutionRaw:
+		table, column = "point_values", "timestamp"
+	case Resolution1m:
+		table, column = "point_values_1m", "bucket"
+	case Resolution1h:
+		table, column = "point_values_1h", "bucket"
+	default:
+		return nil, errors.New("unknown resolution " + res)
+	}
+
+	tx := db.WithContext(ctx).Table(table)
+	if q.ServerID != "" {
+		tx = tx.Where("server_id = ?", q.ServerID)
+	}
+	if q.DeviceID != "" {
+		tx = tx.Where("device_id = ?", q.DeviceID)
+	}
+	if q.Name != "" {
+		tx = tx.Where("name = ?", q.Name)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where(column+" >= ?", dbTime(column, q.From))
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where(column+" < ?", dbTime(column, q.To))
+	}
+	tx = tx.Order(column + ", device_id, name")
+	if q.Limit > 0 {
+		tx = tx.Limit(q.Limit)
+	}
+
+	out := &HistoryResult{Resolution: res}
+	if res == ResolutionRaw {
+		var rows []model.PointValue
+		if err := tx.Find(&rows).Error; err != nil {
+			return nil, err
+		}
+		out.Points = make([]HistoryPoint, 0, len(rows))
+		for _, r := range rows {
+			out.Points = append(out.Points, HistoryPoint{
+				ServerID: r.ServerID, DeviceID: r.DeviceID, Name: r.Name, Unit: r.Unit,
+				Timestamp: r.Timestamp, Min: r.Value, Max: r.Value, Avg: r.Value,
+				First: r.Value, Last: r.Value, Count: 1,
+			})
+		}
+		return out, nil
+	}
+	var rows []model.PointRollup1m // both rollup tables share this layout
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out.Points = make([]HistoryPoint, 0, len(rows))
+	for _, r := range rows {
+		out.Points = append(out.Points, HistoryPoint{
+			ServerID: r.ServerID, DeviceID: r.DeviceID, Name: r.Name, Unit: r.Unit,
+			Timestamp: r.Bucket, Min: r.Min, Max: r.Max, Avg: r.Avg,
+			First: r.First, Last: r.Last, Count: r.Count,
+		})
+	}
+	return out, nil
+}
@@ -153,25 +153,10 @@ type PointLatest struct {
 	Timestamp    time.Time `json:"timestamp"`
 }
 
-// LatestPoints returns, for each unique point name, the latest row by timestamp.
+// LatestPoints returns the latest value of every point as maintained in
+// latest_datas_value by the collector's latest_snapshot job.
 func (d *DB) LatestPoints(ctx context.Context) ([]PointLatest, error) {
-	// subquery: latest timestamp per unique (server_id, device_id, name)
-	sub := d.ORM.Table("point_values as p").
-		Joins("JOIN devices d ON d.device_id = p.device_id").
-		Select("d.server_id as server_id, p.device_id as device_id, p.name as name, MAX(p.timestamp) as ts").
-		Group("d.server_id, p.device_id, p.name")
-	var out []PointLatest
-	err := d.ORM.WithContext(ctx).
-		Table("point_values as p").
-		Select("d.server_id, p.device_id, p.name, p.address, p.register_type, p.data_type, p.byte_order, p.unit, COALESCE(p.value, 0.0) as value, p.timestamp").
-		Joins("JOIN (?) as l ON l.server_id = d.server_id AND l.device_id = p.device_id AND l.name = p.name AND l.ts = p.timestamp", sub).
-		Joins("JOIN devices d ON d.device_id = p.device_id").
-		Order("p.name").
-		Scan(&out).Error
-	if err != nil {
-		return nil, err
-	}
-	return out, nil
+	return LatestPointsORM(ctx, d.ORM, "", "")
 }
 
 func (d *DB) Close() error { return closeORM(d.ORM) }
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm/clause"
+
+	"modbus-simulator/internal/model"
+)
+
+// SaveRegisterState stores the encoded register state of a server,
+// replacing the previous one.
+func (d *DB) SaveRegisterState(ctx context.Context, serverID string, data []byte) error {
+	row := model.RegisterState{ServerID: serverID, Data: data, UpdatedAt: time.Now()}
+	return d.ORM.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&row).Error
+}
+
+// LoadRegisterState returns the saved register state of a server, or nil
+// when none was saved.
+func (d *DB) LoadRegisterState(ctx context.Context, serverID string) ([]byte, error) {
+	var rows []model.RegisterState
+	if err := d.ORM.WithContext(ctx).Where("server_id = ?", serverID).Limit(1).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return rows[0].Data, nil
+}
@@ -0,0 +1,225 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"modbus-simulator/internal/capture"
+)
+
+// ServeRTU answers Modbus RTU requests read from rw until reading or
+// writing fails or the server closes. Requests to slave ids rejected by
+// accept (nil accepts all) are ignored; requests to the broadcast address 0
+// are executed on every register image without an answer. Frames with a bad CRC are dropped.
+// The line follows the timing set with SetRTUTiming.
+func (s *Server) ServeRTU(rw io.ReadWriter, accept func(slave byte) bool) {
+	s.serveRTU(rw, accept, newRTUBus(s.currentRTUTiming()))
+}
+
+// serveRTU serves one master of bus.
+func (s *Server) serveRTU(rw io.ReadWriter, accept func(slave byte) bool, bus *rtuBus) {
+	// captured frames go between the master and the server
+	remote, local := "master", "server"
+	if c, ok := rw.(net.Conn); ok {
+		remote, local = c.RemoteAddr().String(), c.LocalAddr().String()
+	}
+	master := bus.join(rw)
+	defer bus.leave(master)
+	t := bus.timing
+	next := streamFrames(rw)
+	if t.FrameGaps {
+		done := make(chan struct{})
+		defer close(done)
+		next = gapFrames(rw, t, done)
+	}
+	for {
+		frame, end, err := next()
+		if err != nil {
+			return
+		}
+		if len(frame) < 4 || CRC16(frame[:len(frame)-2]) != binary.LittleEndian.Uint16(frame[len(frame)-2:]) {
+			continue
+		}
+		s.capture(capture.RTU, capture.Request, remote, local, frame)
+		if !bus.acquire(master, end.Add(-t.transmit(len(frame)))) {
+			continue
+		}
+		if t.Echo {
+			if err := bus.send(master, frame); err != nil {
+				bus.release(master)
+				return
+			}
+		}
+		response, ok := s.answerRTU(frame[0], frame[1:len(frame)-2], accept)
+		if !ok {
+			bus.release(master)
+			return
+		}
+		if len(response) == 0 {
+			bus.release(master)
+			continue
+		}
+		time.Sleep(t.Turnaround)
+		reply := append([]byte{frame[0]}, response...)
+		reply = binary.LittleEndian.AppendUint16(reply, CRC16(reply))
+		if t.Collisions {
+			// the answer holds the line while it is transmitted
+			time.Sleep(t.transmit(len(reply)))
+		}
+		if bus.release(master) {
+			continue
+		}
+		if err := bus.send(master, reply); err != nil {
+			return
+		}
+		s.capture(capture.RTU, capture.Response, local, remote, reply)
+	}
+}
+
+// answerRTU executes the request pdu to slave and returns the answer PDU,
+// empty when none is due.
+func (s *Server) answerRTU(slave byte, pdu []byte, accept func(slave byte) bool) ([]byte, bool) {
+	if slave == 0 {
+		if f := s.currentFault(); !f.NoResponse && f.Exception == 0 {
+			s.broadcast(pdu)
+		}
+		return nil, true
+	}
+	if accept != nil && !accept(slave) {
+		return nil, true
+	}
+	return s.respond(slave, pdu)
+}
+
+// AttachRTU serves RTU requests from a serial line in the background.
+// Close closes the line.
+func (s *Server) AttachRTU(line io.ReadWriteCloser, accept func(slave byte) bool) {
+	s.addCloser(line)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.ServeRTU(line, accept)
+	}()
+}
+
+// ListenRTUOverTCP accepts TCP connections carrying plain RTU frames
+// (RTU-over-TCP), as serial device servers forward them. The connections
+// are masters sharing one line, which matters for echo and collisions.
+func (s *Server) ListenRTUOverTCP(address string, accept func(slave byte) bool) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	bus := newRTUBus(s.currentRTUTiming())
+	s.addCloser(l)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				done := make(chan struct{})
+				defer close(done)
+				go func() {
+					select {
+					case <-s.quit:
+						conn.Close()
+					case <-done:
+					}
+				}()
+				defer conn.Close()
+				s.serveRTU(conn, accept, bus)
+			}()
+		}
+	}()
+	return nil
+}
+
+func (s *Server) addCloser(c io.Closer) {
+	s.hookMu.Lock()
+	s.closers = append(s.closers, c)
+	s.hookMu.Unlock()
+}
+
+// readRTURequest reads the next request frame and returns its slave id and
+// PDU. The request length follows from the function code; bytes that
+// cannot start a request and frames with a bad CRC are skipped.
+func readRTURequest(r io.Reader) (slave byte, pdu []byte, err error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, nil, err
+	}
+	for {
+		var fixed, countAt int // data bytes after the function code; index of the byte count, -1 if none
+		switch head[1] {
+		case functionReadCoils, functionReadDiscreteInputs, functionReadHoldingRegs, functionReadInputRegs,
+			functionWriteSingleCoil, functionWriteSingleReg:
+			fixed, countAt = 4, -1
+		case functionWriteMultipleCoils, functionWriteMultipleRegs:
+			fixed, countAt = 5, 4
+		case functionMaskWriteReg:
+			fixed, countAt = 6, -1
+		case functionReadWriteMultipleRegs:
+			fixed, countAt = 9, 8
+		case functionReadDeviceID:
+			fixed, countAt = 3, -1
+		default:
+			// not the start of a request: slide by one byte
+			head[0] = head[1]
+			if _, err := io.ReadFull(r, head[1:]); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		data := make([]byte, fixed)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return 0, nil, err
+		}
+		if countAt >= 0 {
+			payload := make([]byte, int(data[countAt]))
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return 0, nil, err
+			}
+			data = append(data, payload...)
+		}
+		crc := make([]byte, 2)
+		if _, err := io.ReadFull(r, crc); err != nil {
+			return 0, nil, err
+		}
+		frame := append([]byte{head[0], head[1]}, data...)
+		if CRC16(frame) == binary.LittleEndian.Uint16(crc) {
+			return frame[0], frame[1:], nil
+		}
+		if _, err := io.ReadFull(r, head); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+// CRC16 computes the Modbus RTU CRC of data; frames carry it low byte
+// first.
+func CRC16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&0x0001 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
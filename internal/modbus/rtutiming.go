@@ -0,0 +1,261 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// RTUTiming models the timing of a serial RTU line. The zero value reads
+// requests as a byte stream and answers at once.
+type RTUTiming struct {
+	BaudRate int // character times follow from it, default 9600
+	// FrameGaps delimits requests by silence like a device on the line: 3.5
+	// character times end a frame, a gap over 1.5 character times inside
+	// one discards it. It is off by default because TCP does not keep
+	// silences: coalesced requests would merge and split ones be dropped.
+	FrameGaps  bool
+	Turnaround time.Duration // wait between a request and its answer
+	Echo       bool          // every master hears all traffic on the line, its own requests included
+	Collisions bool          // requests of masters overlapping another transaction are lost
+}
+
+// SetRTUTiming sets the line timing of RTU transports attached or
+// listening afterwards.
+func (s *Server) SetRTUTiming(t RTUTiming) {
+	s.hookMu.Lock()
+	s.rtuTiming = t
+	s.hookMu.Unlock()
+}
+
+func (s *Server) currentRTUTiming() RTUTiming {
+	s.hookMu.RLock()
+	defer s.hookMu.RUnlock()
+	return s.rtuTiming
+}
+
+// charTime is the transmission time of one 11-bit RTU character.
+func (t RTUTiming) charTime() time.Duration {
+	baud := t.BaudRate
+	if baud <= 0 {
+		baud = 9600
+	}
+	return time.Duration(11 * float64(time.Second) / float64(baud))
+}
+
+// gaps returns the inter-character timeout t1.5 and the frame gap t3.5;
+// above 19200 baud the specification fixes them at 750µs and 1.75ms.
+func (t RTUTiming) gaps() (t15, t35 time.Duration) {
+	if t.BaudRate > 19200 {
+		return 750 * time.Microsecond, 1750 * time.Microsecond
+	}
+	c := t.charTime()
+	return c * 3 / 2, c * 7 / 2
+}
+
+// transmit is the time n characters occupy the line.
+func (t RTUTiming) transmit(n int) time.Duration {
+	return time.Duration(n) * t.charTime()
+}
+
+// frameReader returns the next raw request frame, CRC included, and when
+// its last byte arrived.
+type frameReader func() (frame []byte, end time.Time, err error)
+
+// streamFrames reads requests by their function code, see readRTURequest.
+func streamFrames(r io.Reader) frameReader {
+	return func() ([]byte, time.Time, error) {
+		slave, pdu, err := readRTURequest(r)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		frame := append([]byte{slave}, pdu...)
+		return binary.LittleEndian.AppendUint16(frame, CRC16(frame)), time.Now(), nil
+	}
+}
+
+type chunk struct {
+	data []byte
+	at   time.Time
+	err  error
+}
+
+// gapFrames splits the bytes of r into frames at silences of t3.5 and
+// drops frames broken by a silence over t1.5. Silences are measured
+// between the reads that return data. It reads until r fails or done is
+// closed.
+func gapFrames(r io.Reader, t RTUTiming, done <-chan struct{}) frameReader {
+	chunks := make(chan chunk, 16)
+	go func() {
+		for {
+			buf := make([]byte, 256)
+			n, err := r.Read(buf)
+			c := chunk{data: buf[:n], at: time.Now(), err: err}
+			if n == 0 && err == nil {
+				continue
+			}
+			select {
+			case chunks <- c:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	t15, t35 := t.gaps()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var pending *chunk
+	return func() ([]byte, time.Time, error) {
+		var frame []byte
+		var last time.Time
+		broken := false
+		for {
+			var c chunk
+			if pending != nil {
+				c, pending = *pending, nil
+			} else {
+				var silence <-chan time.Time
+				if len(frame) > 0 {
+					timer.Reset(time.Until(last.Add(t35)))
+					silence = timer.C
+				}
+				select {
+				case c = <-chunks:
+					timer.Stop()
+				case <-silence:
+					if !broken {
+						return frame, last, nil
+					}
+					frame, broken = nil, false
+					continue
+				}
+			}
+			if len(frame) > 0 && c.at.Sub(last) >= t35 {
+				// the frame ended before this chunk came in
+				pending = &c
+				if !broken {
+					return frame, last, nil
+				}
+				frame, broken = nil, false
+				continue
+			}
+			if len(c.data) > 0 {
+				if len(frame) > 0 && c.at.Sub(last) > t15 {
+					broken = true
+				}
+				frame = append(frame, c.data...)
+				last = c.at
+			}
+			if c.err != nil {
+				return nil, time.Time{}, c.err
+			}
+		}
+	}
+}
+
+// rtuBus is the line shared by the masters of one RTU transport.
+type rtuBus struct {
+	timing RTUTiming
+
+	mu      sync.Mutex
+	masters map[*rtuMaster]bool
+	owner   *rtuMaster // master whose transaction holds the line
+	lost    bool       // the owner's transaction collided
+	last    *rtuMaster // owner of the previous transaction
+	freeAt  time.Time  // end of the previous transaction
+}
+
+// rtuMaster is one client connection on a bus.
+type rtuMaster struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (m *rtuMaster) write(b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.w.Write(b)
+	return err
+}
+
+func newRTUBus(t RTUTiming) *rtuBus {
+	return &rtuBus{timing: t, masters: map[*rtuMaster]bool{}}
+}
+
+func (b *rtuBus) join(w io.Writer) *rtuMaster {
+	m := &rtuMaster{w: w}
+	b.mu.Lock()
+	b.masters[m] = true
+	b.mu.Unlock()
+	return m
+}
+
+func (b *rtuBus) leave(m *rtuMaster) {
+	b.mu.Lock()
+	delete(b.masters, m)
+	if b.owner == m {
+		b.owner = nil
+	}
+	b.mu.Unlock()
+}
+
+// acquire takes the line for a request of m that started on the wire at
+// start. With collisions, a request overlapping another master's
+// transaction is lost along with that transaction.
+func (b *rtuBus) acquire(m *rtuMaster, start time.Time) bool {
+	if !b.timing.Collisions {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.owner != nil && b.owner != m {
+		b.lost = true
+		return false
+	}
+	if b.last != nil && b.last != m && start.Before(b.freeAt) {
+		return false
+	}
+	b.owner, b.lost = m, false
+	return true
+}
+
+// release ends the transaction of m and reports whether it collided.
+func (b *rtuBus) release(m *rtuMaster) (lost bool) {
+	if !b.timing.Collisions {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.owner != m {
+		return true
+	}
+	lost = b.lost
+	b.owner, b.lost, b.last, b.freeAt = nil, false, m, time.Now()
+	return lost
+}
+
+// send puts frame on the line: with echo every master hears it, else only
+// to. Only errors writing to to are returned.
+func (b *rtuBus) send(to *rtuMaster, frame []byte) error {
+	if !b.timing.Echo {
+		return to.write(frame)
+	}
+	b.mu.Lock()
+	masters := make([]*rtuMaster, 0, len(b.masters))
+	for m := range b.masters {
+		masters = append(masters, m)
+	}
+	b.mu.Unlock()
+	var err error
+	for _, m := range masters {
+		if e := m.write(frame); e != nil && m == to {
+			err = e
+		}
+	}
+	return err
+}
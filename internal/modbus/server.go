@@ -8,6 +8,9 @@ import (
 	"math"
 	"net"
 	"sync"
+	"time"
+
+	"modbus-simulator/internal/capture"
 )
 
 const (
@@ -19,6 +22,10 @@ const (
 	functionWriteSingleReg     = 0x06
 	functionWriteMultipleCoils = 0x0F
 	functionWriteMultipleRegs  = 0x10
+	// recognised in RTU framing only; answered with an exception
+	functionMaskWriteReg          = 0x16
+	functionReadWriteMultipleRegs = 0x17
+	functionReadDeviceID          = 0x2B // MEI type 0x0E
 
 	exceptionIllegalFunction = 0x01
 	exceptionIllegalDataAddr = 0x02
@@ -33,7 +40,8 @@ var (
 	errInvalidByteCount = errors.New("invalid byte count")
 )
 
-// Server implements a minimal Modbus TCP server that supports read functions.
+// Server implements a minimal Modbus server over TCP, RTU-over-TCP and RTU
+// serial lines sharing one register image.
 type Server struct {
 	listener  net.Listener
 	wg        sync.WaitGroup
@@ -45,6 +53,63 @@ type Server struct {
 	InputRegisters   []uint16
 	Coils            []bool
 	DiscreteInputs   []bool
+
+	hookMu    sync.RWMutex
+	onWrite   WriteFunc
+	fault     Fault
+	rtuTiming RTUTiming
+	tap       capture.Tap
+	closers   []io.Closer      // RTU lines and listeners closed by Close
+	units     map[byte]*Server // register images of single slaves, see Unit
+}
+
+// WriteFunc is called after a client wrote quantity coils or holding
+// registers starting at address. kind is "coil" or "holding".
+type WriteFunc func(kind string, address, quantity uint16)
+
+// Fault makes the server misbehave to simulate communication failures.
+// The zero value is a healthy server.
+type Fault struct {
+	NoResponse bool          // drop requests without answering
+	Exception  byte          // answer every request with this exception code
+	Delay      time.Duration // wait before answering
+}
+
+// OnWrite registers fn to be called after every successful client write.
+func (s *Server) OnWrite(fn WriteFunc) {
+	s.hookMu.Lock()
+	s.onWrite = fn
+	s.hookMu.Unlock()
+}
+
+// SetFault replaces the active communication fault.
+func (s *Server) SetFault(f Fault) {
+	s.hookMu.Lock()
+	s.fault = f
+	s.hookMu.Unlock()
+}
+
+func (s *Server) currentFault() Fault {
+	s.hookMu.RLock()
+	defer s.hookMu.RUnlock()
+	return s.fault
+}
+
+func (s *Server) notifyWrite(kind string, pdu []byte) {
+	quantity := uint16(1)
+	if pdu[0] == functionWriteMultipleCoils || pdu[0] == functionWriteMultipleRegs {
+		quantity = binary.BigEndian.Uint16(pdu[3:5])
+	}
+	s.callWriteHook(kind, binary.BigEndian.Uint16(pdu[1:3]), quantity)
+}
+
+func (s *Server) callWriteHook(kind string, address, quantity uint16) {
+	s.hookMu.RLock()
+	fn := s.onWrite
+	s.hookMu.RUnlock()
+	if fn != nil {
+		fn(kind, address, quantity)
+	}
 }
 
 // NewServer constructs a server with default register sizes.
@@ -92,7 +157,13 @@ func (s *Server) acceptLoop() {
 func (s *Server) handleConnection(conn net.Conn) {
 	defer s.wg.Done()
 	defer conn.Close()
+	s.serveTCP(conn, nil)
+}
 
+// serveTCP answers the Modbus TCP requests read from conn until reading or
+// writing fails. Requests allow rejects are answered with exception 01;
+// nil allows all.
+func (s *Server) serveTCP(conn net.Conn, allow func(pdu []byte) bool) {
 	header := make([]byte, 7)
 	for {
 		if _, err := io.ReadFull(conn, header); err != nil {
@@ -114,8 +185,15 @@ func (s *Server) handleConnection(conn net.Conn) {
 		if _, err := io.ReadFull(conn, pdu); err != nil {
 			return
 		}
+		s.capture(capture.TCP, capture.Request, conn.RemoteAddr().String(), conn.LocalAddr().String(), header, pdu)
 
-		response := s.handlePDU(pdu)
+		response, ok := exceptionResponse(pdu[0], exceptionIllegalFunction), true
+		if allow == nil || allow(pdu) {
+			response, ok = s.respond(unitID, pdu)
+		}
+		if !ok {
+			return
+		}
 		if len(response) == 0 {
 			continue
 		}
@@ -130,9 +208,96 @@ func (s *Server) handleConnection(conn net.Conn) {
 		if _, err := conn.Write(response); err != nil {
 			return
 		}
+		s.capture(capture.TCP, capture.Response, conn.LocalAddr().String(), conn.RemoteAddr().String(), header, response)
 	}
 }
 
+// SetTap makes the server report every frame it receives and sends; nil
+// stops capturing.
+func (s *Server) SetTap(t capture.Tap) {
+	s.hookMu.Lock()
+	s.tap = t
+	s.hookMu.Unlock()
+}
+
+// capture reports the frame made of parts to the tap, if any.
+func (s *Server) capture(transport, direction, src, dst string, parts ...[]byte) {
+	s.hookMu.RLock()
+	tap := s.tap
+	s.hookMu.RUnlock()
+	if tap == nil {
+		return
+	}
+	var adu []byte
+	for _, p := range parts {
+		adu = append(adu, p...)
+	}
+	tap(capture.Frame{Time: time.Now(), Transport: transport, Direction: direction, Src: src, Dst: dst, ADU: adu})
+}
+
+// Unit returns the separate register image answering requests for slave
+// (unit) id, creating it on first use. Requests to ids without their own
+// image are answered from s. Faults of s apply to all units; a unit has its
+// own OnWrite hook.
+func (s *Server) Unit(id byte) *Server {
+	s.hookMu.Lock()
+	defer s.hookMu.Unlock()
+	if u := s.units[id]; u != nil {
+		return u
+	}
+	if s.units == nil {
+		s.units = map[byte]*Server{}
+	}
+	u := NewServer()
+	s.units[id] = u
+	return u
+}
+
+// ImageFor returns the register image answering requests for unit id: its
+// Unit image if one was created, else s.
+func (s *Server) ImageFor(id byte) *Server {
+	s.hookMu.RLock()
+	defer s.hookMu.RUnlock()
+	if u := s.units[id]; u != nil {
+		return u
+	}
+	return s
+}
+
+// broadcast executes a request addressed to every slave without answering.
+func (s *Server) broadcast(pdu []byte) {
+	s.hookMu.RLock()
+	images := []*Server{s}
+	for _, u := range s.units {
+		images = append(images, u)
+	}
+	s.hookMu.RUnlock()
+	for _, img := range images {
+		img.handlePDU(pdu)
+	}
+}
+
+// respond applies the active fault and answers a request PDU for unit id
+// from its register image. An empty response means no answer; ok is false
+// when the server is closing.
+func (s *Server) respond(id byte, pdu []byte) (response []byte, ok bool) {
+	fault := s.currentFault()
+	if fault.Delay > 0 {
+		select {
+		case <-time.After(fault.Delay):
+		case <-s.quit:
+			return nil, false
+		}
+	}
+	if fault.NoResponse {
+		return nil, true
+	}
+	if fault.Exception != 0 {
+		return exceptionResponse(pdu[0], fault.Exception), true
+	}
+	return s.ImageFor(id).handlePDU(pdu), true
+}
+
 func (s *Server) handlePDU(pdu []byte) []byte {
 	if len(pdu) == 0 {
 		return exceptionResponse(0, exceptionIllegalFunction)
@@ -169,24 +334,28 @@ func (s *Server) handlePDU(pdu []byte) []byte {
 		if err != nil {
 			return exceptionResponse(function, errToCode(err))
 		}
+		s.notifyWrite("coil", pdu)
 		return resp
 	case functionWriteSingleReg:
 		resp, err := s.writeSingleRegister(pdu)
 		if err != nil {
 			return exceptionResponse(function, errToCode(err))
 		}
+		s.notifyWrite("holding", pdu)
 		return resp
 	case functionWriteMultipleCoils:
 		resp, err := s.writeMultipleCoils(pdu)
 		if err != nil {
 			return exceptionResponse(function, errToCode(err))
 		}
+		s.notifyWrite("coil", pdu)
 		return resp
 	case functionWriteMultipleRegs:
 		resp, err := s.writeMultipleRegisters(pdu)
 		if err != nil {
 			return exceptionResponse(function, errToCode(err))
 		}
+		s.notifyWrite("holding", pdu)
 		return resp
 	default:
 		return exceptionResponse(function, exceptionIllegalFunction)
@@ -374,6 +543,11 @@ func (s *Server) Close() {
 		if s.listener != nil {
 			s.listener.Close()
 		}
+		s.hookMu.Lock()
+		for _, c := range s.closers {
+			c.Close()
+		}
+		s.hookMu.Unlock()
 	})
 	s.wg.Wait()
 }
@@ -49,3 +49,90 @@ func GetDiscreteInput(s *Server, address uint16) (bool, error) {
 func ErrAddrOutOfRange(addr uint16) error {
 	return fmt.Errorf("address %d out of range", addr)
 }
+
+// HoldingRegister returns the holding register at address.
+func (s *Server) HoldingRegister(address uint16) (uint16, error) {
+	return GetHoldingRegister(s, address)
+}
+
+// InputRegister returns the input register at address.
+func (s *Server) InputRegister(address uint16) (uint16, error) {
+	return GetInputRegister(s, address)
+}
+
+// Coil returns the coil at address.
+func (s *Server) Coil(address uint16) (bool, error) {
+	return GetCoil(s, address)
+}
+
+// DiscreteInput returns the discrete input at address.
+func (s *Server) DiscreteInput(address uint16) (bool, error) {
+	return GetDiscreteInput(s, address)
+}
+
+// ReadTable returns count registers of table starting at address. Coils and
+// discrete inputs read as 0 or 1.
+func (s *Server) ReadTable(table string, address uint16, count int) ([]uint16, error) {
+	if count <= 0 {
+		return nil, errInvalidQty
+	}
+	start, end, err := Range{Table: table, Start: address, Count: count}.bounds()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]uint16, 0, count)
+	for a := start; a < end; a++ {
+		switch table {
+		case TableHolding:
+			out = append(out, s.HoldingRegisters[a])
+		case TableInput:
+			out = append(out, s.InputRegisters[a])
+		case TableCoil:
+			out = append(out, boolWord(s.Coils[a]))
+		case TableDiscrete:
+			out = append(out, boolWord(s.DiscreteInputs[a]))
+		}
+	}
+	return out, nil
+}
+
+// WriteTable stores values into table starting at address. Non-zero values
+// set bits. Holding register and coil writes run the OnWrite hook like a
+// client write.
+func (s *Server) WriteTable(table string, address uint16, values []uint16) error {
+	if len(values) == 0 {
+		return errInvalidQty
+	}
+	start, end, err := Range{Table: table, Start: address, Count: len(values)}.bounds()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	for a := start; a < end; a++ {
+		v := values[a-start]
+		switch table {
+		case TableHolding:
+			s.HoldingRegisters[a] = v
+		case TableInput:
+			s.InputRegisters[a] = v
+		case TableCoil:
+			s.Coils[a] = v != 0
+		case TableDiscrete:
+			s.DiscreteInputs[a] = v != 0
+		}
+	}
+	s.mu.Unlock()
+	if table == TableHolding || table == TableCoil {
+		s.callWriteHook(table, address, uint16(len(values)))
+	}
+	return nil
+}
+
+func boolWord(b bool) uint16 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,199 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Register tables addressed by Range and Block.
+const (
+	TableHolding  = "holding"
+	TableInput    = "input"
+	TableCoil     = "coil"
+	TableDiscrete = "discrete"
+)
+
+var tableIDs = map[string]byte{TableHolding: 1, TableInput: 2, TableCoil: 3, TableDiscrete: 4}
+
+var errBadState = errors.New("malformed register state")
+
+// stateMagic starts every encoded State; the last byte is the format version.
+var stateMagic = []byte{'M', 'B', 'S', 1}
+
+// Range selects Count registers of Table starting at Start. A zero Count
+// extends to the end of the table.
+type Range struct {
+	Table string
+	Start uint16
+	Count int
+}
+
+// Block is a saved run of registers: Words for holding/input tables, Bits
+// for coils and discrete inputs.
+type Block struct {
+	Table string
+	Start uint16
+	Words []uint16
+	Bits  []bool
+}
+
+// State is a saved copy of selected parts of the register image.
+type State struct {
+	Blocks []Block
+}
+
+func (r Range) bounds() (start, end int, err error) {
+	if _, ok := tableIDs[r.Table]; !ok {
+		return 0, 0, fmt.Errorf("unknown register table %q", r.Table)
+	}
+	start = int(r.Start)
+	end = 65536
+	if r.Count > 0 {
+		end = start + r.Count
+	}
+	if r.Count < 0 || end > 65536 {
+		return 0, 0, fmt.Errorf("%s range %d+%d: %w", r.Table, r.Start, r.Count, errOutOfRange)
+	}
+	return start, end, nil
+}
+
+// SaveState copies the registers selected by ranges.
+func (s *Server) SaveState(ranges []Range) (State, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var st State
+	for _, r := range ranges {
+		start, end, err := r.bounds()
+		if err != nil {
+			return State{}, err
+		}
+		b := Block{Table: r.Table, Start: r.Start}
+		switch r.Table {
+		case TableHolding:
+			b.Words = append([]uint16(nil), s.HoldingRegisters[start:end]...)
+		case TableInput:
+			b.Words = append([]uint16(nil), s.InputRegisters[start:end]...)
+		case TableCoil:
+			b.Bits = append([]bool(nil), s.Coils[start:end]...)
+		case TableDiscrete:
+			b.Bits = append([]bool(nil), s.DiscreteInputs[start:end]...)
+		}
+		st.Blocks = append(st.Blocks, b)
+	}
+	return st, nil
+}
+
+// RestoreState writes the saved registers back. Only the parts of the
+// blocks inside ranges are restored, so registers that are no longer
+// retentive keep their current value.
+func (s *Server) RestoreState(st State, ranges []Range) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range ranges {
+		start, end, err := r.bounds()
+		if err != nil {
+			return err
+		}
+		for _, b := range st.Blocks {
+			if b.Table != r.Table {
+				continue
+			}
+			from := max(start, int(b.Start))
+			to := min(end, int(b.Start)+len(b.Words)+len(b.Bits))
+			for a := from; a < to; a++ {
+				i := a - int(b.Start)
+				switch b.Table {
+				case TableHolding:
+					s.HoldingRegisters[a] = b.Words[i]
+				case TableInput:
+					s.InputRegisters[a] = b.Words[i]
+				case TableCoil:
+					s.Coils[a] = b.Bits[i]
+				case TableDiscrete:
+					s.DiscreteInputs[a] = b.Bits[i]
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the state compactly: a header, then per block the
+// table, start address and length followed by big-endian words or packed
+// bits.
+func (st State) MarshalBinary() ([]byte, error) {
+	buf := append([]byte(nil), stateMagic...)
+	for _, b := range st.Blocks {
+		id, ok := tableIDs[b.Table]
+		if !ok {
+			return nil, fmt.Errorf("unknown register table %q", b.Table)
+		}
+		n := len(b.Words) + len(b.Bits)
+		buf = append(buf, id)
+		buf = binary.BigEndian.AppendUint16(buf, b.Start)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(n))
+		for _, w := range b.Words {
+			buf = binary.BigEndian.AppendUint16(buf, w)
+		}
+		if len(b.Bits) > 0 {
+			packed := make([]byte, (len(b.Bits)+7)/8)
+			for i, v := range b.Bits {
+				if v {
+					packed[i/8] |= 1 << (i % 8)
+				}
+			}
+			buf = append(buf, packed...)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a state written by MarshalBinary.
+func (st *State) UnmarshalBinary(data []byte) error {
+	if len(data) < len(stateMagic) || string(data[:len(stateMagic)]) != string(stateMagic) {
+		return errBadState
+	}
+	data = data[len(stateMagic):]
+	var blocks []Block
+	for len(data) > 0 {
+		if len(data) < 7 {
+			return errBadState
+		}
+		b := Block{Start: binary.BigEndian.Uint16(data[1:3])}
+		for name, id := range tableIDs {
+			if id == data[0] {
+				b.Table = name
+			}
+		}
+		n := int(binary.BigEndian.Uint32(data[3:7]))
+		data = data[7:]
+		if b.Table == "" || int(b.Start)+n > 65536 {
+			return errBadState
+		}
+		switch b.Table {
+		case TableHolding, TableInput:
+			if len(data) < 2*n {
+				return errBadState
+			}
+			b.Words = make([]uint16, n)
+			for i := range b.Words {
+				b.Words[i] = binary.BigEndian.Uint16(data[2*i:])
+			}
+			data = data[2*n:]
+		default:
+			size := (n + 7) / 8
+			if len(data) < size {
+				return errBadState
+			}
+			b.Bits = make([]bool, n)
+			for i := range b.Bits {
+				b.Bits[i] = data[i/8]&(1<<(i%8)) != 0
+			}
+			data = data[size:]
+		}
+		blocks = append(blocks, b)
+	}
+	st.Blocks = blocks
+	return nil
+}
@@ -5,11 +5,12 @@ import "time"
 // LatestDataValue stores a periodic snapshot of the latest value of each point.
 // Table: latest_datas_value
 // It mirrors the output from internal/db.PointLatest with an auto-increment ID.
+// (server_id, device_id, name) is unique so snapshots can be upserted.
 type LatestDataValue struct {
 	ID           uint      `gorm:"column:id;primaryKey;autoIncrement"`
-	ServerID     string    `gorm:"column:server_id;index"`
-	DeviceID     string    `gorm:"column:device_id;index"`
-	Name         string    `gorm:"column:name;index"`
+	ServerID     string    `gorm:"column:server_id;uniqueIndex:idx_latest_point,priority:1"`
+	DeviceID     string    `gorm:"column:device_id;uniqueIndex:idx_latest_point,priority:2;index"`
+	Name         string    `gorm:"column:name;uniqueIndex:idx_latest_point,priority:3"`
 	Address      int       `gorm:"column:address"`
 	RegisterType string    `gorm:"column:register_type"`
 	DataType     string    `gorm:"column:data_type"`
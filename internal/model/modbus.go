@@ -34,8 +34,8 @@ type Device struct {
 type PointValue struct {
     ID           uint      `gorm:"column:id;primaryKey;autoIncrement"`
     ServerID     string    `gorm:"column:server_id;index"`
-    DeviceID     string    `gorm:"column:device_id;index"`
-    Name         string    `gorm:"column:name"`
+    DeviceID     string    `gorm:"column:device_id;index;index:idx_point_values_series,priority:1"`
+    Name         string    `gorm:"column:name;index:idx_point_values_series,priority:2"`
     Address      int       `gorm:"column:address"`
     RegisterType string    `gorm:"column:register_type"`
     DataType     string    `gorm:"column:data_type"`
@@ -44,7 +44,7 @@ type PointValue struct {
     Offset       float64   `gorm:"column:offset;default:0"`
     Unit         string    `gorm:"column:unit"`
     Value        float64   `gorm:"column:value"`
-    Timestamp    time.Time `gorm:"column:timestamp;autoCreateTime"`
+    Timestamp    time.Time `gorm:"column:timestamp;autoCreateTime;index;index:idx_point_values_series,priority:3"`
 
     Device Device `gorm:"foreignKey:DeviceID;references:DeviceID"`
 }
@@ -4,6 +4,8 @@ import "time"
 
 // PointSnapshot represents a single point's current value
 // Only one of ValueUint16 or ValueBool will be set depending on register type.
+// Value is the engineering value decoded like the collector does, from
+// RawWords (holding/input) by DataType, ByteOrder, scale and offset.
 type PointSnapshot struct {
 	Name         string    `json:"name"`
 	RegisterType string    `json:"register_type"`
@@ -11,6 +13,10 @@ type PointSnapshot struct {
 	Unit         string    `json:"unit"`
 	ValueUint16  *uint16   `json:"value_uint16,omitempty"`
 	ValueBool    *bool     `json:"value_bool,omitempty"`
+	Value        *float64  `json:"value,omitempty"`
+	RawWords     []uint16  `json:"raw_words,omitempty"`
+	DataType     string    `json:"data_type,omitempty"`
+	ByteOrder    string    `json:"byte_order,omitempty"`
 	Timestamp    time.Time `json:"timestamp"`
 }
 
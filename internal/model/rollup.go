@@ -0,0 +1,64 @@
+package model
+
+import "time"
+
+// RollupAggregate holds the per-bucket statistics shared by the rollup tables.
+type RollupAggregate struct {
+	Unit    string    `gorm:"column:unit"`
+	Min     float64   `gorm:"column:min"`
+	Max     float64   `gorm:"column:max"`
+	Avg     float64   `gorm:"column:avg"`
+	Sum     float64   `gorm:"column:sum"`
+	First   float64   `gorm:"column:first"`
+	Last    float64   `gorm:"column:last"`
+	Count   int64     `gorm:"column:count"`
+	FirstTS time.Time `gorm:"column:first_ts"`
+	LastTS  time.Time `gorm:"column:last_ts"`
+}
+
+// PointRollup1m aggregates point_values into one-minute buckets.
+// Table: point_values_1m
+type PointRollup1m struct {
+	ID       uint      `gorm:"column:id;primaryKey;autoIncrement"`
+	ServerID string    `gorm:"column:server_id;uniqueIndex:idx_rollup_1m_key,priority:1"`
+	DeviceID string    `gorm:"column:device_id;uniqueIndex:idx_rollup_1m_key,priority:2"`
+	Name     string    `gorm:"column:name;uniqueIndex:idx_rollup_1m_key,priority:3"`
+	Bucket   time.Time `gorm:"column:bucket;uniqueIndex:idx_rollup_1m_key,priority:4;index"`
+
+	RollupAggregate `gorm:"embedded"`
+}
+
+func (PointRollup1m) TableName() string { return "point_values_1m" }
+
+// PointRollup1h aggregates point_values_1m into one-hour buckets.
+// Table: point_values_1h
+type PointRollup1h struct {
+	ID       uint      `gorm:"column:id;primaryKey;autoIncrement"`
+	ServerID string    `gorm:"column:server_id;uniqueIndex:idx_rollup_1h_key,priority:1"`
+	DeviceID string    `gorm:"column:device_id;uniqueIndex:idx_rollup_1h_key,priority:2"`
+	Name     string    `gorm:"column:name;uniqueIndex:idx_rollup_1h_key,priority:3"`
+	Bucket   time.Time `gorm:"column:bucket;uniqueIndex:idx_rollup_1h_key,priority:4;index"`
+
+	RollupAggregate `gorm:"embedded"`
+}
+
+func (PointRollup1h) TableName() string { return "point_values_1h" }
+
+// RollupState records, per resolution, the end of the last fully aggregated
+// bucket. Rows before the watermark are never aggregated again.
+// Table: rollup_state
+type RollupState struct {
+	Resolution string    `gorm:"column:resolution;primaryKey"`
+	Watermark  time.Time `gorm:"column:watermark"`
+}
+
+func (RollupState) TableName() string { return "rollup_state" }
+
+// RollupLate marks a point_values row inserted behind the 1m watermark. The
+// next rollup merges it into its buckets; raw pruning keeps it until then.
+// Table: rollup_late
+type RollupLate struct {
+	PointValueID uint `gorm:"column:point_value_id;primaryKey;autoIncrement:false"`
+}
+
+func (RollupLate) TableName() string { return "rollup_late" }
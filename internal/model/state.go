@@ -0,0 +1,14 @@
+package model
+
+import "time"
+
+// RegisterState holds the saved retentive registers of a simulated server,
+// encoded by modbus.State.MarshalBinary.
+// Table: register_state
+type RegisterState struct {
+	ServerID  string    `gorm:"column:server_id;primaryKey"`
+	Data      []byte    `gorm:"column:data"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+}
+
+func (RegisterState) TableName() string { return "register_state" }
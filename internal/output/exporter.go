@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"modbus-simulator/internal/model"
@@ -23,7 +25,9 @@ func WriteJSON(path string, snaps []model.ServerSnapshot) error {
 }
 
 // WriteCSV flattens snapshots and writes to a CSV file.
-// Columns: server_id,server_name,address,device_id,point_name,register_type,address_idx,unit,value_uint16,value_bool,timestamp
+// Columns: server_id,server_name,address,device_id,point_name,register_type,address_idx,unit,value_uint16,value_bool,timestamp,
+// value,raw_words,data_type,byte_order. value is the decoded engineering value, raw_words the
+// space separated register words it was decoded from.
 func WriteCSV(path string, snaps []model.ServerSnapshot) error {
 	f, err := os.Create(path)
 	if err != nil {
@@ -34,7 +38,8 @@ func WriteCSV(path string, snaps []model.ServerSnapshot) error {
 	w := csv.NewWriter(f)
 	defer w.Flush()
 
-	headers := []string{"server_id", "server_name", "address", "device_id", "point_name", "register_type", "address_idx", "unit", "value_uint16", "value_bool", "timestamp"}
+	headers := []string{"server_id", "server_name", "address", "device_id", "point_name", "register_type", "address_idx", "unit", "value_uint16", "value_bool", "timestamp",
+		"value", "raw_words", "data_type", "byte_order"}
 	if err := w.Write(headers); err != nil {
 		return fmt.Errorf("write header: %w", err)
 	}
@@ -53,6 +58,14 @@ func WriteCSV(path string, snaps []model.ServerSnapshot) error {
 						vBool = "0"
 					}
 				}
+				var value string
+				if p.Value != nil {
+					value = strconv.FormatFloat(*p.Value, 'f', -1, 64)
+				}
+				words := make([]string, len(p.RawWords))
+				for i, w := range p.RawWords {
+					words[i] = strconv.FormatUint(uint64(w), 10)
+				}
 				rec := []string{
 					s.ServerID,
 					s.ServerName,
@@ -65,6 +78,10 @@ func WriteCSV(path string, snaps []model.ServerSnapshot) error {
 					vU16,
 					vBool,
 					timeToRFC3339(p.Timestamp),
+					value,
+					strings.Join(words, " "),
+					p.DataType,
+					p.ByteOrder,
 				}
 				if err := w.Write(rec); err != nil {
 					return fmt.Errorf("write record: %w", err)
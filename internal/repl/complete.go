@@ -0,0 +1,113 @@
+package repl
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// commands lists the command words for completion.
+var commands = []string{"close", "connect", "help", "history", "load", "points", "quit", "read", "status", "timeout", "unit", "watch", "write"}
+
+// Complete is a tab completion callback for golang.org/x/term: it
+// completes the word before pos from the commands, tables, encodings and
+// point names that fit there, and lists the candidates when several fit.
+func (s *Session) Complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	head := line[:pos]
+	words := strings.Fields(head)
+	partial := ""
+	if len(words) > 0 && !strings.HasSuffix(head, " ") {
+		partial, words = words[len(words)-1], words[:len(words)-1]
+	}
+	candidates := s.candidates(words, partial)
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(strings.ToLower(c), strings.ToLower(partial)) {
+			matches = append(matches, c)
+		}
+	}
+	slices.Sort(matches)
+	matches = slices.Compact(matches)
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	if len(matches) > 1 {
+		completion = commonPrefix(matches)
+		if len(completion) <= len(partial) {
+			s.printf("%s\n", strings.Join(matches, "  "))
+			return "", 0, false
+		}
+	} else if !strings.HasSuffix(completion, "/") {
+		completion += " "
+	}
+	start := pos - len(partial)
+	newLine := line[:start] + completion + line[pos:]
+	return newLine, start + len(completion), true
+}
+
+// candidates lists the words that may follow words.
+func (s *Session) candidates(words []string, partial string) []string {
+	if len(words) == 0 {
+		return commands
+	}
+	cmd := strings.ToLower(words[0])
+	last := strings.ToLower(words[len(words)-1])
+	switch cmd {
+	case "help":
+		return commands
+	case "load":
+		matches, _ := filepath.Glob(partial + "*")
+		return matches
+	case "connect":
+		if len(words) == 1 {
+			return []string{"tcp://", "rtu-over-tcp://", "rtu:///dev/"}
+		}
+		return nil
+	case "read", "r", "watch", "w", "write":
+	default:
+		return nil
+	}
+	if len(words) == 1 {
+		return append([]string{"hr", "ir", "coil", "di"}, s.names...)
+	}
+	if last == "as" {
+		return dataTypes
+	}
+	if slices.Contains(dataTypes, last) {
+		return byteOrders
+	}
+	table := tableAliases[strings.ToLower(words[1])]
+	var next []string
+	switch {
+	case table == "":
+		if cmd == "write" {
+			if ref, ok, _ := s.lookup(words[1]); ok && strings.EqualFold(ref.point.RegisterType, "coil") {
+				return []string{"on", "off"}
+			}
+			return nil
+		}
+		next = s.names
+	case table == "coil" && cmd == "write" && len(words) >= 3:
+		return []string{"on", "off"}
+	case len(words) >= 3 && !isBitTable(table):
+		next = []string{"as"}
+	}
+	if cmd == "watch" || cmd == "w" {
+		next = append(next, "every", "for")
+	}
+	return next
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,70 @@
+package repl
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// maxHistory bounds the lines kept in memory and loaded from the file.
+const maxHistory = 1000
+
+// History is the command history of the shell. It implements the
+// History interface of golang.org/x/term and appends every line to a
+// file so the next session can recall it.
+type History struct {
+	lines []string // oldest first
+	path  string
+}
+
+// OpenHistory loads the history kept in path. An empty path keeps the
+// history in memory only; a missing file starts an empty history.
+func OpenHistory(path string) (*History, error) {
+	h := &History{path: path}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			h.lines = append(h.lines, line)
+		}
+	}
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[len(h.lines)-maxHistory:]
+	}
+	return h, scanner.Err()
+}
+
+// Add records a line, skipping blanks and repeats of the previous line.
+func (h *History) Add(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || (len(h.lines) > 0 && h.lines[len(h.lines)-1] == line) {
+		return
+	}
+	h.lines = append(h.lines, line)
+	if len(h.lines) > maxHistory {
+		h.lines = h.lines[1:]
+	}
+	if h.path == "" {
+		return
+	}
+	if f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600); err == nil {
+		f.WriteString(line + "\n")
+		f.Close()
+	}
+}
+
+// Len returns the number of lines.
+func (h *History) Len() int { return len(h.lines) }
+
+// At returns a line, 0 being the most recent.
+func (h *History) At(idx int) string { return h.lines[len(h.lines)-1-idx] }
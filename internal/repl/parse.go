@@ -0,0 +1,240 @@
+package repl
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// tableAliases maps the table names accepted in commands to register types.
+var tableAliases = map[string]string{
+	"hr": "holding", "holding": "holding", "4x": "holding",
+	"ir": "input", "input": "input", "3x": "input",
+	"coil": "coil", "co": "coil", "0x": "coil",
+	"di": "discrete", "discrete": "discrete", "1x": "discrete",
+}
+
+// tableShort is how results name each table.
+var tableShort = map[string]string{"holding": "hr", "input": "ir", "coil": "coil", "discrete": "di"}
+
+// dataTypes and byteOrders are the encodings of "as <type> [order]".
+var (
+	dataTypes  = []string{"uint16", "int16", "uint32", "int32", "float32"}
+	byteOrders = []string{"ABCD", "CDAB", "BADC", "DCBA"}
+)
+
+func isBitTable(table string) bool { return table == "coil" || table == "discrete" }
+
+// parseAddressSpec parses "100" or an inclusive range "0..8". The range
+// end is -1 for a single address.
+func parseAddressSpec(s string) (start uint16, end int, err error) {
+	first, last, isRange := strings.Cut(s, "..")
+	a, err := strconv.ParseUint(first, 0, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid address %q", s)
+	}
+	if !isRange {
+		return uint16(a), -1, nil
+	}
+	b, err := strconv.ParseUint(last, 0, 16)
+	if err != nil || b < a {
+		return 0, 0, fmt.Errorf("invalid address range %q", s)
+	}
+	return uint16(a), int(b), nil
+}
+
+// parseEncoding parses the words after "as": a data type and an optional
+// byte order.
+func parseEncoding(args []string) (dataType, order string, err error) {
+	if len(args) == 0 || len(args) > 2 {
+		return "", "", fmt.Errorf("usage: as <%s> [%s]", strings.Join(dataTypes, "|"), strings.Join(byteOrders, "|"))
+	}
+	dataType = strings.ToLower(args[0])
+	if !slices.Contains(dataTypes, dataType) {
+		return "", "", fmt.Errorf("unknown data type %q, want one of %s", args[0], strings.Join(dataTypes, ", "))
+	}
+	if len(args) == 2 {
+		order = strings.ToUpper(args[1])
+		if !slices.Contains(byteOrders, order) {
+			return "", "", fmt.Errorf("unknown byte order %q, want one of %s", args[1], strings.Join(byteOrders, ", "))
+		}
+	}
+	return dataType, order, nil
+}
+
+// splitAs splits args at the "as" keyword.
+func splitAs(args []string) (before, encoding []string, ok bool) {
+	for i, a := range args {
+		if strings.EqualFold(a, "as") {
+			return args[:i], args[i+1:], true
+		}
+	}
+	return args, nil, false
+}
+
+// parseBit parses a coil value.
+func parseBit(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "on", "1", "true":
+		return true, nil
+	case "off", "0", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid coil value %q, want on or off", s)
+}
+
+// parseNumber parses a decimal, float or 0x hex value.
+func parseNumber(s string) (float64, error) {
+	if strings.HasPrefix(strings.ToLower(s), "0x") {
+		v, err := strconv.ParseUint(s[2:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value %q", s)
+		}
+		return float64(v), nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return v, nil
+}
+
+// ParseTarget parses a connection target into a server config:
+//
+//	tcp://host:port, rtu-over-tcp://host:port, host:port (TCP),
+//	rtu:///dev/ttyUSB0?baud=9600&parity=E&data_bits=8&stop_bits=1,
+//	tls://host:port?cert=client.pem&key=client.key&ca=ca.pem&server_name=plc
+//
+// or the words "tcp host:port", "rtu-over-tcp host:port" and
+// "rtu <port> [baud] [parity]". TCP ports default to 502, TLS (Modbus/TCP
+// Security) ports to 802.
+func ParseTarget(args []string) (collector.ServerConfig, error) {
+	srv := collector.ServerConfig{ServerID: "client", Enabled: true}
+	if len(args) == 0 {
+		return srv, fmt.Errorf("usage: connect <tcp://host:port | rtu-over-tcp://host:port | rtu:///dev/tty... | tls://host:port?cert=..&key=..&ca=..>")
+	}
+	proto, rest := "", args
+	if u, err := url.Parse(args[0]); err == nil && strings.Contains(args[0], "://") {
+		proto = strings.ToLower(u.Scheme)
+		if proto == "rtu" {
+			port := u.Path
+			if u.Host != "" {
+				port = u.Host + u.Path
+			}
+			rest = []string{port}
+			q := u.Query()
+			for _, key := range []string{"baud", "parity"} {
+				if v := q.Get(key); v != "" {
+					rest = append(rest, v)
+				}
+			}
+			if err := serialOptions(&srv.Connection, q.Get("data_bits"), q.Get("stop_bits")); err != nil {
+				return srv, err
+			}
+		} else {
+			rest = []string{u.Host}
+		}
+		if proto == "tls" {
+			q := u.Query()
+			srv.Connection.TLS = &collector.TLSConfig{CertFile: q.Get("cert"), KeyFile: q.Get("key"), CAFile: q.Get("ca"),
+				ServerName: q.Get("server_name")}
+		}
+	} else if p := strings.ToLower(args[0]); p == "tcp" || p == "rtu" || p == "rtu-over-tcp" {
+		proto, rest = p, args[1:]
+	} else if strings.HasPrefix(args[0], "/dev/") || strings.HasPrefix(strings.ToUpper(args[0]), "COM") {
+		proto = "rtu"
+	} else {
+		proto = "tcp"
+	}
+	if len(rest) == 0 || rest[0] == "" {
+		return srv, fmt.Errorf("target %q has no address", strings.Join(args, " "))
+	}
+
+	switch proto {
+	case "tcp", "modbus-tcp", "rtu-over-tcp", "tls":
+		if len(rest) > 1 {
+			return srv, fmt.Errorf("unexpected %q after the address", strings.Join(rest[1:], " "))
+		}
+		host, port, err := net.SplitHostPort(rest[0])
+		if err != nil {
+			host, port = rest[0], "502"
+			if proto == "tls" {
+				host, port = rest[0], "802"
+			}
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return srv, fmt.Errorf("invalid port %q", port)
+		}
+		if host == "" || host == "0.0.0.0" {
+			host = "127.0.0.1"
+		}
+		srv.Protocol = "modbus-tcp"
+		switch proto {
+		case "rtu-over-tcp":
+			srv.Protocol = "rtu-over-tcp"
+		case "tls":
+			srv.Protocol = "modbus-tls"
+		}
+		srv.Connection.Host, srv.Connection.Port = host, n
+	case "rtu":
+		if len(rest) > 3 {
+			return srv, fmt.Errorf("usage: rtu <port> [baud] [parity]")
+		}
+		srv.Protocol = "modbus-rtu"
+		srv.Connection.SerialPort = rest[0]
+		if len(rest) > 1 {
+			baud, err := strconv.Atoi(rest[1])
+			if err != nil || baud <= 0 {
+				return srv, fmt.Errorf("invalid baud rate %q", rest[1])
+			}
+			srv.Connection.BaudRate = baud
+		}
+		if len(rest) > 2 {
+			p := strings.ToUpper(rest[2])
+			if p != "N" && p != "E" && p != "O" {
+				return srv, fmt.Errorf("invalid parity %q, want N, E or O", rest[2])
+			}
+			srv.Connection.Parity = p
+		}
+	default:
+		return srv, fmt.Errorf("unsupported protocol %q", proto)
+	}
+	return srv, nil
+}
+
+func serialOptions(c *collector.Connection, dataBits, stopBits string) error {
+	for _, o := range []struct {
+		s   string
+		dst *int
+	}{{dataBits, &c.DataBits}, {stopBits, &c.StopBits}} {
+		if o.s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(o.s)
+		if err != nil {
+			return fmt.Errorf("invalid serial option %q", o.s)
+		}
+		*o.dst = n
+	}
+	return nil
+}
+
+// describe renders the target of srv the way ParseTarget reads it.
+func describe(srv collector.ServerConfig) string {
+	c := srv.Connection
+	switch srv.Protocol {
+	case "modbus-rtu", "rtu":
+		return "rtu://" + c.SerialPort
+	case "rtu-over-tcp":
+		return fmt.Sprintf("rtu-over-tcp://%s", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+	case "modbus-tls":
+		return fmt.Sprintf("tls://%s", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+	}
+	return fmt.Sprintf("tcp://%s", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+}
@@ -0,0 +1,783 @@
+// Package repl implements the interactive Modbus client shell of
+// cmd/client: connecting to TCP, RTU and RTU-over-TCP targets, reading,
+// writing and watching registers by address or by point name.
+package repl
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+	cfgpkg "modbus-simulator/internal/config"
+	"modbus-simulator/internal/simulator"
+)
+
+// ErrQuit is returned by Exec for the quit command.
+var ErrQuit = errors.New("quit")
+
+// pointRef is a named point of a loaded config.
+type pointRef struct {
+	key    string // device_id/point
+	server collector.ServerConfig
+	device collector.Device
+	point  collector.Point
+}
+
+// Session is the state of one shell: the connection, the addressed unit
+// and the loaded point names. It is not safe for concurrent use.
+type Session struct {
+	Out     io.Writer
+	Timeout time.Duration // answer timeout of new connections
+	Tap     capture.Tap   // receives every frame when set
+	History *History      // lines listed by the history command, optional
+
+	link   *collector.Link
+	server collector.ServerConfig
+	unit   uint8
+	points map[string][]pointRef // by point name and by device_id/point
+	names  []string              // point names and keys, sorted, for completion
+}
+
+// NewSession returns a disconnected session addressing unit 1.
+func NewSession(out io.Writer) *Session {
+	return &Session{Out: out, Timeout: time.Second, unit: 1, points: map[string][]pointRef{}}
+}
+
+// Prompt names the target and unit of the session.
+func (s *Session) Prompt() string {
+	if s.link == nil {
+		return "modbus> "
+	}
+	return fmt.Sprintf("modbus %s u%d> ", describe(s.server), s.unit)
+}
+
+// Unit returns the addressed unit id.
+func (s *Session) Unit() uint8 { return s.unit }
+
+// SetUnit changes the addressed unit id.
+func (s *Session) SetUnit(id uint8) { s.unit = id }
+
+// Connect opens srv, closing the previous connection.
+func (s *Session) Connect(srv collector.ServerConfig) error {
+	srv.Timeout = s.Timeout
+	if h := srv.Connection.Host; srv.Connection.SerialPort == "" && (h == "" || h == "0.0.0.0" || h == "::") {
+		srv.Connection.Host = "127.0.0.1" // a listen address of the simulator
+	}
+	link, err := collector.OpenLink(srv, s.Tap)
+	if err != nil {
+		return err
+	}
+	s.Close()
+	s.link, s.server = link, srv
+	return nil
+}
+
+// Close closes the connection, if any.
+func (s *Session) Close() {
+	if s.link != nil {
+		s.link.Close()
+		s.link = nil
+	}
+}
+
+// Load reads point names from a collector YAML config, or from a TOML
+// simulator config (.toml), and returns its servers.
+func (s *Session) Load(path string) ([]collector.ServerConfig, error) {
+	var root collector.RootConfig
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		var cfg cfgpkg.Config
+		if cfg, err = cfgpkg.Load(path); err != nil {
+			return nil, err
+		}
+		// the [client] section, if any, says how to reach the simulator
+		if cfg.Client.Mode != "" || cfg.Client.SerialPort != "" || cfg.Client.ListenAddress != "" {
+			cfg.Server = cfg.Client
+		}
+		root, err = cfg.RootConfig(false)
+	} else {
+		root, err = collector.LoadYAML(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, srv := range root.Servers {
+		for _, dev := range srv.Devices {
+			for _, p := range dev.Points {
+				ref := pointRef{key: simulator.PointKey(dev.DeviceID, p.Name), server: srv, device: dev, point: p}
+				s.points[p.Name] = append(s.points[p.Name], ref)
+				if ref.key != p.Name {
+					s.points[ref.key] = []pointRef{ref}
+				}
+			}
+		}
+	}
+	s.names = s.names[:0]
+	for name := range s.points {
+		s.names = append(s.names, name)
+	}
+	sort.Strings(s.names)
+	return root.Servers, nil
+}
+
+// lookup resolves a point name or device_id/point.
+func (s *Session) lookup(name string) (pointRef, bool, error) {
+	refs := s.points[name]
+	switch len(refs) {
+	case 0:
+		return pointRef{}, false, nil
+	case 1:
+		return refs[0], true, nil
+	}
+	keys := make([]string, len(refs))
+	for i, r := range refs {
+		keys[i] = r.key
+	}
+	return pointRef{}, true, fmt.Errorf("point %s is ambiguous: %s", name, strings.Join(keys, ", "))
+}
+
+// Exec runs one command line. Watch commands run until ctx is done or
+// their duration has passed.
+func (s *Session) Exec(ctx context.Context, line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return nil
+	}
+	cmd, args := strings.ToLower(args[0]), args[1:]
+	switch cmd {
+	case "help", "?":
+		s.help()
+		return nil
+	case "quit", "exit":
+		return ErrQuit
+	case "connect", "open":
+		if len(args) == 0 {
+			return s.status()
+		}
+		srv, err := ParseTarget(args)
+		if err != nil {
+			return err
+		}
+		if err := s.Connect(srv); err != nil {
+			return err
+		}
+		s.printf("connected to %s\n", describe(srv))
+		return nil
+	case "close", "disconnect":
+		s.Close()
+		return nil
+	case "status":
+		return s.status()
+	case "unit", "slave":
+		if len(args) == 0 {
+			s.printf("unit %d\n", s.unit)
+			return nil
+		}
+		id, err := strconv.ParseUint(args[0], 0, 8)
+		if err != nil {
+			return fmt.Errorf("invalid unit id %q", args[0])
+		}
+		s.unit = uint8(id)
+		return nil
+	case "timeout":
+		if len(args) == 0 {
+			s.printf("timeout %s\n", s.Timeout)
+			return nil
+		}
+		d, err := time.ParseDuration(args[0])
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q", args[0])
+		}
+		s.Timeout = d
+		if s.link != nil {
+			return s.Connect(s.server)
+		}
+		return nil
+	case "load":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: load <config.yaml|config.toml>")
+		}
+		if _, err := s.Load(args[0]); err != nil {
+			return err
+		}
+		s.printf("%d points loaded\n", s.pointCount())
+		return nil
+	case "points":
+		s.listPoints(args)
+		return nil
+	case "history":
+		if s.History != nil {
+			for i := s.History.Len() - 1; i >= 0; i-- {
+				s.printf("%4d  %s\n", s.History.Len()-i, s.History.At(i))
+			}
+		}
+		return nil
+	case "read", "r":
+		r, err := s.parseRead(args)
+		if err != nil {
+			return err
+		}
+		lines, err := s.read(r, false)
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			s.printf("%s\n", l)
+		}
+		return nil
+	case "watch", "w":
+		return s.watch(ctx, args)
+	case "write":
+		return s.write(args)
+	}
+	return fmt.Errorf("unknown command %q, try help", cmd)
+}
+
+// IsWatch reports whether line is a watch command, which runs until it
+// is stopped.
+func IsWatch(line string) bool {
+	args := strings.Fields(line)
+	return len(args) > 0 && (strings.EqualFold(args[0], "watch") || strings.EqualFold(args[0], "w"))
+}
+
+func (s *Session) printf(format string, args ...any) {
+	fmt.Fprintf(s.Out, format, args...)
+}
+
+const helpText = `commands:
+  connect <target>           tcp://host:port | rtu-over-tcp://host:port | rtu:///dev/ttyUSB0?baud=9600&parity=E
+                             | tls://host:802?cert=client.pem&key=client.key&ca=ca.pem
+  close                      close the connection
+  unit [id]                  show or change the unit (slave) id
+  timeout [duration]         show or change the answer timeout
+  read <table> <addr>[..<end>] [count] [as <type> [order]]
+                             tables: hr, ir, coil, di; types: uint16 int16 uint32 int32 float32;
+                             orders: ABCD CDAB BADC DCBA; count is the number of values
+  read <point>...            read points by name or device_id/point (see load)
+  write <table> <addr> <value>... [as <type> [order]]
+                             write holding registers or coils (on/off)
+  write <point> <value>      write a point in engineering units
+  watch <read args> [every <duration>] [for <duration>]
+                             repeat a read, by default every 1s; Enter stops it
+  load <config>              load point names from a collector YAML or simulator TOML
+  points [filter]            list the loaded points
+  status                     show the connection
+  history                    list previous commands
+  quit                       leave
+`
+
+func (s *Session) help() { s.printf("%s", helpText) }
+
+func (s *Session) status() error {
+	if s.link == nil {
+		s.printf("not connected, unit %d, timeout %s, %d points\n", s.unit, s.Timeout, s.pointCount())
+		return nil
+	}
+	s.printf("connected to %s, unit %d, timeout %s, %d points\n", describe(s.server), s.unit, s.Timeout, s.pointCount())
+	return nil
+}
+
+// pointCount returns the number of loaded points, each of which is in
+// points under its name and under its device_id/point key.
+func (s *Session) pointCount() int {
+	n := 0
+	for name, refs := range s.points {
+		if refs[0].key == name {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Session) listPoints(filter []string) {
+	seen := map[string]bool{}
+	for _, name := range s.names {
+		for _, r := range s.points[name] {
+			if seen[r.key] || (len(filter) > 0 && !strings.Contains(r.key, filter[0])) {
+				continue
+			}
+			seen[r.key] = true
+			p := r.point
+			s.printf("%-32s unit %-3d %-4s %-5d %-7s %s\n", r.key, s.slaveOf(r), tableShort[strings.ToLower(p.RegisterType)], p.Address, p.DataType, p.Unit)
+		}
+	}
+}
+
+// readSpec is a parsed read: a run of addresses of one table decoded as
+// one type, or named points.
+type readSpec struct {
+	table  string
+	start  uint16
+	count  int             // values
+	format collector.Point // DataType and ByteOrder of the values
+	points []pointRef
+}
+
+func (s *Session) parseRead(args []string) (readSpec, error) {
+	var r readSpec
+	if len(args) == 0 {
+		return r, fmt.Errorf("usage: read <table> <addr>[..<end>] [count] [as <type> [order]] | read <point>...")
+	}
+	table, isTable := tableAliases[strings.ToLower(args[0])]
+	if !isTable {
+		for _, name := range args {
+			ref, ok, err := s.lookup(name)
+			if err != nil {
+				return r, err
+			}
+			if !ok {
+				return r, fmt.Errorf("unknown table or point %q", name)
+			}
+			r.points = append(r.points, ref)
+		}
+		return r, nil
+	}
+	args, enc, hasAs := splitAs(args[1:])
+	r.table = table
+	r.format = collector.Point{DataType: "uint16"}
+	if hasAs {
+		if isBitTable(table) {
+			return r, fmt.Errorf("%s values are bits", table)
+		}
+		dt, order, err := parseEncoding(enc)
+		if err != nil {
+			return r, err
+		}
+		r.format = collector.Point{DataType: dt, ByteOrder: order}
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return r, fmt.Errorf("usage: read %s <addr>[..<end>] [count]", tableShort[table])
+	}
+	start, end, err := parseAddressSpec(args[0])
+	if err != nil {
+		return r, err
+	}
+	r.start, r.count = start, 1
+	width := s.width(r)
+	if end >= 0 {
+		if len(args) > 1 {
+			return r, fmt.Errorf("give a range or a count, not both")
+		}
+		n := end - int(start) + 1
+		if n%width != 0 {
+			return r, fmt.Errorf("%d registers do not hold whole %s values", n, r.format.DataType)
+		}
+		r.count = n / width
+	} else if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return r, fmt.Errorf("invalid count %q", args[1])
+		}
+		r.count = n
+	}
+	if int(start)+r.count*width > 65536 {
+		return r, fmt.Errorf("read passes address 65535")
+	}
+	return r, nil
+}
+
+// width is the number of addresses one value of r occupies.
+func (s *Session) width(r readSpec) int {
+	if isBitTable(r.table) {
+		return 1
+	}
+	return simulator.WordCount(r.format)
+}
+
+// read performs r and renders the values, one line per value or, when
+// compact, all on one line.
+func (s *Session) read(r readSpec, compact bool) ([]string, error) {
+	if len(r.points) > 0 {
+		return s.readPoints(r.points, compact)
+	}
+	if s.link == nil {
+		return nil, errNotConnected
+	}
+	client := s.link.Client(s.unit)
+	width := s.width(r)
+	total := r.count * width
+	limit := 125
+	if isBitTable(r.table) {
+		limit = 2000
+	}
+	var words []uint16
+	for at := 0; at < total; at += limit {
+		n := min(limit, total-at)
+		chunk, err := readTable(client, r.table, r.start+uint16(at), n)
+		if err != nil {
+			return nil, err
+		}
+		words = append(words, chunk...)
+	}
+
+	short := tableShort[r.table]
+	var lines []string
+	var parts []string
+	for i := 0; i < r.count; i++ {
+		addr := int(r.start) + i*width
+		var text string
+		switch {
+		case isBitTable(r.table):
+			text = onOff(words[i] != 0)
+		case width == 1 && r.format.DataType == "uint16":
+			text = fmt.Sprintf("%d (0x%04X)", words[i], words[i])
+			if compact {
+				text = strconv.Itoa(int(words[i]))
+			}
+		default:
+			v, err := simulator.DecodeWords(r.format, words[i*width:(i+1)*width])
+			if err != nil {
+				return nil, err
+			}
+			text = formatValue(v, r.format.DataType)
+		}
+		if compact {
+			parts = append(parts, fmt.Sprintf("%d=%s", addr, text))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s %d = %s", short, addr, text))
+		}
+	}
+	if compact {
+		lines = []string{short + " " + strings.Join(parts, " ")}
+	}
+	return lines, nil
+}
+
+var errNotConnected = errors.New("not connected, use connect <target>")
+
+// readPoints reads named points with their device's unit id. When the
+// session is not connected it connects to the server of the first point.
+func (s *Session) readPoints(refs []pointRef, compact bool) ([]string, error) {
+	if s.link == nil {
+		if err := s.Connect(refs[0].server); err != nil {
+			return nil, err
+		}
+		s.printf("connected to %s\n", describe(s.server))
+	}
+	var lines []string
+	for _, ref := range refs {
+		p := ref.point
+		table := strings.ToLower(p.RegisterType)
+		client := s.link.Client(s.slaveOf(ref))
+		width := 1
+		if !isBitTable(table) {
+			width = simulator.WordCount(p)
+		}
+		words, err := readTable(client, table, p.Address, width)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", ref.key, err)
+		}
+		var text string
+		if isBitTable(table) {
+			text = onOff(words[0] != 0)
+		} else {
+			v, err := simulator.DecodeWords(p, words)
+			if err != nil {
+				return nil, err
+			}
+			text = formatValue(v, p.DataType)
+			if p.Unit != "" {
+				text += " " + p.Unit
+			}
+		}
+		if compact {
+			lines = append(lines, fmt.Sprintf("%s=%s", p.Name, text))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s (%s, unit %d %s %d) = %s", p.Name, ref.device.DeviceID, s.slaveOf(ref), tableShort[table], p.Address, text))
+		}
+	}
+	if compact {
+		lines = []string{strings.Join(lines, " ")}
+	}
+	return lines, nil
+}
+
+// slaveOf returns the unit id of a point's device, or the session unit
+// when the config leaves it unset.
+func (s *Session) slaveOf(ref pointRef) uint8 {
+	if ref.device.SlaveID == 0 {
+		return s.unit
+	}
+	return ref.device.SlaveID
+}
+
+// readTable reads n addresses of table, registers as words and bits as 0
+// or 1.
+func readTable(client interface {
+	ReadCoils(address, quantity uint16) ([]byte, error)
+	ReadDiscreteInputs(address, quantity uint16) ([]byte, error)
+	ReadHoldingRegisters(address, quantity uint16) ([]byte, error)
+	ReadInputRegisters(address, quantity uint16) ([]byte, error)
+}, table string, address uint16, n int) ([]uint16, error) {
+	var data []byte
+	var err error
+	switch table {
+	case "holding":
+		data, err = client.ReadHoldingRegisters(address, uint16(n))
+	case "input":
+		data, err = client.ReadInputRegisters(address, uint16(n))
+	case "coil":
+		data, err = client.ReadCoils(address, uint16(n))
+	case "discrete":
+		data, err = client.ReadDiscreteInputs(address, uint16(n))
+	default:
+		return nil, fmt.Errorf("unsupported register type %q", table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	words := make([]uint16, n)
+	for i := range words {
+		if isBitTable(table) {
+			if len(data) <= i/8 {
+				return nil, fmt.Errorf("short answer: %d bytes for %d bits", len(data), n)
+			}
+			words[i] = uint16(data[i/8]>>(i%8)) & 1
+			continue
+		}
+		if len(data) < 2*i+2 {
+			return nil, fmt.Errorf("short answer: %d bytes for %d registers", len(data), n)
+		}
+		words[i] = binary.BigEndian.Uint16(data[2*i:])
+	}
+	return words, nil
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+// formatValue prints v as short as its data type allows.
+func formatValue(v float64, dataType string) string {
+	if strings.EqualFold(dataType, "float32") {
+		return strconv.FormatFloat(v, 'g', -1, 32)
+	}
+	if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(v, 'g', 10, 64)
+}
+
+// watch repeats a read until ctx is done or the "for" duration passed.
+func (s *Session) watch(ctx context.Context, args []string) error {
+	every, total := time.Second, time.Duration(0)
+	var readArgs []string
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "every", "for":
+			if i+1 >= len(args) {
+				return fmt.Errorf("%s needs a duration", args[i])
+			}
+			d, err := time.ParseDuration(args[i+1])
+			if err != nil || d <= 0 {
+				return fmt.Errorf("invalid duration %q", args[i+1])
+			}
+			if strings.EqualFold(args[i], "every") {
+				every = d
+			} else {
+				total = d
+			}
+			i++
+		default:
+			readArgs = append(readArgs, args[i])
+		}
+	}
+	r, err := s.parseRead(readArgs)
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, total)
+		defer cancel()
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		lines, err := s.read(r, true)
+		if err != nil {
+			if errors.Is(err, errNotConnected) {
+				return err
+			}
+			lines = []string{"error: " + err.Error()}
+		}
+		for _, l := range lines {
+			s.printf("%s %s\n", now.Format("15:04:05.000"), l)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// write performs a write command.
+func (s *Session) write(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: write <table> <addr> <value>... [as <type> [order]] | write <point> <value>")
+	}
+	table, isTable := tableAliases[strings.ToLower(args[0])]
+	if !isTable {
+		return s.writePoint(args)
+	}
+	args, enc, hasAs := splitAs(args[1:])
+	if table == "input" || table == "discrete" {
+		return fmt.Errorf("%s is read-only", table)
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("usage: write %s <addr> <value>...", tableShort[table])
+	}
+	start, end, err := parseAddressSpec(args[0])
+	if err != nil {
+		return err
+	}
+	if end >= 0 {
+		return fmt.Errorf("write takes a start address, not a range")
+	}
+	if s.link == nil {
+		return errNotConnected
+	}
+	client := s.link.Client(s.unit)
+	values := args[1:]
+
+	if table == "coil" {
+		if hasAs {
+			return fmt.Errorf("coil values are bits")
+		}
+		bits := make([]bool, len(values))
+		for i, v := range values {
+			if bits[i], err = parseBit(v); err != nil {
+				return err
+			}
+		}
+		if len(bits) == 1 {
+			value := uint16(0)
+			if bits[0] {
+				value = 0xFF00
+			}
+			_, err = client.WriteSingleCoil(start, value)
+		} else {
+			packed := make([]byte, (len(bits)+7)/8)
+			for i, b := range bits {
+				if b {
+					packed[i/8] |= 1 << (i % 8)
+				}
+			}
+			_, err = client.WriteMultipleCoils(start, uint16(len(bits)), packed)
+		}
+		if err != nil {
+			return err
+		}
+		s.printf("wrote %d coil(s) at %d\n", len(bits), start)
+		return nil
+	}
+
+	format := collector.Point{DataType: "uint16"}
+	if hasAs {
+		dt, order, err := parseEncoding(enc)
+		if err != nil {
+			return err
+		}
+		format = collector.Point{DataType: dt, ByteOrder: order}
+	}
+	var words []uint16
+	for i, v := range values {
+		f, err := parseNumber(v)
+		if err != nil {
+			return err
+		}
+		format.Address = start + uint16(i*simulator.WordCount(format))
+		w, err := simulator.EncodeWords(format, f)
+		if err != nil {
+			return err
+		}
+		words = append(words, w...)
+	}
+	if err := writeWords(client, start, words); err != nil {
+		return err
+	}
+	s.printf("wrote %d register(s) at %d\n", len(words), start)
+	return nil
+}
+
+func (s *Session) writePoint(args []string) error {
+	ref, ok, err := s.lookup(args[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unknown table or point %q", args[0])
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: write <point> <value>")
+	}
+	if s.link == nil {
+		if err := s.Connect(ref.server); err != nil {
+			return err
+		}
+		s.printf("connected to %s\n", describe(s.server))
+	}
+	p := ref.point
+	client := s.link.Client(s.slaveOf(ref))
+	switch strings.ToLower(p.RegisterType) {
+	case "coil":
+		b, err := parseBit(args[1])
+		if err != nil {
+			return err
+		}
+		value := uint16(0)
+		if b {
+			value = 0xFF00
+		}
+		if _, err := client.WriteSingleCoil(p.Address, value); err != nil {
+			return err
+		}
+	case "holding":
+		v, err := parseNumber(args[1])
+		if err != nil {
+			return err
+		}
+		words, err := simulator.EncodeWords(p, v)
+		if err != nil {
+			return err
+		}
+		if err := writeWords(client, p.Address, words); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("point %s is read-only (%s)", ref.key, p.RegisterType)
+	}
+	s.printf("wrote %s\n", ref.key)
+	return nil
+}
+
+func writeWords(client interface {
+	WriteSingleRegister(address, value uint16) ([]byte, error)
+	WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error)
+}, start uint16, words []uint16) error {
+	if len(words) == 1 {
+		_, err := client.WriteSingleRegister(start, words[0])
+		return err
+	}
+	data := make([]byte, 0, 2*len(words))
+	for _, w := range words {
+		data = binary.BigEndian.AppendUint16(data, w)
+	}
+	_, err := client.WriteMultipleRegisters(start, uint16(len(words)), data)
+	return err
+}
@@ -0,0 +1,199 @@
+package scan
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	collector "modbus-simulator/internal/collector"
+)
+
+// Target is a scanned server and the units found on it.
+type Target struct {
+	Server collector.ServerConfig
+	Units  []Unit
+}
+
+// draftPollInterval is the poll interval of drafted devices.
+const draftPollInterval = 5 * time.Second
+
+// Devices drafts one collector device per unit of t that has values: a
+// uint16 point per register and a point per bit, named after table and
+// address. Device ids are "unit<slave>", after prefix.
+func (t Target) Devices(prefix string) []collector.Device {
+	var devices []collector.Device
+	for _, u := range t.Units {
+		dev := collector.Device{
+			DeviceID:     fmt.Sprintf("%sunit%d", prefix, u.Slave),
+			Vendor:       u.Vendor(),
+			SlaveID:      u.Slave,
+			PollInterval: draftPollInterval,
+		}
+		for _, table := range Tables {
+			values := u.Values[table]
+			addresses := make([]int, 0, len(values))
+			for a := range values {
+				addresses = append(addresses, int(a))
+			}
+			sort.Ints(addresses)
+			for _, a := range addresses {
+				p := collector.Point{Name: fmt.Sprintf("%s_%d", table, a), Address: uint16(a), RegisterType: table}
+				if table == "holding" || table == "input" {
+					p.DataType = "uint16"
+				}
+				dev.Points = append(dev.Points, p)
+			}
+		}
+		if len(dev.Points) > 0 {
+			devices = append(devices, dev)
+		}
+	}
+	return devices
+}
+
+// prefix keeps device ids unique when the devices of several targets
+// share one devices file.
+func prefix(targets []Target, t Target) string {
+	if len(targets) < 2 {
+		return ""
+	}
+	return t.Server.ServerID + "_"
+}
+
+// draft mirrors the collector YAML, leaving out what the scan cannot know.
+type (
+	draftConfig struct {
+		Servers []draftServer `yaml:"servers"`
+	}
+	draftServer struct {
+		ServerID   string          `yaml:"server_id"`
+		Protocol   string          `yaml:"protocol"`
+		Connection draftConnection `yaml:"connection"`
+		Timeout    string          `yaml:"timeout,omitempty"`
+		Enabled    bool            `yaml:"enabled"`
+		Devices    []draftDevice   `yaml:"devices"`
+	}
+	draftConnection struct {
+		Host       string `yaml:"host,omitempty"`
+		Port       int    `yaml:"port,omitempty"`
+		SerialPort string `yaml:"serial_port,omitempty"`
+		BaudRate   int    `yaml:"baud_rate,omitempty"`
+		DataBits   int    `yaml:"data_bits,omitempty"`
+		StopBits   int    `yaml:"stop_bits,omitempty"`
+		Parity     string `yaml:"parity,omitempty"`
+	}
+	draftDevice struct {
+		DeviceID     string       `yaml:"device_id"`
+		Vendor       string       `yaml:"vendor,omitempty"`
+		SlaveID      uint8        `yaml:"slave_id"`
+		PollInterval string       `yaml:"poll_interval"`
+		Points       []draftPoint `yaml:"points"`
+	}
+	draftPoint struct {
+		Name         string `yaml:"name"`
+		Address      uint16 `yaml:"address"`
+		RegisterType string `yaml:"register_type"`
+		DataType     string `yaml:"data_type,omitempty"`
+	}
+)
+
+// WriteYAML writes a collector config with a server per target and its
+// drafted devices, preceded by comments listing every unit found, its
+// readable ranges and identification.
+func WriteYAML(w io.Writer, targets []Target) error {
+	var cfg draftConfig
+	for _, t := range targets {
+		if _, err := io.WriteString(w, summary(t)); err != nil {
+			return err
+		}
+		c := t.Server.Connection
+		srv := draftServer{
+			ServerID: t.Server.ServerID,
+			Protocol: t.Server.Protocol,
+			Connection: draftConnection{Host: c.Host, Port: c.Port, SerialPort: c.SerialPort,
+				BaudRate: c.BaudRate, DataBits: c.DataBits, StopBits: c.StopBits, Parity: c.Parity},
+			Enabled: true,
+		}
+		if t.Server.Timeout > 0 {
+			srv.Timeout = t.Server.Timeout.String()
+		}
+		for _, dev := range t.Devices("") {
+			d := draftDevice{DeviceID: dev.DeviceID, Vendor: dev.Vendor, SlaveID: dev.SlaveID, PollInterval: dev.PollInterval.String()}
+			for _, p := range dev.Points {
+				d.Points = append(d.Points, draftPoint{Name: p.Name, Address: p.Address, RegisterType: p.RegisterType, DataType: p.DataType})
+			}
+			srv.Devices = append(srv.Devices, d)
+		}
+		if len(srv.Devices) > 0 {
+			cfg.Servers = append(cfg.Servers, srv)
+		}
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(cfg); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// summary renders the units of t as YAML comments.
+func summary(t Target) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s (%s %s): %d unit(s)\n", t.Server.ServerID, t.Server.Protocol, address(t.Server), len(t.Units))
+	for _, u := range t.Units {
+		fmt.Fprintf(&b, "#   unit %d:", u.Slave)
+		if len(u.Ranges) == 0 {
+			b.WriteString(" nothing readable")
+		}
+		for i, r := range u.Ranges {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, " %s %d-%d", r.Table, r.Start, int(r.Start)+r.Count-1)
+		}
+		b.WriteString("\n")
+		for _, o := range u.Identity {
+			fmt.Fprintf(&b, "#     %s: %s\n", o.Name, o.Value)
+		}
+	}
+	return b.String()
+}
+
+// address is the host:port or serial port of srv.
+func address(srv collector.ServerConfig) string {
+	if srv.Connection.SerialPort != "" {
+		return srv.Connection.SerialPort
+	}
+	return fmt.Sprintf("%s:%d", srv.Connection.Host, srv.Connection.Port)
+}
+
+// csvHeader lists the devices_file columns written by WriteCSV.
+var csvHeader = []string{"device_id", "vendor", "slave_id", "poll_interval", "point_name", "address", "register_type", "data_type", "scale", "offset", "unit"}
+
+// WriteCSV writes the drafted devices of all targets as a devices_file
+// (type: csvfile). Device ids carry the server id when there are several
+// targets.
+func WriteCSV(w io.Writer, targets []Target) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, t := range targets {
+		for _, dev := range t.Devices(prefix(targets, t)) {
+			for _, p := range dev.Points {
+				err := cw.Write([]string{dev.DeviceID, dev.Vendor, strconv.Itoa(int(dev.SlaveID)), dev.PollInterval.String(),
+					p.Name, strconv.Itoa(int(p.Address)), p.RegisterType, p.DataType, "1", "0", ""})
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,347 @@
+// Package scan discovers Modbus devices: it finds the units answering on a
+// server, the readable address ranges of their register tables and their
+// device identification, and drafts collector device definitions from
+// what it found.
+package scan
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	mb "github.com/goburrow/modbus"
+	collector "modbus-simulator/internal/collector"
+)
+
+// Register tables, in scanning order.
+var Tables = []string{"holding", "input", "coil", "discrete"}
+
+// Options selects what Scan probes. The zero value probes units 1-247 and
+// every address of every table.
+type Options struct {
+	Units  []uint8  // slave ids to probe, default 1-247
+	Tables []string // tables to scan, default Tables
+	Start  uint16   // first address probed
+	Count  int      // addresses probed from Start, 0 means up to 65535
+	Zeros  bool     // keep addresses reading 0 as draft points
+	// Stride spaces the single-address probes in windows that cannot be
+	// read whole. The default 1 probes every address; larger values need
+	// fewer requests but miss readable runs that fit between two probes.
+	Stride int
+}
+
+// Range is a run of readable addresses of one table.
+type Range struct {
+	Table string
+	Start uint16
+	Count int
+}
+
+// Object is one device identification object (function 0x2B/0x0E).
+type Object struct {
+	ID    byte
+	Name  string
+	Value string
+}
+
+// Unit is a unit that answered the scan.
+type Unit struct {
+	Slave    uint8
+	Identity []Object // nil when the unit does not support identification
+	Ranges   []Range
+	// Values holds the value read per table and address: registers as
+	// words, bits as 0 or 1. Zeros are kept only with Options.Zeros.
+	Values map[string]map[uint16]uint16
+}
+
+// Vendor returns the VendorName identification object, if any.
+func (u Unit) Vendor() string {
+	for _, o := range u.Identity {
+		if o.ID == 0 {
+			return o.Value
+		}
+	}
+	return ""
+}
+
+// errUnsupported marks a table the unit answers with illegal function.
+var errUnsupported = errors.New("table not supported")
+
+// Scan probes the units of the server behind link and returns those that
+// answered, in the order of opts.Units.
+func Scan(ctx context.Context, link *collector.Link, opts Options) ([]Unit, error) {
+	units := opts.Units
+	if len(units) == 0 {
+		for id := 1; id <= 247; id++ {
+			units = append(units, uint8(id))
+		}
+	}
+	tables := opts.Tables
+	if len(tables) == 0 {
+		tables = Tables
+	}
+	stride := max(opts.Stride, 1)
+	end := 65535
+	if opts.Count > 0 {
+		end = min(int(opts.Start)+opts.Count-1, end)
+	}
+
+	var found []Unit
+	for _, slave := range units {
+		if err := ctx.Err(); err != nil {
+			return found, err
+		}
+		if !present(link, slave, opts.Start) {
+			continue
+		}
+		u := Unit{Slave: slave, Values: map[string]map[uint16]uint16{}}
+		for _, table := range tables {
+			s := tableScan{ctx: ctx, client: link.Client(slave), table: table, zeros: opts.Zeros, stride: stride, values: map[uint16]uint16{}}
+			if err := s.run(int(opts.Start), end); err != nil && !errors.Is(err, errUnsupported) {
+				if ctx.Err() != nil {
+					return found, ctx.Err()
+				}
+				log.Printf("scan %s unit %d %s: %v (table skipped after %d ranges)", link.Address, slave, table, err, len(s.ranges))
+			}
+			u.Ranges = append(u.Ranges, s.ranges...)
+			if len(s.values) > 0 {
+				u.Values[table] = s.values
+			}
+		}
+		u.Identity, _ = Identify(link, slave)
+		found = append(found, u)
+	}
+	return found, nil
+}
+
+// present reports whether slave answers a read of one holding register,
+// with data or a Modbus exception other than the gateway ones.
+func present(link *collector.Link, slave uint8, address uint16) bool {
+	_, err := link.Client(slave).ReadHoldingRegisters(address, 1)
+	var me *mb.ModbusError
+	if errors.As(err, &me) {
+		return me.ExceptionCode != mb.ExceptionCodeGatewayPathUnavailable &&
+			me.ExceptionCode != mb.ExceptionCodeGatewayTargetDeviceFailedToRespond
+	}
+	return err == nil
+}
+
+// tableScan finds the readable ranges of one table of a unit.
+type tableScan struct {
+	ctx    context.Context
+	client mb.Client
+	table  string
+	zeros  bool
+	stride int
+	ranges []Range
+	values map[uint16]uint16
+}
+
+// run reads start..end in requests of the largest quantity the table
+// allows. Windows answered with an exception are searched with probe.
+func (s *tableScan) run(start, end int) error {
+	window := 125
+	if s.table == "coil" || s.table == "discrete" {
+		window = 2000
+	}
+	for a := start; a <= end; a += window {
+		n := min(window, end-a+1)
+		values, err := s.tryRead(a, n)
+		if err != nil {
+			return err
+		}
+		if values != nil {
+			s.record(uint16(a), values)
+		} else if err := s.probe(a, a+n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// probe finds the readable runs of the unreadable window [start, end). It
+// reads single addresses at the multiples of the stride (and the last
+// address) and, from each readable one, binary-searches the first and the
+// last address of its run. An unreadable window thus costs about
+// window/stride requests; with stride 1 every run is found.
+func (s *tableScan) probe(start, end int) error {
+	floor := start // lowest address not known to be unreadable
+	p := (start + s.stride - 1) / s.stride * s.stride
+	for p < end {
+		values, err := s.tryRead(p, 1)
+		if err != nil {
+			return err
+		}
+		first := p
+		if values != nil {
+			// lowest first >= floor with first..p readable
+			for lo := floor; lo < first; {
+				mid := (lo + first) / 2
+				v, err := s.tryRead(mid, p-mid+1)
+				if err != nil {
+					return err
+				}
+				if v != nil {
+					first, values = mid, v
+				} else {
+					lo = mid + 1
+				}
+			}
+			// longest run from first within the window
+			for lo, hi := len(values), end-first; lo < hi; {
+				mid := (lo + hi + 1) / 2
+				v, err := s.tryRead(first, mid)
+				if err != nil {
+					return err
+				}
+				if v != nil {
+					lo, values = mid, v
+				} else {
+					hi = mid - 1
+				}
+			}
+			s.record(uint16(first), values)
+			p = first + len(values) // unreadable, or the window end
+		}
+		floor = p + 1
+		next := (p/s.stride + 1) * s.stride
+		if next >= end && p < end-1 {
+			next = end - 1
+		}
+		p = next
+	}
+	return nil
+}
+
+// tryRead reads n addresses from address. An exception answer yields no
+// values and no error, except illegal function, which means the table is
+// not supported.
+func (s *tableScan) tryRead(address, n int) ([]uint16, error) {
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	values, err := s.read(uint16(address), uint16(n))
+	var me *mb.ModbusError
+	if errors.As(err, &me) {
+		if me.ExceptionCode == mb.ExceptionCodeIllegalFunction {
+			return nil, errUnsupported
+		}
+		return nil, nil
+	}
+	return values, err
+}
+
+// read reads n addresses, registers as words and bits as 0 or 1.
+func (s *tableScan) read(address, n uint16) ([]uint16, error) {
+	var data []byte
+	var err error
+	switch s.table {
+	case "holding":
+		data, err = s.client.ReadHoldingRegisters(address, n)
+	case "input":
+		data, err = s.client.ReadInputRegisters(address, n)
+	case "coil":
+		data, err = s.client.ReadCoils(address, n)
+	case "discrete":
+		data, err = s.client.ReadDiscreteInputs(address, n)
+	default:
+		return nil, fmt.Errorf("unknown table %q", s.table)
+	}
+	if err != nil {
+		return nil, err
+	}
+	values := make([]uint16, n)
+	for i := range values {
+		if s.table == "holding" || s.table == "input" {
+			if len(data) < 2*i+2 {
+				return nil, fmt.Errorf("short answer: %d bytes for %d registers", len(data), n)
+			}
+			values[i] = binary.BigEndian.Uint16(data[2*i:])
+		} else {
+			if len(data) <= i/8 {
+				return nil, fmt.Errorf("short answer: %d bytes for %d bits", len(data), n)
+			}
+			values[i] = uint16(data[i/8]>>(i%8)) & 1
+		}
+	}
+	return values, nil
+}
+
+// record adds the readable addresses starting at address, merging with
+// the previous range when they touch.
+func (s *tableScan) record(address uint16, values []uint16) {
+	if k := len(s.ranges) - 1; k >= 0 && int(s.ranges[k].Start)+s.ranges[k].Count == int(address) {
+		s.ranges[k].Count += len(values)
+	} else {
+		s.ranges = append(s.ranges, Range{Table: s.table, Start: address, Count: len(values)})
+	}
+	for i, v := range values {
+		if v != 0 || s.zeros {
+			s.values[address+uint16(i)] = v
+		}
+	}
+}
+
+// objectNames names the basic and regular identification objects.
+var objectNames = map[byte]string{
+	0x00: "VendorName",
+	0x01: "ProductCode",
+	0x02: "MajorMinorRevision",
+	0x03: "VendorUrl",
+	0x04: "ProductName",
+	0x05: "ModelName",
+	0x06: "UserApplicationName",
+}
+
+// Identify reads the device identification of slave (function 0x2B, MEI
+// type 0x0E): the regular objects, or the basic ones when the unit only
+// has those.
+func Identify(link *collector.Link, slave uint8) ([]Object, error) {
+	objects, err := readIdentification(link, slave, 0x02)
+	if err != nil {
+		var me *mb.ModbusError
+		if errors.As(err, &me) && me.ExceptionCode != mb.ExceptionCodeIllegalFunction {
+			return readIdentification(link, slave, 0x01)
+		}
+	}
+	return objects, err
+}
+
+// readIdentification reads the objects of one access level, following the
+// "more follows" flag across answers.
+func readIdentification(link *collector.Link, slave uint8, code byte) ([]Object, error) {
+	var objects []Object
+	next := byte(0)
+	for range 16 {
+		data, err := link.Send(slave, mb.ProtocolDataUnit{FunctionCode: 0x2B, Data: []byte{0x0E, code, next}})
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < 6 || data[0] != 0x0E {
+			return nil, fmt.Errorf("malformed identification answer % X", data)
+		}
+		more, following, count := data[3], data[4], int(data[5])
+		rest := data[6:]
+		for i := 0; i < count; i++ {
+			if len(rest) < 2 || len(rest) < 2+int(rest[1]) {
+				return nil, fmt.Errorf("truncated identification object in % X", data)
+			}
+			id, value := rest[0], string(rest[2:2+int(rest[1])])
+			name, ok := objectNames[id]
+			if !ok {
+				name = fmt.Sprintf("Object0x%02X", id)
+			}
+			objects = append(objects, Object{ID: id, Name: name, Value: value})
+			rest = rest[2+int(rest[1]):]
+		}
+		if more != 0xFF || following <= next {
+			break
+		}
+		next = following
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].ID < objects[j].ID })
+	return objects, nil
+}
@@ -0,0 +1,115 @@
+package scan
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseUnits parses a list of slave ids and ranges, e.g. "1-10,17".
+func ParseUnits(spec string) ([]uint8, error) {
+	var units []uint8
+	seen := map[int]bool{}
+	for _, part := range strings.Split(spec, ",") {
+		lo, hi, err := parseSpan(strings.TrimSpace(part), 247)
+		if err != nil {
+			return nil, fmt.Errorf("units %q: %w", spec, err)
+		}
+		if lo < 1 {
+			return nil, fmt.Errorf("units %q: slave ids start at 1", spec)
+		}
+		for id := lo; id <= hi; id++ {
+			if !seen[id] {
+				seen[id] = true
+				units = append(units, uint8(id))
+			}
+		}
+	}
+	return units, nil
+}
+
+// ParseAddresses parses an address range "start-end", or a single address.
+func ParseAddresses(spec string) (start, end uint16, err error) {
+	lo, hi, err := parseSpan(strings.TrimSpace(spec), 65535)
+	if err != nil {
+		return 0, 0, fmt.Errorf("addresses %q: %w", spec, err)
+	}
+	return uint16(lo), uint16(hi), nil
+}
+
+// parseSpan parses "n" or "lo-hi" within 0..limit.
+func parseSpan(s string, limit int) (lo, hi int, err error) {
+	a, b, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(strings.TrimSpace(a)); err != nil {
+		return 0, 0, err
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(b)); err != nil {
+			return 0, 0, err
+		}
+	}
+	if lo < 0 || hi > limit || lo > hi {
+		return 0, 0, fmt.Errorf("%q is not within 0-%d", s, limit)
+	}
+	return lo, hi, nil
+}
+
+// Hosts expands a list of hosts: names, IPv4 addresses, ranges of the
+// last octet ("192.168.1.10-20") and CIDR blocks ("192.168.1.0/24",
+// without network and broadcast addresses).
+func Hosts(spec string) ([]string, error) {
+	var hosts []string
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == "":
+		case strings.Contains(part, "/"):
+			ip, block, err := net.ParseCIDR(part)
+			if err != nil || ip.To4() == nil {
+				return nil, fmt.Errorf("hosts %q: %q is not an IPv4 block", spec, part)
+			}
+			ones, bits := block.Mask.Size()
+			if bits-ones > 16 {
+				return nil, fmt.Errorf("hosts %q: %q is larger than a /16", spec, part)
+			}
+			base := ipv4(block.IP)
+			n := uint32(1) << (bits - ones)
+			for i := uint32(0); i < n; i++ {
+				if n > 2 && (i == 0 || i == n-1) {
+					continue
+				}
+				hosts = append(hosts, ipString(base+i))
+			}
+		case strings.Contains(part, "-"):
+			first, last, _ := strings.Cut(part, "-")
+			ip := net.ParseIP(first).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("hosts %q: %q is not an IPv4 range", spec, part)
+			}
+			end, err := strconv.Atoi(last)
+			if err != nil || end < int(ip[3]) || end > 255 {
+				return nil, fmt.Errorf("hosts %q: %q is not an IPv4 range", spec, part)
+			}
+			for o := int(ip[3]); o <= end; o++ {
+				hosts = append(hosts, net.IPv4(ip[0], ip[1], ip[2], byte(o)).String())
+			}
+		default:
+			hosts = append(hosts, part)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("hosts %q: no hosts", spec)
+	}
+	return hosts, nil
+}
+
+func ipv4(ip net.IP) uint32 {
+	ip = ip.To4()
+	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])
+}
+
+func ipString(v uint32) string {
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)).String()
+}
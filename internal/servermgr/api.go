@@ -0,0 +1,190 @@
+package servermgr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Handler returns the HTTP/JSON control API of the manager:
+//
+//	GET  /api/servers                                  list servers
+//	POST /api/servers/{id}/start|stop|restart          control a server
+//	GET  /api/servers/{id}/registers/{table}/{address}?count=N&slave=S
+//	PUT  /api/servers/{id}/registers/{table}/{address}?slave=S {"values": [1, 2]}
+//	GET  /api/servers/{id}/points                      decoded values of all points
+//	GET  /api/servers/{id}/points/{ref}                ref is device_id/point or a unique point name
+//	PUT  /api/servers/{id}/points/{ref}                {"value": 21.5}
+//	POST /api/servers/{id}/playback/pause|resume
+//	PUT  /api/servers/{id}/source                      {"type": "csv", "csv_file": "data/b.csv"}
+//
+// Errors are answered as {"error": "..."}. The API has no authentication;
+// source files are limited to the manager's DataDir.
+func (m *Manager) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/servers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, m.Servers())
+	})
+	mux.HandleFunc("POST /api/servers/{id}/{action}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		var err error
+		switch r.PathValue("action") {
+		case "start":
+			err = m.StartServer(id)
+		case "stop":
+			err = m.StopServer(id)
+		case "restart":
+			err = m.RestartServer(id)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		reply(w, err, nil)
+	})
+	mux.HandleFunc("GET /api/servers/{id}/registers/{table}/{address}", func(w http.ResponseWriter, r *http.Request) {
+		address, err := parseAddress(r.PathValue("address"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		slave, err := parseSlave(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		count := 1
+		if c := r.URL.Query().Get("count"); c != "" {
+			if count, err = strconv.Atoi(c); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid count %q", c))
+				return
+			}
+		}
+		values, err := m.ReadRegisters(r.PathValue("id"), slave, r.PathValue("table"), address, count)
+		reply(w, err, map[string]any{"address": address, "values": values})
+	})
+	mux.HandleFunc("PUT /api/servers/{id}/registers/{table}/{address}", func(w http.ResponseWriter, r *http.Request) {
+		address, err := parseAddress(r.PathValue("address"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		slave, err := parseSlave(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		var body struct {
+			Values []uint16 `json:"values"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		reply(w, m.WriteRegisters(r.PathValue("id"), slave, r.PathValue("table"), address, body.Values), nil)
+	})
+	mux.HandleFunc("GET /api/servers/{id}/points", func(w http.ResponseWriter, r *http.Request) {
+		values, err := m.ReadPoints(r.PathValue("id"))
+		reply(w, err, values)
+	})
+	mux.HandleFunc("GET /api/servers/{id}/points/{ref...}", func(w http.ResponseWriter, r *http.Request) {
+		v, err := m.ReadPoint(r.PathValue("id"), r.PathValue("ref"))
+		reply(w, err, v)
+	})
+	mux.HandleFunc("PUT /api/servers/{id}/points/{ref...}", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Value *float64 `json:"value"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+			writeError(w, http.StatusBadRequest, errors.New(`body must be {"value": <number>}`))
+			return
+		}
+		id, ref := r.PathValue("id"), r.PathValue("ref")
+		if err := m.WritePoint(id, ref, *body.Value); err != nil {
+			reply(w, err, nil)
+			return
+		}
+		v, err := m.ReadPoint(id, ref)
+		reply(w, err, v)
+	})
+	mux.HandleFunc("POST /api/servers/{id}/playback/{action}", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("action") {
+		case "pause":
+			reply(w, m.PausePlayback(r.PathValue("id")), nil)
+		case "resume":
+			reply(w, m.ResumePlayback(r.PathValue("id")), nil)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("PUT /api/servers/{id}/source", func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// JSON is valid YAML, which reuses the yaml tags of the config types
+		var src Source
+		if err := yaml.Unmarshal(b, &src); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		reply(w, m.SetSource(r.PathValue("id"), src), nil)
+	})
+	return mux
+}
+
+func parseAddress(s string) (uint16, error) {
+	n, err := strconv.ParseUint(s, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid address %q", s)
+	}
+	return uint16(n), nil
+}
+
+// parseSlave reads the optional slave query parameter selecting the
+// register map of a server with slave_maps; 0 is the server's own map.
+func parseSlave(r *http.Request) (uint8, error) {
+	v := r.URL.Query().Get("slave")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid slave %q", v)
+	}
+	return uint8(n), nil
+}
+
+// reply answers v, {"ok": true} when v is nil, or the error with a status
+// derived from it.
+func reply(w http.ResponseWriter, err error, v any) {
+	switch {
+	case err == nil && v == nil:
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	case err == nil:
+		writeJSON(w, http.StatusOK, v)
+	case errors.Is(err, errOutsideData):
+		writeError(w, http.StatusForbidden, err)
+	case errors.Is(err, errUnknownServer):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, errServerStopped), errors.Is(err, errAlreadyRunning), errors.Is(err, errNotRunning), errors.Is(err, errNoPlayback):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,470 @@
+package servermgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/simulator"
+)
+
+var (
+	errNotRunning     = errors.New("manager is not running")
+	errUnknownServer  = errors.New("unknown server")
+	errServerStopped  = errors.New("server is not running")
+	errAlreadyRunning = errors.New("server is already running")
+	errNoPlayback     = errors.New("server has no playback")
+	errOutsideData    = errors.New("file is outside the data directory")
+)
+
+// instance is a running server with its data sources.
+type instance struct {
+	server   *modbus.Server
+	images   images
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	sink     pointSink
+	scripted bool
+	interval time.Duration
+
+	mu         sync.Mutex
+	cfg        collector.ServerConfig
+	srcCancel  context.CancelFunc
+	player     *simulator.Player
+	noPlayback bool // source switched to "none"
+}
+
+func (in *instance) config() collector.ServerConfig {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.cfg
+}
+
+// setSource (re)starts the recorded data source and the generators of the
+// server from s. When explicit is set a source that fails to load is an
+// error and the running source is kept; otherwise the server runs with its
+// generators only and the error is returned for logging.
+func (in *instance) setSource(clk clock.Clock, s collector.ServerConfig, explicit bool) error {
+	// Points with a generator are driven by it; the remaining points replay
+	// a collector recording or play back the CSV file.
+	gens := buildGenerators(s)
+	var player *simulator.Player
+	var err error
+	if !in.noPlayback {
+		required := explicit || (len(gens) == 0 && in.sink.scenario == nil && !in.scripted)
+		player, err = loadPlayer(s, in.interval, required, clk.Now())
+		if err != nil && explicit {
+			return err
+		}
+	}
+
+	if in.srcCancel != nil {
+		in.srcCancel()
+	}
+	ctx, cancel := context.WithCancel(in.ctx)
+	in.srcCancel, in.player, in.cfg = cancel, player, s
+	if player == nil && len(gens) == 0 {
+		return err
+	}
+
+	// with timestamps the recording is sampled every tick
+	tick := in.interval
+	if s.Playback.TimestampColumn != "" || s.Replay != nil {
+		tick = s.Playback.Tick
+		if tick <= 0 {
+			tick = time.Second
+		}
+	}
+	sink := in.sink
+	apply := func(now time.Time) {
+		if ctx.Err() != nil {
+			return
+		}
+		if player != nil {
+			if s.Replay != nil {
+				applyReplayToServer(sink, s, player.Values(now))
+			} else {
+				applyRowToServer(sink, s, player.Values(now))
+			}
+		}
+		applyGenerators(sink, gens, now)
+	}
+	// apply first row immediately
+	apply(clk.Now())
+	clk.Every(ctx, tick, apply)
+	return err
+}
+
+// ServerStatus describes a configured server for the control API.
+type ServerStatus struct {
+	ServerID   string `json:"server_id"`
+	ServerName string `json:"server_name"`
+	Protocol   string `json:"protocol"`
+	Address    string `json:"address"`
+	Enabled    bool   `json:"enabled"`
+	Running    bool   `json:"running"`
+	Source     string `json:"source"` // csv, replay, none or generators
+	File       string `json:"file,omitempty"`
+	Paused     bool   `json:"paused"`
+}
+
+// Servers lists the configured servers and whether they are running.
+func (m *Manager) Servers() []ServerStatus {
+	out := make([]ServerStatus, 0, len(m.Cfg.Servers))
+	for _, s := range m.Cfg.Servers {
+		st := ServerStatus{
+			ServerID:   s.ServerID,
+			ServerName: s.ServerName,
+			Protocol:   s.Protocol,
+			Address:    serverAddress(s),
+			Enabled:    s.Enabled,
+		}
+		m.mu.Lock()
+		inst := m.servers[s.ServerID]
+		m.mu.Unlock()
+		if inst != nil {
+			inst.mu.Lock()
+			st.Running = true
+			switch {
+			case inst.player == nil && inst.noPlayback:
+				st.Source = "none"
+			case inst.player == nil:
+				st.Source = "generators"
+			case inst.cfg.Replay != nil:
+				st.Source, st.File = "replay", inst.cfg.Replay.File
+			default:
+				st.Source, st.File = "csv", inst.cfg.CSVFile
+			}
+			st.Paused = inst.player != nil && inst.player.Paused()
+			inst.mu.Unlock()
+		}
+		out = append(out, st)
+	}
+	return out
+}
+
+func (m *Manager) serverConfig(serverID string) (collector.ServerConfig, error) {
+	for _, s := range m.Cfg.Servers {
+		if s.ServerID == serverID {
+			return s, nil
+		}
+	}
+	return collector.ServerConfig{}, fmt.Errorf("%w %s", errUnknownServer, serverID)
+}
+
+// instance returns the running server serverID.
+func (m *Manager) instance(serverID string) (*instance, error) {
+	if _, err := m.serverConfig(serverID); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	inst := m.servers[serverID]
+	m.mu.Unlock()
+	if inst == nil {
+		return nil, fmt.Errorf("%w: %s", errServerStopped, serverID)
+	}
+	return inst, nil
+}
+
+func (m *Manager) clock() clock.Clock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.clk
+}
+
+// StartServer starts a configured server, including a disabled one, while
+// Run is running.
+func (m *Manager) StartServer(serverID string) error {
+	m.ctlMu.Lock()
+	defer m.ctlMu.Unlock()
+	s, err := m.serverConfig(serverID)
+	if err != nil {
+		return err
+	}
+	if normalizeProtocol(s.Protocol) == "" {
+		return fmt.Errorf("server %s: protocol %s not supported", s.ServerID, s.Protocol)
+	}
+	return m.launch(s)
+}
+
+// StopServer stops a running server and waits until it has closed and
+// saved its state.
+func (m *Manager) StopServer(serverID string) error {
+	m.ctlMu.Lock()
+	defer m.ctlMu.Unlock()
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return err
+	}
+	inst.cancel()
+	<-inst.done
+	log.Printf("server %s stopped", serverID)
+	return nil
+}
+
+// RestartServer stops serverID if it is running and starts it again.
+func (m *Manager) RestartServer(serverID string) error {
+	if err := m.StopServer(serverID); err != nil && !errors.Is(err, errServerStopped) {
+		return err
+	}
+	return m.StartServer(serverID)
+}
+
+// ReadRegisters returns count raw registers of table ("holding", "input",
+// "coil" or "discrete") starting at address, from the register map that
+// answers requests to slave. Bits read as 0 or 1.
+func (m *Manager) ReadRegisters(serverID string, slave uint8, table string, address uint16, count int) ([]uint16, error) {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return nil, err
+	}
+	return inst.server.ImageFor(slave).ReadTable(strings.ToLower(table), address, count)
+}
+
+// WriteRegisters stores raw values into table starting at address of the
+// register map of slave. Holding register and coil writes reach scenarios
+// and scripts like client writes.
+func (m *Manager) WriteRegisters(serverID string, slave uint8, table string, address uint16, values []uint16) error {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return err
+	}
+	return inst.server.ImageFor(slave).WriteTable(strings.ToLower(table), address, values)
+}
+
+// point resolves ref, "device_id/point" or a point name unique on the
+// server, to its point definition and register map.
+func (in *instance) point(ref string) (collector.Point, string, *modbus.Server, error) {
+	s := in.config()
+	key, err := simulator.PointResolver(s)(ref)
+	if err != nil {
+		return collector.Point{}, "", nil, err
+	}
+	for _, dev := range s.Devices {
+		for _, p := range dev.Points {
+			if simulator.PointKey(dev.DeviceID, p.Name) == key {
+				return p, key, in.images.of(dev.DeviceID), nil
+			}
+		}
+	}
+	return collector.Point{}, "", nil, fmt.Errorf("unknown point %q", ref)
+}
+
+// PointValue is the decoded value of a point.
+type PointValue struct {
+	Key   string  `json:"key"` // device_id/point
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// ReadPoint decodes the engineering value of a point of serverID.
+func (m *Manager) ReadPoint(serverID, ref string) (PointValue, error) {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return PointValue{}, err
+	}
+	p, key, img, err := inst.point(ref)
+	if err != nil {
+		return PointValue{}, err
+	}
+	v, err := simulator.ReadPoint(img, p)
+	if err != nil {
+		return PointValue{}, err
+	}
+	return PointValue{Key: key, Value: v, Unit: p.Unit}, nil
+}
+
+// ReadPoints decodes every point of serverID.
+func (m *Manager) ReadPoints(serverID string) ([]PointValue, error) {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return nil, err
+	}
+	var out []PointValue
+	for _, dev := range inst.config().Devices {
+		img := inst.images.of(dev.DeviceID)
+		for _, p := range dev.Points {
+			v, err := simulator.ReadPoint(img, p)
+			if err != nil {
+				return nil, fmt.Errorf("point %s/%s: %w", dev.DeviceID, p.Name, err)
+			}
+			out = append(out, PointValue{Key: simulator.PointKey(dev.DeviceID, p.Name), Value: v, Unit: p.Unit})
+		}
+	}
+	return out, nil
+}
+
+// WritePoint encodes an engineering value into a point of serverID using
+// its data type, byte order, scale and offset. Like WriteRegisters, writes
+// to holding registers and coils reach scenarios and scripts.
+func (m *Manager) WritePoint(serverID, ref string, value float64) error {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return err
+	}
+	p, _, img, err := inst.point(ref)
+	if err != nil {
+		return err
+	}
+	kind := strings.ToLower(p.RegisterType)
+	switch kind {
+	case "holding", "input":
+		words, err := simulator.EncodeWords(p, value)
+		if err != nil {
+			return err
+		}
+		return img.WriteTable(kind, p.Address, words)
+	case "coil", "discrete":
+		scale := p.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		var bit uint16
+		if value*scale+p.Offset > 0 {
+			bit = 1
+		}
+		return img.WriteTable(kind, p.Address, []uint16{bit})
+	default:
+		return fmt.Errorf("unsupported register type %s", p.RegisterType)
+	}
+}
+
+// PausePlayback freezes the CSV playback or replay of serverID; the
+// registers keep their current values until ResumePlayback.
+func (m *Manager) PausePlayback(serverID string) error {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.player == nil {
+		return errNoPlayback
+	}
+	inst.player.Pause(m.clock().Now())
+	return nil
+}
+
+// ResumePlayback continues a paused playback where it stopped.
+func (m *Manager) ResumePlayback(serverID string) error {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	if inst.player == nil {
+		return errNoPlayback
+	}
+	inst.player.Resume(m.clock().Now())
+	return nil
+}
+
+// Source selects the recorded data source of a running server. It is
+// decoded from YAML or JSON with the field names of the server config.
+type Source struct {
+	Type     string                    `yaml:"type"` // csv | replay | none
+	CSVFile  string                    `yaml:"csv_file"`
+	Replay   *collector.ReplayConfig   `yaml:"replay"`
+	Playback *collector.PlaybackConfig `yaml:"playback"` // default: keep the current settings
+}
+
+// SetSource switches the data source of a running server without
+// restarting it: a CSV file, a collector recording, or none to leave the
+// registers to clients, generators, scenarios and scripts. The register
+// image is kept; playback starts from the beginning.
+func (m *Manager) SetSource(serverID string, src Source) error {
+	inst, err := m.instance(serverID)
+	if err != nil {
+		return err
+	}
+	inst.mu.Lock()
+	defer inst.mu.Unlock()
+	s := inst.cfg
+	if src.Playback != nil {
+		s.Playback = *src.Playback
+	}
+	typ := strings.ToLower(strings.TrimSpace(src.Type))
+	if typ == "" {
+		switch {
+		case src.Replay != nil:
+			typ = "replay"
+		case src.CSVFile != "":
+			typ = "csv"
+		}
+	}
+	noPlayback := inst.noPlayback
+	switch typ {
+	case "csv":
+		if src.CSVFile == "" {
+			return errors.New("csv source needs csv_file")
+		}
+		if err := m.checkDataFile(src.CSVFile); err != nil {
+			return err
+		}
+		s.CSVFile, s.Replay = src.CSVFile, nil
+		inst.noPlayback = false
+	case "replay":
+		if src.Replay == nil || src.Replay.File == "" {
+			return errors.New("replay source needs replay.file")
+		}
+		if err := m.checkDataFile(src.Replay.File); err != nil {
+			return err
+		}
+		s.Replay = src.Replay
+		inst.noPlayback = false
+	case "none":
+		s.Replay = nil
+		inst.noPlayback = true
+	default:
+		return fmt.Errorf("unknown source type %q", src.Type)
+	}
+	if err := inst.setSource(m.clock(), s, !inst.noPlayback); err != nil {
+		inst.noPlayback = noPlayback
+		return err
+	}
+	log.Printf("server %s: source switched to %s", serverID, typ)
+	return nil
+}
+
+// checkDataFile accepts name only below DataDir, symlinks resolved.
+func (m *Manager) checkDataFile(name string) error {
+	if m.DataDir == "" {
+		return fmt.Errorf("%w: no data directory is configured", errOutsideData)
+	}
+	dir, err := realPath(m.DataDir)
+	if err != nil {
+		return err
+	}
+	path, err := realPath(name)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: %s", errOutsideData, name)
+	}
+	return nil
+}
+
+// realPath is the absolute path of name with symlinks resolved; a missing
+// file is left to the loader to report.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real, nil
+	}
+	return abs, nil
+}
@@ -2,29 +2,47 @@ package servermgr
 
 import (
 	"context"
-	"encoding/csv"
-	"errors"
+	"encoding/binary"
 	"fmt"
 	"log"
-	"math"
-	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"modbus-simulator/internal/capture"
+	"modbus-simulator/internal/clock"
 	collector "modbus-simulator/internal/collector"
 	"modbus-simulator/internal/modbus"
 	"modbus-simulator/internal/model"
+	"modbus-simulator/internal/simulator"
 )
 
 // Manager spins up multiple Modbus servers concurrently from YAML config.
-// Currently supports Modbus TCP based on collector.ServerConfig.
-// It initializes registers defined by devices/points to zero values.
+// Servers speak Modbus TCP, RTU on a serial line or RTU-over-TCP, following
+// collector.ServerConfig. It initializes registers defined by devices/points
+// to zero values.
 type Manager struct {
-	Cfg     collector.RootConfig
-	servers map[string]*modbus.Server
+	Cfg collector.RootConfig
+	// Clock drives playback, generators, scenarios and scripts; NewManager
+	// sets it from system.clock.
+	Clock clock.Clock
+	// Tap receives every frame of every server; nil means system.capture
+	// when enabled.
+	Tap capture.Tap
+	// DataDir holds the files SetSource may load; empty refuses file
+	// sources, so control API clients cannot read arbitrary host files.
+	DataDir string
+	servers map[string]*instance // running servers by server_id
 	mu      sync.Mutex
+	ready   chan struct{}
+
+	// set by Run for servers started later
+	ctx   context.Context
+	clk   clock.Clock
+	store stateStore
+	tap   capture.Tap
+	wg    sync.WaitGroup
+	ctlMu sync.Mutex // serializes start/stop requests
 }
 
 // registerValue holds metadata for a single register point
@@ -37,298 +55,507 @@ type registerValue struct {
 	dataType string
 }
 
-// loadCSV reads a CSV file where the header row defines column names.
-// Returns a slice of rows as map[column]float64, suitable for numeric transformations.
-func loadCSV(path string) ([]map[string]float64, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-	if len(records) < 2 {
-		return nil, errors.New("csv must contain header and at least one data row")
-	}
-
-	header := records[0]
-	rows := make([]map[string]float64, 0, len(records)-1)
-	for _, record := range records[1:] {
-		if len(record) != len(header) {
-			return nil, errors.New("csv record length mismatch")
-		}
-		row := make(map[string]float64, len(header))
-		for i, key := range header {
-			valStr := strings.TrimSpace(record[i])
-			if valStr == "" {
-				return nil, fmt.Errorf("empty value for column %s", key)
-			}
-			val, err := strconv.ParseFloat(valStr, 64)
-			if err != nil {
-				return nil, fmt.Errorf("invalid value for column %s: %w", key, err)
-			}
-			row[key] = val
-		}
-		rows = append(rows, row)
-	}
-	return rows, nil
-}
-
 // applyRowToServer writes one CSV row into the server's registers based on point names.
+// A "device_id/point" column takes precedence over the bare point name, so
+// one CSV can feed devices sharing point names.
 // Applies scale and offset transformations and supports multiple data types.
-func applyRowToServer(server *modbus.Server, s collector.ServerConfig, rows []map[string]float64, index int) {
-	if len(rows) == 0 {
-		return
-	}
-	row := rows[index]
+// Points driven by a generator or script are left to it.
+func applyRowToServer(sink pointSink, s collector.ServerConfig, row map[string]float64) {
 	for _, dev := range s.Devices {
 		for _, p := range dev.Points {
+			if p.Generator != nil || p.Script != nil {
+				continue
+			}
 			key := strings.TrimSpace(p.Name)
-			raw, ok := row[key]
+			raw, ok := row[simulator.PointKey(dev.DeviceID, key)]
+			if !ok {
+				raw, ok = row[key]
+			}
 			if !ok {
 				// no matching column; skip
 				continue
 			}
+			sink.write(dev.DeviceID, p, raw)
+		}
+	}
+}
 
-			// Apply scale and offset
-			scale := p.Scale
-			if scale == 0 {
-				scale = 1
+// applyReplayToServer writes replayed values, keyed by
+// simulator.PointKey, into the registers of the matching points.
+func applyReplayToServer(sink pointSink, s collector.ServerConfig, values map[string]float64) {
+	for _, dev := range s.Devices {
+		for _, p := range dev.Points {
+			if p.Generator != nil || p.Script != nil {
+				continue
 			}
-			scaled := raw*scale + p.Offset
-
-			// Get data type, default to uint16 for numeric registers
-			dataType := strings.ToLower(p.DataType)
-			regType := strings.ToLower(p.RegisterType)
+			if v, ok := values[simulator.PointKey(dev.DeviceID, p.Name)]; ok {
+				sink.write(dev.DeviceID, p, v)
+			}
+		}
+	}
+}
 
-			switch regType {
-			case "holding", "input":
-				if dataType == "" {
-					dataType = "uint16"
-				}
-				if err := writeNumericRegister(server, regType, p.Address, dataType, scaled); err != nil {
-					log.Printf("set %s register: %v", regType, err)
-				}
-			case "coil":
-				_ = server.SetCoil(p.Address, scaled > 0)
-			case "discrete":
-				_ = server.SetDiscreteInput(p.Address, scaled > 0)
+// loadPlayer opens the server's recorded data source: the collector
+// recording in replay, or csv_file (default data/topway_dashboard.csv).
+// A missing CSV is only reported when required is set, since servers driven
+// purely by generators need no CSV.
+func loadPlayer(s collector.ServerConfig, interval time.Duration, required bool, start time.Time) (*simulator.Player, error) {
+	var rec *simulator.Recording
+	var err error
+	if s.Replay != nil {
+		if rec, err = simulator.LoadReplay(*s.Replay, s.ServerID); err != nil {
+			return nil, fmt.Errorf("load replay %s failed: %w", s.Replay.File, err)
+		}
+		if rec.Skipped > 0 {
+			log.Printf("server %s: replay %s has %d unreadable records (skipped)", s.ServerID, s.Replay.File, rec.Skipped)
+		}
+	} else {
+		csvPath := s.CSVFile
+		if csvPath == "" {
+			csvPath = "data/topway_dashboard.csv"
+		}
+		if rec, err = simulator.LoadRecording(csvPath, s.Playback, interval); err != nil {
+			if !required {
+				return nil, nil
 			}
+			return nil, fmt.Errorf("load csv %s failed: %w (skipping periodic updates)", csvPath, err)
+		}
+		if rec.Skipped > 0 {
+			log.Printf("server %s: csv %s has %d empty or non-numeric cells (keeping last values)", s.ServerID, csvPath, rec.Skipped)
 		}
 	}
+	player, err := simulator.NewPlayer(rec, s.Playback, start)
+	if err != nil {
+		return nil, fmt.Errorf("playback: %w", err)
+	}
+	return player, nil
 }
 
-// writeNumericRegister writes a numeric value to a register with the specified data type
-func writeNumericRegister(server *modbus.Server, regType string, address uint16, dataType string, scaled float64) error {
-	switch dataType {
-	case "uint16":
-		word, err := floatToUint16(scaled)
-		if err != nil {
-			return err
+// applyPointValue encodes an engineering value into the point's register,
+// applying scale and offset the same way the collector reverses them.
+func applyPointValue(server *modbus.Server, p collector.Point, raw float64) {
+	if err := simulator.WritePoint(server, p, raw); err != nil {
+		log.Printf("set %s register: %v", strings.ToLower(p.RegisterType), err)
+	}
+}
+
+// pointSink writes values from CSV playback, replay and generators into a
+// server. With a scenario, point faults are applied first and points owned
+// by a process model are left to the model.
+type pointSink struct {
+	images   images
+	scenario *simulator.Scenario
+}
+
+func (ps pointSink) write(deviceID string, p collector.Point, v float64) {
+	if ps.scenario != nil {
+		key := simulator.PointKey(deviceID, p.Name)
+		if ps.scenario.Drives(key) {
+			return
 		}
-		return setRegisterWord(server, regType, address, word)
-	case "int16":
-		word, err := floatToInt16(scaled)
-		if err != nil {
-			return err
+		var ok bool
+		if v, ok = ps.scenario.Filter(key, v); !ok {
+			return
 		}
-		return setRegisterWord(server, regType, address, word)
-	case "float32":
-		return setRegisterFloat32(server, regType, address, scaled)
-	default:
-		return fmt.Errorf("unsupported data type %s", dataType)
 	}
+	applyPointValue(ps.images.of(deviceID), p, v)
 }
 
-// setRegisterWord sets a single 16-bit word to a register
-func setRegisterWord(server *modbus.Server, regType string, address uint16, word uint16) error {
-	switch regType {
-	case "holding":
-		return server.SetHoldingRegister(address, word)
-	case "input":
-		return server.SetInputRegister(address, word)
-	default:
-		return fmt.Errorf("register type %s does not support word writes", regType)
+// serverPlant exposes a server's points to a scenario.
+type serverPlant struct {
+	server *modbus.Server
+	points map[string]plantPoint
+}
+
+type plantPoint struct {
+	point collector.Point
+	image *modbus.Server
+}
+
+func newServerPlant(im images, s collector.ServerConfig) *serverPlant {
+	pl := &serverPlant{server: im.server, points: map[string]plantPoint{}}
+	for _, dev := range s.Devices {
+		for _, p := range dev.Points {
+			pl.points[simulator.PointKey(dev.DeviceID, p.Name)] = plantPoint{p, im.of(dev.DeviceID)}
+		}
 	}
+	return pl
 }
 
-// setRegisterFloat32 writes a float32 value across two consecutive registers
-func setRegisterFloat32(server *modbus.Server, regType string, address uint16, scaled float64) error {
-	if math.IsNaN(scaled) || math.IsInf(scaled, 0) {
-		return fmt.Errorf("invalid float32 value")
+func (pl *serverPlant) ReadPoint(key string) (float64, error) {
+	pp, ok := pl.points[key]
+	if !ok {
+		return 0, fmt.Errorf("unknown point %s", key)
 	}
-	if address == math.MaxUint16 {
-		return fmt.Errorf("address %d out of range for float32", address)
+	return simulator.ReadPoint(pp.image, pp.point)
+}
+
+func (pl *serverPlant) WritePoint(key string, v float64) error {
+	pp, ok := pl.points[key]
+	if !ok {
+		return fmt.Errorf("unknown point %s", key)
 	}
-	f32 := float32(scaled)
-	if math.IsInf(float64(f32), 0) {
-		return fmt.Errorf("value %f overflows float32", scaled)
+	applyPointValue(pp.image, pp.point, v)
+	return nil
+}
+
+func (pl *serverPlant) SetFault(f modbus.Fault) { pl.server.SetFault(f) }
+
+// notifyWrites reports client writes to the given devices to the scenario
+// by point.
+func notifyWrites(sc *simulator.Scenario, devices []collector.Device) modbus.WriteFunc {
+	type span struct {
+		kind       string
+		start, end int
+		key        string
+	}
+	var spans []span
+	for _, dev := range devices {
+		for _, p := range dev.Points {
+			kind := strings.ToLower(p.RegisterType)
+			if kind != "holding" && kind != "coil" {
+				continue
+			}
+			n := 1
+			if kind == "holding" {
+				n = simulator.WordCount(p)
+			}
+			spans = append(spans, span{kind, int(p.Address), int(p.Address) + n, simulator.PointKey(dev.DeviceID, p.Name)})
+		}
 	}
-	bits := math.Float32bits(f32)
-	hi := uint16(bits >> 16)
-	lo := uint16(bits & 0xFFFF)
-	if err := setRegisterWord(server, regType, address, hi); err != nil {
-		return err
+	return func(kind string, address, quantity uint16) {
+		from, to := int(address), int(address)+int(quantity)
+		for _, sp := range spans {
+			if sp.kind == kind && sp.start < to && from < sp.end {
+				sc.NotifyWrite(sp.key)
+			}
+		}
 	}
-	return setRegisterWord(server, regType, address+1, lo)
 }
 
-// floatToUint16 converts a float64 to uint16 with range checking
-func floatToUint16(value float64) (uint16, error) {
-	if math.IsNaN(value) || math.IsInf(value, 0) {
-		return 0, fmt.Errorf("invalid uint16 value")
+// startScripts loads the device and point scripts of the server and runs
+// their on_tick hooks on clk until ctx is done. Scripts that fail to load
+// are logged and skipped. The scripts are returned by the register image
+// of their device.
+func startScripts(ctx context.Context, clk clock.Clock, im images, s collector.ServerConfig) map[*modbus.Server][]*simulator.Script {
+	byImage := map[*modbus.Server][]*simulator.Script{}
+	var scripts []*simulator.Script
+	load := func(cfg *collector.ScriptConfig, dev collector.Device, p *collector.Point) {
+		img := im.of(dev.DeviceID)
+		sc, err := simulator.NewScript(*cfg, dev, p, img, clk)
+		if err != nil {
+			log.Printf("server %s device %s: %v", s.ServerID, dev.DeviceID, err)
+			return
+		}
+		scripts = append(scripts, sc)
+		byImage[img] = append(byImage[img], sc)
+	}
+	for _, dev := range s.Devices {
+		if dev.Script != nil {
+			load(dev.Script, dev, nil)
+		}
+		for i := range dev.Points {
+			if p := &dev.Points[i]; p.Script != nil {
+				load(p.Script, dev, p)
+			}
+		}
 	}
-	rounded := math.Round(value)
-	if rounded < 0 || rounded > 65535 {
-		return 0, fmt.Errorf("value %f out of range for uint16", value)
+	for _, sc := range scripts {
+		run := func(now time.Time) {
+			if err := sc.RunTick(now); err != nil {
+				log.Printf("server %s: %v", s.ServerID, err)
+			}
+		}
+		run(clk.Now())
+		clk.Every(ctx, sc.Tick(), run)
+		go func() {
+			<-ctx.Done()
+			sc.Close()
+		}()
 	}
-	return uint16(rounded), nil
+	return byImage
 }
 
-// floatToInt16 converts a float64 to int16 with range checking
-func floatToInt16(value float64) (uint16, error) {
-	if math.IsNaN(value) || math.IsInf(value, 0) {
-		return 0, fmt.Errorf("invalid int16 value")
+// pointGenerator pairs a point with the generator driving it.
+type pointGenerator struct {
+	deviceID string
+	point    collector.Point
+	gen      simulator.Generator
+}
+
+// buildGenerators creates the generators declared on the server's points.
+// Invalid definitions are logged and the point keeps its initial value.
+func buildGenerators(s collector.ServerConfig) []pointGenerator {
+	var out []pointGenerator
+	for _, dev := range s.Devices {
+		for _, p := range dev.Points {
+			if p.Generator == nil {
+				continue
+			}
+			g, err := simulator.NewGenerator(*p.Generator)
+			if err != nil {
+				log.Printf("server %s point %s/%s: %v", s.ServerID, dev.DeviceID, p.Name, err)
+				continue
+			}
+			out = append(out, pointGenerator{deviceID: dev.DeviceID, point: p, gen: g})
+		}
 	}
-	rounded := math.Round(value)
-	if rounded < -32768 || rounded > 32767 {
-		return 0, fmt.Errorf("value %f out of range for int16", value)
+	return out
+}
+
+// applyGenerators writes the current value of every generator.
+func applyGenerators(sink pointSink, gens []pointGenerator, now time.Time) {
+	for _, pg := range gens {
+		sink.write(pg.deviceID, pg.point, pg.gen.Value(now))
 	}
-	return uint16(int16(rounded)), nil
 }
 
 func NewManager(cfg collector.RootConfig) *Manager {
-	return &Manager{Cfg: cfg, servers: make(map[string]*modbus.Server)}
+	return &Manager{
+		Cfg:     cfg,
+		Clock:   cfg.System.Clock.NewClock(),
+		servers: make(map[string]*instance),
+		ready:   make(chan struct{}),
+	}
+}
+
+// Ready is closed once Run has started every server and registered its
+// timers, so tests can step a manual clock from a known state.
+func (m *Manager) Ready() <-chan struct{} {
+	return m.ready
 }
 
-// Run starts all enabled TCP servers and blocks until ctx is canceled.
+// Run starts all enabled servers and blocks until ctx is canceled. Servers
+// can be started and stopped individually while it runs.
 func (m *Manager) Run(ctx context.Context) error {
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, 16) // cap concurrent starts
+	clk := m.Clock
+	if clk == nil {
+		clk = clock.Wall()
+	}
+	var store stateStore
+	if m.Cfg.System.Persistence.Enabled {
+		var err error
+		if store, err = openStateStore(m.Cfg.System.Persistence); err != nil {
+			return fmt.Errorf("open state store: %w", err)
+		}
+		defer store.close()
+	}
+	tap := m.Tap
+	if tap == nil && m.Cfg.System.Capture.Enabled {
+		c := m.Cfg.System.Capture
+		w, err := capture.Create(c.Path("servers"), c.Format)
+		if err != nil {
+			return fmt.Errorf("open capture: %w", err)
+		}
+		defer w.Close()
+		tap = w.Tap
+	}
+	m.mu.Lock()
+	m.ctx, m.clk, m.store, m.tap = ctx, clk, store, tap
+	m.mu.Unlock()
 
+	var setup sync.WaitGroup
+	sem := make(chan struct{}, 16) // cap concurrent starts
 	for _, srv := range m.Cfg.Servers {
 		if !srv.Enabled {
 			continue
 		}
-		proto := strings.ToLower(strings.TrimSpace(srv.Protocol))
-		if proto != "modbus-tcp" && proto != "tcp" {
+		if normalizeProtocol(srv.Protocol) == "" {
 			log.Printf("server %s: protocol %s not supported yet (skipping)", srv.ServerID, srv.Protocol)
 			continue
 		}
 
-		wg.Add(1)
+		setup.Add(1)
 		go func(s collector.ServerConfig) {
-			defer wg.Done()
+			defer setup.Done()
 			select {
 			case sem <- struct{}{}:
 				defer func() { <-sem }()
 			case <-ctx.Done():
 				return
 			}
+			if err := m.launch(s); err != nil {
+				log.Printf("server %s: %v", s.ServerID, err)
+			}
+		}(srv)
+	}
 
-			addr := fmt.Sprintf("%s:%d", s.Connection.Host, s.Connection.Port)
-			retry := s.RetryCount
-			if retry < 0 {
-				retry = 0
+	go func() {
+		setup.Wait()
+		if m.ready != nil {
+			close(m.ready)
+		}
+	}()
+
+	// wait for ctx canceled then wait for the servers to close
+	<-ctx.Done()
+	setup.Wait()
+	m.wg.Wait()
+	return nil
+}
+
+// launch starts server s and its data sources. It returns once the server
+// listens and its timers are registered; the server then runs until it is
+// stopped or the manager's context ends.
+func (m *Manager) launch(s collector.ServerConfig) error {
+	m.mu.Lock()
+	parent, clk, store, tap := m.ctx, m.clk, m.store, m.tap
+	_, running := m.servers[s.ServerID]
+	m.mu.Unlock()
+	if parent == nil || parent.Err() != nil {
+		return errNotRunning
+	}
+	if running {
+		return fmt.Errorf("%w: %s", errAlreadyRunning, s.ServerID)
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	addr := serverAddress(s)
+	retry := s.RetryCount
+	if retry < 0 {
+		retry = 0
+	}
+
+	server := modbus.NewServer()
+	server.SetTap(tap)
+
+	// initialize registers for declared points to zero values, or to
+	// their initial value
+	im := newImages(server, s)
+	for _, dev := range s.Devices {
+		img := im.of(dev.DeviceID)
+		for _, p := range dev.Points {
+			switch strings.ToLower(p.RegisterType) {
+			case "holding":
+				_ = img.SetHoldingRegister(p.Address, 0)
+			case "input":
+				_ = img.SetInputRegister(p.Address, 0)
+			case "coil":
+				_ = img.SetCoil(p.Address, false)
+			case "discrete":
+				_ = img.SetDiscreteInput(p.Address, false)
+			}
+			if p.Initial != nil {
+				applyPointValue(img, p, *p.Initial)
 			}
+		}
+	}
 
-			var server *modbus.Server
-			var err error
-			for attempt := 0; attempt <= retry; attempt++ {
-				server = modbus.NewServer()
-				if err = server.Listen(addr); err != nil {
-					if attempt == retry {
-						log.Printf("server %s listen %s failed: %v", s.ServerID, addr, err)
-						return
-					}
-					time.Sleep(time.Second)
-					continue
-				}
-				break
+	// Retentive registers come back from the last run; sources below still
+	// drive their own points.
+	persistCfg := m.Cfg.System.Persistence
+	var p *persister
+	if store != nil {
+		p = newPersister(store, persistCfg, s, im)
+		if err := p.restore(); err != nil {
+			log.Printf("server %s: restore state: %v", s.ServerID, err)
+		}
+	}
+
+	// Clients only connect once the registers hold their restored values.
+	var err error
+	for attempt := 0; attempt <= retry; attempt++ {
+		if err = listen(ctx, server, s); err != nil {
+			if attempt == retry {
+				cancel()
+				return fmt.Errorf("listen %s failed: %w", addr, err)
 			}
+			time.Sleep(time.Second)
+			continue
+		}
+		break
+	}
 
-			m.mu.Lock()
-			m.servers[s.ServerID] = server
-			m.mu.Unlock()
-
-			log.Printf("server %s listening on %s", s.ServerID, addr)
-
-			// initialize registers for declared points to zero values
-			for _, dev := range s.Devices {
-				for _, p := range dev.Points {
-					switch strings.ToLower(p.RegisterType) {
-					case "holding":
-						_ = server.SetHoldingRegister(p.Address, 0)
-					case "input":
-						_ = server.SetInputRegister(p.Address, 0)
-					case "coil":
-						_ = server.SetCoil(p.Address, false)
-					case "discrete":
-						_ = server.SetDiscreteInput(p.Address, false)
-					}
-				}
+	log.Printf("server %s listening on %s", s.ServerID, addr)
+
+	hooks := map[*modbus.Server][]modbus.WriteFunc{}
+	saved := make(chan struct{})
+	if p != nil {
+		if persistCfg.OnWrite {
+			for _, ni := range p.images {
+				hooks[ni.image] = append(hooks[ni.image], p.onWrite)
 			}
+		}
+		go func() {
+			defer close(saved)
+			p.run(ctx, persistCfg.Interval)
+		}()
+	} else {
+		close(saved)
+	}
 
-			// Load CSV data and periodically write to registers following cmd/server simulator
-			// Use CSV file from config or default to data/topway_dashboard.csv
-			csvPath := s.CSVFile
-			if csvPath == "" {
-				csvPath = "data/topway_dashboard.csv"
+	// A scenario runs its timeline, rules and process models on its own
+	// tick and filters the values of the other sources.
+	inst := &instance{cfg: s, server: server, images: im, cancel: cancel, done: make(chan struct{}), ctx: ctx}
+	inst.sink = pointSink{images: im}
+	if s.Scenario != nil {
+		sc, err := simulator.NewScenario(*s.Scenario, s, newServerPlant(im, s), clk.Now())
+		if err != nil {
+			log.Printf("server %s: scenario: %v", s.ServerID, err)
+		} else {
+			inst.sink.scenario = sc
+			for _, ni := range im.named(s) {
+				hooks[ni.image] = append(hooks[ni.image], notifyWrites(sc, im.devicesOf(s, ni.image)))
 			}
-			rows, err := loadCSV(csvPath)
-			if err != nil {
-				log.Printf("server %s: load csv %s failed: %v (skipping periodic updates)", s.ServerID, csvPath, err)
-			} else if len(rows) == 0 {
-				log.Printf("server %s: csv has no data rows (skipping periodic updates)", s.ServerID)
-			} else {
-				// interval from frequency map; fallback 3s
-				interval := m.Cfg.Frequency[s.ServerID]
-				if interval <= 0 {
-					interval = 3 * time.Second
+			sc.Step(clk.Now())
+			clk.Every(ctx, sc.Tick(), sc.Step)
+		}
+	}
+
+	// Scripts run on_write synchronously, before the client gets its
+	// response.
+	for img, scripts := range startScripts(ctx, clk, im, s) {
+		inst.scripted = true
+		for _, sc := range scripts {
+			hooks[img] = append(hooks[img], func(kind string, address, quantity uint16) {
+				if err := sc.OnWrite(kind, address, quantity); err != nil {
+					log.Printf("server %s: %v", s.ServerID, err)
 				}
-				ticker := time.NewTicker(interval)
-				defer ticker.Stop()
-
-				// apply first row immediately
-				applyRowToServer(server, s, rows, 0)
-
-				go func() {
-					idx := 0
-					for {
-						select {
-						case <-ctx.Done():
-							return
-						case <-ticker.C:
-							idx = (idx + 1) % len(rows)
-							applyRowToServer(server, s, rows, idx)
-						}
-					}
-				}()
+			})
+		}
+	}
+	for img, fns := range hooks {
+		img.OnWrite(func(kind string, address, quantity uint16) {
+			for _, h := range fns {
+				h(kind, address, quantity)
 			}
+		})
+	}
 
-			// wait for context cancellation, then close
-			<-ctx.Done()
-			server.Close()
-			m.mu.Lock()
-			delete(m.servers, s.ServerID)
-			m.mu.Unlock()
-		}(srv)
+	// interval from frequency map; fallback 3s
+	inst.interval = m.Cfg.Frequency[s.ServerID]
+	if inst.interval <= 0 {
+		inst.interval = 3 * time.Second
+	}
+	if err := inst.setSource(clk, s, false); err != nil {
+		log.Printf("server %s: %v", s.ServerID, err)
 	}
 
-	// wait for ctx canceled then wait workers
-	<-ctx.Done()
-	wg.Wait()
+	m.mu.Lock()
+	m.servers[s.ServerID] = inst
+	m.mu.Unlock()
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(inst.done)
+		// wait for the stop or the manager's cancellation, then close
+		<-ctx.Done()
+		<-saved
+		server.Close()
+		m.mu.Lock()
+		if m.servers[s.ServerID] == inst {
+			delete(m.servers, s.ServerID)
+		}
+		m.mu.Unlock()
+	}()
 	return nil
 }
 
 // Snapshot reads current values from running servers and returns server/device/point snapshots.
 func (m *Manager) Snapshot() ([]model.ServerSnapshot, error) {
 	m.mu.Lock()
-	servers := make(map[string]*modbus.Server, len(m.servers))
+	servers := make(map[string]*instance, len(m.servers))
 	for k, v := range m.servers {
 		servers[k] = v
 	}
@@ -336,21 +563,26 @@ func (m *Manager) Snapshot() ([]model.ServerSnapshot, error) {
 
 	res := make([]model.ServerSnapshot, 0, len(servers))
 	now := time.Now()
+	if m.Clock != nil {
+		now = m.Clock.Now()
+	}
 
-	for _, sc := range m.Cfg.Servers {
-		s := servers[sc.ServerID]
-		if s == nil || !sc.Enabled {
+	for _, cfg := range m.Cfg.Servers {
+		inst := servers[cfg.ServerID]
+		if inst == nil {
 			continue
 		}
+		sc := inst.config()
 
 		snap := model.ServerSnapshot{
 			ServerID:   sc.ServerID,
 			ServerName: sc.ServerName,
-			Address:    fmt.Sprintf("%s:%d", sc.Connection.Host, sc.Connection.Port),
+			Address:    serverAddress(sc),
 			Timestamp:  now,
 		}
 
 		for _, dev := range sc.Devices {
+			s := inst.images.of(dev.DeviceID)
 			ds := model.DeviceSnapshot{
 				DeviceID: dev.DeviceID,
 				Vendor:   dev.Vendor,
@@ -382,6 +614,7 @@ func (m *Manager) Snapshot() ([]model.ServerSnapshot, error) {
 						ps.ValueBool = &b
 					}
 				}
+				decodeSnapshot(s, p, &ps)
 				ds.Points = append(ds.Points, ps)
 			}
 			snap.Devices = append(snap.Devices, ds)
@@ -412,3 +645,44 @@ func modbusGetBool(s *modbus.Server, kind string, addr uint16) (bool, error) {
 		return false, fmt.Errorf("unsupported kind %s", kind)
 	}
 }
+
+// decodeSnapshot fills the engineering value of ps as the collector would
+// read it: holding/input words are decoded by collector.DecodeRegisters,
+// an empty data_type meaning uint16 as in the simulators; bits read as 0
+// or 1.
+func decodeSnapshot(s *modbus.Server, p collector.Point, ps *model.PointSnapshot) {
+	switch ps.RegisterType {
+	case "holding", "input":
+		if strings.TrimSpace(p.DataType) == "" {
+			p.DataType = "uint16"
+		}
+		words, err := s.ReadTable(ps.RegisterType, p.Address, simulator.WordCount(p))
+		if err != nil {
+			return
+		}
+		data := make([]byte, 0, 2*len(words))
+		for _, w := range words {
+			data = binary.BigEndian.AppendUint16(data, w)
+		}
+		ps.RawWords = words
+		ps.DataType = strings.ToLower(p.DataType)
+		if len(words) == 2 {
+			ps.ByteOrder = strings.ToUpper(p.ByteOrder)
+			if ps.ByteOrder == "" {
+				ps.ByteOrder = "ABCD"
+			}
+		}
+		if pv, err := collector.DecodeRegisters(p, data); err == nil {
+			ps.Value = &pv.Value
+		}
+	case "coil", "discrete":
+		if ps.ValueBool != nil {
+			v := 0.0
+			if *ps.ValueBool {
+				v = 1
+			}
+			ps.Value = &v
+			ps.DataType = "bool"
+		}
+	}
+}
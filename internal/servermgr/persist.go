@@ -0,0 +1,242 @@
+package servermgr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/db"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/simulator"
+)
+
+// stateStore keeps the encoded register state of each server.
+type stateStore interface {
+	load(serverID string) ([]byte, error) // nil when nothing was saved
+	save(serverID string, data []byte) error
+	close() error
+}
+
+// openStateStore opens the backend of system.persistence.
+func openStateStore(cfg collector.PersistenceConfig) (stateStore, error) {
+	switch strings.ToLower(cfg.Backend) {
+	case "", "file":
+		dir := cfg.Path
+		if dir == "" {
+			dir = filepath.Join("data", "state")
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+		return fileStore{dir: dir}, nil
+	case "sqlite", "db":
+		path := cfg.Path
+		if path == "" {
+			path = filepath.Join("data", "state.db")
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, err
+		}
+		d, err := db.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return dbStore{db: d}, nil
+	default:
+		return nil, fmt.Errorf("unsupported persistence backend %q", cfg.Backend)
+	}
+}
+
+// fileStore writes one <server_id>.state file per server, replacing it
+// atomically so a crash never leaves a torn file.
+type fileStore struct{ dir string }
+
+func (f fileStore) path(serverID string) string {
+	return filepath.Join(f.dir, serverID+".state")
+}
+
+func (f fileStore) load(serverID string) ([]byte, error) {
+	b, err := os.ReadFile(f.path(serverID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (f fileStore) save(serverID string, data []byte) error {
+	tmp, err := os.CreateTemp(f.dir, serverID+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path(serverID))
+}
+
+func (fileStore) close() error { return nil }
+
+// dbStore keeps the state in the register_state table.
+type dbStore struct{ db *db.DB }
+
+func (d dbStore) load(serverID string) ([]byte, error) {
+	return d.db.LoadRegisterState(context.Background(), serverID)
+}
+
+func (d dbStore) save(serverID string, data []byte) error {
+	return d.db.SaveRegisterState(context.Background(), serverID, data)
+}
+
+func (d dbStore) close() error { return d.db.Close() }
+
+// retentiveRanges returns the registers of s that survive a restart: the
+// server's own list, else the system default, else the holding registers
+// and coils of the declared points.
+func retentiveRanges(cfg collector.PersistenceConfig, s collector.ServerConfig) []modbus.Range {
+	list := s.Retentive
+	if len(list) == 0 {
+		list = cfg.Retentive
+	}
+	if len(list) == 0 {
+		return pointRanges(s)
+	}
+	ranges := make([]modbus.Range, 0, len(list))
+	for _, r := range list {
+		ranges = append(ranges, modbus.Range{Table: strings.ToLower(r.Table), Start: r.Start, Count: r.Count})
+	}
+	return ranges
+}
+
+// pointRanges returns one range per declared holding or coil point of s.
+func pointRanges(s collector.ServerConfig) []modbus.Range {
+	var ranges []modbus.Range
+	for _, dev := range s.Devices {
+		for _, p := range dev.Points {
+			switch strings.ToLower(p.RegisterType) {
+			case "holding":
+				n := min(simulator.WordCount(p), 65536-int(p.Address))
+				ranges = append(ranges, modbus.Range{Table: modbus.TableHolding, Start: p.Address, Count: n})
+			case "coil":
+				ranges = append(ranges, modbus.Range{Table: modbus.TableCoil, Start: p.Address, Count: 1})
+			}
+		}
+	}
+	return ranges
+}
+
+// persister saves the retentive registers of one server, one state per
+// register image.
+type persister struct {
+	store    stateStore
+	serverID string
+	images   []namedImage
+	ranges   []modbus.Range
+	dirty    chan struct{}
+	last     map[string][]byte // last state saved per image
+}
+
+func newPersister(store stateStore, cfg collector.PersistenceConfig, s collector.ServerConfig, im images) *persister {
+	return &persister{
+		store:    store,
+		serverID: s.ServerID,
+		images:   im.named(s),
+		ranges:   retentiveRanges(cfg, s),
+		dirty:    make(chan struct{}, 1),
+		last:     map[string][]byte{},
+	}
+}
+
+// restore loads the saved states into the images that have one.
+func (p *persister) restore() error {
+	for _, ni := range p.images {
+		data, err := p.store.load(ni.name)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		var st modbus.State
+		if err := st.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("%s: %w", ni.name, err)
+		}
+		if err := ni.image.RestoreState(st, p.ranges); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes the states that changed since the last save.
+func (p *persister) save() error {
+	for _, ni := range p.images {
+		st, err := ni.image.SaveState(p.ranges)
+		if err != nil {
+			return err
+		}
+		data, err := st.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(p.last[ni.name], data) {
+			continue
+		}
+		if err := p.store.save(ni.name, data); err != nil {
+			return err
+		}
+		p.last[ni.name] = data
+	}
+	return nil
+}
+
+// onWrite is the server write hook: it asks run to save without blocking
+// the client's request.
+func (p *persister) onWrite(kind string, address, quantity uint16) {
+	select {
+	case p.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// run saves every interval (wall time) and on requests from onWrite until
+// ctx is done, then saves a last time.
+func (p *persister) run(ctx context.Context, interval time.Duration) {
+	var tick <-chan time.Time
+	if interval >= 0 {
+		if interval == 0 {
+			interval = 10 * time.Second
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.save(); err != nil {
+				log.Printf("server %s: save state: %v", p.serverID, err)
+			}
+			return
+		case <-tick:
+		case <-p.dirty:
+		}
+		if err := p.save(); err != nil {
+			log.Printf("server %s: save state: %v", p.serverID, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package servermgr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/output"
+)
+
+// Options are the run options shared by the simulator commands.
+type Options struct {
+	SnapshotJSON string        // write a one-time JSON snapshot here and exit
+	SnapshotCSV  string        // write a one-time CSV snapshot here and exit
+	SnapshotWait time.Duration // wait before the snapshot, default 3s
+	API          string        // listen address of the HTTP control API, empty to disable; a bare :port listens on localhost
+	DataDir      string        // files the control API may switch sources to, see Manager.DataDir
+	Capture      string        // capture every frame to this file, pcapng for .pcapng/.pcap, else a log
+}
+
+// Serve runs the servers of cfg until ctx is done. When a snapshot path is
+// set it instead waits SnapshotWait, writes the snapshot and returns.
+func Serve(ctx context.Context, cfg collector.RootConfig, opts Options) error {
+	mgr := NewManager(cfg)
+	mgr.DataDir = opts.DataDir
+	if opts.Capture != "" {
+		w, err := capture.Create(opts.Capture, "")
+		if err != nil {
+			return fmt.Errorf("capture: %w", err)
+		}
+		defer w.Close()
+		mgr.Tap = w.Tap
+	}
+	if opts.API != "" {
+		ln, err := net.Listen("tcp", apiAddr(opts.API))
+		if err != nil {
+			return fmt.Errorf("control api: %w", err)
+		}
+		srv := &http.Server{Handler: mgr.Handler(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Printf("control api: %v", err)
+			}
+		}()
+		defer srv.Close()
+		log.Printf("control api listening on %s", ln.Addr())
+	}
+	if opts.SnapshotJSON == "" && opts.SnapshotCSV == "" {
+		return mgr.Run(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		if err := mgr.Run(ctx); err != nil {
+			log.Printf("server manager exited with error: %v", err)
+		}
+		close(done)
+	}()
+	defer func() { <-done }()
+	defer cancel()
+
+	// wait for servers to start and the first values to apply
+	wait := opts.SnapshotWait
+	if wait <= 0 {
+		wait = 3 * time.Second
+	}
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+		return nil
+	}
+
+	snaps, err := mgr.Snapshot()
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	if opts.SnapshotJSON != "" {
+		if err := output.WriteJSON(opts.SnapshotJSON, snaps); err != nil {
+			return fmt.Errorf("write snapshot json: %w", err)
+		}
+	}
+	if opts.SnapshotCSV != "" {
+		if err := output.WriteCSV(opts.SnapshotCSV, snaps); err != nil {
+			return fmt.Errorf("write snapshot csv: %w", err)
+		}
+	}
+	return nil
+}
+
+// apiAddr binds a listen address without host to localhost: the control
+// API is unauthenticated, so other hosts must be allowed explicitly, e.g.
+// with 0.0.0.0:8080.
+func apiAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
@@ -0,0 +1,68 @@
+package servermgr
+
+import (
+	"fmt"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+)
+
+// images maps the devices of a server to the register image serving them:
+// the server itself, or with slave_maps the Unit image of the device's
+// slave id.
+type images struct {
+	server   *modbus.Server
+	byDevice map[string]*modbus.Server
+}
+
+func newImages(server *modbus.Server, s collector.ServerConfig) images {
+	im := images{server: server, byDevice: map[string]*modbus.Server{}}
+	for _, dev := range s.Devices {
+		if s.SlaveMaps {
+			im.byDevice[dev.DeviceID] = server.Unit(dev.SlaveID)
+		} else {
+			im.byDevice[dev.DeviceID] = server
+		}
+	}
+	return im
+}
+
+// of returns the register image of a device.
+func (im images) of(deviceID string) *modbus.Server {
+	if img := im.byDevice[deviceID]; img != nil {
+		return img
+	}
+	return im.server
+}
+
+// named returns every distinct image with a name for its saved state: the
+// server id for the server's own map, server_id@slave for a slave map.
+func (im images) named(s collector.ServerConfig) []namedImage {
+	out := []namedImage{{name: s.ServerID, image: im.server}}
+	seen := map[*modbus.Server]bool{im.server: true}
+	for _, dev := range s.Devices {
+		img := im.of(dev.DeviceID)
+		if seen[img] {
+			continue
+		}
+		seen[img] = true
+		out = append(out, namedImage{name: fmt.Sprintf("%s@%d", s.ServerID, dev.SlaveID), image: img})
+	}
+	return out
+}
+
+// devicesOf returns the devices of s served by img.
+func (im images) devicesOf(s collector.ServerConfig, img *modbus.Server) []collector.Device {
+	var out []collector.Device
+	for _, dev := range s.Devices {
+		if im.of(dev.DeviceID) == img {
+			out = append(out, dev)
+		}
+	}
+	return out
+}
+
+type namedImage struct {
+	name  string
+	image *modbus.Server
+}
@@ -0,0 +1,279 @@
+package servermgr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/goburrow/serial"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	utils "modbus-simulator/internal/utils"
+)
+
+// Supported server protocols.
+const (
+	protoTCP        = "modbus-tcp"
+	protoRTU        = "modbus-rtu"
+	protoRTUOverTCP = "rtu-over-tcp"
+	protoTLS        = "modbus-tls"
+)
+
+// normalizeProtocol maps the accepted protocol spellings to one of the
+// proto constants, or "" when the protocol is not supported.
+func normalizeProtocol(p string) string {
+	switch strings.ToLower(strings.TrimSpace(p)) {
+	case "", "modbus-tcp", "tcp":
+		return protoTCP
+	case "modbus-rtu", "rtu", "serial":
+		return protoRTU
+	case "rtu-over-tcp", "rtu_over_tcp", "modbus-rtu-over-tcp":
+		return protoRTUOverTCP
+	case "modbus-tls", "tls", "mbaps":
+		return protoTLS
+	}
+	return ""
+}
+
+// serverAddress describes where a server is reachable.
+func serverAddress(s collector.ServerConfig) string {
+	if normalizeProtocol(s.Protocol) == protoRTU {
+		return s.Connection.SerialPort
+	}
+	return fmt.Sprintf("%s:%d", s.Connection.Host, s.Connection.Port)
+}
+
+// slaveFilter accepts the slave ids of the server's devices on RTU lines,
+// or every id when no device sets one.
+func slaveFilter(s collector.ServerConfig) func(byte) bool {
+	ids := map[byte]bool{}
+	for _, dev := range s.Devices {
+		if dev.SlaveID != 0 {
+			ids[dev.SlaveID] = true
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	return func(id byte) bool { return ids[id] }
+}
+
+// rtuTiming returns the simulated line timing of an RTU connection.
+func rtuTiming(c collector.Connection) modbus.RTUTiming {
+	return modbus.RTUTiming{
+		BaudRate:   c.BaudRate,
+		FrameGaps:  c.FrameGaps,
+		Turnaround: c.Turnaround,
+		Echo:       c.Echo,
+		Collisions: c.Collisions,
+	}
+}
+
+// tlsRoles converts the configured roles of a TLS server; none gives nil,
+// which lets every authenticated client do everything.
+func tlsRoles(cfg map[string]collector.RoleConfig) (modbus.Roles, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	roles := modbus.Roles{}
+	for name, rc := range cfg {
+		var role modbus.Role
+		for _, f := range rc.Functions {
+			switch f = strings.ToLower(strings.TrimSpace(f)); f {
+			case "read":
+				role.Functions = append(role.Functions, 0x01, 0x02, 0x03, 0x04)
+			case "write":
+				role.Functions = append(role.Functions, 0x05, 0x06, 0x0F, 0x10)
+			default:
+				fc, err := strconv.ParseUint(f, 0, 8)
+				if err != nil {
+					return nil, fmt.Errorf("role %s: invalid function %q", name, f)
+				}
+				role.Functions = append(role.Functions, byte(fc))
+			}
+		}
+		var err error
+		if role.Read, err = addressRanges(rc.Read); err != nil {
+			return nil, fmt.Errorf("role %s: %w", name, err)
+		}
+		if role.Write, err = addressRanges(rc.Write); err != nil {
+			return nil, fmt.Errorf("role %s: %w", name, err)
+		}
+		roles[name] = role
+	}
+	return roles, nil
+}
+
+// addressRanges parses ranges such as "0-99" and "100".
+func addressRanges(specs []string) ([]modbus.AddressRange, error) {
+	var ranges []modbus.AddressRange
+	for _, spec := range specs {
+		lo, hi, found := strings.Cut(strings.TrimSpace(spec), "-")
+		if !found {
+			hi = lo
+		}
+		start, err1 := strconv.ParseUint(strings.TrimSpace(lo), 0, 16)
+		end, err2 := strconv.ParseUint(strings.TrimSpace(hi), 0, 16)
+		if err1 != nil || err2 != nil || end < start {
+			return nil, fmt.Errorf("invalid address range %q", spec)
+		}
+		ranges = append(ranges, modbus.AddressRange{Start: uint16(start), End: uint16(end)})
+	}
+	return ranges, nil
+}
+
+// listen attaches server to the transport of s.
+func listen(ctx context.Context, server *modbus.Server, s collector.ServerConfig) error {
+	server.SetRTUTiming(rtuTiming(s.Connection))
+	switch normalizeProtocol(s.Protocol) {
+	case protoTCP:
+		return server.Listen(serverAddress(s))
+	case protoRTUOverTCP:
+		return server.ListenRTUOverTCP(serverAddress(s), slaveFilter(s))
+	case protoTLS:
+		if s.Connection.TLS == nil {
+			return errors.New("protocol modbus-tls requires connection.tls")
+		}
+		config, err := s.Connection.TLS.ServerConfig()
+		if err != nil {
+			return err
+		}
+		roles, err := tlsRoles(s.Connection.TLS.Roles)
+		if err != nil {
+			return err
+		}
+		return server.ListenTLS(serverAddress(s), config, roles)
+	case protoRTU:
+		line, err := openSerial(ctx, s.Connection)
+		if err != nil {
+			return err
+		}
+		server.AttachRTU(line, slaveFilter(s))
+		return nil
+	default:
+		return fmt.Errorf("protocol %s not supported", s.Protocol)
+	}
+}
+
+// openSerial opens the serial port of c, first creating it as a virtual
+// pair with its peer socat_peer when spawn_socat is set: natively on Linux,
+// else with socat. The pair lives until ctx is done.
+func openSerial(ctx context.Context, c collector.Connection) (io.ReadWriteCloser, error) {
+	if strings.TrimSpace(c.SerialPort) == "" {
+		return nil, errors.New("serial_port is required for RTU")
+	}
+	var stop func()
+	if c.SpawnSocat {
+		if c.SocatPeer == "" {
+			return nil, errors.New("spawn_socat requires socat_peer")
+		}
+		var err error
+		if stop, err = virtualPair(ctx, utils.SocatPair{Link: c.SerialPort, Peer: c.SocatPeer}); err != nil {
+			return nil, err
+		}
+	}
+	port, err := utils.OpenSerial(utils.SerialParams{
+		Address:  c.SerialPort,
+		BaudRate: c.BaudRate,
+		DataBits: c.DataBits,
+		StopBits: c.StopBits,
+		Parity:   c.Parity,
+		Timeout:  time.Second,
+	})
+	if err != nil {
+		if stop != nil {
+			stop()
+		}
+		return nil, err
+	}
+	if _, ok := port.(*utils.MemPort); ok {
+		return port, nil
+	}
+	return &serialLine{port: port}, nil
+}
+
+// virtualPair creates the serial pair until ctx is done and returns a
+// function removing it earlier.
+func virtualPair(ctx context.Context, pair utils.SocatPair) (stop func(), err error) {
+	pty, err := utils.OpenPTYPair(pair)
+	if err == nil {
+		log.Printf("created pty pair %s <-> %s", pair.Link, pair.Peer)
+		ctx, cancel := context.WithCancel(ctx)
+		go func() {
+			<-ctx.Done()
+			pty.Close()
+		}()
+		return cancel, nil
+	}
+	if !errors.Is(err, utils.ErrPTYUnsupported) {
+		return nil, fmt.Errorf("create pty pair: %w", err)
+	}
+	socat := utils.BuildSocatPairCmd(ctx, pair)
+	socat.Cancel = func() error { return socat.Process.Signal(syscall.SIGTERM) }
+	socat.WaitDelay = 2 * time.Second
+	if err := socat.Start(); err != nil {
+		return nil, fmt.Errorf("start socat: %w", err)
+	}
+	go func() { _ = socat.Wait() }()
+	log.Printf("spawned socat pair %s <-> %s (pid=%d)", pair.Link, pair.Peer, socat.Process.Pid)
+	// wait for socat to create the link
+	for i := 0; i < 40; i++ {
+		if _, err := os.Stat(pair.Link); err == nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return func() { _ = socat.Process.Signal(syscall.SIGTERM) }, nil
+}
+
+// serialLine keeps reading through read timeouts of an idle serial port.
+// goburrow/serial ports must not be closed during a read or write, so Close
+// waits for them; reads return within the port timeout.
+type serialLine struct {
+	port   io.ReadWriteCloser
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (l *serialLine) Read(b []byte) (int, error) {
+	for {
+		l.mu.RLock()
+		if l.closed {
+			l.mu.RUnlock()
+			return 0, net.ErrClosed
+		}
+		n, err := l.port.Read(b)
+		l.mu.RUnlock()
+		if n > 0 || !errors.Is(err, serial.ErrTimeout) {
+			return n, err
+		}
+	}
+}
+
+func (l *serialLine) Write(b []byte) (int, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return 0, net.ErrClosed
+	}
+	return l.port.Write(b)
+}
+
+func (l *serialLine) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+	return l.port.Close()
+}
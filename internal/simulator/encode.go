@@ -0,0 +1,119 @@
+package simulator
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// PointKey identifies a point of a server as "device_id/point". Replayed
+// recordings and scenarios use it to address points.
+func PointKey(deviceID, pointName string) string {
+	return deviceID + "/" + pointName
+}
+
+// WordCount returns the number of registers a holding/input point occupies.
+func WordCount(p collector.Point) int {
+	switch strings.ToLower(strings.TrimSpace(p.DataType)) {
+	case "float32", "uint32", "int32":
+		return 2
+	}
+	return 1
+}
+
+// EncodeWords converts an engineering value into the register words of a
+// holding/input point: raw = value*scale + offset, encoded by data_type and
+// byte_order so that the collector decodes it back to value.
+func EncodeWords(p collector.Point, value float64) ([]uint16, error) {
+	scale := p.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	raw := value*scale + p.Offset
+	if math.IsNaN(raw) || math.IsInf(raw, 0) {
+		return nil, fmt.Errorf("invalid value for point %s", p.Name)
+	}
+	dt := strings.ToLower(strings.TrimSpace(p.DataType))
+	var u32 uint32
+	switch dt {
+	case "", "uint16":
+		r := math.Round(raw)
+		if r < 0 || r > math.MaxUint16 {
+			return nil, fmt.Errorf("value %f out of range for uint16", raw)
+		}
+		return []uint16{uint16(r)}, nil
+	case "int16":
+		r := math.Round(raw)
+		if r < math.MinInt16 || r > math.MaxInt16 {
+			return nil, fmt.Errorf("value %f out of range for int16", raw)
+		}
+		return []uint16{uint16(int16(r))}, nil
+	case "float32":
+		f := float32(raw)
+		if math.IsInf(float64(f), 0) {
+			return nil, fmt.Errorf("value %f overflows float32", raw)
+		}
+		u32 = math.Float32bits(f)
+	case "uint32":
+		r := math.Round(raw)
+		if r < 0 || r > math.MaxUint32 {
+			return nil, fmt.Errorf("value %f out of range for uint32", raw)
+		}
+		u32 = uint32(r)
+	case "int32":
+		r := math.Round(raw)
+		if r < math.MinInt32 || r > math.MaxInt32 {
+			return nil, fmt.Errorf("value %f out of range for int32", raw)
+		}
+		u32 = uint32(int32(r))
+	default:
+		return nil, fmt.Errorf("unsupported data type %s", p.DataType)
+	}
+	if p.Address == math.MaxUint16 {
+		return nil, fmt.Errorf("address %d out of range for %s", p.Address, dt)
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], u32)
+	w := collector.Reorder32(b[:], p.ByteOrder)
+	return []uint16{binary.BigEndian.Uint16(w[:2]), binary.BigEndian.Uint16(w[2:])}, nil
+}
+
+// DecodeWords is the inverse of EncodeWords: it returns the engineering value
+// held in the register words of a holding/input point.
+func DecodeWords(p collector.Point, words []uint16) (float64, error) {
+	scale := p.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	dt := strings.ToLower(strings.TrimSpace(p.DataType))
+	need := WordCount(p)
+	if len(words) < need {
+		return 0, fmt.Errorf("point %s needs %d registers", p.Name, need)
+	}
+	var raw float64
+	var b [4]byte
+	if need == 2 {
+		binary.BigEndian.PutUint16(b[:2], words[0])
+		binary.BigEndian.PutUint16(b[2:], words[1])
+		copy(b[:], collector.Reorder32(b[:], p.ByteOrder))
+	}
+	u32 := binary.BigEndian.Uint32(b[:])
+	switch dt {
+	case "", "uint16":
+		raw = float64(words[0])
+	case "int16":
+		raw = float64(int16(words[0]))
+	case "float32":
+		raw = float64(math.Float32frombits(u32))
+	case "uint32":
+		raw = float64(u32)
+	case "int32":
+		raw = float64(int32(u32))
+	default:
+		return 0, fmt.Errorf("unsupported data type %s", p.DataType)
+	}
+	return (raw - p.Offset) / scale, nil
+}
@@ -0,0 +1,260 @@
+// Package simulator provides the value sources that drive simulated points.
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// Generator produces the engineering value of a point at a given time.
+// Calls must use non-decreasing times; stateful generators (random walk,
+// counter) advance by the time elapsed since the previous call.
+type Generator interface {
+	Value(t time.Time) float64
+}
+
+// NewGenerator builds a generator from its YAML definition.
+func NewGenerator(cfg collector.GeneratorConfig) (Generator, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case "constant", "const":
+		return constant(cfg.Value), nil
+	case "ramp":
+		return &ramp{cfg: cfg}, nil
+	case "sine", "sin":
+		return newWave(cfg, sineShape), nil
+	case "square":
+		duty := cfg.Duty
+		if duty <= 0 || duty >= 1 {
+			duty = 0.5
+		}
+		return newWave(cfg, func(frac float64) float64 {
+			if frac < duty {
+				return 1
+			}
+			return -1
+		}), nil
+	case "sawtooth", "saw":
+		return newWave(cfg, func(frac float64) float64 { return 2*frac - 1 }), nil
+	case "triangle":
+		return newWave(cfg, func(frac float64) float64 {
+			if frac < 0.5 {
+				return 4*frac - 1
+			}
+			return 3 - 4*frac
+		}), nil
+	case "random_walk", "randomwalk":
+		return &randomWalk{cfg: cfg, value: cfg.Value, rnd: newRand(cfg.Seed)}, nil
+	case "noise", "gaussian":
+		var base Generator = constant(cfg.Value)
+		if cfg.Source != nil {
+			g, err := NewGenerator(*cfg.Source)
+			if err != nil {
+				return nil, fmt.Errorf("noise source: %w", err)
+			}
+			base = g
+		}
+		return &noise{base: base, stddev: cfg.StdDev, rnd: newRand(cfg.Seed)}, nil
+	case "steps", "step":
+		if len(cfg.Steps) == 0 {
+			return nil, fmt.Errorf("steps generator needs at least one step")
+		}
+		var total time.Duration
+		for i, st := range cfg.Steps {
+			if st.Duration <= 0 {
+				return nil, fmt.Errorf("step %d: duration must be positive", i)
+			}
+			total += st.Duration
+		}
+		return &steps{steps: cfg.Steps, total: total, once: cfg.Once}, nil
+	case "counter":
+		return &counter{cfg: cfg, acc: cfg.Value}, nil
+	default:
+		return nil, fmt.Errorf("unknown generator type %q", cfg.Type)
+	}
+}
+
+func newRand(seed int64) *rand.Rand {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(seed))
+}
+
+// clock records the time of the first call so waveforms start at phase 0.
+type clock struct {
+	start time.Time
+	last  time.Time
+}
+
+// elapsed returns the time since the first call and since the previous one.
+func (c *clock) elapsed(t time.Time) (total, delta time.Duration) {
+	if c.start.IsZero() {
+		c.start, c.last = t, t
+	}
+	total, delta = t.Sub(c.start), t.Sub(c.last)
+	if delta < 0 {
+		delta = 0
+	}
+	c.last = t
+	return total, delta
+}
+
+type constant float64
+
+func (c constant) Value(time.Time) float64 { return float64(c) }
+
+// ramp rises (or falls with a negative rate) from Value, clamped to
+// [Min, Max] when Max > Min.
+type ramp struct {
+	cfg collector.GeneratorConfig
+	clk clock
+}
+
+func (r *ramp) Value(t time.Time) float64 {
+	total, _ := r.clk.elapsed(t)
+	v := r.cfg.Value + r.cfg.Rate*total.Seconds()
+	if r.cfg.Max > r.cfg.Min {
+		v = math.Max(r.cfg.Min, math.Min(r.cfg.Max, v))
+	}
+	return v
+}
+
+// wave is a periodic waveform: center + amplitude*shape(fraction of period).
+type wave struct {
+	center, amplitude float64
+	period            time.Duration
+	phase             float64
+	shape             func(frac float64) float64
+	clk               clock
+}
+
+func sineShape(frac float64) float64 { return math.Sin(2 * math.Pi * frac) }
+
+func newWave(cfg collector.GeneratorConfig, shape func(float64) float64) *wave {
+	w := &wave{center: cfg.Center, amplitude: cfg.Amplitude, period: cfg.Period, phase: cfg.Phase, shape: shape}
+	if w.amplitude == 0 && cfg.Max > cfg.Min {
+		w.center = (cfg.Max + cfg.Min) / 2
+		w.amplitude = (cfg.Max - cfg.Min) / 2
+	}
+	if w.period <= 0 {
+		w.period = time.Minute
+	}
+	return w
+}
+
+func (w *wave) Value(t time.Time) float64 {
+	total, _ := w.clk.elapsed(t)
+	frac := math.Mod(total.Seconds()/w.period.Seconds()+w.phase, 1)
+	if frac < 0 {
+		frac++
+	}
+	return w.center + w.amplitude*w.shape(frac)
+}
+
+// randomWalk is a Gaussian random walk whose step scales with the square
+// root of elapsed time, reflected at [Min, Max] when Max > Min.
+type randomWalk struct {
+	cfg   collector.GeneratorConfig
+	value float64
+	rnd   *rand.Rand
+	clk   clock
+}
+
+func (r *randomWalk) Value(t time.Time) float64 {
+	_, dt := r.clk.elapsed(t)
+	if dt > 0 {
+		r.value += r.rnd.NormFloat64() * r.cfg.Step * math.Sqrt(dt.Seconds())
+	}
+	if lo, hi := r.cfg.Min, r.cfg.Max; hi > lo {
+		r.value = reflectInto(r.value, lo, hi)
+	}
+	return r.value
+}
+
+// reflectInto folds v into [lo, hi] as if it bounced off both bounds. The
+// bounces repeat every 2*(hi-lo), so any distance folds in one step; an
+// overflow to infinity stops at the bound it crossed.
+func reflectInto(v, lo, hi float64) float64 {
+	switch {
+	case v >= lo && v <= hi:
+		return v
+	case math.IsInf(v, 1):
+		return hi
+	case math.IsInf(v, -1):
+		return lo
+	}
+	span := hi - lo
+	m := math.Mod(v-lo, 2*span)
+	if math.IsNaN(m) {
+		// v-lo overflowed
+		return math.Max(lo, math.Min(hi, v))
+	}
+	if m < 0 {
+		m += 2 * span
+	}
+	if m > span {
+		m = 2*span - m
+	}
+	return lo + m
+}
+
+// noise adds Gaussian noise to another generator.
+type noise struct {
+	base   Generator
+	stddev float64
+	rnd    *rand.Rand
+}
+
+func (n *noise) Value(t time.Time) float64 {
+	return n.base.Value(t) + n.rnd.NormFloat64()*n.stddev
+}
+
+// steps walks through a list of values, each held for its duration.
+type steps struct {
+	steps []collector.GeneratorStep
+	total time.Duration
+	once  bool
+	clk   clock
+}
+
+func (s *steps) Value(t time.Time) float64 {
+	pos, _ := s.clk.elapsed(t)
+	if pos >= s.total {
+		if s.once {
+			return s.steps[len(s.steps)-1].Value
+		}
+		pos %= s.total
+	}
+	for _, st := range s.steps {
+		if pos < st.Duration {
+			return st.Value
+		}
+		pos -= st.Duration
+	}
+	return s.steps[len(s.steps)-1].Value
+}
+
+// counter counts up by Rate per second from Value, wrapping to zero at
+// Rollover. It reports whole counts.
+type counter struct {
+	cfg collector.GeneratorConfig
+	acc float64
+	clk clock
+}
+
+func (c *counter) Value(t time.Time) float64 {
+	_, dt := c.clk.elapsed(t)
+	c.acc += c.cfg.Rate * dt.Seconds()
+	if c.cfg.Rollover > 0 {
+		c.acc = math.Mod(c.acc, c.cfg.Rollover)
+		if c.acc < 0 {
+			c.acc += c.cfg.Rollover
+		}
+	}
+	return math.Floor(c.acc)
+}
@@ -0,0 +1,386 @@
+package simulator
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// Playback modes and interpolations accepted by collector.PlaybackConfig.
+const (
+	ModeLoop     = "loop"
+	ModeOnce     = "once"
+	ModePingPong = "ping_pong"
+
+	InterpolationStep   = "step"
+	InterpolationLinear = "linear"
+)
+
+// timestampLayouts are tried in order when no timestamp_format is set.
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006/01/02 15:04:05",
+	"01/02/2006 15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Sample is one recorded value at an offset from the start of a recording.
+type Sample struct {
+	Offset time.Duration
+	Value  float64
+}
+
+// Recording holds one time series per column, each sorted by offset.
+// Offsets are relative to the earliest sample of the recording.
+type Recording struct {
+	Columns []string
+	Series  [][]Sample
+	// Length is the offset of the last sample; Period is the loop length,
+	// Length plus the last gap so the final values are held as long as the
+	// ones before them.
+	Length time.Duration
+	Period time.Duration
+	// Skipped counts cells that were empty or not numeric.
+	Skipped int
+}
+
+// newRecording builds a Recording from timestamped rows of the given
+// columns. A NaN value marks an empty cell, which keeps the previous value
+// of its column.
+func newRecording(columns []string, times []time.Time, rows [][]float64) *Recording {
+	rec := &Recording{Columns: columns, Series: make([][]Sample, len(columns))}
+	if len(times) == 0 {
+		return rec
+	}
+	t0 := times[0]
+	last := make([]float64, len(columns))
+	for j := range last {
+		last[j] = math.NaN()
+	}
+	for i, row := range rows {
+		off := times[i].Sub(t0)
+		for j, v := range row {
+			if math.IsNaN(v) {
+				v = last[j]
+			}
+			if math.IsNaN(v) {
+				continue
+			}
+			last[j] = v
+			rec.Series[j] = append(rec.Series[j], Sample{Offset: off, Value: v})
+		}
+	}
+	rec.finish(times)
+	return rec
+}
+
+// finish derives Length and Period from the sorted distinct sample times.
+func (r *Recording) finish(times []time.Time) {
+	n := len(times)
+	r.Length = times[n-1].Sub(times[0])
+	gap := time.Second
+	for i := n - 2; i >= 0; i-- {
+		if d := times[n-1].Sub(times[i]); d > 0 {
+			gap = d
+			break
+		}
+	}
+	r.Period = r.Length + gap
+}
+
+// LoadRecording reads a CSV file whose header row names the columns. With a
+// timestamp column the rows are sorted by it; without one, rows are spaced
+// by interval so that one row is played per tick.
+func LoadRecording(path string, cfg collector.PlaybackConfig, interval time.Duration) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errors.New("csv must contain a header row")
+		}
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	tsCol := -1
+	if cfg.TimestampColumn != "" {
+		for i, h := range header {
+			if strings.EqualFold(h, cfg.TimestampColumn) {
+				tsCol = i
+			}
+		}
+		if tsCol < 0 {
+			return nil, fmt.Errorf("timestamp column %q not found", cfg.TimestampColumn)
+		}
+	}
+
+	var columns []string
+	colIdx := make([]int, 0, len(header))
+	for i, h := range header {
+		if i == tsCol {
+			continue
+		}
+		columns = append(columns, h)
+		colIdx = append(colIdx, i)
+	}
+
+	type row struct {
+		ts   time.Time
+		vals []float64
+	}
+	var rows []row
+	skipped := 0
+	base := time.Unix(0, 0)
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		r := row{ts: base.Add(time.Duration(len(rows)) * interval), vals: make([]float64, len(colIdx))}
+		if tsCol >= 0 {
+			if tsCol >= len(record) {
+				return nil, fmt.Errorf("line %d: missing timestamp", line)
+			}
+			if r.ts, err = parseTimestamp(strings.TrimSpace(record[tsCol]), cfg.TimestampFormat); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		for j, i := range colIdx {
+			r.vals[j] = math.NaN()
+			if i >= len(record) {
+				skipped++
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(record[i]), 64)
+			if err != nil {
+				skipped++
+				continue
+			}
+			r.vals[j] = v
+		}
+		rows = append(rows, r)
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("csv must contain header and at least one data row")
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].ts.Before(rows[j].ts) })
+
+	times := make([]time.Time, len(rows))
+	vals := make([][]float64, len(rows))
+	for i, r := range rows {
+		times[i], vals[i] = r.ts, r.vals
+	}
+	rec := newRecording(columns, times, vals)
+	rec.Skipped = skipped
+	return rec, nil
+}
+
+// parseTimestamp parses s with layout, or with the common layouts and
+// Unix seconds/milliseconds when layout is empty.
+func parseTimestamp(s, layout string) (time.Time, error) {
+	switch strings.ToLower(layout) {
+	case "unix":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f*1e9)), nil
+	case "unix_ms":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, int64(f*1e6)), nil
+	case "":
+	default:
+		return time.ParseInLocation(layout, s, time.Local)
+	}
+	for _, l := range timestampLayouts {
+		if t, err := time.ParseInLocation(l, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		if f > 1e12 {
+			return time.Unix(0, int64(f*1e6)), nil
+		}
+		return time.Unix(0, int64(f*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+// Player maps time to the values of a Recording according to the playback
+// settings. A paused player keeps returning the values at the pause time.
+type Player struct {
+	rec    *Recording
+	speed  float64
+	offset time.Duration
+	mode   string
+	linear bool
+
+	mu       sync.Mutex
+	start    time.Time
+	pausedAt time.Time // zero while playing
+}
+
+// NewPlayer starts playing rec at start.
+func NewPlayer(rec *Recording, cfg collector.PlaybackConfig, start time.Time) (*Player, error) {
+	p := &Player{
+		rec:    rec,
+		start:  start,
+		speed:  cfg.Speed,
+		offset: cfg.StartOffset,
+		mode:   strings.ToLower(strings.TrimSpace(cfg.Mode)),
+	}
+	if p.speed <= 0 {
+		p.speed = 1
+	}
+	switch p.mode {
+	case "", ModeLoop:
+		p.mode = ModeLoop
+	case ModeOnce, ModePingPong:
+	case "once_then_hold", "hold":
+		p.mode = ModeOnce
+	case "pingpong", "ping-pong":
+		p.mode = ModePingPong
+	default:
+		return nil, fmt.Errorf("unknown playback mode %q", cfg.Mode)
+	}
+	switch strings.ToLower(strings.TrimSpace(cfg.Interpolation)) {
+	case "", InterpolationStep:
+	case InterpolationLinear:
+		p.linear = true
+	default:
+		return nil, fmt.Errorf("unknown interpolation %q", cfg.Interpolation)
+	}
+	if rec.Period <= 0 {
+		rec.Period = rec.Length + time.Second
+	}
+	return p, nil
+}
+
+// Pause freezes playback at t.
+func (p *Player) Pause(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pausedAt.IsZero() {
+		p.pausedAt = t
+	}
+}
+
+// Resume continues playback at t from where it was paused.
+func (p *Player) Resume(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.pausedAt.IsZero() {
+		p.start = p.start.Add(t.Sub(p.pausedAt))
+		p.pausedAt = time.Time{}
+	}
+}
+
+// Paused reports whether playback is paused.
+func (p *Player) Paused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.pausedAt.IsZero()
+}
+
+// Position returns the offset into the recording that is played at t.
+func (p *Player) Position(t time.Time) time.Duration {
+	p.mu.Lock()
+	if !p.pausedAt.IsZero() {
+		t = p.pausedAt
+	}
+	start := p.start
+	p.mu.Unlock()
+	pos := p.offset + time.Duration(float64(t.Sub(start))*p.speed)
+	if pos < 0 {
+		pos = 0
+	}
+	last := p.rec.Length
+	switch p.mode {
+	case ModeOnce:
+		if pos > last {
+			pos = last
+		}
+	case ModePingPong:
+		if last <= 0 {
+			return 0
+		}
+		pos %= 2 * last
+		if pos > last {
+			pos = 2*last - pos
+		}
+	default:
+		pos %= p.rec.Period
+	}
+	return pos
+}
+
+// Values returns the value of every column at t. Columns without a sample
+// at or before the current position are omitted.
+func (p *Player) Values(t time.Time) map[string]float64 {
+	pos := p.Position(t)
+	out := make(map[string]float64, len(p.rec.Columns))
+	for j, col := range p.rec.Columns {
+		if v, ok := p.seriesValue(p.rec.Series[j], pos); ok {
+			out[col] = v
+		}
+	}
+	return out
+}
+
+func (p *Player) seriesValue(series []Sample, pos time.Duration) (float64, bool) {
+	// last sample at or before pos
+	i := sort.Search(len(series), func(k int) bool { return series[k].Offset > pos }) - 1
+	if i < 0 {
+		return 0, false
+	}
+	cur := series[i]
+	if !p.linear {
+		return cur.Value, true
+	}
+	switch {
+	case i+1 < len(series):
+		next := series[i+1]
+		if next.Offset > cur.Offset {
+			frac := float64(pos-cur.Offset) / float64(next.Offset-cur.Offset)
+			return cur.Value + (next.Value-cur.Value)*frac, true
+		}
+	case p.mode == ModeLoop:
+		// wrap towards the first sample of the next cycle
+		next := series[0]
+		nextOff := p.rec.Period + next.Offset
+		if nextOff > cur.Offset {
+			frac := float64(pos-cur.Offset) / float64(nextOff-cur.Offset)
+			return cur.Value + (next.Value-cur.Value)*frac, true
+		}
+	}
+	return cur.Value, true
+}
@@ -0,0 +1,195 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// processModel is a dynamic model advanced by a Scenario every tick. read
+// returns the current engineering value of a point.
+type processModel interface {
+	output() string
+	step(dt float64, read func(key string) float64) float64
+	// reset moves the model state to v, e.g. after a timeline step set
+	// the output point.
+	reset(v float64)
+}
+
+type modelInput struct {
+	key  string
+	gain float64
+}
+
+type modelInputs []modelInput
+
+func (in modelInputs) sum(read func(string) float64) float64 {
+	var s float64
+	for _, i := range in {
+		s += i.gain * read(i.key)
+	}
+	return s
+}
+
+// limits clamps values to [min, max] when max > min.
+type limits struct{ min, max float64 }
+
+func (l limits) clamp(v float64) float64 {
+	if l.max <= l.min {
+		return v
+	}
+	return math.Max(l.min, math.Min(l.max, v))
+}
+
+// newProcessModel builds a model; resolve turns point references into
+// PointKeys.
+func newProcessModel(cfg collector.ProcessModelConfig, resolve func(string) (string, error)) (processModel, error) {
+	out, err := resolve(cfg.Output)
+	if err != nil {
+		return nil, fmt.Errorf("output: %w", err)
+	}
+	var in modelInputs
+	for _, i := range cfg.Inputs {
+		key, err := resolve(i.Point)
+		if err != nil {
+			return nil, fmt.Errorf("input: %w", err)
+		}
+		gain := i.Gain
+		if gain == 0 {
+			gain = 1
+		}
+		in = append(in, modelInput{key: key, gain: gain})
+	}
+	lim := limits{cfg.Min, cfg.Max}
+
+	var m processModel
+	switch strings.ToLower(strings.TrimSpace(cfg.Type)) {
+	case "first_order", "lag":
+		tau := cfg.TimeConstant
+		if tau <= 0 {
+			tau = 10 * time.Second
+		}
+		gain := cfg.Gain
+		if gain == 0 {
+			gain = 1
+		}
+		m = &firstOrder{out: out, in: in, bias: cfg.Bias, gain: gain, tau: tau.Seconds(), lim: lim}
+	case "integrator", "tank":
+		m = &integrator{out: out, in: in, bias: cfg.Bias, lim: lim}
+	case "pid":
+		pv, err := resolve(cfg.Process)
+		if err != nil {
+			return nil, fmt.Errorf("process: %w", err)
+		}
+		p := &pid{out: out, pv: pv, sp: cfg.Setpoint, bias: cfg.Bias,
+			kp: cfg.Kp, ki: cfg.Ki, kd: cfg.Kd, reverse: cfg.Reverse, lim: lim}
+		if cfg.SetpointPoint != "" {
+			if p.spKey, err = resolve(cfg.SetpointPoint); err != nil {
+				return nil, fmt.Errorf("setpoint_point: %w", err)
+			}
+		}
+		m = p
+	default:
+		return nil, fmt.Errorf("unknown model type %q", cfg.Type)
+	}
+	m.reset(lim.clamp(cfg.Initial))
+	return m, nil
+}
+
+// firstOrder follows gain*(bias+inputs) with an exponential lag.
+type firstOrder struct {
+	out  string
+	in   modelInputs
+	bias float64
+	gain float64
+	tau  float64
+	lim  limits
+	y    float64
+}
+
+func (m *firstOrder) output() string  { return m.out }
+func (m *firstOrder) reset(v float64) { m.y = v }
+
+func (m *firstOrder) step(dt float64, read func(string) float64) float64 {
+	target := m.gain * (m.bias + m.in.sum(read))
+	// exact discretisation, stable for any dt
+	m.y += (target - m.y) * (1 - math.Exp(-dt/m.tau))
+	m.y = m.lim.clamp(m.y)
+	return m.y
+}
+
+// integrator accumulates bias+inputs per second, e.g. a tank level fed by
+// a pump (positive gain) and drained by a valve (negative gain).
+type integrator struct {
+	out  string
+	in   modelInputs
+	bias float64
+	lim  limits
+	y    float64
+}
+
+func (m *integrator) output() string  { return m.out }
+func (m *integrator) reset(v float64) { m.y = v }
+
+func (m *integrator) step(dt float64, read func(string) float64) float64 {
+	m.y = m.lim.clamp(m.y + dt*(m.bias+m.in.sum(read)))
+	return m.y
+}
+
+// pid is a positional PID controller with derivative on measurement and
+// conditional integration as anti-windup.
+type pid struct {
+	out, pv, spKey string
+	sp, bias       float64
+	kp, ki, kd     float64
+	reverse        bool
+	lim            limits
+
+	y        float64
+	integral float64
+	prevPV   float64
+	havePrev bool
+}
+
+func (m *pid) output() string { return m.out }
+
+// reset makes the next output continue from v without a bump.
+func (m *pid) reset(v float64) {
+	m.y = v
+	m.integral = 0
+	if m.ki != 0 {
+		m.integral = (v - m.bias) / m.ki
+	}
+	m.havePrev = false
+}
+
+func (m *pid) step(dt float64, read func(string) float64) float64 {
+	pv := read(m.pv)
+	sp := m.sp
+	if m.spKey != "" {
+		sp = read(m.spKey)
+	}
+	e := sp - pv
+	var d float64
+	if m.havePrev && dt > 0 {
+		d = -(pv - m.prevPV) / dt
+	}
+	if m.reverse {
+		e, d = -e, -d
+	}
+	m.prevPV, m.havePrev = pv, true
+
+	integral := m.integral + e*dt
+	u := m.bias + m.kp*e + m.ki*integral + m.kd*d
+	if c := m.lim.clamp(u); c != u && (c < u) == (e > 0) {
+		// saturated in the direction of the error: stop integrating
+		integral = m.integral
+		u = m.bias + m.kp*e + m.ki*integral + m.kd*d
+	}
+	m.integral = integral
+	m.y = m.lim.clamp(u)
+	return m.y
+}
@@ -0,0 +1,122 @@
+package simulator
+
+import (
+	"fmt"
+	"strings"
+
+	collector "modbus-simulator/internal/collector"
+)
+
+// Registers is a simulated register image, implemented by modbus.Server
+// and the RTU store of cmd/server.
+type Registers interface {
+	SetHoldingRegister(address uint16, value uint16) error
+	SetInputRegister(address uint16, value uint16) error
+	SetCoil(address uint16, value bool) error
+	SetDiscreteInput(address uint16, value bool) error
+	HoldingRegister(address uint16) (uint16, error)
+	InputRegister(address uint16) (uint16, error)
+	Coil(address uint16) (bool, error)
+	DiscreteInput(address uint16) (bool, error)
+}
+
+// WritePoint encodes an engineering value into the registers of p. Coils
+// and discrete inputs are set when value*scale+offset is positive.
+func WritePoint(regs Registers, p collector.Point, value float64) error {
+	kind := strings.ToLower(p.RegisterType)
+	switch kind {
+	case "holding", "input":
+		words, err := EncodeWords(p, value)
+		if err != nil {
+			return err
+		}
+		for i, w := range words {
+			if err := WriteWord(regs, kind, p.Address+uint16(i), w); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "coil", "discrete":
+		scale := p.Scale
+		if scale == 0 {
+			scale = 1
+		}
+		return WriteBit(regs, kind, p.Address, value*scale+p.Offset > 0)
+	default:
+		return fmt.Errorf("unsupported register type %s", p.RegisterType)
+	}
+}
+
+// ReadPoint decodes the engineering value of p. Coils and discrete inputs
+// read as 0 or 1.
+func ReadPoint(regs Registers, p collector.Point) (float64, error) {
+	kind := strings.ToLower(p.RegisterType)
+	switch kind {
+	case "holding", "input":
+		words := make([]uint16, WordCount(p))
+		for i := range words {
+			w, err := ReadWord(regs, kind, p.Address+uint16(i))
+			if err != nil {
+				return 0, err
+			}
+			words[i] = w
+		}
+		return DecodeWords(p, words)
+	case "coil", "discrete":
+		b, err := ReadBit(regs, kind, p.Address)
+		if err != nil || !b {
+			return 0, err
+		}
+		return 1, nil
+	default:
+		return 0, fmt.Errorf("unsupported register type %s", p.RegisterType)
+	}
+}
+
+// WriteWord sets one holding or input register.
+func WriteWord(regs Registers, kind string, address, value uint16) error {
+	switch kind {
+	case "holding":
+		return regs.SetHoldingRegister(address, value)
+	case "input":
+		return regs.SetInputRegister(address, value)
+	default:
+		return fmt.Errorf("register type %s does not support word writes", kind)
+	}
+}
+
+// ReadWord returns one holding or input register.
+func ReadWord(regs Registers, kind string, address uint16) (uint16, error) {
+	switch kind {
+	case "holding":
+		return regs.HoldingRegister(address)
+	case "input":
+		return regs.InputRegister(address)
+	default:
+		return 0, fmt.Errorf("register type %s does not support word reads", kind)
+	}
+}
+
+// WriteBit sets one coil or discrete input.
+func WriteBit(regs Registers, kind string, address uint16, value bool) error {
+	switch kind {
+	case "coil":
+		return regs.SetCoil(address, value)
+	case "discrete":
+		return regs.SetDiscreteInput(address, value)
+	default:
+		return fmt.Errorf("register type %s does not support bit writes", kind)
+	}
+}
+
+// ReadBit returns one coil or discrete input.
+func ReadBit(regs Registers, kind string, address uint16) (bool, error) {
+	switch kind {
+	case "coil":
+		return regs.Coil(address)
+	case "discrete":
+		return regs.DiscreteInput(address)
+	default:
+		return false, fmt.Errorf("register type %s does not support bit reads", kind)
+	}
+}
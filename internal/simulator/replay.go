@@ -0,0 +1,204 @@
+package simulator
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	dbpkg "modbus-simulator/internal/db"
+	"modbus-simulator/internal/model"
+)
+
+// replayRecord is one value read from a collector recording.
+type replayRecord struct {
+	key   string
+	ts    time.Time
+	value float64
+}
+
+// LoadReplay reads a collector recording (collector.jsonl, collector.csv or
+// the point_values table) and returns one series per device/point of the
+// recorded server, keyed by PointKey.
+func LoadReplay(cfg collector.ReplayConfig, serverID string) (*Recording, error) {
+	if cfg.ServerID != "" {
+		serverID = cfg.ServerID
+	}
+	format := strings.ToLower(strings.TrimSpace(cfg.Format))
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(cfg.File)) {
+		case ".jsonl", ".json", ".ndjson":
+			format = "jsonl"
+		case ".csv":
+			format = "csv"
+		default:
+			format = "db"
+		}
+	}
+	var recs []replayRecord
+	var skipped int
+	var err error
+	switch format {
+	case "jsonl", "json":
+		recs, skipped, err = readReplayJSONL(cfg.File, serverID)
+	case "csv":
+		recs, skipped, err = readReplayCSV(cfg.File, serverID)
+	case "db", "sqlite":
+		recs, err = readReplayDB(cfg.File, serverID)
+	default:
+		return nil, fmt.Errorf("unknown replay format %q", cfg.Format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 {
+		return nil, fmt.Errorf("no records for server %s in %s", serverID, cfg.File)
+	}
+	rec := sparseRecording(recs)
+	rec.Skipped = skipped
+	return rec, nil
+}
+
+// sparseRecording turns individually timestamped values into per-key series.
+func sparseRecording(recs []replayRecord) *Recording {
+	sort.SliceStable(recs, func(i, j int) bool { return recs[i].ts.Before(recs[j].ts) })
+	rec := &Recording{}
+	index := map[string]int{}
+	t0 := recs[0].ts
+	times := make([]time.Time, 0, len(recs))
+	for _, r := range recs {
+		j, ok := index[r.key]
+		if !ok {
+			j = len(rec.Columns)
+			index[r.key] = j
+			rec.Columns = append(rec.Columns, r.key)
+			rec.Series = append(rec.Series, nil)
+		}
+		rec.Series[j] = append(rec.Series[j], Sample{Offset: r.ts.Sub(t0), Value: r.value})
+		if n := len(times); n == 0 || !times[n-1].Equal(r.ts) {
+			times = append(times, r.ts)
+		}
+	}
+	rec.finish(times)
+	return rec
+}
+
+func readReplayJSONL(path, serverID string) ([]replayRecord, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	var out []replayRecord
+	skipped := 0
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var v struct {
+			Timestamp time.Time `json:"timestamp"`
+			ServerID  string    `json:"server_id"`
+			DeviceID  string    `json:"device_id"`
+			PointName string    `json:"point_name"`
+			Value     *float64  `json:"value"`
+		}
+		if err := json.Unmarshal([]byte(line), &v); err != nil || v.Value == nil {
+			skipped++
+			continue
+		}
+		if v.ServerID != serverID {
+			continue
+		}
+		out = append(out, replayRecord{key: PointKey(v.DeviceID, v.PointName), ts: v.Timestamp, value: *v.Value})
+	}
+	return out, skipped, sc.Err()
+}
+
+// readReplayCSV reads collector.csv. Files written before data_type and
+// byte_order were added to the header carry more fields than header names;
+// the value is always the last field.
+func readReplayCSV(path, serverID string) ([]replayRecord, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err != nil {
+		return nil, 0, fmt.Errorf("read csv header: %w", err)
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.TrimSpace(h)] = i
+	}
+	for _, name := range []string{"timestamp", "server_id", "device_id", "point_name"} {
+		if _, ok := col[name]; !ok {
+			return nil, 0, fmt.Errorf("csv column %s not found", name)
+		}
+	}
+	var out []replayRecord
+	skipped := 0
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(rec) <= col["point_name"] || rec[col["server_id"]] != serverID {
+			continue
+		}
+		ts, err := time.Parse(time.RFC3339Nano, rec[col["timestamp"]])
+		if err != nil {
+			skipped++
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(rec[len(rec)-1]), 64)
+		if err != nil {
+			skipped++
+			continue
+		}
+		out = append(out, replayRecord{key: PointKey(rec[col["device_id"]], rec[col["point_name"]]), ts: ts, value: v})
+	}
+	return out, skipped, nil
+}
+
+func readReplayDB(path, serverID string) ([]replayRecord, error) {
+	db, err := dbpkg.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.ORM.WithContext(context.Background()).Model(&model.PointValue{}).
+		Select("device_id, name, value, timestamp").
+		Where("server_id = ?", serverID).
+		Order("timestamp").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []replayRecord
+	for rows.Next() {
+		var pv model.PointValue
+		if err := db.ORM.ScanRows(rows, &pv); err != nil {
+			return nil, err
+		}
+		out = append(out, replayRecord{key: PointKey(pv.DeviceID, pv.Name), ts: pv.Timestamp, value: pv.Value})
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,532 @@
+package simulator
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+)
+
+// Fault types accepted by collector.FaultConfig.
+const (
+	FaultStuck      = "stuck"
+	FaultBias       = "bias"
+	FaultNoise      = "noise"
+	FaultFreeze     = "freeze"
+	FaultNoResponse = "no_response"
+	FaultException  = "exception"
+	FaultDelay      = "delay"
+)
+
+// Plant is the register image a Scenario runs against. Points are addressed
+// by PointKey and values are engineering values.
+type Plant interface {
+	ReadPoint(key string) (float64, error)
+	WritePoint(key string, value float64) error
+	SetFault(f modbus.Fault)
+}
+
+type setValue struct {
+	key   string
+	value float64
+}
+
+type action struct {
+	set   []setValue
+	fault *fault
+	clear string // PointKey, "comm" or "all"
+	log   string
+}
+
+type fault struct {
+	key      string // empty for communication faults
+	kind     string
+	value    float64
+	delay    time.Duration
+	duration time.Duration
+}
+
+type activeFault struct {
+	kind  string
+	value float64
+	until time.Time // zero: until cleared
+}
+
+type condition struct {
+	key   string
+	op    string
+	value float64
+}
+
+type rule struct {
+	onWrite string
+	when    []condition
+	hold    time.Duration
+	then    *action
+	els     *action
+
+	active bool
+	since  time.Time
+}
+
+type timelineStep struct {
+	at  time.Duration
+	act *action
+}
+
+// Scenario runs the timeline, rules and process models of a
+// collector.ScenarioConfig against a Plant. Step is called from a single
+// goroutine; NotifyWrite and Filter may be called concurrently.
+type Scenario struct {
+	serverID string
+	plant    Plant
+	tick     time.Duration
+
+	start  time.Time
+	last   time.Time
+	steps  []timelineStep
+	next   int
+	loop   bool
+	period time.Duration
+	cycle  int64
+
+	rules  []*rule
+	models []processModel
+	driven map[string]processModel
+
+	mu     sync.Mutex
+	writes []string
+	faults map[string]*activeFault
+	comm   *activeFault
+	rng    *rand.Rand
+}
+
+// NewScenario validates cfg against the points of s and starts the
+// timeline at start.
+func NewScenario(cfg collector.ScenarioConfig, s collector.ServerConfig, plant Plant, start time.Time) (*Scenario, error) {
+	sc := &Scenario{
+		serverID: s.ServerID,
+		plant:    plant,
+		tick:     cfg.Tick,
+		start:    start,
+		last:     start,
+		loop:     cfg.Loop,
+		period:   cfg.Period,
+		driven:   map[string]processModel{},
+		faults:   map[string]*activeFault{},
+		rng:      rand.New(rand.NewSource(start.UnixNano())),
+	}
+	if sc.tick <= 0 {
+		sc.tick = 100 * time.Millisecond
+	}
+	resolve := PointResolver(s)
+
+	for i, st := range cfg.Timeline {
+		act, err := newAction(st.ScenarioAction, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("timeline step %d: %w", i+1, err)
+		}
+		sc.steps = append(sc.steps, timelineStep{at: st.At, act: act})
+	}
+	sort.SliceStable(sc.steps, func(i, j int) bool { return sc.steps[i].at < sc.steps[j].at })
+	if sc.loop && sc.period <= 0 && len(sc.steps) > 0 {
+		sc.period = sc.steps[len(sc.steps)-1].at
+	}
+	if sc.loop && sc.period <= 0 {
+		sc.loop = false
+	}
+
+	for i, rc := range cfg.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprintf("rule %d", i+1)
+		}
+		r, err := newRule(rc, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sc.rules = append(sc.rules, r)
+	}
+
+	for i, mc := range cfg.Models {
+		name := mc.Name
+		if name == "" {
+			name = fmt.Sprintf("model %d", i+1)
+		}
+		m, err := newProcessModel(mc, resolve)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if _, dup := sc.driven[m.output()]; dup {
+			return nil, fmt.Errorf("%s: output %s is already driven by another model", name, m.output())
+		}
+		sc.models = append(sc.models, m)
+		sc.driven[m.output()] = m
+	}
+	return sc, nil
+}
+
+// PointResolver resolves "device_id/point" references and point names that
+// are unique on the server to PointKeys.
+func PointResolver(s collector.ServerConfig) func(string) (string, error) {
+	keys := map[string]bool{}
+	byName := map[string][]string{}
+	for _, dev := range s.Devices {
+		for _, p := range dev.Points {
+			key := PointKey(dev.DeviceID, p.Name)
+			keys[key] = true
+			byName[p.Name] = append(byName[p.Name], key)
+		}
+	}
+	return func(ref string) (string, error) {
+		ref = strings.TrimSpace(ref)
+		if keys[ref] {
+			return ref, nil
+		}
+		switch matches := byName[ref]; len(matches) {
+		case 1:
+			return matches[0], nil
+		case 0:
+			return "", fmt.Errorf("unknown point %q", ref)
+		default:
+			return "", fmt.Errorf("point %q is ambiguous, use device_id/point", ref)
+		}
+	}
+}
+
+func newAction(cfg collector.ScenarioAction, resolve func(string) (string, error)) (*action, error) {
+	act := &action{log: cfg.Log}
+	refs := make([]string, 0, len(cfg.Set))
+	for ref := range cfg.Set {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		key, err := resolve(ref)
+		if err != nil {
+			return nil, fmt.Errorf("set: %w", err)
+		}
+		act.set = append(act.set, setValue{key: key, value: cfg.Set[ref]})
+	}
+	if f := cfg.Fault; f != nil {
+		flt := &fault{kind: strings.ToLower(strings.TrimSpace(f.Type)), value: f.Value, delay: f.Delay, duration: f.Duration}
+		if f.Point != "" {
+			key, err := resolve(f.Point)
+			if err != nil {
+				return nil, fmt.Errorf("fault: %w", err)
+			}
+			flt.key = key
+			switch flt.kind {
+			case FaultStuck, FaultBias, FaultNoise, FaultFreeze:
+			default:
+				return nil, fmt.Errorf("unknown point fault %q", f.Type)
+			}
+		} else {
+			switch flt.kind {
+			case FaultNoResponse, FaultDelay:
+			case FaultException:
+				if flt.value <= 0 || flt.value > 255 {
+					flt.value = 4 // server device failure
+				}
+			default:
+				return nil, fmt.Errorf("unknown communication fault %q", f.Type)
+			}
+		}
+		act.fault = flt
+	}
+	switch c := strings.TrimSpace(cfg.ClearFault); strings.ToLower(c) {
+	case "":
+	case "comm", "all":
+		act.clear = strings.ToLower(c)
+	default:
+		key, err := resolve(c)
+		if err != nil {
+			return nil, fmt.Errorf("clear_fault: %w", err)
+		}
+		act.clear = key
+	}
+	return act, nil
+}
+
+func newRule(cfg collector.ScenarioRule, resolve func(string) (string, error)) (*rule, error) {
+	r := &rule{hold: cfg.For}
+	if cfg.OnWrite != "" {
+		key, err := resolve(cfg.OnWrite)
+		if err != nil {
+			return nil, fmt.Errorf("on_write: %w", err)
+		}
+		r.onWrite = key
+	}
+	if r.onWrite == "" && len(cfg.When) == 0 {
+		return nil, fmt.Errorf("rule needs on_write or when")
+	}
+	for _, c := range cfg.When {
+		key, err := resolve(c.Point)
+		if err != nil {
+			return nil, fmt.Errorf("when: %w", err)
+		}
+		op := strings.TrimSpace(c.Op)
+		switch op {
+		case "":
+			op = ">"
+		case ">", ">=", "<", "<=", "==", "!=":
+		default:
+			return nil, fmt.Errorf("unknown operator %q", c.Op)
+		}
+		r.when = append(r.when, condition{key: key, op: op, value: c.Value})
+	}
+	var err error
+	if cfg.Then != nil {
+		if r.then, err = newAction(*cfg.Then, resolve); err != nil {
+			return nil, fmt.Errorf("then: %w", err)
+		}
+	}
+	if cfg.Else != nil {
+		if r.els, err = newAction(*cfg.Else, resolve); err != nil {
+			return nil, fmt.Errorf("else: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Tick returns the configured step period.
+func (sc *Scenario) Tick() time.Duration { return sc.tick }
+
+// Drives reports whether a process model owns the point; other value
+// sources should leave it alone.
+func (sc *Scenario) Drives(key string) bool {
+	_, ok := sc.driven[key]
+	return ok
+}
+
+// NotifyWrite records a client write to the point for the next Step.
+func (sc *Scenario) NotifyWrite(key string) {
+	sc.mu.Lock()
+	sc.writes = append(sc.writes, key)
+	sc.mu.Unlock()
+}
+
+// Filter applies an active point fault to a value about to be written to
+// the point. It returns false when the register must be left unchanged.
+func (sc *Scenario) Filter(key string, v float64) (float64, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	f := sc.faults[key]
+	if f == nil {
+		return v, true
+	}
+	switch f.kind {
+	case FaultStuck:
+		return f.value, true
+	case FaultBias:
+		return v + f.value, true
+	case FaultNoise:
+		return v + sc.rng.NormFloat64()*f.value, true
+	case FaultFreeze:
+		return v, false
+	}
+	return v, true
+}
+
+// Step advances the scenario to now: expired faults are cleared, due
+// timeline steps and write rules run, models are integrated and condition
+// rules are evaluated on the result.
+func (sc *Scenario) Step(now time.Time) {
+	dt := now.Sub(sc.last).Seconds()
+	if dt < 0 {
+		dt = 0
+	}
+	sc.last = now
+
+	sc.expireFaults(now)
+	sc.runTimeline(now)
+
+	sc.mu.Lock()
+	writes := sc.writes
+	sc.writes = nil
+	sc.mu.Unlock()
+	for _, key := range writes {
+		for _, r := range sc.rules {
+			if r.onWrite == key && sc.holds(r.when) {
+				sc.run(r.then, now)
+			}
+		}
+	}
+
+	for _, m := range sc.models {
+		sc.write(m.output(), m.step(dt, sc.read))
+	}
+
+	for _, r := range sc.rules {
+		if r.onWrite != "" {
+			continue
+		}
+		if sc.holds(r.when) {
+			if r.since.IsZero() {
+				r.since = now
+			}
+			if !r.active && now.Sub(r.since) >= r.hold {
+				r.active = true
+				sc.run(r.then, now)
+			}
+			continue
+		}
+		r.since = time.Time{}
+		if r.active {
+			r.active = false
+			sc.run(r.els, now)
+		}
+	}
+}
+
+func (sc *Scenario) runTimeline(now time.Time) {
+	elapsed := now.Sub(sc.start)
+	if sc.loop {
+		for elapsed >= time.Duration(sc.cycle+1)*sc.period {
+			// finish the current cycle before starting the next one
+			sc.runSteps(sc.period, now)
+			sc.cycle++
+			sc.next = 0
+		}
+		elapsed -= time.Duration(sc.cycle) * sc.period
+	}
+	sc.runSteps(elapsed, now)
+}
+
+func (sc *Scenario) runSteps(upTo time.Duration, now time.Time) {
+	for sc.next < len(sc.steps) && sc.steps[sc.next].at <= upTo {
+		sc.run(sc.steps[sc.next].act, now)
+		sc.next++
+	}
+}
+
+func (sc *Scenario) run(act *action, now time.Time) {
+	if act == nil {
+		return
+	}
+	if act.log != "" {
+		log.Printf("scenario %s: %s", sc.serverID, act.log)
+	}
+	if act.clear != "" {
+		sc.clearFault(act.clear)
+	}
+	if f := act.fault; f != nil {
+		sc.injectFault(f, now)
+	}
+	for _, sv := range act.set {
+		if m := sc.driven[sv.key]; m != nil {
+			m.reset(sv.value)
+		}
+		sc.write(sv.key, sv.value)
+	}
+}
+
+func (sc *Scenario) injectFault(f *fault, now time.Time) {
+	af := &activeFault{kind: f.kind, value: f.value}
+	if f.duration > 0 {
+		af.until = now.Add(f.duration)
+	}
+	if f.key != "" {
+		sc.mu.Lock()
+		sc.faults[f.key] = af
+		sc.mu.Unlock()
+		if f.kind == FaultStuck {
+			sc.write(f.key, f.value)
+		}
+		return
+	}
+	mf := modbus.Fault{}
+	switch f.kind {
+	case FaultNoResponse:
+		mf.NoResponse = true
+	case FaultException:
+		mf.Exception = byte(f.value)
+	case FaultDelay:
+		mf.Delay = f.delay
+	}
+	sc.comm = af
+	sc.plant.SetFault(mf)
+}
+
+func (sc *Scenario) clearFault(target string) {
+	if target == "comm" || target == "all" {
+		if sc.comm != nil {
+			sc.comm = nil
+			sc.plant.SetFault(modbus.Fault{})
+		}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	switch target {
+	case "comm":
+	case "all":
+		sc.faults = map[string]*activeFault{}
+	default:
+		delete(sc.faults, target)
+	}
+}
+
+func (sc *Scenario) expireFaults(now time.Time) {
+	if sc.comm != nil && !sc.comm.until.IsZero() && !now.Before(sc.comm.until) {
+		sc.clearFault("comm")
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for key, f := range sc.faults {
+		if !f.until.IsZero() && !now.Before(f.until) {
+			delete(sc.faults, key)
+		}
+	}
+}
+
+func (sc *Scenario) holds(conds []condition) bool {
+	for _, c := range conds {
+		v, err := sc.plant.ReadPoint(c.key)
+		if err != nil {
+			return false
+		}
+		var ok bool
+		switch c.op {
+		case ">":
+			ok = v > c.value
+		case ">=":
+			ok = v >= c.value
+		case "<":
+			ok = v < c.value
+		case "<=":
+			ok = v <= c.value
+		case "==":
+			ok = v == c.value
+		case "!=":
+			ok = v != c.value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (sc *Scenario) read(key string) float64 {
+	v, err := sc.plant.ReadPoint(key)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func (sc *Scenario) write(key string, v float64) {
+	v, ok := sc.Filter(key, v)
+	if !ok {
+		return
+	}
+	if err := sc.plant.WritePoint(key, v); err != nil {
+		log.Printf("scenario %s: write %s: %v", sc.serverID, key, err)
+	}
+}
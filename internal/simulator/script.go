@@ -0,0 +1,410 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	simclock "modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Script runs a Lua script bound to a device, or to one point of it, in a
+// sandbox: only the base (without file and code loading), string, table
+// and math libraries are available, plus
+//
+//	device.id, device.slave_id
+//	device.get(name) / device.set(name, value)      engineering values
+//	device.read(kind, address[, count])             raw registers
+//	device.write(kind, address, value_or_table)     raw registers
+//	sim.time() / sim.elapsed()                      seconds
+//	bit.band/bor/bxor/bnot/lshift/rshift            32-bit operations
+//	log(...)
+//
+// Raw access is limited to the registers covered by the device's points.
+// Every hook call runs under Timeout. Tick and OnWrite may be called from
+// different goroutines.
+type Script struct {
+	name    string
+	dev     collector.Device
+	point   *collector.Point
+	regs    Registers
+	tick    time.Duration
+	timeout time.Duration
+
+	points map[string]collector.Point
+	spans  []regSpan
+
+	mu    sync.Mutex
+	L     *lua.LState
+	clock simclock.Clock
+	start time.Time
+	last  time.Time
+	now   time.Time
+}
+
+// regSpan is a range [start, end) of registers of one kind.
+type regSpan struct {
+	kind       string
+	start, end int
+	point      string
+}
+
+// NewScript loads cfg for dev; point is nil for a device script. The
+// script's top-level code runs immediately; sim.elapsed counts from the
+// current time of clk.
+func NewScript(cfg collector.ScriptConfig, dev collector.Device, point *collector.Point, regs Registers, clk simclock.Clock) (*Script, error) {
+	src, name := cfg.Code, "inline"
+	if cfg.File != "" {
+		b, err := os.ReadFile(cfg.File)
+		if err != nil {
+			return nil, fmt.Errorf("read script: %w", err)
+		}
+		src, name = string(b), cfg.File
+	}
+	if strings.TrimSpace(src) == "" {
+		return nil, errors.New("script has no file or code")
+	}
+	start := clk.Now()
+	s := &Script{
+		name:    dev.DeviceID,
+		dev:     dev,
+		point:   point,
+		regs:    regs,
+		tick:    cfg.Tick,
+		timeout: cfg.Timeout,
+		points:  map[string]collector.Point{},
+		clock:   clk,
+		start:   start,
+		last:    start,
+		now:     start,
+	}
+	if point != nil {
+		s.name = PointKey(dev.DeviceID, point.Name)
+	}
+	if s.tick <= 0 {
+		s.tick = time.Second
+	}
+	if s.timeout <= 0 {
+		s.timeout = 100 * time.Millisecond
+	}
+	for _, p := range dev.Points {
+		s.points[p.Name] = p
+		kind := strings.ToLower(p.RegisterType)
+		n := 1
+		if kind == "holding" || kind == "input" {
+			n = WordCount(p)
+		}
+		s.spans = append(s.spans, regSpan{kind: kind, start: int(p.Address), end: int(p.Address) + n, point: p.Name})
+	}
+
+	s.L = lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 200, RegistrySize: 1024, RegistryMaxSize: 256 * 1024})
+	s.openLibs()
+	fn, err := s.L.LoadString(src)
+	if err != nil {
+		s.L.Close()
+		return nil, fmt.Errorf("load script %s: %w", name, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.call(fn, 0); err != nil {
+		s.L.Close()
+		return nil, fmt.Errorf("run script %s: %w", name, err)
+	}
+	s.L.Pop(s.L.GetTop())
+	return s, nil
+}
+
+// Tick returns the on_tick period.
+func (s *Script) Tick() time.Duration { return s.tick }
+
+// Close releases the interpreter.
+func (s *Script) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.L.Close()
+}
+
+// RunTick calls on_tick(t, dt) with the simulation time in seconds. For a
+// point script a returned number is written to the point.
+func (s *Script) RunTick(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dt := now.Sub(s.last).Seconds()
+	s.last, s.now = now, now
+	fn := s.L.GetGlobal("on_tick")
+	if fn == lua.LNil {
+		return nil
+	}
+	if err := s.call(fn, 1, unixSeconds(now), lua.LNumber(dt)); err != nil {
+		return fmt.Errorf("script %s on_tick: %w", s.name, err)
+	}
+	ret := s.L.Get(-1)
+	s.L.Pop(1)
+	if n, ok := ret.(lua.LNumber); ok && s.point != nil {
+		return WritePoint(s.regs, *s.point, float64(n))
+	}
+	return nil
+}
+
+// OnWrite calls on_write(name, value) for every point of the script
+// touched by a client write of quantity registers of kind at address.
+func (s *Script) OnWrite(kind string, address, quantity uint16) error {
+	from, to := int(address), int(address)+int(quantity)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn := s.L.GetGlobal("on_write")
+	if fn == lua.LNil {
+		return nil
+	}
+	s.now = s.clock.Now()
+	for _, sp := range s.spans {
+		if sp.kind != kind || sp.end <= from || to <= sp.start {
+			continue
+		}
+		if s.point != nil && sp.point != s.point.Name {
+			continue
+		}
+		v, err := ReadPoint(s.regs, s.points[sp.point])
+		if err != nil {
+			return err
+		}
+		if err := s.call(fn, 0, lua.LString(sp.point), lua.LNumber(v)); err != nil {
+			return fmt.Errorf("script %s on_write %s: %w", s.name, sp.point, err)
+		}
+	}
+	return nil
+}
+
+// call runs fn with a deadline; the caller holds s.mu.
+func (s *Script) call(fn lua.LValue, nret int, args ...lua.LValue) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.L.SetContext(ctx)
+	defer s.L.RemoveContext()
+	return s.L.CallByParam(lua.P{Fn: fn, NRet: nret, Protect: true}, args...)
+}
+
+func unixSeconds(t time.Time) lua.LNumber {
+	return lua.LNumber(float64(t.UnixNano()) / 1e9)
+}
+
+func (s *Script) openLibs() {
+	L := s.L
+	for _, lib := range []struct {
+		name string
+		fn   lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.fn))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "print", "collectgarbage", "getfenv", "setfenv"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	dev := L.NewTable()
+	dev.RawSetString("id", lua.LString(s.dev.DeviceID))
+	dev.RawSetString("slave_id", lua.LNumber(s.dev.SlaveID))
+	L.SetFuncs(dev, map[string]lua.LGFunction{
+		"get":   s.luaGet,
+		"set":   s.luaSet,
+		"read":  s.luaRead,
+		"write": s.luaWrite,
+	})
+	L.SetGlobal("device", dev)
+
+	sim := L.NewTable()
+	L.SetFuncs(sim, map[string]lua.LGFunction{
+		"time": func(L *lua.LState) int {
+			L.Push(unixSeconds(s.now))
+			return 1
+		},
+		"elapsed": func(L *lua.LState) int {
+			L.Push(lua.LNumber(s.now.Sub(s.start).Seconds()))
+			return 1
+		},
+	})
+	L.SetGlobal("sim", sim)
+
+	bit := L.NewTable()
+	L.SetFuncs(bit, map[string]lua.LGFunction{
+		"band":   bitOp(func(a, b uint32) uint32 { return a & b }),
+		"bor":    bitOp(func(a, b uint32) uint32 { return a | b }),
+		"bxor":   bitOp(func(a, b uint32) uint32 { return a ^ b }),
+		"lshift": bitOp(func(a, b uint32) uint32 { return a << (b & 31) }),
+		"rshift": bitOp(func(a, b uint32) uint32 { return a >> (b & 31) }),
+		"bnot": func(L *lua.LState) int {
+			L.Push(lua.LNumber(^uint32(L.CheckInt64(1))))
+			return 1
+		},
+	})
+	L.SetGlobal("bit", bit)
+
+	L.SetGlobal("log", L.NewFunction(func(L *lua.LState) int {
+		parts := make([]string, 0, L.GetTop())
+		for i := 1; i <= L.GetTop(); i++ {
+			parts = append(parts, L.ToStringMeta(L.Get(i)).String())
+		}
+		log.Printf("script %s: %s", s.name, strings.Join(parts, " "))
+		return 0
+	}))
+}
+
+// bitOp folds a binary operation over all arguments.
+func bitOp(op func(a, b uint32) uint32) lua.LGFunction {
+	return func(L *lua.LState) int {
+		acc := uint32(L.CheckInt64(1))
+		for i := 2; i <= L.GetTop(); i++ {
+			acc = op(acc, uint32(L.CheckInt64(i)))
+		}
+		L.Push(lua.LNumber(acc))
+		return 1
+	}
+}
+
+func (s *Script) checkPoint(L *lua.LState) collector.Point {
+	name := L.CheckString(1)
+	p, ok := s.points[name]
+	if !ok {
+		L.ArgError(1, fmt.Sprintf("unknown point %q on device %s", name, s.dev.DeviceID))
+	}
+	return p
+}
+
+func (s *Script) luaGet(L *lua.LState) int {
+	v, err := ReadPoint(s.regs, s.checkPoint(L))
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+	L.Push(lua.LNumber(v))
+	return 1
+}
+
+func (s *Script) luaSet(L *lua.LState) int {
+	p := s.checkPoint(L)
+	if err := WritePoint(s.regs, p, luaNumber(L, 2)); err != nil {
+		L.RaiseError("%v", err)
+	}
+	return 0
+}
+
+// checkRange validates raw access to count registers of kind at address.
+func (s *Script) checkRange(L *lua.LState, kind string, address, count int) {
+	for a := address; a < address+count; a++ {
+		ok := false
+		for _, sp := range s.spans {
+			if sp.kind == kind && sp.start <= a && a < sp.end {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			L.RaiseError("%s register %d is outside device %s", kind, a, s.dev.DeviceID)
+		}
+	}
+}
+
+func (s *Script) luaRead(L *lua.LState) int {
+	kind := strings.ToLower(L.CheckString(1))
+	address := L.CheckInt(2)
+	count := L.OptInt(3, 0)
+	n := count
+	if n <= 0 {
+		n = 1
+	}
+	s.checkRange(L, kind, address, n)
+	vals := make([]lua.LValue, n)
+	for i := range vals {
+		addr := uint16(address + i)
+		var err error
+		switch kind {
+		case "coil", "discrete":
+			var b bool
+			b, err = ReadBit(s.regs, kind, addr)
+			vals[i] = lua.LNumber(0)
+			if b {
+				vals[i] = lua.LNumber(1)
+			}
+		default:
+			var w uint16
+			w, err = ReadWord(s.regs, kind, addr)
+			vals[i] = lua.LNumber(w)
+		}
+		if err != nil {
+			L.RaiseError("%v", err)
+		}
+	}
+	if count <= 0 {
+		L.Push(vals[0])
+		return 1
+	}
+	t := L.CreateTable(n, 0)
+	for _, v := range vals {
+		t.Append(v)
+	}
+	L.Push(t)
+	return 1
+}
+
+func (s *Script) luaWrite(L *lua.LState) int {
+	kind := strings.ToLower(L.CheckString(1))
+	address := L.CheckInt(2)
+	var vals []float64
+	if t, ok := L.Get(3).(*lua.LTable); ok {
+		for i := 1; i <= t.Len(); i++ {
+			v, ok := t.RawGetInt(i).(lua.LNumber)
+			if !ok {
+				L.ArgError(3, "table must contain numbers")
+			}
+			vals = append(vals, float64(v))
+		}
+	} else {
+		vals = append(vals, luaNumber(L, 3))
+	}
+	s.checkRange(L, kind, address, len(vals))
+	for i, v := range vals {
+		addr := uint16(address + i)
+		var err error
+		switch kind {
+		case "coil", "discrete":
+			err = WriteBit(s.regs, kind, addr, v != 0)
+		default:
+			if v < 0 || v > 0xFFFF {
+				L.RaiseError("register value %v out of range", v)
+			}
+			err = WriteWord(s.regs, kind, addr, uint16(v))
+		}
+		if err != nil {
+			L.RaiseError("%v", err)
+		}
+	}
+	return 0
+}
+
+// luaNumber accepts a number or a boolean (1/0) argument.
+func luaNumber(L *lua.LState, n int) float64 {
+	switch v := L.Get(n).(type) {
+	case lua.LNumber:
+		return float64(v)
+	case lua.LBool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	L.TypeError(n, lua.LTNumber)
+	return 0
+}
@@ -3,6 +3,7 @@ package tasks
 import (
 	"context"
 
+	"modbus-simulator/internal/capture"
 	"modbus-simulator/internal/collector"
 )
 
@@ -13,6 +14,7 @@ type Options struct {
 	StorageEnabled bool
 	StorageDir     string
 	StorageQueue   int
+	Capture        string // capture every frame to this file, overrides system.capture
 }
 
 // InitAndRunCollector loads config, applies overrides, constructs the manager and runs it.
@@ -36,5 +38,13 @@ func InitAndRunCollector(ctx context.Context, opts Options) error {
 	}
 
 	mgr := &collector.Manager{Cfg: cfg}
+	if opts.Capture != "" {
+		w, err := capture.Create(opts.Capture, "")
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		mgr.Tap = w.Tap
+	}
 	return mgr.Run(ctx)
 }
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// memLines holds the in-memory serial ports by name.
+var memLines = struct {
+	sync.Mutex
+	ports map[string]*memEnd
+}{ports: map[string]*memEnd{}}
+
+// memEnd is one side of an in-memory serial line.
+type memEnd struct {
+	rx, tx *memBuffer
+	peer   *memEnd
+	opened int // open handles, guarded by memLines
+}
+
+// MemSerialPair registers two connected in-memory serial ports under the
+// names a and b, so simulators and collectors can be wired together in
+// tests without real or virtual ports: OpenSerial opens them like device
+// paths. As on a real line, bytes sent while the other side is not open
+// are lost. remove unregisters both names.
+func MemSerialPair(a, b string) (remove func()) {
+	ab, ba := newMemBuffer(), newMemBuffer()
+	ea := &memEnd{rx: ba, tx: ab}
+	eb := &memEnd{rx: ab, tx: ba}
+	ea.peer, eb.peer = eb, ea
+	memLines.Lock()
+	memLines.ports[a], memLines.ports[b] = ea, eb
+	memLines.Unlock()
+	return func() {
+		memLines.Lock()
+		defer memLines.Unlock()
+		if memLines.ports[a] == ea {
+			delete(memLines.ports, a)
+		}
+		if memLines.ports[b] == eb {
+			delete(memLines.ports, b)
+		}
+	}
+}
+
+// IsMemSerial reports whether name is an in-memory serial port.
+func IsMemSerial(name string) bool {
+	memLines.Lock()
+	defer memLines.Unlock()
+	return memLines.ports[name] != nil
+}
+
+// OpenMemSerial opens the in-memory serial port name.
+func OpenMemSerial(name string) (*MemPort, error) {
+	memLines.Lock()
+	defer memLines.Unlock()
+	end := memLines.ports[name]
+	if end == nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	end.opened++
+	return &MemPort{end: end, closed: make(chan struct{})}, nil
+}
+
+// MemPort is an open in-memory serial port. Reads block until data
+// arrives, the deadline passes or the port is closed.
+type MemPort struct {
+	end       *memEnd
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+func (p *MemPort) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	deadline := p.deadline
+	p.mu.Unlock()
+	return p.end.rx.read(b, deadline, p.closed)
+}
+
+func (p *MemPort) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	memLines.Lock()
+	listening := p.end.peer.opened > 0
+	memLines.Unlock()
+	if listening {
+		p.end.tx.write(b)
+	}
+	return len(b), nil
+}
+
+// SetDeadline sets the read deadline; writes never block.
+func (p *MemPort) SetDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.deadline = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *MemPort) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		memLines.Lock()
+		p.end.opened--
+		memLines.Unlock()
+	})
+	return nil
+}
+
+// memBuffer carries the bytes of one direction.
+type memBuffer struct {
+	mu     sync.Mutex
+	data   []byte
+	notify chan struct{} // closed when data arrives
+}
+
+func newMemBuffer() *memBuffer {
+	return &memBuffer{notify: make(chan struct{})}
+}
+
+func (m *memBuffer) write(b []byte) {
+	m.mu.Lock()
+	m.data = append(m.data, b...)
+	close(m.notify)
+	m.notify = make(chan struct{})
+	m.mu.Unlock()
+}
+
+func (m *memBuffer) read(b []byte, deadline time.Time, closed <-chan struct{}) (int, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		select {
+		case <-closed:
+			return 0, io.EOF
+		default:
+		}
+		m.mu.Lock()
+		if len(m.data) > 0 {
+			n := copy(b, m.data)
+			m.data = m.data[n:]
+			m.mu.Unlock()
+			return n, nil
+		}
+		notify := m.notify
+		m.mu.Unlock()
+		select {
+		case <-notify:
+		case <-timeout:
+			return 0, os.ErrDeadlineExceeded
+		case <-closed:
+			return 0, io.EOF
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package utils
+
+import "errors"
+
+// ErrPTYUnsupported is returned by OpenPTYPair on platforms without native
+// pseudo terminal pairs.
+var ErrPTYUnsupported = errors.New("native pty pairs are not supported on this platform")
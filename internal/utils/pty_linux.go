@@ -0,0 +1,146 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// OpenPTYPair creates two connected pseudo terminals in raw mode without
+// echo, like socat's pty,raw,echo=0 pair, and symlinks their slave devices
+// at pair.Link and pair.Peer. Bytes written to one side are read on the
+// other until Close, which also removes the links.
+func OpenPTYPair(pair SocatPair) (*PTYPair, error) {
+	p := &PTYPair{}
+	for _, link := range []string{pair.Link, pair.Peer} {
+		master, slave, err := openPTY()
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.masters = append(p.masters, master)
+		p.slaves = append(p.slaves, slave)
+		if err := replaceLink(slave.Name(), link); err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.links = append(p.links, link)
+	}
+	p.wg.Add(2)
+	go p.relay(p.masters[1], p.masters[0])
+	go p.relay(p.masters[0], p.masters[1])
+	return p, nil
+}
+
+// PTYPair is a virtual serial pair made of two pseudo terminals.
+type PTYPair struct {
+	masters []*os.File
+	slaves  []*os.File // kept open so the masters never read EIO
+	links   []string
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+func (p *PTYPair) relay(dst, src *os.File) {
+	defer p.wg.Done()
+	_, _ = io.Copy(dst, src)
+}
+
+// Close stops the relay, closes the terminals and removes the links.
+func (p *PTYPair) Close() error {
+	p.once.Do(func() {
+		for _, f := range p.masters {
+			f.Close()
+		}
+		p.wg.Wait()
+		for _, f := range p.slaves {
+			f.Close()
+		}
+		for _, link := range p.links {
+			os.Remove(link)
+		}
+	})
+	return nil
+}
+
+// openPTY opens a new pseudo terminal: posix_openpt, grantpt (a no-op
+// with devpts) and unlockpt, then its slave side in raw mode.
+func openPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	unlock := int32(0)
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlockpt: %w", err)
+	}
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("ptsname: %w", err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	if err := makeRaw(slave); err != nil {
+		slave.Close()
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// makeRaw switches the terminal to raw 8-bit mode without echo, as
+// cfmakeraw does.
+func makeRaw(f *os.File) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	return ioctl(f, syscall.TCSETS, unsafe.Pointer(&t))
+}
+
+// ioctl runs an ioctl without switching f to blocking mode.
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// replaceLink points link at target, replacing an earlier symlink.
+func replaceLink(target, link string) error {
+	if fi, err := os.Lstat(link); err == nil {
+		if fi.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("%s exists and is not a symlink", link)
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, link)
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package utils
+
+// OpenPTYPair is only implemented on Linux; elsewhere use socat, see
+// BuildSocatPairCmd.
+func OpenPTYPair(pair SocatPair) (*PTYPair, error) {
+	return nil, ErrPTYUnsupported
+}
+
+// PTYPair is a virtual serial pair made of two pseudo terminals.
+type PTYPair struct{}
+
+// Close does nothing.
+func (p *PTYPair) Close() error { return nil }
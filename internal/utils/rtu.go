@@ -26,7 +26,16 @@ func EnsureSerialDefaults(sp *SerialParams) {
     if sp.Timeout <= 0 { sp.Timeout = 10 * time.Second }
 }
 
+// OpenSerial opens a serial port, or an in-memory port registered with
+// MemSerialPair.
 func OpenSerial(sp SerialParams) (io.ReadWriteCloser, error) {
+    if IsMemSerial(sp.Address) {
+        p, err := OpenMemSerial(sp.Address)
+        if err != nil {
+            return nil, err
+        }
+        return p, nil
+    }
     EnsureSerialDefaults(&sp)
     sc := &serial.Config{
         Address:  sp.Address,
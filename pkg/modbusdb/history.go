@@ -0,0 +1,99 @@
+package modbusdb
+
+import (
+	"context"
+	"time"
+
+	dbpkg "modbus-simulator/internal/db"
+)
+
+// --------------------
+// History DTOs
+// --------------------
+
+// Resolutions accepted by HistoryQuery.Resolution; empty picks automatically.
+const (
+	ResolutionRaw = dbpkg.ResolutionRaw
+	Resolution1m  = dbpkg.Resolution1m
+	Resolution1h  = dbpkg.Resolution1h
+)
+
+type HistoryQuery struct {
+	ServerID   string
+	DeviceID   string
+	Name       string
+	From       time.Time
+	To         time.Time
+	Resolution string
+	Limit      int
+}
+
+type HistoryPoint struct {
+	ServerID  string    `json:"server_id"`
+	DeviceID  string    `json:"device_id"`
+	Name      string    `json:"name"`
+	Unit      string    `json:"unit"`
+	Timestamp time.Time `json:"timestamp"`
+	Min       float64   `json:"min"`
+	Max       float64   `json:"max"`
+	Avg       float64   `json:"avg"`
+	First     float64   `json:"first"`
+	Last      float64   `json:"last"`
+	Count     int64     `json:"count"`
+}
+
+type HistoryResult struct {
+	Resolution string         `json:"resolution"`
+	Points     []HistoryPoint `json:"points"`
+}
+
+// RetentionPolicy says how long each resolution is kept. Zero keeps forever.
+type RetentionPolicy struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+type PruneResult struct {
+	Raw    int64 `json:"raw"`
+	Minute int64 `json:"minute"`
+	Hour   int64 `json:"hour"`
+}
+
+// --------------------
+// History operations
+// --------------------
+
+// History returns point history over a time range. With an empty Resolution
+// the raw table is used for short ranges and the 1m/1h rollups for longer
+// ones or when raw data has already been pruned.
+func (c *Client) History(ctx context.Context, q HistoryQuery) (*HistoryResult, error) {
+	r, err := dbpkg.QueryHistory(ctx, c.db.ORM, dbpkg.HistoryQuery{
+		ServerID:   q.ServerID,
+		DeviceID:   q.DeviceID,
+		Name:       q.Name,
+		From:       q.From,
+		To:         q.To,
+		Resolution: q.Resolution,
+		Limit:      q.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &HistoryResult{Resolution: r.Resolution, Points: make([]HistoryPoint, 0, len(r.Points))}
+	for _, p := range r.Points {
+		out.Points = append(out.Points, HistoryPoint(p))
+	}
+	return out, nil
+}
+
+// ApplyRetention rolls up raw values into the 1m/1h tables and then prunes
+// each table according to p. lateness delays closing a minute bucket.
+func (c *Client) ApplyRetention(ctx context.Context, p RetentionPolicy, lateness time.Duration) (PruneResult, error) {
+	now := time.Now()
+	if err := dbpkg.RunRollups(ctx, c.db.ORM, now, lateness); err != nil {
+		return PruneResult{}, err
+	}
+	r, err := dbpkg.PruneHistory(ctx, c.db.ORM, now, dbpkg.RetentionPolicy(p))
+	return PruneResult(r), err
+}
@@ -6,6 +6,8 @@ import (
 
 	dbpkg "modbus-simulator/internal/db"
 	"modbus-simulator/internal/model"
+
+	"gorm.io/gorm"
 )
 // --------------------
 // Point DTOs
@@ -93,7 +95,12 @@ func (c *Client) SavePointValue(ctx context.Context, p *PointValue) error {
 		Value:        p.Value,
 		Timestamp:    p.Timestamp,
 	}
-	return c.db.SavePointValue(ctx, &mp)
+	return c.db.ORM.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := dbpkg.CreatePointValue(ctx, tx, &mp); err != nil {
+			return err
+		}
+		return dbpkg.UpsertLatestFromPointValues(ctx, tx, []model.PointValue{mp})
+	})
 }
 
 func (c *Client) SavePointValuesBatch(ctx context.Context, ps []PointValue, batchSize int) error {
@@ -115,10 +122,16 @@ func (c *Client) SavePointValuesBatch(ctx context.Context, ps []PointValue, batc
 			Timestamp:    p.Timestamp,
 		})
 	}
-	return dbpkg.InsertPointValuesBatch(ctx, c.db.ORM, arr, batchSize)
+	return c.db.ORM.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := dbpkg.InsertPointValuesBatch(ctx, tx, arr, batchSize); err != nil {
+			return err
+		}
+		return dbpkg.UpsertLatestFromPointValues(ctx, tx, arr)
+	})
 }
 
-// Latest points with optional filters (serverID/deviceID)
+// Latest points with optional filters (serverID/deviceID), read from
+// latest_datas_value. Saves through this client keep that table current.
 func (c *Client) LatestPoints(ctx context.Context, serverID, deviceID string) ([]PointLatest, error) {
 	pls, err := dbpkg.LatestPointsORM(ctx, c.db.ORM, serverID, deviceID)
 	if err != nil {
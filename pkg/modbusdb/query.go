@@ -0,0 +1,103 @@
+package modbusdb
+
+import (
+	"context"
+	"time"
+
+	dbpkg "modbus-simulator/internal/db"
+)
+
+// --------------------
+// Range query DTOs
+// --------------------
+
+// Aggregations accepted by Query.Aggs.
+const (
+	AggAvg   = dbpkg.AggAvg
+	AggMin   = dbpkg.AggMin
+	AggMax   = dbpkg.AggMax
+	AggSum   = dbpkg.AggSum
+	AggCount = dbpkg.AggCount
+	AggFirst = dbpkg.AggFirst
+	AggLast  = dbpkg.AggLast
+	AggDelta = dbpkg.AggDelta
+)
+
+// Gap-fill modes accepted by Query.Fill.
+const (
+	FillNone     = dbpkg.FillNone
+	FillNull     = dbpkg.FillNull
+	FillZero     = dbpkg.FillZero
+	FillPrevious = dbpkg.FillPrevious
+	FillLinear   = dbpkg.FillLinear
+)
+
+// PointRef selects points; empty fields match anything.
+type PointRef struct {
+	ServerID string `json:"server_id"`
+	DeviceID string `json:"device_id"`
+	Name     string `json:"name"`
+}
+
+// Query reads the selected points over [From, To). Without Aggs raw values
+// are returned under Values["value"]; with Aggs the values are grouped into
+// epoch-aligned buckets of Interval (zero: one bucket for the whole range).
+// Pass the previous result's NextCursor as Cursor to fetch the next page.
+type Query struct {
+	Points     []PointRef
+	From       time.Time
+	To         time.Time
+	Aggs       []string
+	Interval   time.Duration
+	Fill       string
+	Resolution string
+	Limit      int
+	Cursor     string
+}
+
+type QueryRow struct {
+	ServerID  string              `json:"server_id"`
+	DeviceID  string              `json:"device_id"`
+	Name      string              `json:"name"`
+	Timestamp time.Time           `json:"timestamp"`
+	Values    map[string]*float64 `json:"values"`
+}
+
+type QueryResult struct {
+	Resolution string     `json:"resolution"`
+	Rows       []QueryRow `json:"rows"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// --------------------
+// Range query operations
+// --------------------
+
+// Query runs a time-range query with optional aggregation, bucketing,
+// gap-fill and pagination. Aggregations read from the 1m/1h rollups when
+// they can answer exactly and from point_values otherwise.
+func (c *Client) Query(ctx context.Context, q Query) (*QueryResult, error) {
+	refs := make([]dbpkg.SeriesRef, 0, len(q.Points))
+	for _, p := range q.Points {
+		refs = append(refs, dbpkg.SeriesRef(p))
+	}
+	r, err := dbpkg.QueryRange(ctx, c.db.ORM, dbpkg.RangeQuery{
+		Points:     refs,
+		From:       q.From,
+		To:         q.To,
+		Aggs:       append([]string(nil), q.Aggs...),
+		Interval:   q.Interval,
+		Fill:       q.Fill,
+		Resolution: q.Resolution,
+		Limit:      q.Limit,
+		Cursor:     q.Cursor,
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &QueryResult{Resolution: r.Resolution, NextCursor: r.NextCursor, Rows: make([]QueryRow, 0, len(r.Rows))}
+	for _, row := range r.Rows {
+		out.Rows = append(out.Rows, QueryRow(row))
+	}
+	return out, nil
+}
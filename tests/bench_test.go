@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/bench"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/servermgr"
+)
+
+func TestBenchSimulator(t *testing.T) {
+	t.Parallel()
+	server := collector.ServerConfig{
+		ServerID:   "bench",
+		Protocol:   "rtu-over-tcp",
+		Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+		Enabled:    true,
+		Devices: []collector.Device{{DeviceID: "d", SlaveID: 1, Points: []collector.Point{
+			{Name: "p", RegisterType: "holding", Address: 0, DataType: "uint16"},
+		}}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(collector.RootConfig{Servers: []collector.ServerConfig{server}})
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("simulator did not start")
+	}
+
+	target := collector.ServerConfig{Protocol: "rtu-over-tcp", Timeout: time.Second, Connection: server.Connection}
+	mix, err := bench.ParseMix("3:3,write_multiple_registers:1,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := bench.Run(ctx, target, bench.Options{Clients: 3, Requests: 300, Mix: mix, MinSize: 1, MaxSize: 20, Span: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := report.Total
+	if total.Requests != 300 || total.OK != 300 || report.Clients != 3 || len(report.Functions) != 3 {
+		t.Fatalf("report %+v", report)
+	}
+	if l := total.Latency; l.Min <= 0 || l.Min > l.P50 || l.P50 > l.P99 || l.P99 > l.Max {
+		t.Fatalf("latency %+v", l)
+	}
+
+	// a rate limit paces all clients together
+	report, err = bench.Run(ctx, target, bench.Options{Clients: 4, Duration: 500 * time.Millisecond, Rate: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Total.Requests; n < 35 || n > 55 {
+		t.Fatalf("%d requests in 500ms at 100/s", n)
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded bench.Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total.Requests != report.Total.Requests || decoded.RateLimit != 100 || decoded.Functions[0].Name != "read_holding_registers" {
+		t.Fatalf("decoded %+v", decoded)
+	}
+}
+
+func TestBenchErrors(t *testing.T) {
+	t.Parallel()
+	// the fake device answers holding registers 100-109 and 200 only
+	device := collector.ServerConfig{Protocol: "modbus-tcp", Timeout: time.Second,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: fakeDevice(t)}}
+	mix, _ := bench.ParseMix("3,4")
+	report, err := bench.Run(context.Background(), device, bench.Options{Requests: 200, Mix: mix, Start: 100, Span: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := report.Total
+	if total.Requests != 200 || total.OK == 0 || total.Exceptions == 0 || total.OK+total.Exceptions != 200 {
+		t.Fatalf("totals %+v", total)
+	}
+	if total.ByCode["0x01 illegal function"] == 0 || total.ByCode["0x02 illegal data address"] == 0 {
+		t.Fatalf("exception codes %v", total.ByCode)
+	}
+	var text bytes.Buffer
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "0x02 illegal data address") {
+		t.Fatalf("text report:\n%s", text.String())
+	}
+
+	// a server that accepts and never answers times out; every timeout
+	// reconnects so a late answer cannot pair with the next request
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	silent := collector.ServerConfig{Protocol: "modbus-tcp", Timeout: 50 * time.Millisecond,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port}}
+	report, err = bench.Run(context.Background(), silent, bench.Options{Requests: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Total.Timeouts != 3 || report.Total.OK != 0 || report.Reconnects != 2 {
+		t.Fatalf("silent server report %+v", report)
+	}
+}
+
+func TestBenchParse(t *testing.T) {
+	t.Parallel()
+	ops, err := bench.ParseMix("3:70, 0x10:20, fc1, read_input_registers:0")
+	want := []bench.Op{{Function: 3, Weight: 70}, {Function: 16, Weight: 20}, {Function: 1, Weight: 1}, {Function: 4, Weight: 0}}
+	if err != nil || !reflect.DeepEqual(ops, want) {
+		t.Fatalf("mix %+v, %v", ops, err)
+	}
+	for _, bad := range []string{"", "43", "read_everything", "3:-1"} {
+		if _, err := bench.ParseMix(bad); err == nil {
+			t.Fatalf("mix %q accepted", bad)
+		}
+	}
+	if lo, hi, err := bench.ParseSize("1-125"); err != nil || lo != 1 || hi != 125 {
+		t.Fatalf("size %d-%d, %v", lo, hi, err)
+	}
+	if _, _, err := bench.ParseSize("10-5"); err == nil {
+		t.Fatal("size 10-5 accepted")
+	}
+}
@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/db"
+	"modbus-simulator/internal/model"
+)
+
+// bufferedStorage opens a storage in dir with the write-ahead buffer on.
+func bufferedStorage(t *testing.T, dir, fileType string, buf collector.BufferConfig) *collector.Storage {
+	t.Helper()
+	buf.Enabled = true
+	s, err := collector.NewStorage(collector.StorageConfig{
+		FileType:      fileType,
+		DBPath:        filepath.Join(dir, "data.sqlite"),
+		FlushInterval: 10 * time.Millisecond,
+		Buffer:        buf,
+	})
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+	return s
+}
+
+func handleValues(t *testing.T, s *collector.Storage, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := s.Handle(collector.PointValue{
+			ServerID: "srv", DeviceID: "dev", PointName: "counter", Register: "holding",
+			DataType: "uint16", Raw: uint16(i), Value: float64(i), Scale: 1,
+			Timestamp: time.Now(),
+		}); err != nil {
+			t.Fatalf("Handle %d failed: %v", i, err)
+		}
+	}
+}
+
+// jsonlValues returns the values written to collector.jsonl, in order.
+func jsonlValues(t *testing.T, dir string) []int {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, "collector.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out []int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var rec struct {
+			Value float64 `json:"value"`
+		}
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			t.Fatalf("bad jsonl line %q: %v", sc.Text(), err)
+		}
+		out = append(out, int(rec.Value))
+	}
+	return out
+}
+
+// dbValues returns the values stored in point_values, sorted.
+func dbValues(t *testing.T, dir string) []int {
+	t.Helper()
+	d, err := db.Open(filepath.Join(dir, "data.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	var vals []float64
+	if err := d.ORM.Model(&model.PointValue{}).Order("value").Pluck("value", &vals).Error; err != nil {
+		t.Fatal(err)
+	}
+	out := make([]int, len(vals))
+	for i, v := range vals {
+		out[i] = int(v)
+	}
+	return out
+}
+
+// setDBFailing makes every insert into point_values fail, or succeed again,
+// so the storage's SQLite sink falls behind the other sinks.
+func setDBFailing(t *testing.T, dir string, failing bool) {
+	t.Helper()
+	d, err := db.Open(filepath.Join(dir, "data.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	stmt := "DROP TRIGGER point_values_fail"
+	if failing {
+		stmt = "CREATE TRIGGER point_values_fail BEFORE INSERT ON point_values BEGIN SELECT RAISE(ABORT, 'disk full'); END"
+	}
+	if err := d.ORM.Exec(stmt).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func seq(from, to int) []int {
+	out := make([]int, 0, to-from)
+	for i := from; i < to; i++ {
+		out = append(out, i)
+	}
+	return out
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBufferTornTailRecovery(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	s := bufferedStorage(t, dir, "json", collector.BufferConfig{})
+	handleValues(t, s, 0, 5)
+	s.Close()
+
+	// a crash in the middle of an append leaves a partial record behind
+	segs, err := filepath.Glob(filepath.Join(dir, "wal", "*.wal"))
+	if err != nil || len(segs) == 0 {
+		t.Fatalf("no buffer segments: %v", err)
+	}
+	sort.Strings(segs)
+	f, err := os.OpenFile(segs[len(segs)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := make([]byte, 16, 21)
+	binary.LittleEndian.PutUint32(torn, 200)
+	torn = append(torn, `{"ser`...)
+	if _, err := f.Write(torn); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s = bufferedStorage(t, dir, "json", collector.BufferConfig{})
+	handleValues(t, s, 5, 8)
+	s.Close()
+
+	if got := jsonlValues(t, dir); !equalInts(got, seq(0, 8)) {
+		t.Fatalf("expected values 0-7 once each after recovery, got %v", got)
+	}
+}
+
+func TestBufferReplaysUnackedPerSink(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	s := bufferedStorage(t, dir, "json+db", collector.BufferConfig{})
+	handleValues(t, s, 0, 3)
+	waitFor(t, "first values in the database", func() bool { return len(dbValues(t, dir)) == 3 })
+	setDBFailing(t, dir, true)
+	handleValues(t, s, 3, 6)
+	waitFor(t, "values in collector.jsonl", func() bool { return len(jsonlValues(t, dir)) == 6 })
+	s.Close()
+
+	if got := dbValues(t, dir); !equalInts(got, seq(0, 3)) {
+		t.Fatalf("expected only values 0-2 stored before the restart, got %v", got)
+	}
+
+	// after a restart only the failed sink gets the backlog
+	setDBFailing(t, dir, false)
+	s = bufferedStorage(t, dir, "json+db", collector.BufferConfig{})
+	s.Close()
+	if got := dbValues(t, dir); !equalInts(got, seq(0, 6)) {
+		t.Fatalf("expected values 0-5 in the database after replay, got %v", got)
+	}
+	if got := jsonlValues(t, dir); !equalInts(got, seq(0, 6)) {
+		t.Fatalf("expected collector.jsonl untouched by the replay, got %v", got)
+	}
+}
+
+func TestBufferCapsDropOldest(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	caps := collector.BufferConfig{SegmentSize: 1024, MaxBytes: 4096}
+
+	s := bufferedStorage(t, dir, "db", caps)
+	setDBFailing(t, dir, true)
+	handleValues(t, s, 0, 100)
+	s.Close()
+
+	setDBFailing(t, dir, false)
+	s = bufferedStorage(t, dir, "db", caps)
+	s.Close()
+	got := dbValues(t, dir)
+	if len(got) == 0 || len(got) >= 50 {
+		t.Fatalf("expected the size cap to keep only the newest values, got %v", got)
+	}
+	if !equalInts(got, seq(100-len(got), 100)) {
+		t.Fatalf("expected the newest values to survive the cap, got %v", got)
+	}
+}
@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/servermgr"
+)
+
+// frameLog collects captured frames for inspection.
+type frameLog struct {
+	mu     sync.Mutex
+	frames []capture.Frame
+}
+
+func (l *frameLog) tap(f capture.Frame) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frames = append(l.frames, f)
+}
+
+// wait returns the frames once there are at least n.
+func (l *frameLog) wait(t *testing.T, n int) []capture.Frame {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		l.mu.Lock()
+		frames := append([]capture.Frame(nil), l.frames...)
+		l.mu.Unlock()
+		if len(frames) >= n {
+			return frames
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("captured fewer than %d frames", n)
+	return nil
+}
+
+func TestCapture(t *testing.T) {
+	t.Parallel()
+	device := collector.Device{DeviceID: "meter", SlaveID: 1, PollInterval: 100 * time.Millisecond, Points: []collector.Point{
+		{Name: "a", RegisterType: "holding", Address: 4, DataType: "uint16", Generator: &collector.GeneratorConfig{Type: "constant", Value: 7}},
+		{Name: "b", RegisterType: "holding", Address: 5, DataType: "uint16", Generator: &collector.GeneratorConfig{Type: "constant", Value: 8}},
+	}}
+	tcp := collector.ServerConfig{ServerID: "tcp", Protocol: "modbus-tcp", Enabled: true, Devices: []collector.Device{device},
+		Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)}}
+	rtu := collector.ServerConfig{ServerID: "rtu", Protocol: "rtu-over-tcp", Enabled: true, Devices: []collector.Device{device},
+		Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var servers frameLog
+	mgr := servermgr.NewManager(collector.RootConfig{Servers: []collector.ServerConfig{tcp, rtu}})
+	mgr.Tap = servers.tap
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("servers did not start")
+	}
+
+	var collected frameLog
+	for _, srv := range []collector.ServerConfig{tcp, rtu} {
+		srv.Timeout = time.Second
+		c := &collector.Collector{Server: srv, Device: device, Tap: collected.tap,
+			Handler: func(collector.PointValue) error { return nil }}
+		go c.Run(ctx)
+	}
+	frames := collected.wait(t, 8)
+	serverFrames := servers.wait(t, 8)
+
+	// the collector decodes the requests of both transports
+	seen := map[string]bool{}
+	for i, f := range frames {
+		if f.Direction != capture.Request {
+			continue
+		}
+		info := capture.Decode(f)
+		if info.Unit != 1 || info.Function != 0x03 || info.Address < 4 || info.Address > 5 || info.Quantity != 1 {
+			t.Fatalf("request %d decoded as %s", i, info)
+		}
+		line := capture.FormatLine(f)
+		if !strings.Contains(line, "unit=1") || !strings.Contains(line, "fc=0x03 read_holding_registers addr=") {
+			t.Fatalf("log line %q", line)
+		}
+		seen[f.Transport] = true
+	}
+	if !seen[capture.TCP] || !seen[capture.RTU] {
+		t.Fatalf("collector captured transports %v", seen)
+	}
+	for _, f := range frames {
+		if f.Direction == capture.Response {
+			if info := capture.Decode(f); info.ByteCount != 2 || !bytes.Equal(f.PDU()[2:], []byte{0, 7}) && !bytes.Equal(f.PDU()[2:], []byte{0, 8}) {
+				t.Fatalf("response decoded as %s, pdu % X", info, f.PDU())
+			}
+		}
+	}
+
+	// the servers tap both sides of every transaction, with endpoints
+	var requests, responses int
+	for _, f := range serverFrames {
+		switch f.Direction {
+		case capture.Request:
+			requests++
+		case capture.Response:
+			responses++
+		}
+		if f.Src == "" || f.Dst == "" || f.Time.IsZero() {
+			t.Fatalf("frame without endpoints or time: %+v", f)
+		}
+		if f.Transport == capture.TCP && f.Direction == capture.Request && !strings.HasPrefix(f.Dst, "127.0.0.1:") {
+			t.Fatalf("tcp request sent to %q", f.Dst)
+		}
+	}
+	if requests == 0 || responses == 0 {
+		t.Fatalf("servers captured %d requests and %d responses", requests, responses)
+	}
+
+	// a pcapng file holds a section header, the TCP and RTU interfaces and
+	// one enhanced packet block per frame
+	path := filepath.Join(t.TempDir(), "frames.pcapng")
+	w, err := capture.Create(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		w.Tap(f)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []uint32
+	var linkTypes []uint16
+	for off := 0; off < len(raw); {
+		if off+12 > len(raw) {
+			t.Fatalf("truncated block at %d", off)
+		}
+		typ, n := binary.LittleEndian.Uint32(raw[off:]), int(binary.LittleEndian.Uint32(raw[off+4:]))
+		if n%4 != 0 || off+n > len(raw) || binary.LittleEndian.Uint32(raw[off+n-4:]) != uint32(n) {
+			t.Fatalf("bad block length %d at %d", n, off)
+		}
+		if typ == 1 {
+			linkTypes = append(linkTypes, binary.LittleEndian.Uint16(raw[off+8:]))
+		}
+		if typ == 6 && binary.LittleEndian.Uint32(raw[off+8:]) == 0 {
+			// Modbus TCP is wrapped in IPv4 and TCP headers
+			if pkt := raw[off+28:]; pkt[0] != 0x45 || pkt[9] != 6 {
+				t.Fatalf("tcp frame is not an IPv4/TCP packet: % X", pkt[:20])
+			}
+		}
+		types = append(types, typ)
+		off += n
+	}
+	if len(types) != 3+len(frames) || types[0] != 0x0A0D0D0A {
+		t.Fatalf("block types %X for %d frames", types, len(frames))
+	}
+	if len(linkTypes) != 2 || linkTypes[0] != 101 || linkTypes[1] != 147 {
+		t.Fatalf("interface link types %v", linkTypes)
+	}
+	for _, typ := range types[3:] {
+		if typ != 6 {
+			t.Fatalf("block types %X", types)
+		}
+	}
+
+	// a log file holds one line per frame
+	logPath := filepath.Join(t.TempDir(), "frames.log")
+	lw, err := capture.Create(logPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range frames {
+		lw.Tap(f)
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	text, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(text)), "\n"); len(lines) != len(frames) || !strings.Contains(lines[0], " request ") {
+		t.Fatalf("log file:\n%s", text)
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/servermgr"
+)
+
+func TestSimClockEndToEnd(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	cfg := collector.RootConfig{
+		Frequency: map[string]time.Duration{"plant": time.Second},
+		Servers: []collector.ServerConfig{{
+			ServerID:   "plant",
+			Protocol:   "modbus-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: port},
+			Enabled:    true,
+			Devices: []collector.Device{{
+				DeviceID: "meter",
+				SlaveID:  1,
+				Points: []collector.Point{
+					{Name: "energy", RegisterType: "holding", Address: 0, DataType: "uint16", Scale: 1,
+						Generator: &collector.GeneratorConfig{Type: "ramp", Rate: 2, Max: 100}},
+					{Name: "uptime", RegisterType: "input", Address: 0, DataType: "uint16", Scale: 1,
+						Script: &collector.ScriptConfig{Code: "function on_tick() return math.floor(sim.elapsed()) end"}},
+				},
+			}},
+		}},
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := clock.NewManual(start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sims := servermgr.NewManager(cfg)
+	sims.Clock = clk
+	go sims.Run(ctx)
+	select {
+	case <-sims.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("servers did not start")
+	}
+
+	var mu sync.Mutex
+	got := map[string][]collector.PointValue{}
+	first := make(chan struct{})
+	var once sync.Once
+	col := &collector.Manager{Cfg: cfg, Clock: clk, OnValue: func(v collector.PointValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[v.PointName] = append(got[v.PointName], v)
+		if len(got["energy"]) > 0 && len(got["uptime"]) > 0 {
+			once.Do(func() { close(first) })
+		}
+		return nil
+	}}
+	go col.Run(ctx)
+	select {
+	case <-first:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector did not poll")
+	}
+
+	// every step applies the generator and the script before the
+	// collector polls, all at the same simulated instant
+	for i := 0; i < 5; i++ {
+		clk.Step(time.Second)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for name, rate := range map[string]float64{"energy": 2, "uptime": 1} {
+		vals := got[name]
+		if len(vals) != 6 {
+			t.Fatalf("%s: expected 6 polls, got %d", name, len(vals))
+		}
+		for i, v := range vals {
+			want := start.Add(time.Duration(i) * time.Second)
+			if v.Value != rate*float64(i) || !v.Timestamp.Equal(want) {
+				t.Fatalf("%s poll %d: expected %v at %s, got %v at %s", name, i, rate*float64(i), want, v.Value, v.Timestamp)
+			}
+		}
+	}
+
+	// a running clock at 100x fires a 1s timer about every 10ms
+	fast := clock.NewSim(start, 100)
+	ticks := make(chan time.Time, 10)
+	stop := fast.Every(ctx, time.Second, func(now time.Time) { ticks <- now })
+	wall := time.Now()
+	for i := 1; i <= 3; i++ {
+		select {
+		case now := <-ticks:
+			if now.Before(start.Add(time.Duration(i) * time.Second)) {
+				t.Fatalf("tick %d fired early at %s", i, now)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("sped up clock did not tick")
+		}
+	}
+	stop()
+	if time.Since(wall) > 2*time.Second {
+		t.Fatalf("expected 3 simulated seconds to take well under 2s, took %s", time.Since(wall))
+	}
+	fast.Pause()
+	paused := fast.Now()
+	time.Sleep(20 * time.Millisecond)
+	if !fast.Now().Equal(paused) {
+		t.Fatalf("expected a paused clock to stand still")
+	}
+}
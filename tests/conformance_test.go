@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/conformance"
+	"modbus-simulator/internal/modbus"
+)
+
+func TestConformanceServer(t *testing.T) {
+	t.Parallel()
+	s := modbus.NewServer()
+	// silence delimits RTU frames like on a line, so the server can answer
+	// requests it cannot frame by function code alone
+	s.SetRTUTiming(modbus.RTUTiming{FrameGaps: true})
+	tcpPort, rtuPort := freePort(t), freePort(t)
+	if err := s.Listen(fmt.Sprintf("127.0.0.1:%d", tcpPort)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ListenRTUOverTCP(fmt.Sprintf("127.0.0.1:%d", rtuPort), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	opts := conformance.Options{Address: 100, Timeout: 300 * time.Millisecond}
+	t.Run("tcp", func(t *testing.T) {
+		r := conformance.Test(t, collector.ServerConfig{Protocol: "modbus-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: tcpPort}}, opts)
+		if r.Passed != len(conformance.Catalog)-1 || r.Skipped != 1 {
+			t.Errorf("%d passed, %d skipped of %d", r.Passed, r.Skipped, len(conformance.Catalog))
+		}
+	})
+	t.Run("rtu-over-tcp", func(t *testing.T) {
+		r := conformance.Test(t, collector.ServerConfig{Protocol: "rtu-over-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: rtuPort}}, opts)
+		if r.Passed != len(conformance.Catalog)-2 || r.Skipped != 2 {
+			t.Errorf("%d passed, %d skipped of %d", r.Passed, r.Skipped, len(conformance.Catalog))
+		}
+	})
+}
+
+func TestConformanceReport(t *testing.T) {
+	t.Parallel()
+	// the fake device answers exception 02 outside holding registers
+	// 100-109 and 200, so the maximum quantity reads fail
+	device := collector.ServerConfig{Protocol: "modbus-tcp",
+		Connection: collector.Connection{Host: "127.0.0.1", Port: fakeDevice(t)}}
+	r, err := conformance.Run(context.Background(), device, conformance.Options{Address: 100, ReadOnly: true,
+		Checks: []string{"read-holding", "unsupported", "tcp-", "write-"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := map[string]string{}
+	for _, res := range r.Results {
+		status[res.ID] = res.Status
+	}
+	if r.OK() || status["read-holding-max"] != conformance.Fail || status["unsupported-function"] != conformance.Pass ||
+		status["tcp-transaction-id"] != conformance.Pass || status["write-coil-echo"] != conformance.Skip {
+		t.Fatalf("statuses %v", status)
+	}
+	if _, ok := status["read-input-max"]; ok || r.Passed+r.Failed+r.Skipped != len(r.Results) {
+		t.Fatalf("report %+v", r)
+	}
+
+	var text bytes.Buffer
+	if err := r.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text.String(), "FAIL  read-holding-max") || !strings.Contains(text.String(), "exception 02") {
+		t.Fatalf("text report:\n%s", text.String())
+	}
+	var out bytes.Buffer
+	if err := r.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded conformance.Report
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil || decoded.Failed != r.Failed || len(decoded.Results) != len(r.Results) {
+		t.Fatalf("decoded %+v, %v", decoded, err)
+	}
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/servermgr"
+)
+
+// apiCall sends a request to the control API and decodes the JSON answer
+// into out, failing unless the status is want.
+func apiCall(t *testing.T, base, method, path, body string, want int, out any) {
+	t.Helper()
+	req, err := http.NewRequest(method, base+path, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		t.Fatalf("%s %s: decode: %v", method, path, err)
+	}
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, want, raw)
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestControlAPI(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	csvA := filepath.Join(dir, "a.csv")
+	csvB := filepath.Join(dir, "b.csv")
+	if err := os.WriteFile(csvA, []byte("temperature\n21.5\n22.5\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(csvB, []byte("temperature\n40\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	points := []collector.Point{
+		{Name: "temperature", RegisterType: "holding", Address: 0, DataType: "float32"},
+		{Name: "setpoint", RegisterType: "holding", Address: 2, DataType: "uint16", Scale: 10},
+	}
+	cfg := collector.RootConfig{
+		Frequency: map[string]time.Duration{"a": time.Second},
+		Servers: []collector.ServerConfig{
+			{
+				ServerID:   "a",
+				Protocol:   "modbus-tcp",
+				Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+				Enabled:    true,
+				CSVFile:    csvA,
+				Devices:    []collector.Device{{DeviceID: "dev", SlaveID: 1, Points: points}},
+			},
+			{
+				ServerID:   "b",
+				Protocol:   "modbus-tcp",
+				Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+				Devices: []collector.Device{{DeviceID: "pump", SlaveID: 1, Points: []collector.Point{
+					{Name: "speed", RegisterType: "input", Address: 0, DataType: "uint16",
+						Generator: &collector.GeneratorConfig{Type: "constant", Value: 1450}},
+				}}},
+			},
+		},
+	}
+	clk := clock.NewManual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(cfg)
+	mgr.Clock = clk
+	mgr.DataDir = dir
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("servers did not start")
+	}
+	api := httptest.NewServer(mgr.Handler())
+	defer api.Close()
+	base := api.URL
+
+	var servers []servermgr.ServerStatus
+	apiCall(t, base, "GET", "/api/servers", "", 200, &servers)
+	if len(servers) != 2 || !servers[0].Running || servers[0].Source != "csv" || servers[1].Running {
+		t.Fatalf("unexpected servers %+v", servers)
+	}
+
+	var pv servermgr.PointValue
+	checkTemp := func(want float64) {
+		t.Helper()
+		apiCall(t, base, "GET", "/api/servers/a/points/dev/temperature", "", 200, &pv)
+		if pv.Key != "dev/temperature" || pv.Value != want {
+			t.Fatalf("temperature %+v, want %v", pv, want)
+		}
+	}
+	checkTemp(21.5)
+
+	// paused playback holds its row, then continues from it
+	apiCall(t, base, "POST", "/api/servers/a/playback/pause", "", 200, nil)
+	clk.Step(time.Second)
+	checkTemp(21.5)
+	apiCall(t, base, "POST", "/api/servers/a/playback/resume", "", 200, nil)
+	checkTemp(21.5)
+	clk.Step(time.Second)
+	checkTemp(22.5)
+
+	// points are written in engineering units, registers raw
+	apiCall(t, base, "PUT", "/api/servers/a/points/setpoint", `{"value": 12.5}`, 200, &pv)
+	if pv.Value != 12.5 {
+		t.Fatalf("setpoint %+v", pv)
+	}
+	var regs struct{ Values []uint16 }
+	apiCall(t, base, "GET", "/api/servers/a/registers/holding/2", "", 200, &regs)
+	if len(regs.Values) != 1 || regs.Values[0] != 125 {
+		t.Fatalf("setpoint register %v", regs.Values)
+	}
+	apiCall(t, base, "PUT", "/api/servers/a/registers/coil/10", `{"values": [1, 0, 1]}`, 200, nil)
+	apiCall(t, base, "GET", "/api/servers/a/registers/coil/10?count=3", "", 200, &regs)
+	if len(regs.Values) != 3 || regs.Values[0] != 1 || regs.Values[1] != 0 || regs.Values[2] != 1 {
+		t.Fatalf("coils %v", regs.Values)
+	}
+	apiCall(t, base, "GET", "/api/servers/a/registers/holding/65535?count=2", "", 400, nil)
+
+	// switch the data source at runtime
+	apiCall(t, base, "PUT", "/api/servers/a/source", `{"type": "csv", "csv_file": "`+csvB+`"}`, 200, nil)
+	checkTemp(40)
+	apiCall(t, base, "PUT", "/api/servers/a/source", `{"csv_file": "`+filepath.Join(dir, "missing.csv")+`"}`, 400, nil)
+	// only files below the data directory, symlinks resolved
+	outside := filepath.Join(t.TempDir(), "c.csv")
+	if err := os.WriteFile(outside, []byte("temperature\n99\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.csv")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{outside, filepath.Join(dir, "..", filepath.Base(filepath.Dir(outside)), "c.csv"), filepath.Join(dir, "link.csv")} {
+		apiCall(t, base, "PUT", "/api/servers/a/source", `{"csv_file": "`+name+`"}`, 403, nil)
+	}
+	apiCall(t, base, "PUT", "/api/servers/a/source", `{"replay": {"file": "`+outside+`"}}`, 403, nil)
+	checkTemp(40)
+	apiCall(t, base, "PUT", "/api/servers/a/source", `{"type": "none"}`, 200, nil)
+	clk.Step(time.Second)
+	checkTemp(40)
+	apiCall(t, base, "POST", "/api/servers/a/playback/pause", "", 409, nil)
+
+	// start the disabled server, stop and restart the other
+	apiCall(t, base, "POST", "/api/servers/b/start", "", 200, nil)
+	apiCall(t, base, "POST", "/api/servers/b/start", "", 409, nil)
+	var values []servermgr.PointValue
+	apiCall(t, base, "GET", "/api/servers/b/points", "", 200, &values)
+	if len(values) != 1 || values[0].Key != "pump/speed" || values[0].Value != 1450 {
+		t.Fatalf("pump points %+v", values)
+	}
+	apiCall(t, base, "POST", "/api/servers/a/stop", "", 200, nil)
+	apiCall(t, base, "GET", "/api/servers/a/points", "", 409, nil)
+	apiCall(t, base, "POST", "/api/servers/a/restart", "", 200, nil)
+	checkTemp(21.5)
+	apiCall(t, base, "GET", "/api/servers", "", 200, &servers)
+	if !servers[0].Running || !servers[1].Running {
+		t.Fatalf("unexpected servers %+v", servers)
+	}
+	apiCall(t, base, "POST", "/api/servers/nope/start", "", 404, nil)
+}
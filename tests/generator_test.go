@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/simulator"
+)
+
+func TestGenerators(t *testing.T) {
+	t.Parallel()
+	type sample struct {
+		at   time.Duration // since the first call
+		want float64
+	}
+	steps := []collector.GeneratorStep{{Value: 1, Duration: 2 * time.Second}, {Value: 2, Duration: 3 * time.Second}}
+	cases := []struct {
+		name    string
+		cfg     collector.GeneratorConfig
+		samples []sample
+	}{
+		{"constant", collector.GeneratorConfig{Type: "constant", Value: 5},
+			[]sample{{0, 5}, {time.Hour, 5}}},
+		{"ramp clamped", collector.GeneratorConfig{Type: "ramp", Value: 10, Rate: 2, Max: 20},
+			[]sample{{0, 10}, {3 * time.Second, 16}, {10 * time.Second, 20}}},
+		{"falling ramp", collector.GeneratorConfig{Type: "ramp", Value: 10, Rate: -1},
+			[]sample{{0, 10}, {15 * time.Second, -5}}},
+		{"sine", collector.GeneratorConfig{Type: "sine", Center: 50, Amplitude: 10, Period: 4 * time.Second},
+			[]sample{{0, 50}, {time.Second, 60}, {2 * time.Second, 50}, {3 * time.Second, 40}, {5 * time.Second, 60}}},
+		{"sine with phase", collector.GeneratorConfig{Type: "sine", Amplitude: 1, Period: 4 * time.Second, Phase: 0.25},
+			[]sample{{0, 1}, {2 * time.Second, -1}}},
+		{"square from min/max", collector.GeneratorConfig{Type: "square", Min: 0, Max: 10, Period: 10 * time.Second, Duty: 0.3},
+			[]sample{{0, 10}, {2 * time.Second, 10}, {3 * time.Second, 0}, {9 * time.Second, 0}, {10 * time.Second, 10}}},
+		{"sawtooth", collector.GeneratorConfig{Type: "sawtooth", Amplitude: 1, Period: 4 * time.Second},
+			[]sample{{0, -1}, {time.Second, -0.5}, {2 * time.Second, 0}, {3 * time.Second, 0.5}, {4 * time.Second, -1}}},
+		{"triangle", collector.GeneratorConfig{Type: "triangle", Amplitude: 2, Period: 4 * time.Second},
+			[]sample{{0, -2}, {time.Second, 0}, {2 * time.Second, 2}, {3 * time.Second, 0}}},
+		{"noise without stddev", collector.GeneratorConfig{Type: "noise", Value: 7},
+			[]sample{{0, 7}, {time.Second, 7}}},
+		{"steps loop", collector.GeneratorConfig{Type: "steps", Steps: steps},
+			[]sample{{0, 1}, {time.Second, 1}, {2 * time.Second, 2}, {4 * time.Second, 2}, {5 * time.Second, 1}, {7 * time.Second, 2}}},
+		{"steps once", collector.GeneratorConfig{Type: "steps", Steps: steps, Once: true},
+			[]sample{{0, 1}, {2 * time.Second, 2}, {time.Minute, 2}}},
+		{"counter rollover", collector.GeneratorConfig{Type: "counter", Value: 65530, Rate: 3, Rollover: 65536},
+			[]sample{{0, 65530}, {time.Second, 65533}, {2 * time.Second, 0}, {3 * time.Second, 3}, {1500*time.Millisecond + 3*time.Second, 7}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			g, err := simulator.NewGenerator(tc.cfg)
+			if err != nil {
+				t.Fatalf("NewGenerator failed: %v", err)
+			}
+			start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			clk := clock.NewManual(start)
+			for _, s := range tc.samples {
+				now := clk.Set(start.Add(s.at))
+				if got := g.Value(now); math.Abs(got-s.want) > 1e-9 {
+					t.Fatalf("at %v: got %v, want %v", s.at, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratorRandomSources(t *testing.T) {
+	t.Parallel()
+	run := func(cfg collector.GeneratorConfig, n int) []float64 {
+		g, err := simulator.NewGenerator(cfg)
+		if err != nil {
+			t.Fatalf("NewGenerator failed: %v", err)
+		}
+		clk := clock.NewManual(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+		out := make([]float64, n)
+		for i := range out {
+			out[i] = g.Value(clk.Step(time.Second))
+		}
+		return out
+	}
+
+	walk := collector.GeneratorConfig{Type: "random_walk", Value: 5, Step: 3, Min: 0, Max: 10, Seed: 42}
+	a, b := run(walk, 1000), run(walk, 1000)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("random walk with a seed is not reproducible at %d: %v != %v", i, a[i], b[i])
+		}
+		if a[i] < 0 || a[i] > 10 {
+			t.Fatalf("random walk left [0, 10] at %d: %v", i, a[i])
+		}
+	}
+
+	// steps far larger than the range, up to overflowing to infinity,
+	// still fold back into the bounds
+	for _, step := range []float64{1e6, 1e300, math.MaxFloat64} {
+		huge := collector.GeneratorConfig{Type: "random_walk", Value: 5, Step: step, Min: 0, Max: 10, Seed: 7}
+		for i, v := range run(huge, 100) {
+			if v < 0 || v > 10 || math.IsNaN(v) {
+				t.Fatalf("random walk with step %g left [0, 10] at %d: %v", step, i, v)
+			}
+		}
+	}
+
+	noise := collector.GeneratorConfig{Type: "noise", StdDev: 2, Seed: 1,
+		Source: &collector.GeneratorConfig{Type: "constant", Value: 50}}
+	samples := run(noise, 2000)
+	var sum, sq float64
+	for _, v := range samples {
+		sum += v
+	}
+	mean := sum / float64(len(samples))
+	for _, v := range samples {
+		sq += (v - mean) * (v - mean)
+	}
+	stddev := math.Sqrt(sq / float64(len(samples)))
+	if math.Abs(mean-50) > 0.3 || math.Abs(stddev-2) > 0.2 {
+		t.Fatalf("noise has mean %v and stddev %v, want 50 and 2", mean, stddev)
+	}
+}
@@ -255,3 +255,316 @@ func TestPointOperations(t *testing.T) {
 		t.Fatalf("expected stats JSON to contain device_points")
 	}
 }
+
+func TestHistoryRetention(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	// one value per minute for the last three full hours
+	end := time.Now().Truncate(time.Hour)
+	start := end.Add(-3 * time.Hour)
+	var batch []modbusdb.PointValue
+	for ts, i := start, 0; ts.Before(end); ts, i = ts.Add(time.Minute), i+1 {
+		batch = append(batch, modbusdb.PointValue{
+			ServerID:     "srv-history",
+			DeviceID:     "dev-history",
+			Name:         "flow",
+			RegisterType: "holding",
+			Value:        float64(i),
+			Timestamp:    ts,
+		})
+	}
+	if err := client.SavePointValuesBatch(ctx, batch, 100); err != nil {
+		t.Fatalf("SavePointValuesBatch failed: %v", err)
+	}
+
+	pruned, err := client.ApplyRetention(ctx, modbusdb.RetentionPolicy{Raw: time.Now().Sub(start) - 90*time.Minute}, 0)
+	if err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	if pruned.Raw == 0 {
+		t.Fatalf("expected raw rows to be pruned")
+	}
+
+	hours, err := client.History(ctx, modbusdb.HistoryQuery{DeviceID: "dev-history", From: start, To: end, Resolution: modbusdb.Resolution1h})
+	if err != nil {
+		t.Fatalf("History 1h failed: %v", err)
+	}
+	if len(hours.Points) != 3 {
+		t.Fatalf("expected 3 hourly buckets, got %d", len(hours.Points))
+	}
+	h := hours.Points[0]
+	if h.Count != 60 || h.Min != 0 || h.Max != 59 || h.First != 0 || h.Last != 59 || h.Avg != 29.5 {
+		t.Fatalf("unexpected first hour aggregate: %+v", h)
+	}
+
+	// the start of the range has been pruned from point_values, so the
+	// automatic choice must fall back to a rollup table
+	auto, err := client.History(ctx, modbusdb.HistoryQuery{DeviceID: "dev-history", Name: "flow", From: start, To: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("History auto failed: %v", err)
+	}
+	if auto.Resolution != modbusdb.Resolution1m || len(auto.Points) != 60 {
+		t.Fatalf("expected 60 points at 1m, got %d at %s", len(auto.Points), auto.Resolution)
+	}
+
+	recent, err := client.History(ctx, modbusdb.HistoryQuery{DeviceID: "dev-history", From: end.Add(-30 * time.Minute), To: end})
+	if err != nil {
+		t.Fatalf("History raw failed: %v", err)
+	}
+	if recent.Resolution != modbusdb.ResolutionRaw || len(recent.Points) != 30 {
+		t.Fatalf("expected 30 raw points, got %d at %s", len(recent.Points), recent.Resolution)
+	}
+}
+
+func TestRangeQuery(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	// one hour of minute samples: "level" has no data for minutes 20-29,
+	// "energy" is a counter that resets at minute 30
+	start := time.Now().Truncate(time.Hour).Add(-2 * time.Hour)
+	end := start.Add(time.Hour)
+	var batch []modbusdb.PointValue
+	for i := 0; i < 60; i++ {
+		ts := start.Add(time.Duration(i) * time.Minute)
+		if i < 20 || i >= 30 {
+			batch = append(batch, modbusdb.PointValue{ServerID: "srv-q", DeviceID: "dev-q", Name: "level", Value: float64(i), Timestamp: ts})
+		}
+		energy := float64(i * 10)
+		if i >= 30 {
+			energy = float64((i - 30) * 10)
+		}
+		batch = append(batch, modbusdb.PointValue{ServerID: "srv-q", DeviceID: "dev-q", Name: "energy", Value: energy, Timestamp: ts})
+	}
+	if err := client.SavePointValuesBatch(ctx, batch, 100); err != nil {
+		t.Fatalf("SavePointValuesBatch failed: %v", err)
+	}
+
+	level, err := client.Query(ctx, modbusdb.Query{
+		Points:   []modbusdb.PointRef{{DeviceID: "dev-q", Name: "level"}},
+		From:     start,
+		To:       end,
+		Aggs:     []string{modbusdb.AggAvg, modbusdb.AggCount},
+		Interval: 10 * time.Minute,
+		Fill:     modbusdb.FillLinear,
+	})
+	if err != nil {
+		t.Fatalf("Query level failed: %v", err)
+	}
+	if len(level.Rows) != 6 {
+		t.Fatalf("expected 6 buckets, got %d", len(level.Rows))
+	}
+	gap := level.Rows[2].Values
+	if *gap["count"] != 0 || gap["avg"] == nil || *gap["avg"] != 24.5 {
+		t.Fatalf("unexpected gap-filled bucket: avg=%v count=%v", gap["avg"], *gap["count"])
+	}
+
+	energy, err := client.Query(ctx, modbusdb.Query{
+		Points:   []modbusdb.PointRef{{Name: "energy"}},
+		From:     start,
+		To:       end,
+		Aggs:     []string{modbusdb.AggDelta},
+		Interval: 30 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Query energy failed: %v", err)
+	}
+	if len(energy.Rows) != 2 || *energy.Rows[0].Values["delta"] != 290 || *energy.Rows[1].Values["delta"] != 290 {
+		t.Fatalf("unexpected counter deltas: %+v", energy.Rows)
+	}
+
+	// rolled-up data must give the same answer as raw data
+	if _, err := client.ApplyRetention(ctx, modbusdb.RetentionPolicy{}, 0); err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	rolled, err := client.Query(ctx, modbusdb.Query{
+		Points:     []modbusdb.PointRef{{Name: "energy"}},
+		From:       start,
+		To:         end,
+		Aggs:       []string{modbusdb.AggDelta},
+		Interval:   30 * time.Minute,
+		Resolution: modbusdb.Resolution1m,
+	})
+	if err != nil {
+		t.Fatalf("Query rollup failed: %v", err)
+	}
+	if rolled.Resolution != modbusdb.Resolution1m || len(rolled.Rows) != 2 || *rolled.Rows[1].Values["delta"] != 290 {
+		t.Fatalf("unexpected rollup result: %+v", rolled)
+	}
+
+	// raw pages of 25 rows cover both series exactly once
+	seen := map[string]bool{}
+	q := modbusdb.Query{Points: []modbusdb.PointRef{{DeviceID: "dev-q"}}, From: start, To: end, Limit: 25}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatalf("pagination does not terminate")
+		}
+		page, err := client.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query page failed: %v", err)
+		}
+		for _, r := range page.Rows {
+			key := r.Name + r.Timestamp.String()
+			if seen[key] {
+				t.Fatalf("row returned twice: %s", key)
+			}
+			seen[key] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+	if len(seen) != 110 {
+		t.Fatalf("expected 110 raw rows, got %d", len(seen))
+	}
+
+	// a page that ends exactly on the last row has no next page
+	full, err := client.Query(ctx, modbusdb.Query{Points: []modbusdb.PointRef{{DeviceID: "dev-q"}}, From: start, To: end, Limit: 110})
+	if err != nil {
+		t.Fatalf("Query full page failed: %v", err)
+	}
+	if len(full.Rows) != 110 || full.NextCursor != "" {
+		t.Fatalf("expected 110 rows and no cursor, got %d rows, cursor %q", len(full.Rows), full.NextCursor)
+	}
+
+	// weekly buckets start on the Unix epoch's weekday, a Thursday
+	week := 7 * 24 * time.Hour
+	weekly, err := client.Query(ctx, modbusdb.Query{
+		Points:   []modbusdb.PointRef{{Name: "level"}},
+		From:     start.Add(-2 * week),
+		To:       end,
+		Aggs:     []string{modbusdb.AggCount},
+		Interval: week,
+	})
+	if err != nil {
+		t.Fatalf("Query weekly failed: %v", err)
+	}
+	if len(weekly.Rows) != 1 || weekly.Rows[0].Timestamp.Unix()%int64(week/time.Second) != 0 || weekly.Rows[0].Timestamp.UTC().Weekday() != time.Thursday {
+		t.Fatalf("unexpected weekly bucket: %+v", weekly.Rows)
+	}
+}
+
+func TestRangeQueryPagesMatchUnpaged(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	// a counter sampled every minute for two hours with a 40 minute gap, so
+	// filled buckets and counter steps fall on page boundaries
+	start := time.Now().Truncate(time.Hour).Add(-3 * time.Hour)
+	end := start.Add(2 * time.Hour)
+	var batch []modbusdb.PointValue
+	for i := 0; i < 120; i++ {
+		if i >= 30 && i < 70 {
+			continue
+		}
+		ts := start.Add(time.Duration(i) * time.Minute)
+		for _, name := range []string{"a", "b"} {
+			batch = append(batch, modbusdb.PointValue{ServerID: "srv-p", DeviceID: "dev-p", Name: name, Value: float64(i * 3), Timestamp: ts})
+		}
+	}
+	if err := client.SavePointValuesBatch(ctx, batch, 100); err != nil {
+		t.Fatalf("SavePointValuesBatch failed: %v", err)
+	}
+
+	// raw data, then minute rollups
+	if _, err := client.ApplyRetention(ctx, modbusdb.RetentionPolicy{}, 0); err != nil {
+		t.Fatalf("ApplyRetention failed: %v", err)
+	}
+	for _, c := range []struct{ res, fill string }{
+		{modbusdb.ResolutionRaw, modbusdb.FillPrevious},
+		{modbusdb.ResolutionRaw, modbusdb.FillLinear},
+		{modbusdb.ResolutionRaw, modbusdb.FillNone},
+		{modbusdb.Resolution1m, modbusdb.FillPrevious},
+		{modbusdb.Resolution1m, modbusdb.FillLinear},
+	} {
+		name := c.res + "/" + c.fill
+		q := modbusdb.Query{
+			Points:     []modbusdb.PointRef{{DeviceID: "dev-p"}},
+			From:       start,
+			To:         end,
+			Aggs:       []string{modbusdb.AggAvg, modbusdb.AggDelta},
+			Interval:   5 * time.Minute,
+			Fill:       c.fill,
+			Resolution: c.res,
+		}
+		full, err := client.Query(ctx, q)
+		if err != nil {
+			t.Fatalf("Query %s failed: %v", name, err)
+		}
+		var paged []modbusdb.QueryRow
+		q.Limit = 7
+		for pages := 0; ; pages++ {
+			if pages > 20 {
+				t.Fatalf("%s: pagination does not terminate", name)
+			}
+			page, err := client.Query(ctx, q)
+			if err != nil {
+				t.Fatalf("Query %s page failed: %v", name, err)
+			}
+			paged = append(paged, page.Rows...)
+			if page.NextCursor == "" {
+				break
+			}
+			q.Cursor = page.NextCursor
+		}
+		want, _ := json.Marshal(full.Rows)
+		got, _ := json.Marshal(paged)
+		if string(got) != string(want) {
+			t.Fatalf("%s: paged rows differ from the unpaged query:\n got %s\nwant %s", name, got, want)
+		}
+	}
+}
+
+func TestLatestPointsKeepNewest(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	now := time.Now().UTC()
+	point := func(v float64, ts time.Time) modbusdb.PointValue {
+		return modbusdb.PointValue{
+			ServerID:     "srv-latest",
+			DeviceID:     "dev-latest",
+			Name:         "temperature",
+			RegisterType: "holding",
+			DataType:     "float32",
+			Value:        v,
+			Timestamp:    ts,
+		}
+	}
+
+	newer := point(25, now)
+	if err := client.SavePointValue(ctx, &newer); err != nil {
+		t.Fatalf("SavePointValue failed: %v", err)
+	}
+	// A historical import arriving after the live value must not replace it.
+	older := []modbusdb.PointValue{point(10, now.Add(-time.Hour)), point(11, now.Add(-time.Minute))}
+	if err := client.SavePointValuesBatch(ctx, older, 100); err != nil {
+		t.Fatalf("SavePointValuesBatch failed: %v", err)
+	}
+
+	latest, err := client.LatestPoints(ctx, "srv-latest", "dev-latest")
+	if err != nil {
+		t.Fatalf("LatestPoints failed: %v", err)
+	}
+	if len(latest) != 1 || latest[0].Value != 25 || !latest[0].Timestamp.Equal(now) {
+		t.Fatalf("expected newest value 25 at %v, got %+v", now, latest)
+	}
+
+	newest := point(30, now.Add(time.Second))
+	if err := client.SavePointValue(ctx, &newest); err != nil {
+		t.Fatalf("SavePointValue failed: %v", err)
+	}
+	latest, err = client.LatestPoints(ctx, "srv-latest", "dev-latest")
+	if err != nil {
+		t.Fatalf("LatestPoints failed: %v", err)
+	}
+	if len(latest) != 1 || latest[0].Value != 30 {
+		t.Fatalf("expected newer value 30 to replace latest, got %+v", latest)
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/db"
+	"modbus-simulator/internal/servermgr"
+)
+
+// runPersisted starts a manager serving cfg, calls fn with a client and
+// stops the manager, waiting for its final state save.
+func runPersisted(t *testing.T, cfg collector.RootConfig, fn func(modbus.Client)) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	mgr := servermgr.NewManager(cfg)
+	done := make(chan error, 1)
+	go func() { done <- mgr.Run(ctx) }()
+	select {
+	case <-mgr.Ready():
+	case err := <-done:
+		t.Fatalf("manager exited: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not start")
+	}
+
+	conn := cfg.Servers[0].Connection
+	handler := modbus.NewTCPClientHandler(net.JoinHostPort(conn.Host, strconv.Itoa(conn.Port)))
+	handler.Timeout = time.Second
+	handler.SlaveId = 1
+	if err := handler.Connect(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	fn(modbus.NewClient(handler))
+	handler.Close()
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("manager exited: %v", err)
+	}
+}
+
+func persistConfig(t *testing.T, persistence collector.PersistenceConfig) collector.RootConfig {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	var cfg collector.RootConfig
+	cfg.System.Persistence = persistence
+	cfg.Servers = []collector.ServerConfig{{
+		ServerID:   "plc",
+		Protocol:   "modbus-tcp",
+		Enabled:    true,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: port},
+		Devices: []collector.Device{{
+			DeviceID: "plc1",
+			SlaveID:  1,
+			Points: []collector.Point{
+				{Name: "setpoint", RegisterType: "holding", Address: 5, DataType: "uint16"},
+				{Name: "scratch", RegisterType: "holding", Address: 20, DataType: "uint16"},
+				{Name: "enable", RegisterType: "coil", Address: 3},
+			},
+		}},
+	}}
+	return cfg
+}
+
+func TestPersistRegisterState(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	// file backend, only holding 0-9 and the coils are retentive
+	cfg := persistConfig(t, collector.PersistenceConfig{
+		Enabled:  true,
+		Path:     filepath.Join(dir, "state"),
+		Interval: -1,
+		Retentive: []collector.RetentiveRange{
+			{Table: "holding", Start: 0, Count: 10},
+			{Table: "coil"},
+		},
+	})
+	runPersisted(t, cfg, func(c modbus.Client) {
+		if _, err := c.WriteSingleRegister(5, 1234); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if _, err := c.WriteSingleRegister(20, 77); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		if _, err := c.WriteSingleCoil(3, 0xFF00); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	})
+	runPersisted(t, cfg, func(c modbus.Client) {
+		b, err := c.ReadHoldingRegisters(5, 1)
+		if err != nil || b[0] != 0x04 || b[1] != 0xD2 {
+			t.Fatalf("setpoint not restored: % X %v", b, err)
+		}
+		b, err = c.ReadHoldingRegisters(20, 1)
+		if err != nil || b[0] != 0 || b[1] != 0 {
+			t.Fatalf("non-retentive register restored: % X %v", b, err)
+		}
+		b, err = c.ReadCoils(3, 1)
+		if err != nil || b[0] != 1 {
+			t.Fatalf("coil not restored: % X %v", b, err)
+		}
+	})
+
+	// SQLite backend saving on every write
+	dbPath := filepath.Join(dir, "state.db")
+	cfg = persistConfig(t, collector.PersistenceConfig{
+		Enabled:  true,
+		Backend:  "sqlite",
+		Path:     dbPath,
+		Interval: -1,
+		OnWrite:  true,
+	})
+	runPersisted(t, cfg, func(c modbus.Client) {
+		if _, err := c.WriteMultipleRegisters(20, 2, []byte{0, 7, 0, 8}); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+		// saved by the write hook while the server keeps running
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			d, err := db.Open(dbPath)
+			if err != nil {
+				t.Fatalf("open db: %v", err)
+			}
+			data, err := d.LoadRegisterState(context.Background(), "plc")
+			d.Close()
+			if err == nil && data != nil {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("state not saved after write: %v", err)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	})
+	runPersisted(t, cfg, func(c modbus.Client) {
+		// by default only the declared points are retentive
+		b, err := c.ReadHoldingRegisters(20, 2)
+		if err != nil || b[1] != 7 || b[3] != 0 {
+			t.Fatalf("expected only the declared register restored from sqlite: % X %v", b, err)
+		}
+	})
+}
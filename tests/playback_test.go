@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/simulator"
+)
+
+// playbackCSV is 20s long: b is empty at 10s and c has no value before 20s.
+const playbackCSV = `ts,a,b,c
+2024-01-01 00:00:00,0,10,
+2024-01-01 00:00:10,10,,
+2024-01-01 00:00:20,20,30,5
+`
+
+func TestPlaybackTimestamps(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "recording.csv")
+	if err := os.WriteFile(path, []byte(playbackCSV), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	type sample struct {
+		at   time.Duration // since the player started
+		want map[string]float64
+	}
+	cases := []struct {
+		name    string
+		cfg     collector.PlaybackConfig
+		samples []sample
+	}{
+		{"loop step", collector.PlaybackConfig{},
+			[]sample{
+				{0, map[string]float64{"a": 0, "b": 10}},
+				{5 * time.Second, map[string]float64{"a": 0, "b": 10}},
+				{10 * time.Second, map[string]float64{"a": 10, "b": 10}},
+				{25 * time.Second, map[string]float64{"a": 20, "b": 30, "c": 5}},
+				{30 * time.Second, map[string]float64{"a": 0, "b": 10}},
+				{45 * time.Second, map[string]float64{"a": 10, "b": 10}},
+			}},
+		{"loop linear", collector.PlaybackConfig{Interpolation: "linear"},
+			[]sample{
+				{5 * time.Second, map[string]float64{"a": 5, "b": 10}},
+				{15 * time.Second, map[string]float64{"a": 15, "b": 20}},
+				{25 * time.Second, map[string]float64{"a": 10, "b": 20, "c": 5}},
+			}},
+		{"ping-pong", collector.PlaybackConfig{Mode: "ping_pong"},
+			[]sample{
+				{20 * time.Second, map[string]float64{"a": 20, "b": 30, "c": 5}},
+				{25 * time.Second, map[string]float64{"a": 10, "b": 10}},
+				{35 * time.Second, map[string]float64{"a": 0, "b": 10}},
+				{45 * time.Second, map[string]float64{"a": 0, "b": 10}},
+				{60 * time.Second, map[string]float64{"a": 20, "b": 30, "c": 5}},
+			}},
+		{"once holds the end", collector.PlaybackConfig{Mode: "once"},
+			[]sample{
+				{time.Hour, map[string]float64{"a": 20, "b": 30, "c": 5}},
+			}},
+		{"start offset", collector.PlaybackConfig{StartOffset: 10 * time.Second},
+			[]sample{
+				{0, map[string]float64{"a": 10, "b": 10}},
+				{10 * time.Second, map[string]float64{"a": 20, "b": 30, "c": 5}},
+				{25 * time.Second, map[string]float64{"a": 0, "b": 10}},
+			}},
+		{"speed", collector.PlaybackConfig{Speed: 2},
+			[]sample{
+				{5 * time.Second, map[string]float64{"a": 10, "b": 10}},
+				{15 * time.Second, map[string]float64{"a": 0, "b": 10}},
+			}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.TimestampColumn = "ts"
+			rec, err := simulator.LoadRecording(path, cfg, time.Second)
+			if err != nil {
+				t.Fatalf("LoadRecording failed: %v", err)
+			}
+			if rec.Skipped != 3 {
+				t.Fatalf("expected 3 empty cells skipped, got %d", rec.Skipped)
+			}
+			start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+			clk := clock.NewManual(start)
+			p, err := simulator.NewPlayer(rec, cfg, clk.Now())
+			if err != nil {
+				t.Fatalf("NewPlayer failed: %v", err)
+			}
+			for _, s := range tc.samples {
+				got := p.Values(clk.Set(start.Add(s.at)))
+				if len(got) != len(s.want) {
+					t.Fatalf("at %v: got %v, want %v", s.at, got, s.want)
+				}
+				for col, want := range s.want {
+					if v, ok := got[col]; !ok || math.Abs(v-want) > 1e-9 {
+						t.Fatalf("at %v: got %v, want %v", s.at, got, s.want)
+					}
+				}
+			}
+		})
+	}
+}
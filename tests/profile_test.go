@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/simulator"
+)
+
+func TestDeviceTemplates(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "profiles"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	profile := `
+vendor: acme
+slave_id: 9
+defaults: { register_type: input, data_type: uint16 }
+points:
+  - { name: temperature, address: 0, scale: 10 }
+  - { name: humidity, address: 1 }
+`
+	config := `
+templates: ["profiles"]
+servers:
+  - server_id: plant
+    protocol: modbus-tcp
+    enabled: true
+    devices:
+      - { device_id: meter_1, template: generic_meter, slave_id: 1 }
+      - device_id: meter_2
+        template: generic_meter
+        slave_id: 2
+        address_offset: 100
+        select: [voltage, energy]
+        points:
+          - { name: energy, address: 500, register_type: input, data_type: uint32 }
+          - { name: alarm, address: 3, register_type: discrete }
+      - { device_id: room, template: sensor }
+      - { device_id: drive, template: vfd, slave_id: 5 }
+`
+	if err := os.WriteFile(filepath.Join(dir, "profiles", "sensor.yaml"), []byte(profile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := collector.LoadYAML(path)
+	if err != nil {
+		t.Fatalf("LoadYAML failed: %v", err)
+	}
+	devs := cfg.Servers[0].Devices
+
+	if m := devs[0]; len(m.Points) != 6 || m.SlaveID != 1 || m.Points[0].DataType != "float32" || m.Points[0].Generator == nil {
+		t.Fatalf("unexpected generic_meter expansion: %+v", m)
+	}
+	m := devs[1]
+	if len(m.Points) != 3 || m.Points[0].Name != "voltage" || m.Points[0].Address != 100 {
+		t.Fatalf("expected selected voltage at 100, got %+v", m.Points)
+	}
+	// the device point overrides the fields it sets and inherits the rest
+	if e := m.Points[1]; e.Name != "energy" || e.Address != 600 || e.RegisterType != "input" ||
+		e.DataType != "uint32" || e.Scale != 10 || e.Unit != "kWh" || e.Generator == nil || e.Generator.Type != "counter" {
+		t.Fatalf("expected energy merged onto the template point, got %+v", e)
+	}
+	if a := m.Points[2]; a.Name != "alarm" || a.Address != 3 {
+		t.Fatalf("expected extra device point, got %+v", a)
+	}
+	if r := devs[2]; r.SlaveID != 9 || r.Vendor != "acme" || r.Points[0].RegisterType != "input" || r.Points[0].Scale != 10 {
+		t.Fatalf("unexpected custom profile expansion: %+v", r)
+	}
+	drive := devs[3]
+	if drive.Script == nil || drive.Script.Tick != 200*time.Millisecond {
+		t.Fatalf("expected the vfd device script, got %+v", drive.Script)
+	}
+
+	// the built-in VFD accelerates towards the setpoint at 10 Hz/s
+	regs := modbus.NewServer()
+	start := time.Unix(1700000000, 0)
+	sc, err := simulator.NewScript(*drive.Script, drive, nil, regs, clock.NewManual(start))
+	if err != nil {
+		t.Fatalf("NewScript failed: %v", err)
+	}
+	defer sc.Close()
+	point := func(name string) collector.Point {
+		for _, p := range drive.Points {
+			if p.Name == name {
+				return p
+			}
+		}
+		t.Fatalf("no point %s", name)
+		return collector.Point{}
+	}
+	_ = simulator.WritePoint(regs, point("run"), 1)
+	_ = simulator.WritePoint(regs, point("frequency_setpoint"), 30)
+	for i := 1; i <= 20; i++ {
+		if err := sc.RunTick(start.Add(time.Duration(i) * 200 * time.Millisecond)); err != nil {
+			t.Fatalf("RunTick failed: %v", err)
+		}
+	}
+	f, _ := simulator.ReadPoint(regs, point("output_frequency"))
+	rpm, _ := simulator.ReadPoint(regs, point("motor_speed"))
+	running, _ := simulator.ReadPoint(regs, point("running"))
+	if f != 30 || rpm != 900 || running != 1 {
+		t.Fatalf("expected 30 Hz, 900 rpm and running after 4s, got %v %v %v", f, rpm, running)
+	}
+
+	inv, ok := collector.BuiltinProfile("inverter")
+	if !ok {
+		t.Fatalf("missing built-in inverter profile")
+	}
+	if _, err := collector.ApplyProfile(collector.Device{DeviceID: "x", Select: []string{"missing"}}, inv); err == nil {
+		t.Fatalf("expected unknown selected point to be rejected")
+	}
+}
@@ -0,0 +1,164 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/repl"
+	"modbus-simulator/internal/servermgr"
+)
+
+func TestREPLSession(t *testing.T) {
+	t.Parallel()
+	constant := func(v float64) *collector.GeneratorConfig {
+		return &collector.GeneratorConfig{Type: "constant", Value: v}
+	}
+	server := collector.ServerConfig{
+		ServerID:   "plant",
+		Protocol:   "modbus-tcp",
+		Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+		Enabled:    true,
+		Devices: []collector.Device{
+			{DeviceID: "boiler", SlaveID: 1, Points: []collector.Point{
+				{Name: "temperature", RegisterType: "holding", Address: 10, DataType: "float32", ByteOrder: "CDAB", Unit: "C", Generator: constant(21.5)},
+				{Name: "level", RegisterType: "input", Address: 0, DataType: "uint16", Scale: 10, Generator: constant(4.2)},
+				{Name: "pump", RegisterType: "coil", Address: 5},
+			}},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(collector.RootConfig{Servers: []collector.ServerConfig{server}})
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("simulator did not start")
+	}
+	time.Sleep(200 * time.Millisecond) // let the generators write
+
+	var out bytes.Buffer
+	s := repl.NewSession(&out)
+	defer s.Close()
+	run := func(line string, want ...string) {
+		t.Helper()
+		out.Reset()
+		if err := s.Exec(ctx, line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+		for _, w := range want {
+			if !strings.Contains(out.String(), w) {
+				t.Fatalf("%s: output lacks %q:\n%s", line, w, out.String())
+			}
+		}
+	}
+
+	if err := s.Exec(ctx, "read hr 0"); err == nil || !strings.Contains(err.Error(), "not connected") {
+		t.Fatalf("read before connect: %v", err)
+	}
+	run("connect tcp://127.0.0.1:"+strconv.Itoa(server.Connection.Port), "connected to tcp://127.0.0.1")
+	run("read hr 10 as float32 CDAB", "hr 10 = 21.5")
+	run("read ir 0", "ir 0 = 42 (0x002A)")
+	run("write hr 100 1 2 3")
+	run("read hr 100..102", "hr 100 = 1", "hr 101 = 2", "hr 102 = 3")
+	run("write hr 200 -3.25 as float32", "wrote 2 register(s)")
+	run("read hr 200 as float32", "hr 200 = -3.25")
+	run("write coil 5 on")
+	run("read coil 4 2", "coil 4 = off", "coil 5 = on")
+	run("watch hr 100..101 every 50ms for 180ms", "100=1 101=2")
+	if n := strings.Count(out.String(), "\n"); n < 3 || n > 5 {
+		t.Fatalf("watch printed %d lines:\n%s", n, out.String())
+	}
+	if err := s.Exec(ctx, "read ir 0..2 as float32"); err == nil {
+		t.Fatal("range of 3 registers read as float32")
+	}
+	if err := s.Exec(ctx, "write ir 0 1"); err == nil {
+		t.Fatal("input register written")
+	}
+
+	// point names from the collector config
+	dir := t.TempDir()
+	cfg := "servers:\n  - server_id: plant\n    protocol: modbus-tcp\n    enabled: true\n    connection: {host: 127.0.0.1, port: " + strconv.Itoa(server.Connection.Port) + "}\n" +
+		"    devices:\n      - device_id: boiler\n        slave_id: 1\n        points:\n" +
+		"          - {name: temperature, register_type: holding, address: 10, data_type: float32, byte_order: CDAB, unit: C}\n" +
+		"          - {name: level, register_type: input, address: 0, data_type: uint16, scale: 10}\n" +
+		"          - {name: pump, register_type: coil, address: 5}\n"
+	if err := os.WriteFile(filepath.Join(dir, "plant.yaml"), []byte(cfg), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	run("load "+filepath.Join(dir, "plant.yaml"), "3 points loaded")
+	run("read temperature level", "connected to", "temperature (boiler, unit 1 hr 10) = 21.5 C", "level (boiler, unit 1 ir 0) = 4.2")
+	run("write boiler/temperature 30")
+	run("read hr 10 as float32 CDAB", "hr 10 = 30")
+	run("write pump off")
+	run("read pump", "= off")
+	run("unit 7")
+	if p := s.Prompt(); !strings.Contains(p, "u7") {
+		t.Fatalf("prompt %q", p)
+	}
+	if err := s.Exec(ctx, "quit"); err != repl.ErrQuit {
+		t.Fatalf("quit: %v", err)
+	}
+
+	// tab completion
+	complete := func(line, want string) {
+		t.Helper()
+		got, pos, ok := s.Complete(line, len(line), '\t')
+		if !ok || got != want || pos != len(want) {
+			t.Fatalf("complete %q = %q, %d, %v; want %q", line, got, pos, ok, want)
+		}
+	}
+	complete("wa", "watch ")
+	complete("read temp", "read temperature ")
+	complete("read hr 0 as fl", "read hr 0 as float32 ")
+	complete("read hr 0 as float32 CD", "read hr 0 as float32 CDAB ")
+	out.Reset()
+	if _, _, ok := s.Complete("write pump o", 12, '\t'); ok || !strings.Contains(out.String(), "off  on") {
+		t.Fatalf("ambiguous completion listed %q", out.String())
+	}
+	if _, _, ok := s.Complete("re", 2, 'x'); ok {
+		t.Fatal("completed on a key other than tab")
+	}
+}
+
+func TestREPLParseTarget(t *testing.T) {
+	t.Parallel()
+	for _, c := range []struct {
+		in       string
+		protocol string
+		host     string
+		port     int
+		serial   string
+	}{
+		{"tcp://10.0.0.5:1502", "modbus-tcp", "10.0.0.5", 1502, ""},
+		{"10.0.0.5", "modbus-tcp", "10.0.0.5", 502, ""},
+		{"rtu-over-tcp 0.0.0.0:5020", "rtu-over-tcp", "127.0.0.1", 5020, ""},
+		{"rtu:///dev/ttyUSB0?baud=19200&parity=E", "modbus-rtu", "", 0, "/dev/ttyUSB0"},
+		{"/dev/ttyS1 9600", "modbus-rtu", "", 0, "/dev/ttyS1"},
+	} {
+		srv, err := repl.ParseTarget(strings.Fields(c.in))
+		if err != nil {
+			t.Fatalf("%s: %v", c.in, err)
+		}
+		conn := srv.Connection
+		if srv.Protocol != c.protocol || conn.Host != c.host || conn.Port != c.port || conn.SerialPort != c.serial {
+			t.Fatalf("%s: %+v", c.in, srv)
+		}
+	}
+	if srv, _ := repl.ParseTarget([]string{"rtu:///dev/ttyUSB0?baud=19200&parity=E"}); srv.Connection.BaudRate != 19200 || srv.Connection.Parity != "E" {
+		t.Fatalf("serial options %+v", srv.Connection)
+	}
+	for _, bad := range []string{"tcp://host:99999", "rtu /dev/ttyS0 fast", "ftp://host"} {
+		if _, err := repl.ParseTarget(strings.Fields(bad)); err == nil {
+			t.Fatalf("%s accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/simulator"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	store, err := collector.NewStorage(collector.StorageConfig{
+		FileType:      "all",
+		DBPath:        filepath.Join(dir, "rec.sqlite"),
+		FlushInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+	t0 := time.Now().Add(-time.Minute).Truncate(time.Second)
+	record := func(server, device, name string, off time.Duration, v float64) {
+		t.Helper()
+		if err := store.Handle(collector.PointValue{
+			ServerID: server, DeviceID: device, PointName: name,
+			Register: "holding", DataType: "float32", ByteOrder: "CDAB",
+			Value: v, Raw: v, Scale: 1, Timestamp: t0.Add(off),
+		}); err != nil {
+			t.Fatalf("Handle failed: %v", err)
+		}
+	}
+	record("plant", "meter", "power", 0, 10)
+	record("plant", "meter", "voltage", 0, 230)
+	record("plant", "meter", "power", 2*time.Second, 30)
+	record("plant", "meter", "voltage", 4*time.Second, 231)
+	record("other", "meter", "power", time.Second, 99)
+	store.Close()
+
+	files := map[string]string{
+		"jsonl": filepath.Join(dir, "collector.jsonl"),
+		"csv":   filepath.Join(dir, "collector.csv"),
+		"db":    filepath.Join(dir, "rec.sqlite"),
+	}
+	for format, path := range files {
+		rec, err := simulator.LoadReplay(collector.ReplayConfig{File: path}, "plant")
+		if err != nil {
+			t.Fatalf("%s: LoadReplay failed: %v", format, err)
+		}
+		if len(rec.Columns) != 2 || rec.Length != 4*time.Second {
+			t.Fatalf("%s: expected 2 series over 4s, got %v over %s", format, rec.Columns, rec.Length)
+		}
+
+		start := time.Now()
+		player, err := simulator.NewPlayer(rec, collector.PlaybackConfig{
+			Speed: 2, Mode: simulator.ModeOnce, Interpolation: simulator.InterpolationLinear,
+		}, start)
+		if err != nil {
+			t.Fatalf("%s: NewPlayer failed: %v", format, err)
+		}
+		power := simulator.PointKey("meter", "power")
+		voltage := simulator.PointKey("meter", "voltage")
+		if v := player.Values(start.Add(500 * time.Millisecond)); v[power] != 20 || v[voltage] != 230.25 {
+			t.Fatalf("%s: unexpected values at 1s of recording: %v", format, v)
+		}
+		if v := player.Values(start.Add(time.Hour)); v[power] != 30 || v[voltage] != 231 {
+			t.Fatalf("%s: expected last values to be held, got %v", format, v)
+		}
+	}
+
+	words, err := simulator.EncodeWords(collector.Point{DataType: "float32", ByteOrder: "CDAB"}, 1.5)
+	if err != nil {
+		t.Fatalf("EncodeWords failed: %v", err)
+	}
+	if len(words) != 2 || words[0] != 0x0000 || words[1] != 0x3FC0 {
+		t.Fatalf("expected CDAB words [0000 3FC0], got %04X", words)
+	}
+}
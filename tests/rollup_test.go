@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/db"
+	"modbus-simulator/internal/model"
+)
+
+func TestRollupLateRows(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	d, err := db.Open(filepath.Join(t.TempDir(), "rollup.sqlite"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { _ = d.Close() })
+
+	// one value per minute for two full hours, rolled up right away
+	now := time.Now()
+	start := now.Truncate(time.Hour).Add(-3 * time.Hour)
+	var rows []model.PointValue
+	for i := 0; i < 120; i++ {
+		rows = append(rows, model.PointValue{ServerID: "srv", DeviceID: "dev", Name: "flow", Value: float64(i), Timestamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+	if err := db.InsertPointValuesBatch(ctx, d.ORM, rows, 0); err != nil {
+		t.Fatalf("InsertPointValuesBatch failed: %v", err)
+	}
+	if err := db.RunRollups(ctx, d.ORM, now, 0); err != nil {
+		t.Fatalf("RunRollups failed: %v", err)
+	}
+
+	// a replayed backlog lands behind both watermarks
+	late := []model.PointValue{{ServerID: "srv", DeviceID: "dev", Name: "flow", Value: 1000, Timestamp: start.Add(30 * time.Second)}}
+	if err := db.InsertPointValuesBatch(ctx, d.ORM, late, 0); err != nil {
+		t.Fatalf("InsertPointValuesBatch failed: %v", err)
+	}
+	single := model.PointValue{ServerID: "srv", DeviceID: "dev", Name: "flow", Value: -5, Timestamp: start.Add(61 * time.Minute)}
+	if err := db.CreatePointValue(ctx, d.ORM, &single); err != nil {
+		t.Fatalf("CreatePointValue failed: %v", err)
+	}
+
+	// pruning before the next rollup must keep the late rows
+	pruned, err := db.PruneHistory(ctx, d.ORM, now, db.RetentionPolicy{Raw: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("PruneHistory failed: %v", err)
+	}
+	if pruned.Raw != 120 {
+		t.Fatalf("expected 120 rolled-up raw rows pruned, got %d", pruned.Raw)
+	}
+
+	if err := db.RunRollups(ctx, d.ORM, now, 0); err != nil {
+		t.Fatalf("RunRollups failed: %v", err)
+	}
+	mins, err := db.QueryHistory(ctx, d.ORM, db.HistoryQuery{Name: "flow", From: start, To: start.Add(2 * time.Hour), Resolution: db.Resolution1m})
+	if err != nil {
+		t.Fatalf("QueryHistory 1m failed: %v", err)
+	}
+	if len(mins.Points) != 120 {
+		t.Fatalf("expected 120 minute buckets, got %d", len(mins.Points))
+	}
+	if m := mins.Points[0]; m.Count != 2 || m.Max != 1000 || m.First != 0 || m.Last != 1000 {
+		t.Fatalf("late row not merged into its minute: %+v", m)
+	}
+	if m := mins.Points[61]; m.Count != 2 || m.Min != -5 {
+		t.Fatalf("late single row not merged into its minute: %+v", m)
+	}
+
+	hours, err := db.QueryHistory(ctx, d.ORM, db.HistoryQuery{Name: "flow", From: start, To: start.Add(2 * time.Hour), Resolution: db.Resolution1h})
+	if err != nil {
+		t.Fatalf("QueryHistory 1h failed: %v", err)
+	}
+	if len(hours.Points) != 2 {
+		t.Fatalf("expected 2 hourly buckets, got %d", len(hours.Points))
+	}
+	if h := hours.Points[0]; h.Count != 61 || h.Max != 1000 {
+		t.Fatalf("late row not merged into its hour: %+v", h)
+	}
+	if h := hours.Points[1]; h.Count != 61 || h.Min != -5 {
+		t.Fatalf("late single row not merged into its hour: %+v", h)
+	}
+
+	// once merged, the late rows are pruned like any other
+	pruned, err = db.PruneHistory(ctx, d.ORM, now, db.RetentionPolicy{Raw: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("PruneHistory failed: %v", err)
+	}
+	if pruned.Raw != 2 {
+		t.Fatalf("expected the 2 merged late rows pruned, got %d", pruned.Raw)
+	}
+}
+
+// TestRollupLateRowsOutsideUTC reruns TestRollupLateRows in a process whose
+// local zone is not UTC: SQLite compares stored time strings, so buckets
+// written with an offset would not match the ones late rows merge into.
+func TestRollupLateRowsOutsideUTC(t *testing.T) {
+	t.Parallel()
+	if os.Getenv("ROLLUP_TZ_CHILD") != "" {
+		t.Skip("already running in the child process")
+	}
+	if _, err := time.LoadLocation("Asia/Kolkata"); err != nil {
+		t.Skipf("no zone database: %v", err)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRollupLateRows$", "-test.count=1")
+	cmd.Env = append(os.Environ(), "TZ=Asia/Kolkata", "ROLLUP_TZ_CHILD=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("TestRollupLateRows failed with TZ=Asia/Kolkata: %v\n%s", err, out)
+	}
+}
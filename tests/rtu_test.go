@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/config"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/servermgr"
+)
+
+// rtuRequest sends one RTU frame over conn and returns the answer, or nil
+// when none arrives in time.
+func rtuRequest(t *testing.T, conn net.Conn, frame []byte) []byte {
+	t.Helper()
+	frame = binary.LittleEndian.AppendUint16(frame, modbus.CRC16(frame))
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil
+	}
+	resp := buf[:n]
+	if len(resp) < 4 || modbus.CRC16(resp[:len(resp)-2]) != binary.LittleEndian.Uint16(resp[len(resp)-2:]) {
+		t.Fatalf("bad response frame % X", resp)
+	}
+	return resp[:len(resp)-2]
+}
+
+func TestRTUOverTCPAndTOML(t *testing.T) {
+	t.Parallel()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "data.csv")
+	if err := os.WriteFile(csvPath, []byte("temperature,pump\n21.5,1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tomlPath := filepath.Join(dir, "config.toml")
+	toml := `
+csv_file = "` + csvPath + `"
+update_interval = "1s"
+
+[server]
+listen_address = "127.0.0.1:1502"
+slave_id = 2
+
+[[registers]]
+type = "holding"
+address = 4
+csv_column = "temperature"
+data_type = "float32"
+
+[[registers]]
+type = "coil"
+address = 1
+csv_column = "pump"
+`
+	if err := os.WriteFile(tomlPath, []byte(toml), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tc, err := config.Load(tomlPath)
+	if err != nil {
+		t.Fatalf("config.Load failed: %v", err)
+	}
+	cfg, err := tc.RootConfig(false)
+	if err != nil {
+		t.Fatalf("RootConfig failed: %v", err)
+	}
+	srv := &cfg.Servers[0]
+	if srv.Connection.Port != 1502 || srv.Devices[0].SlaveID != 2 || srv.Devices[0].Points[0].Name != "temperature" {
+		t.Fatalf("unexpected converted server: %+v", srv)
+	}
+	// serve the same registers as RTU-over-TCP
+	srv.Protocol = "rtu-over-tcp"
+	srv.Connection.Port = port
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(cfg)
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not start")
+	}
+
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(srv.Connection.Port)))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// float32 21.5 is 0x41AC0000
+	resp := rtuRequest(t, conn, []byte{2, 0x03, 0x00, 0x04, 0x00, 0x02})
+	if !bytes.Equal(resp, []byte{2, 0x03, 4, 0x41, 0xAC, 0x00, 0x00}) {
+		t.Fatalf("unexpected holding response % X", resp)
+	}
+	if resp := rtuRequest(t, conn, []byte{2, 0x01, 0x00, 0x01, 0x00, 0x01}); !bytes.Equal(resp, []byte{2, 0x01, 1, 0x01}) {
+		t.Fatalf("unexpected coil response % X", resp)
+	}
+	if resp := rtuRequest(t, conn, []byte{2, 0x04, 0xFF, 0xFF, 0x00, 0x02}); !bytes.Equal(resp, []byte{2, 0x84, 0x02}) {
+		t.Fatalf("expected illegal address exception, got % X", resp)
+	}
+	if resp := rtuRequest(t, conn, []byte{7, 0x03, 0x00, 0x04, 0x00, 0x02}); resp != nil {
+		t.Fatalf("expected other slave ids to be ignored, got % X", resp)
+	}
+
+	snaps, err := mgr.Snapshot()
+	if err != nil || len(snaps) != 1 || snaps[0].Devices[0].Points[1].ValueBool == nil || !*snaps[0].Devices[0].Points[1].ValueBool {
+		t.Fatalf("unexpected snapshot %+v: %v", snaps, err)
+	}
+}
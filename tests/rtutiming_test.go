@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/servermgr"
+)
+
+// withCRC appends the RTU CRC to frame.
+func withCRC(frame []byte) []byte {
+	return binary.LittleEndian.AppendUint16(append([]byte(nil), frame...), modbus.CRC16(frame))
+}
+
+// readFor returns everything conn receives within d.
+func readFor(t *testing.T, conn net.Conn, d time.Duration) []byte {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(d))
+	b, err := io.ReadAll(conn)
+	if ne, ok := err.(net.Error); err != nil && !(ok && ne.Timeout()) {
+		t.Fatalf("read failed: %v", err)
+	}
+	return b
+}
+
+func TestRTUTiming(t *testing.T) {
+	t.Parallel()
+	device := collector.Device{DeviceID: "meter", SlaveID: 1, Points: []collector.Point{
+		{Name: "a", RegisterType: "holding", Address: 0, DataType: "uint16", Generator: &collector.GeneratorConfig{Type: "constant", Value: 7}},
+		{Name: "b", RegisterType: "holding", Address: 1, DataType: "uint16", Generator: &collector.GeneratorConfig{Type: "constant", Value: 8}},
+		{Name: "c", RegisterType: "holding", Address: 2, DataType: "uint16", Generator: &collector.GeneratorConfig{Type: "constant", Value: 9}},
+	}}
+	gapPort, busPort := freePort(t), freePort(t)
+	cfg := collector.RootConfig{Servers: []collector.ServerConfig{
+		{
+			ServerID: "gaps",
+			Protocol: "rtu-over-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: gapPort, BaudRate: 9600,
+				FrameGaps: true, Turnaround: 40 * time.Millisecond},
+			Enabled: true,
+			Devices: []collector.Device{device},
+		},
+		{
+			ServerID: "bus",
+			Protocol: "rtu-over-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: busPort, BaudRate: 9600,
+				Turnaround: 100 * time.Millisecond, Echo: true, Collisions: true, FrameDelay: 60 * time.Millisecond},
+			Enabled: true,
+			Devices: []collector.Device{device},
+		},
+	}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(cfg)
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("servers did not start")
+	}
+	dial := func(port int) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	request := withCRC([]byte{1, 0x03, 0x00, 0x00, 0x00, 0x01})
+	answer := withCRC([]byte{1, 0x03, 2, 0x00, 7})
+
+	// frames end after 3.5 characters of silence and are answered after the turnaround
+	conn := dial(gapPort)
+	start := time.Now()
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(answer))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, answer) {
+		t.Fatalf("unexpected answer % X: %v", buf, err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("answered after %v, before the turnaround", d)
+	}
+	// a pause over 1.5 characters (1.7ms at 9600 baud) inside a frame discards it
+	if _, err := conn.Write(request[:3]); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(3 * time.Millisecond)
+	if _, err := conn.Write(request[3:]); err != nil {
+		t.Fatal(err)
+	}
+	if b := readFor(t, conn, 300*time.Millisecond); len(b) != 0 {
+		t.Fatalf("broken frame answered % X", b)
+	}
+	if _, err := conn.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if b := readFor(t, conn, 300*time.Millisecond); !bytes.Equal(b, answer) {
+		t.Fatalf("unexpected answer after broken frame % X", b)
+	}
+
+	// with echo every master hears the request and the answer
+	a, b := dial(busPort), dial(busPort)
+	time.Sleep(50 * time.Millisecond) // let the server register both masters
+	if _, err := a.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	want := append(append([]byte(nil), request...), answer...)
+	for name, c := range map[string]net.Conn{"a": a, "b": b} {
+		if got := readFor(t, c, 300*time.Millisecond); !bytes.Equal(got, want) {
+			t.Fatalf("master %s heard % X, want % X", name, got, want)
+		}
+	}
+	// a request during another master's transaction collides: both are lost
+	if _, err := a.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := b.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]net.Conn{"a": a, "b": b} {
+		if got := readFor(t, c, 400*time.Millisecond); !bytes.Equal(got, request) {
+			t.Fatalf("master %s heard % X after a collision, want only the first request", name, got)
+		}
+	}
+
+	// the collector keeps frame_delay between its frames and skips the echo
+	var mu sync.Mutex
+	var stamps []time.Time
+	values := map[string]float64{}
+	done := make(chan struct{})
+	col := &collector.Manager{Cfg: collector.RootConfig{Servers: cfg.Servers[1:]}, OnValue: func(v collector.PointValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		stamps = append(stamps, time.Now())
+		values[v.PointName] = v.Value
+		if len(stamps) == 3 {
+			close(done)
+		}
+		return nil
+	}}
+	colCtx, colCancel := context.WithCancel(ctx)
+	defer colCancel()
+	go col.Run(colCtx)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector did not poll")
+	}
+	colCancel()
+	mu.Lock()
+	defer mu.Unlock()
+	if values["a"] != 7 || values["b"] != 8 || values["c"] != 9 {
+		t.Fatalf("unexpected values %v", values)
+	}
+	for i := 1; i < 3; i++ {
+		// the next answer takes at least frame_delay plus the turnaround
+		if d := stamps[i].Sub(stamps[i-1]); d < 160*time.Millisecond {
+			t.Fatalf("frames %v apart", d)
+		}
+	}
+}
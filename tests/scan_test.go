@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/capture"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/scan"
+	"modbus-simulator/internal/servermgr"
+)
+
+// fakeDevice answers Modbus TCP requests to unit 1 like a device with
+// holding registers 100-109 and 200, no other tables and device
+// identification across two answers. Other units get a gateway exception.
+func fakeDevice(t *testing.T) int {
+	t.Helper()
+	return fakeDeviceWith(t, func(a int) bool { return a >= 100 && a < 110 || a == 200 })
+}
+
+// fakeDeviceWith is fakeDevice with the holding registers selected by
+// readable.
+func fakeDeviceWith(t *testing.T, readable func(int) bool) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	answer := func(unit byte, pdu []byte) []byte {
+		fc := pdu[0]
+		if unit != 1 {
+			return []byte{fc | 0x80, 0x0B}
+		}
+		switch fc {
+		case 0x03:
+			start, n := int(binary.BigEndian.Uint16(pdu[1:])), int(binary.BigEndian.Uint16(pdu[3:]))
+			out := []byte{fc, byte(2 * n)}
+			for a := start; a < start+n; a++ {
+				if !readable(a) {
+					return []byte{fc | 0x80, 0x02}
+				}
+				out = binary.BigEndian.AppendUint16(out, uint16(a))
+			}
+			return out
+		case 0x2B:
+			if pdu[3] == 0 {
+				return []byte{0x2B, 0x0E, pdu[2], 0x82, 0xFF, 0x02, 2, 0, 4, 'A', 'c', 'm', 'e', 1, 2, 'M', '1'}
+			}
+			return []byte{0x2B, 0x0E, pdu[2], 0x82, 0x00, 0x00, 1, 2, 3, '1', '.', '0'}
+		}
+		return []byte{fc | 0x80, 0x01}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				header := make([]byte, 7)
+				for {
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					pdu := make([]byte, binary.BigEndian.Uint16(header[4:])-1)
+					if _, err := io.ReadFull(conn, pdu); err != nil {
+						return
+					}
+					resp := answer(header[6], pdu)
+					binary.BigEndian.PutUint16(header[4:], uint16(len(resp)+1))
+					if _, err := conn.Write(append(header, resp...)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestScanFakeDevice(t *testing.T) {
+	t.Parallel()
+	srv := collector.ServerConfig{ServerID: "fake", Protocol: "modbus-tcp", Timeout: time.Second,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: fakeDevice(t)}}
+	link, err := collector.OpenLink(srv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer link.Close()
+	units, err := scan.Scan(context.Background(), link, scan.Options{Units: []uint8{1, 2}, Count: 300})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 1 || units[0].Slave != 1 {
+		t.Fatalf("found units %+v", units)
+	}
+	u := units[0]
+	want := []scan.Range{{Table: "holding", Start: 100, Count: 10}, {Table: "holding", Start: 200, Count: 1}}
+	if !reflect.DeepEqual(u.Ranges, want) {
+		t.Fatalf("ranges %+v", u.Ranges)
+	}
+	if u.Vendor() != "Acme" || len(u.Identity) != 3 || u.Identity[2].Name != "MajorMinorRevision" || u.Identity[2].Value != "1.0" {
+		t.Fatalf("identity %+v", u.Identity)
+	}
+
+	var out bytes.Buffer
+	if err := scan.WriteYAML(&out, []scan.Target{{Server: srv, Units: units}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"holding 100-109, holding 200-200", "VendorName: Acme", "vendor: Acme", "name: holding_200"} {
+		if !strings.Contains(out.String(), s) {
+			t.Fatalf("draft lacks %q:\n%s", s, out.String())
+		}
+	}
+}
+
+func TestScanRequestCount(t *testing.T) {
+	t.Parallel()
+	srv := collector.ServerConfig{ServerID: "fake", Protocol: "modbus-tcp", Timeout: time.Second,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: fakeDevice(t)}}
+	var requests atomic.Int64
+	link, err := collector.OpenLink(srv, func(f capture.Frame) {
+		if f.Direction == capture.Request {
+			requests.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer link.Close()
+	units, err := scan.Scan(context.Background(), link, scan.Options{Units: []uint8{1}, Tables: []string{"holding"}, Count: 10000, Stride: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scan.Range{{Table: "holding", Start: 100, Count: 10}, {Table: "holding", Start: 200, Count: 1}}
+	if len(units) != 1 || !reflect.DeepEqual(units[0].Ranges, want) {
+		t.Fatalf("found units %+v", units)
+	}
+	// 80 windows of 125 registers, almost all unreadable: about one probe
+	// every 8 addresses, far from the 249 requests a bisection costs each
+	if n := requests.Load(); n > 1500 {
+		t.Fatalf("scan of 10000 addresses took %d requests", n)
+	}
+}
+
+func TestScanShortRuns(t *testing.T) {
+	t.Parallel()
+	// runs shorter than a stride of 8: 1-5 lies between the probes at 0 and
+	// 8, 15-17 covers the probe at 16
+	port := fakeDeviceWith(t, func(a int) bool { return a >= 1 && a <= 5 || a >= 15 && a <= 17 })
+	srv := collector.ServerConfig{ServerID: "fake", Protocol: "modbus-tcp", Timeout: time.Second,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: port}}
+	link, err := collector.OpenLink(srv, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer link.Close()
+	opts := scan.Options{Units: []uint8{1}, Tables: []string{"holding"}, Count: 20}
+	units, err := scan.Scan(context.Background(), link, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []scan.Range{{Table: "holding", Start: 1, Count: 5}, {Table: "holding", Start: 15, Count: 3}}
+	if len(units) != 1 || !reflect.DeepEqual(units[0].Ranges, want) {
+		t.Fatalf("found units %+v", units)
+	}
+
+	// a wider stride only finds the runs that contain a probe
+	opts.Stride = 8
+	if units, err = scan.Scan(context.Background(), link, opts); err != nil {
+		t.Fatal(err)
+	}
+	want = []scan.Range{{Table: "holding", Start: 15, Count: 3}}
+	if len(units) != 1 || !reflect.DeepEqual(units[0].Ranges, want) {
+		t.Fatalf("found units %+v with stride 8", units)
+	}
+}
+
+func TestScanSimulator(t *testing.T) {
+	t.Parallel()
+	constant := func(v float64) *collector.GeneratorConfig {
+		return &collector.GeneratorConfig{Type: "constant", Value: v}
+	}
+	server := collector.ServerConfig{
+		ServerID:   "line",
+		Protocol:   "rtu-over-tcp",
+		Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+		Enabled:    true,
+		SlaveMaps:  true,
+		Devices: []collector.Device{
+			{DeviceID: "a", SlaveID: 1, Points: []collector.Point{
+				{Name: "setpoint", RegisterType: "holding", Address: 10, DataType: "uint16", Generator: constant(7)},
+				{Name: "level", RegisterType: "input", Address: 3, DataType: "uint16", Generator: constant(9)},
+			}},
+			{DeviceID: "b", SlaveID: 2, Points: []collector.Point{
+				{Name: "setpoint", RegisterType: "holding", Address: 20, DataType: "uint16", Generator: constant(5)},
+			}},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(collector.RootConfig{Servers: []collector.ServerConfig{server}})
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("simulator did not start")
+	}
+	time.Sleep(200 * time.Millisecond) // let the generators write
+
+	target := collector.ServerConfig{ServerID: "line", Protocol: "rtu-over-tcp", Timeout: 200 * time.Millisecond,
+		Connection: server.Connection}
+	link, err := collector.OpenLink(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer link.Close()
+	units, err := scan.Scan(ctx, link, scan.Options{Units: []uint8{1, 2, 3}, Count: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(units) != 2 || units[0].Slave != 1 || units[1].Slave != 2 {
+		t.Fatalf("found units %+v", units)
+	}
+	if units[0].Identity != nil {
+		t.Fatalf("simulator has no identification, got %+v", units[0].Identity)
+	}
+	if len(units[0].Ranges) != 4 || units[0].Ranges[0] != (scan.Range{Table: "holding", Start: 0, Count: 200}) {
+		t.Fatalf("ranges %+v", units[0].Ranges)
+	}
+
+	// the CSV draft loads as a devices_file and lists the non-zero values
+	dir := t.TempDir()
+	var csvOut bytes.Buffer
+	targets := []scan.Target{{Server: target, Units: units}}
+	if err := scan.WriteCSV(&csvOut, targets); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "devices.csv"), csvOut.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfgYAML := "servers:\n  - server_id: line\n    protocol: rtu-over-tcp\n    enabled: true\n    type: csvfile\n    devices_file: devices.csv\n"
+	if err := os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(cfgYAML), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := collector.LoadYAML(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := cfg.Servers[0].Devices
+	if len(devices) != 2 || devices[0].DeviceID != "unit1" || devices[1].SlaveID != 2 {
+		t.Fatalf("devices %+v", devices)
+	}
+	var names []string
+	for _, p := range devices[0].Points {
+		names = append(names, p.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"holding_10", "input_3"}) || devices[1].Points[0].Address != 20 {
+		t.Fatalf("points %v and %+v", names, devices[1].Points)
+	}
+
+	// the YAML draft is a collector config
+	var yamlOut bytes.Buffer
+	if err := scan.WriteYAML(&yamlOut, targets); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "draft.yaml"), yamlOut.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	draft, err := collector.LoadYAML(filepath.Join(dir, "draft.yaml"))
+	if err != nil {
+		t.Fatalf("load draft: %v\n%s", err, yamlOut.String())
+	}
+	got := draft.Servers[0]
+	if got.Connection.Port != server.Connection.Port || len(got.Devices) != 2 || got.Devices[0].PollInterval != 5*time.Second ||
+		got.Devices[0].Points[0].DataType != "uint16" {
+		t.Fatalf("draft server %+v", got)
+	}
+}
+
+func TestScanSpecs(t *testing.T) {
+	t.Parallel()
+	hosts, err := scan.Hosts("10.0.0.1-3,plc.local,10.0.1.0/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "plc.local", "10.0.1.1", "10.0.1.2"}
+	if !reflect.DeepEqual(hosts, want) {
+		t.Fatalf("hosts %v", hosts)
+	}
+	units, err := scan.ParseUnits("1-3,7,2")
+	if err != nil || !reflect.DeepEqual(units, []uint8{1, 2, 3, 7}) {
+		t.Fatalf("units %v, %v", units, err)
+	}
+	if _, err := scan.ParseUnits("0-5"); err == nil {
+		t.Fatal("unit 0 accepted")
+	}
+	if start, end, err := scan.ParseAddresses("40000-40099"); err != nil || start != 40000 || end != 40099 {
+		t.Fatalf("addresses %d-%d, %v", start, end, err)
+	}
+}
@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/simulator"
+)
+
+// memPlant is an in-memory simulator.Plant.
+type memPlant struct {
+	values map[string]float64
+	fault  modbus.Fault
+}
+
+func (p *memPlant) ReadPoint(key string) (float64, error) {
+	v, ok := p.values[key]
+	if !ok {
+		return 0, fmt.Errorf("unknown point %s", key)
+	}
+	return v, nil
+}
+
+func (p *memPlant) WritePoint(key string, v float64) error {
+	if _, ok := p.values[key]; !ok {
+		return fmt.Errorf("unknown point %s", key)
+	}
+	p.values[key] = v
+	return nil
+}
+
+func (p *memPlant) SetFault(f modbus.Fault) { p.fault = f }
+
+func TestScenarioTank(t *testing.T) {
+	t.Parallel()
+	srv := collector.ServerConfig{
+		ServerID: "plant",
+		Devices: []collector.Device{{
+			DeviceID: "tank",
+			Points: []collector.Point{
+				{Name: "pump", RegisterType: "coil", Address: 1},
+				{Name: "reset", RegisterType: "coil", Address: 2},
+				{Name: "level", RegisterType: "holding", Address: 10, DataType: "float32"},
+				{Name: "alarm", RegisterType: "discrete", Address: 1},
+				{Name: "temperature", RegisterType: "input", Address: 20},
+				{Name: "heater", RegisterType: "holding", Address: 30},
+			},
+		}},
+	}
+	cfg := collector.ScenarioConfig{
+		Timeline: []collector.ScenarioStep{
+			{At: 0, ScenarioAction: collector.ScenarioAction{Set: map[string]float64{"pump": 1}}},
+			{At: 30 * time.Second, ScenarioAction: collector.ScenarioAction{
+				Fault: &collector.FaultConfig{Point: "temperature", Type: simulator.FaultStuck, Value: 150, Duration: 10 * time.Second},
+			}},
+			{At: 35 * time.Second, ScenarioAction: collector.ScenarioAction{
+				Fault: &collector.FaultConfig{Type: simulator.FaultException},
+			}},
+		},
+		Rules: []collector.ScenarioRule{
+			{
+				Name: "high level",
+				When: []collector.ScenarioCondition{{Point: "level", Op: ">", Value: 90}},
+				Then: &collector.ScenarioAction{Set: map[string]float64{"alarm": 1, "pump": 0}},
+				Else: &collector.ScenarioAction{Set: map[string]float64{"alarm": 0}},
+			},
+			{
+				Name:    "reset",
+				OnWrite: "reset",
+				When:    []collector.ScenarioCondition{{Point: "reset", Op: "==", Value: 1}},
+				Then:    &collector.ScenarioAction{Set: map[string]float64{"level": 0}, ClearFault: "all"},
+			},
+		},
+		Models: []collector.ProcessModelConfig{
+			{Name: "tank", Type: "tank", Output: "level", Inputs: []collector.ModelInput{{Point: "pump", Gain: 5}}, Min: 0, Max: 100},
+			{Name: "heat", Type: "first_order", Output: "temperature", Inputs: []collector.ModelInput{{Point: "heater"}}, Bias: 20, TimeConstant: 5 * time.Second},
+			{Name: "ctl", Type: "pid", Output: "heater", Process: "temperature", Setpoint: 60, Kp: 2, Ki: 0.5, Min: 0, Max: 100},
+		},
+	}
+	plant := &memPlant{values: map[string]float64{}}
+	for _, p := range srv.Devices[0].Points {
+		plant.values[simulator.PointKey("tank", p.Name)] = 0
+	}
+	start := time.Unix(1700000000, 0)
+	sc, err := simulator.NewScenario(cfg, srv, plant, start)
+	if err != nil {
+		t.Fatalf("NewScenario failed: %v", err)
+	}
+	run := func(from, to time.Duration) {
+		for d := from; d <= to; d += 100 * time.Millisecond {
+			sc.Step(start.Add(d))
+		}
+	}
+	get := func(name string) float64 { return plant.values[simulator.PointKey("tank", name)] }
+
+	// pump fills the tank at 5 %/s until the alarm trips above 90 %
+	run(0, 10*time.Second)
+	if get("level") != 50 || get("alarm") != 0 {
+		t.Fatalf("expected level 50 without alarm at 10s, got level %v alarm %v", get("level"), get("alarm"))
+	}
+	run(10*time.Second+100*time.Millisecond, 29*time.Second)
+	if get("alarm") != 1 || get("pump") != 0 || get("level") < 90 || get("level") > 91 {
+		t.Fatalf("expected alarm and stopped pump just above 90, got level %v alarm %v pump %v", get("level"), get("alarm"), get("pump"))
+	}
+	if math.Abs(get("temperature")-60) > 1 {
+		t.Fatalf("expected PID to hold temperature near 60, got %v", get("temperature"))
+	}
+
+	// stuck sensor and communication fault
+	run(29*time.Second+100*time.Millisecond, 36*time.Second)
+	if get("temperature") != 150 || plant.fault.Exception != 4 {
+		t.Fatalf("expected stuck temperature and exception fault, got %v %+v", get("temperature"), plant.fault)
+	}
+	if sc.Drives(simulator.PointKey("tank", "temperature")) != true {
+		t.Fatalf("expected temperature to be driven by a model")
+	}
+
+	// a client write to reset empties the tank, clears faults and the alarm
+	plant.values[simulator.PointKey("tank", "reset")] = 1
+	sc.NotifyWrite(simulator.PointKey("tank", "reset"))
+	run(36*time.Second+100*time.Millisecond, 37*time.Second)
+	if get("level") != 0 || get("alarm") != 0 || plant.fault != (modbus.Fault{}) {
+		t.Fatalf("expected reset plant, got level %v alarm %v fault %+v", get("level"), get("alarm"), plant.fault)
+	}
+	if get("temperature") == 150 {
+		t.Fatalf("expected stuck fault to be cleared")
+	}
+
+	if _, err := simulator.NewScenario(collector.ScenarioConfig{
+		Rules: []collector.ScenarioRule{{When: []collector.ScenarioCondition{{Point: "missing"}}}},
+	}, srv, plant, start); err == nil {
+		t.Fatalf("expected unknown point to be rejected")
+	}
+}
@@ -0,0 +1,122 @@
+package tests
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"modbus-simulator/internal/clock"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/simulator"
+)
+
+func TestScriptHooks(t *testing.T) {
+	t.Parallel()
+	regs := modbus.NewServer()
+	dev := collector.Device{
+		DeviceID: "meter",
+		SlaveID:  3,
+		Points: []collector.Point{
+			{Name: "power", RegisterType: "holding", Address: 0, DataType: "float32", Scale: 10},
+			{Name: "status", RegisterType: "holding", Address: 2},
+			{Name: "checksum", RegisterType: "input", Address: 5},
+			{Name: "command", RegisterType: "holding", Address: 10},
+			{Name: "ack", RegisterType: "coil", Address: 1},
+			{Name: "counter", RegisterType: "input", Address: 20},
+		},
+	}
+	device := `
+state = "idle"
+function on_tick(t, dt)
+  device.set("power", 12.5)
+  local words = device.read("holding", 0, 3)
+  local sum = 0
+  for _, w in ipairs(words) do sum = bit.bxor(sum, w) end
+  device.write("input", 5, sum)
+end
+function on_write(name, value)
+  if name == "command" and value == 1 and state == "idle" then
+    state = "armed"
+    device.set("ack", true)
+  elseif name == "command" and value == 2 and state == "armed" then
+    state = "running"
+    device.set("status", 7)
+  end
+end
+`
+	start := time.Unix(1700000000, 0)
+	sc, err := simulator.NewScript(collector.ScriptConfig{Code: device}, dev, nil, regs, clock.NewManual(start))
+	if err != nil {
+		t.Fatalf("NewScript failed: %v", err)
+	}
+	defer sc.Close()
+
+	if err := sc.RunTick(start.Add(time.Second)); err != nil {
+		t.Fatalf("RunTick failed: %v", err)
+	}
+	power, _ := simulator.ReadPoint(regs, dev.Points[0])
+	hi, _ := regs.HoldingRegister(0)
+	lo, _ := regs.HoldingRegister(1)
+	sum, _ := regs.InputRegister(5)
+	if power != 12.5 || sum != hi^lo {
+		t.Fatalf("expected power 12.5 and checksum %04X, got %v and %04X", hi^lo, power, sum)
+	}
+
+	// handshake: 2 before 1 is ignored, then 1 arms and 2 starts
+	write := func(v uint16) {
+		t.Helper()
+		_ = regs.SetHoldingRegister(10, v)
+		if err := sc.OnWrite("holding", 10, 1); err != nil {
+			t.Fatalf("OnWrite failed: %v", err)
+		}
+	}
+	write(2)
+	if st, _ := regs.HoldingRegister(2); st != 0 {
+		t.Fatalf("expected status 0 before arming, got %d", st)
+	}
+	write(1)
+	write(2)
+	ack, _ := regs.Coil(1)
+	st, _ := regs.HoldingRegister(2)
+	if !ack || st != 7 {
+		t.Fatalf("expected ack and status 7, got %v %d", ack, st)
+	}
+
+	// a point script returns the point value
+	counter := `
+function on_tick(t, dt)
+  return math.floor(sim.elapsed() * 2)
+end
+`
+	pc, err := simulator.NewScript(collector.ScriptConfig{Code: counter}, dev, &dev.Points[5], regs, clock.NewManual(start))
+	if err != nil {
+		t.Fatalf("NewScript failed: %v", err)
+	}
+	defer pc.Close()
+	for i := 1; i <= 5; i++ {
+		if err := pc.RunTick(start.Add(time.Duration(i) * time.Second)); err != nil {
+			t.Fatalf("RunTick failed: %v", err)
+		}
+	}
+	if v, _ := regs.InputRegister(20); v != 10 {
+		t.Fatalf("expected counter 10, got %d", v)
+	}
+
+	// sandbox: no file access, registers outside the device, runaway loops
+	for name, code := range map[string]string{
+		"io":       `io.open("/etc/passwd")`,
+		"dofile":   `dofile("/etc/passwd")`,
+		"os":       `os.exit(1)`,
+		"outside":  `device.write("holding", 100, 1)`,
+		"deadline": `while true do end`,
+	} {
+		_, err := simulator.NewScript(collector.ScriptConfig{Code: code, Timeout: 50 * time.Millisecond}, dev, nil, regs, clock.NewManual(start))
+		if err == nil {
+			t.Fatalf("%s: expected sandboxed script to fail", name)
+		}
+		if name == "outside" && !strings.Contains(err.Error(), "outside device") {
+			t.Fatalf("%s: unexpected error %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/servermgr"
+	"modbus-simulator/internal/utils"
+)
+
+// pollOverSerial runs an RTU simulator on the simulator side of a serial
+// pair and returns the first value the collector reads from the other side.
+func pollOverSerial(t *testing.T, sim, col collector.Connection) float64 {
+	t.Helper()
+	device := collector.Device{DeviceID: "meter", SlaveID: 3, PollInterval: 100 * time.Millisecond, Points: []collector.Point{
+		{Name: "voltage", RegisterType: "holding", Address: 10, DataType: "float32",
+			Generator: &collector.GeneratorConfig{Type: "constant", Value: 230.5}},
+	}}
+	server := collector.ServerConfig{ServerID: "line", Protocol: "modbus-rtu", Connection: sim, Enabled: true,
+		Devices: []collector.Device{device}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(collector.RootConfig{Servers: []collector.ServerConfig{server}})
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("simulator did not start")
+	}
+
+	server.Connection = col
+	server.Timeout = time.Second
+	values := make(chan float64, 1)
+	c := &collector.Collector{Server: server, Device: device, Handler: func(v collector.PointValue) error {
+		select {
+		case values <- v.Value:
+		default:
+		}
+		return nil
+	}}
+	go c.Run(ctx)
+	select {
+	case v := <-values:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector read nothing")
+	}
+	return 0
+}
+
+func TestMemSerialPair(t *testing.T) {
+	t.Parallel()
+	remove := utils.MemSerialPair("mem-sim", "mem-col")
+	defer remove()
+	if v := pollOverSerial(t, collector.Connection{SerialPort: "mem-sim"}, collector.Connection{SerialPort: "mem-col"}); v != 230.5 {
+		t.Fatalf("collector read %v", v)
+	}
+}
+
+func TestNativePTYPair(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS != "linux" {
+		t.Skip("native pty pairs are Linux only")
+	}
+	if _, err := os.Stat("/dev/ptmx"); err != nil {
+		t.Skipf("no /dev/ptmx: %v", err)
+	}
+	dir := t.TempDir()
+	link, peer := filepath.Join(dir, "vport1"), filepath.Join(dir, "vport2")
+
+	pair, err := utils.OpenPTYPair(utils.SocatPair{Link: link, Peer: peer})
+	if err != nil {
+		t.Fatalf("OpenPTYPair: %v", err)
+	}
+	a, err := utils.OpenSerial(utils.SerialParams{Address: link, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := utils.OpenSerial(utils.SerialParams{Address: peer, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Write([]byte{0x01, 0x0A, 0x0D, 0xFF}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	n := 0
+	for n < 4 {
+		m, err := b.Read(buf[n:])
+		if err != nil {
+			t.Fatalf("read %d bytes: %v", n, err)
+		}
+		n += m
+	}
+	if string(buf[:4]) != "\x01\x0A\x0D\xFF" {
+		t.Fatalf("raw bytes changed to % X", buf[:4])
+	}
+	a.Close()
+	b.Close()
+	pair.Close()
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Fatalf("link not removed: %v", err)
+	}
+
+	// spawn_socat creates the pair natively, without socat installed
+	sim := collector.Connection{SerialPort: link, SpawnSocat: true, SocatPeer: peer}
+	if v := pollOverSerial(t, sim, collector.Connection{SerialPort: peer}); v != 230.5 {
+		t.Fatalf("collector read %v", v)
+	}
+}
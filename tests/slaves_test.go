@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/servermgr"
+)
+
+func TestMultiSlaveMaps(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	csvPath := filepath.Join(dir, "bus.csv")
+	if err := os.WriteFile(csvPath, []byte("meter1/power,meter2/power,power\n120,340,999\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	initial := func(v float64) *float64 { return &v }
+	meter := func(id string, slave uint8, serial, setpoint float64) collector.Device {
+		return collector.Device{DeviceID: id, SlaveID: slave, Points: []collector.Point{
+			{Name: "power", RegisterType: "holding", Address: 0, DataType: "uint16"},
+			{Name: "setpoint", RegisterType: "holding", Address: 5, DataType: "uint16", Scale: 10, Initial: initial(setpoint)},
+			{Name: "serial", RegisterType: "input", Address: 0, DataType: "uint16",
+				Generator: &collector.GeneratorConfig{Type: "constant", Value: serial}},
+		}}
+	}
+	port := freePort(t)
+	cfg := collector.RootConfig{Servers: []collector.ServerConfig{{
+		ServerID:   "bus",
+		Protocol:   "rtu-over-tcp",
+		Connection: collector.Connection{Host: "127.0.0.1", Port: port},
+		Enabled:    true,
+		CSVFile:    csvPath,
+		SlaveMaps:  true,
+		Devices:    []collector.Device{meter("meter1", 1, 1001, 1.5), meter("meter2", 2, 1002, 2.5)},
+	}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(cfg)
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not start")
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// same addresses, different values per slave
+	for _, c := range []struct {
+		slave                byte
+		power, setpoint, ser uint16
+	}{{1, 120, 15, 1001}, {2, 340, 25, 1002}} {
+		resp := rtuRequest(t, conn, []byte{c.slave, 0x03, 0x00, 0x00, 0x00, 0x06})
+		if len(resp) != 15 || resp[3] != byte(c.power>>8) || resp[4] != byte(c.power) || resp[13] != byte(c.setpoint>>8) || resp[14] != byte(c.setpoint) {
+			t.Fatalf("slave %d: unexpected holding response % X", c.slave, resp)
+		}
+		resp = rtuRequest(t, conn, []byte{c.slave, 0x04, 0x00, 0x00, 0x00, 0x01})
+		if !bytes.Equal(resp, []byte{c.slave, 0x04, 2, byte(c.ser >> 8), byte(c.ser)}) {
+			t.Fatalf("slave %d: unexpected input response % X", c.slave, resp)
+		}
+	}
+	if resp := rtuRequest(t, conn, []byte{3, 0x03, 0x00, 0x00, 0x00, 0x01}); resp != nil {
+		t.Fatalf("expected slave 3 to be silent, got % X", resp)
+	}
+
+	// a broadcast write reaches every slave and is not answered
+	if resp := rtuRequest(t, conn, []byte{0, 0x06, 0x00, 0x05, 0x00, 0x63}); resp != nil {
+		t.Fatalf("broadcast answered % X", resp)
+	}
+	for _, slave := range []uint8{1, 2} {
+		regs, err := mgr.ReadRegisters("bus", slave, "holding", 5, 1)
+		if err != nil || regs[0] != 99 {
+			t.Fatalf("slave %d setpoint %v: %v", slave, regs, err)
+		}
+	}
+
+	// points address the map of their device
+	if err := mgr.WritePoint("bus", "meter2/setpoint", 4); err != nil {
+		t.Fatal(err)
+	}
+	if resp := rtuRequest(t, conn, []byte{2, 0x03, 0x00, 0x05, 0x00, 0x01}); !bytes.Equal(resp, []byte{2, 0x03, 2, 0x00, 40}) {
+		t.Fatalf("unexpected meter2 setpoint % X", resp)
+	}
+	if pv, err := mgr.ReadPoint("bus", "meter1/setpoint"); err != nil || pv.Value != 9.9 {
+		t.Fatalf("meter1 setpoint %+v: %v", pv, err)
+	}
+	snaps, err := mgr.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []float64{120, 340} {
+		if p := snaps[0].Devices[i].Points[0]; p.Value == nil || *p.Value != want {
+			t.Fatalf("%s power snapshot %+v", snaps[0].Devices[i].DeviceID, p)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/model"
+	"modbus-simulator/internal/output"
+	"modbus-simulator/internal/servermgr"
+)
+
+func TestDecodedSnapshots(t *testing.T) {
+	t.Parallel()
+	constant := func(v float64) *collector.GeneratorConfig {
+		return &collector.GeneratorConfig{Type: "constant", Value: v}
+	}
+	cfg := collector.RootConfig{
+		Servers: []collector.ServerConfig{{
+			ServerID:   "plant",
+			Protocol:   "modbus-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+			Enabled:    true,
+			Devices: []collector.Device{{
+				DeviceID: "meter",
+				SlaveID:  1,
+				Points: []collector.Point{
+					{Name: "power", RegisterType: "holding", Address: 0, DataType: "float32", ByteOrder: "CDAB", Scale: 10, Offset: 2, Generator: constant(12.5)},
+					{Name: "energy", RegisterType: "input", Address: 10, DataType: "int32", Generator: constant(-70000)},
+					{Name: "temp", RegisterType: "holding", Address: 4, DataType: "int16", Scale: 10, Generator: constant(-1.5)},
+					{Name: "run", RegisterType: "coil", Address: 1, Generator: constant(1)},
+				},
+			}},
+		}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(cfg)
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not start")
+	}
+
+	// what the collector reads from the same server
+	var mu sync.Mutex
+	polled := map[string]float64{}
+	done := make(chan struct{})
+	var once sync.Once
+	col := &collector.Manager{Cfg: cfg, OnValue: func(v collector.PointValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		polled[v.PointName] = v.Value
+		if len(polled) == 4 {
+			once.Do(func() { close(done) })
+		}
+		return nil
+	}}
+	go col.Run(ctx)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector did not poll")
+	}
+
+	snaps, err := mgr.Snapshot()
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("snapshot: %v %+v", err, snaps)
+	}
+	want := map[string]struct {
+		value     float64
+		words     int
+		dataType  string
+		byteOrder string
+	}{
+		"power":  {12.5, 2, "float32", "CDAB"},
+		"energy": {-70000, 2, "int32", "ABCD"},
+		"temp":   {-1.5, 1, "int16", ""},
+		"run":    {1, 0, "bool", ""},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range snaps[0].Devices[0].Points {
+		w := want[p.Name]
+		if p.Value == nil || *p.Value != w.value || *p.Value != polled[p.Name] {
+			t.Fatalf("%s: snapshot value %v, collector %v, want %v", p.Name, p.Value, polled[p.Name], w.value)
+		}
+		if len(p.RawWords) != w.words || p.DataType != w.dataType || p.ByteOrder != w.byteOrder {
+			t.Fatalf("%s: unexpected snapshot %+v", p.Name, p)
+		}
+	}
+	// power: (12.5*10+2) = 127 = 0x42FE0000, words swapped by CDAB
+	if power := snaps[0].Devices[0].Points[0]; power.RawWords[0] != 0x0000 || power.RawWords[1] != 0x42FE {
+		t.Fatalf("power raw words %X", power.RawWords)
+	}
+
+	dir := t.TempDir()
+	jsonPath, csvPath := filepath.Join(dir, "snap.json"), filepath.Join(dir, "snap.csv")
+	if err := output.WriteJSON(jsonPath, snaps); err != nil {
+		t.Fatal(err)
+	}
+	if err := output.WriteCSV(csvPath, snaps); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back []model.ServerSnapshot
+	if err := json.Unmarshal(b, &back); err != nil {
+		t.Fatal(err)
+	}
+	if p := back[0].Devices[0].Points[1]; p.Value == nil || *p.Value != -70000 || p.DataType != "int32" || len(p.RawWords) != 2 {
+		t.Fatalf("unexpected json point %+v", p)
+	}
+
+	f, err := os.Open(csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols := map[string]int{}
+	for i, h := range rows[0] {
+		cols[h] = i
+	}
+	power := rows[1]
+	if power[cols["value"]] != "12.5" || power[cols["raw_words"]] != "0 17150" ||
+		power[cols["data_type"]] != "float32" || power[cols["byte_order"]] != "CDAB" {
+		t.Fatalf("unexpected csv row %v", power)
+	}
+}
+
+func TestSnapshotMatchesCollectorByteOrders(t *testing.T) {
+	t.Parallel()
+	constant := func(v float64) *collector.GeneratorConfig {
+		return &collector.GeneratorConfig{Type: "constant", Value: v}
+	}
+	// multi-word points in every byte order, scaled and offset
+	want := map[string]float64{}
+	var points []collector.Point
+	addr := uint16(0)
+	for _, dt := range []string{"float32", "int32", "uint32"} {
+		for _, order := range []string{"ABCD", "CDAB", "BADC", "DCBA"} {
+			name := dt + "_" + order
+			v := 12.34
+			if dt == "int32" {
+				v = -12.34
+			}
+			want[name] = v
+			points = append(points, collector.Point{Name: name, RegisterType: "holding", Address: addr, DataType: dt,
+				ByteOrder: order, Scale: 100, Offset: 7, Generator: constant(v)})
+			addr += 2
+		}
+	}
+	cfg := collector.RootConfig{
+		Servers: []collector.ServerConfig{{
+			ServerID:   "plant",
+			Protocol:   "modbus-tcp",
+			Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t)},
+			Enabled:    true,
+			Devices:    []collector.Device{{DeviceID: "meter", SlaveID: 1, Points: points}},
+		}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(cfg)
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not start")
+	}
+
+	var mu sync.Mutex
+	polled := map[string]float64{}
+	done := make(chan struct{})
+	var once sync.Once
+	col := &collector.Manager{Cfg: cfg, OnValue: func(v collector.PointValue) error {
+		mu.Lock()
+		defer mu.Unlock()
+		polled[v.PointName] = v.Value
+		if len(polled) == len(points) {
+			once.Do(func() { close(done) })
+		}
+		return nil
+	}}
+	go col.Run(ctx)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("collector did not poll")
+	}
+
+	snaps, err := mgr.Snapshot()
+	if err != nil || len(snaps) != 1 {
+		t.Fatalf("snapshot: %v %+v", err, snaps)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, p := range snaps[0].Devices[0].Points {
+		if p.Value == nil || *p.Value != polled[p.Name] {
+			t.Fatalf("%s: snapshot value %v, collector %v", p.Name, p.Value, polled[p.Name])
+		}
+		if math.Abs(*p.Value-want[p.Name]) > 1e-6 {
+			t.Fatalf("%s: value %v, want %v", p.Name, *p.Value, want[p.Name])
+		}
+	}
+}
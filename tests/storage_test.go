@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/db"
+	"modbus-simulator/internal/model"
+)
+
+func dbStorage(t *testing.T, dir string, batchSize int, flush time.Duration) *collector.Storage {
+	t.Helper()
+	s, err := collector.NewStorage(collector.StorageConfig{
+		FileType:      "db",
+		DBPath:        filepath.Join(dir, "data.sqlite"),
+		BatchSize:     batchSize,
+		FlushInterval: flush,
+	})
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+	return s
+}
+
+func TestStorageFlushBySize(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	s := dbStorage(t, dir, 3, time.Hour)
+	handleValues(t, s, 0, 3)
+	waitFor(t, "a full batch in the database", func() bool { return len(dbValues(t, dir)) == 3 })
+
+	// a partial batch waits for the interval, or for Close
+	handleValues(t, s, 3, 5)
+	time.Sleep(100 * time.Millisecond)
+	if got := dbValues(t, dir); !equalInts(got, seq(0, 3)) {
+		t.Fatalf("expected the partial batch to wait, got %v", got)
+	}
+	s.Close()
+	if got := dbValues(t, dir); !equalInts(got, seq(0, 5)) {
+		t.Fatalf("expected values 0-4 after Close, got %v", got)
+	}
+}
+
+func TestStorageFlushByInterval(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	s := dbStorage(t, dir, 100, 20*time.Millisecond)
+	defer s.Close()
+	handleValues(t, s, 0, 2)
+	waitFor(t, "a partial batch after the flush interval", func() bool { return len(dbValues(t, dir)) == 2 })
+}
+
+func TestInsertPointValuesBatchIsAtomic(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	d, err := db.Open(filepath.Join(dir, "data.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if err := d.ORM.Exec("CREATE TRIGGER point_values_fail BEFORE INSERT ON point_values WHEN NEW.value = 3 BEGIN SELECT RAISE(ABORT, 'bad row'); END").Error; err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	rows := make([]model.PointValue, 5)
+	for i := range rows {
+		rows[i] = model.PointValue{ServerID: "srv", DeviceID: "dev", Name: "counter", Value: float64(i), Timestamp: now}
+	}
+
+	// the fourth row fails, in the second INSERT statement
+	if err := db.InsertPointValuesBatch(context.Background(), d.ORM, rows, 2); err == nil {
+		t.Fatalf("expected the batch to fail")
+	}
+	var n int64
+	if err := d.ORM.Model(&model.PointValue{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected the failed batch to be rolled back, found %d rows", n)
+	}
+
+	if err := db.InsertPointValuesBatch(context.Background(), d.ORM, append(rows[:3], rows[4]), 2); err != nil {
+		t.Fatalf("InsertPointValuesBatch failed: %v", err)
+	}
+	if err := d.ORM.Model(&model.PointValue{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("expected 4 rows, found %d", n)
+	}
+}
+
+func TestManagerRunKeepsOnValue(t *testing.T) {
+	t.Parallel()
+	var cfg collector.RootConfig
+	cfg.System.Storage.DBPath = filepath.Join(t.TempDir(), "data.sqlite")
+	cfg.System.Storage.LatestSnapshot.Enabled = true
+
+	onValue := func(collector.PointValue) error { return nil }
+	m := &collector.Manager{Cfg: cfg, OnValue: onValue}
+	want := reflect.ValueOf(onValue).Pointer()
+	// a restarted manager must not wrap the latest snapshot job around itself
+	for run := 1; run <= 2; run++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := m.Run(ctx); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if got := reflect.ValueOf(m.OnValue).Pointer(); got != want {
+			t.Fatalf("run %d replaced OnValue", run)
+		}
+	}
+}
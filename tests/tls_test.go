@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mb "github.com/goburrow/modbus"
+	collector "modbus-simulator/internal/collector"
+	"modbus-simulator/internal/conformance"
+	"modbus-simulator/internal/modbus"
+	"modbus-simulator/internal/repl"
+	"modbus-simulator/internal/servermgr"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // PEM of cert
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue writes a certificate and key named name to dir: a server
+// certificate for 127.0.0.1 when server is set, else a client certificate
+// holding role unless it is "".
+func (ca *testCA) issue(t *testing.T, dir, name string, server bool, role string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: name},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		tmpl.DNSNames = []string{"localhost"}
+	}
+	if role != "" {
+		value, err := asn1.MarshalWithParams(role, "utf8")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: modbus.RoleOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, kind string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTLSRoles(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", true, "")
+	server := collector.ServerConfig{
+		ServerID: "secure",
+		Protocol: "modbus-tls",
+		Enabled:  true,
+		Connection: collector.Connection{Host: "127.0.0.1", Port: freePort(t), TLS: &collector.TLSConfig{
+			CertFile: serverCert, KeyFile: serverKey, CAFile: ca.file,
+			Roles: map[string]collector.RoleConfig{
+				"operator": {Functions: []string{"read", "write"}, Write: []string{"0-9", "100"}},
+				"viewer":   {Functions: []string{"read"}, Read: []string{"0-49"}},
+			},
+		}},
+		Devices: []collector.Device{{DeviceID: "d", SlaveID: 1, Points: []collector.Point{
+			{Name: "p", RegisterType: "holding", Address: 0, DataType: "uint16"},
+		}}},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mgr := servermgr.NewManager(collector.RootConfig{Servers: []collector.ServerConfig{server}})
+	go mgr.Run(ctx)
+	select {
+	case <-mgr.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("simulator did not start")
+	}
+
+	// client connects as the collector would, with a certificate of role
+	client := func(t *testing.T, ca *testCA, role string) *collector.Link {
+		t.Helper()
+		cert, key := ca.issue(t, dir, fmt.Sprintf("client-%s-%d", role, time.Now().UnixNano()), false, role)
+		target := collector.ServerConfig{Protocol: "modbus-tls", Timeout: time.Second, Connection: collector.Connection{
+			Host: "127.0.0.1", Port: server.Connection.Port,
+			TLS: &collector.TLSConfig{CertFile: cert, KeyFile: key, CAFile: ca.file}}}
+		link, err := collector.OpenLink(target, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { link.Close() })
+		return link
+	}
+	exception := func(err error) byte {
+		var me *mb.ModbusError
+		if errors.As(err, &me) {
+			return me.ExceptionCode
+		}
+		return 0
+	}
+
+	operator := client(t, ca, "operator").Client(1)
+	if _, err := operator.WriteSingleRegister(5, 42); err != nil {
+		t.Fatalf("operator write: %v", err)
+	}
+	if _, err := operator.WriteMultipleRegisters(8, 2, []byte{0, 1, 0, 2}); err != nil {
+		t.Fatalf("operator write 8-9: %v", err)
+	}
+	if got, err := operator.ReadHoldingRegisters(5, 1); err != nil || got[1] != 42 {
+		t.Fatalf("operator read % X, %v", got, err)
+	}
+	if _, err := operator.WriteMultipleRegisters(9, 2, []byte{0, 1, 0, 2}); exception(err) != 0x01 {
+		t.Fatalf("operator write across its range: %v", err)
+	}
+	if _, err := operator.WriteSingleCoil(100, 0xFF00); err != nil {
+		t.Fatalf("operator write coil 100: %v", err)
+	}
+
+	viewer := client(t, ca, "viewer").Client(1)
+	if _, err := viewer.ReadInputRegisters(0, 50); err != nil {
+		t.Fatalf("viewer read: %v", err)
+	}
+	if _, err := viewer.ReadInputRegisters(40, 20); exception(err) != 0x01 {
+		t.Fatalf("viewer read beyond its range: %v", err)
+	}
+	if _, err := viewer.WriteSingleRegister(5, 1); exception(err) != 0x01 {
+		t.Fatalf("viewer write: %v", err)
+	}
+
+	// without a known role every request is refused
+	for _, role := range []string{"", "guest"} {
+		if _, err := client(t, ca, role).Client(1).ReadHoldingRegisters(0, 1); exception(err) != 0x01 {
+			t.Fatalf("role %q read: %v", role, err)
+		}
+	}
+
+	// certificates of another CA, or none, fail the handshake
+	other := newTestCA(t, dir, "other")
+	foreign := collector.ServerConfig{Protocol: "modbus-tls", Timeout: time.Second, Connection: collector.Connection{
+		Host: "127.0.0.1", Port: server.Connection.Port, TLS: &collector.TLSConfig{CAFile: ca.file}}}
+	foreign.Connection.TLS.CertFile, foreign.Connection.TLS.KeyFile = other.issue(t, dir, "foreign", false, "operator")
+	for name, cfg := range map[string]*collector.TLSConfig{"foreign": foreign.Connection.TLS, "none": {CAFile: ca.file}} {
+		foreign.Connection.TLS = cfg
+		link, err := collector.OpenLink(foreign, nil)
+		if err == nil {
+			_, err = link.Client(1).ReadHoldingRegisters(0, 1)
+			link.Close()
+		}
+		if err == nil || exception(err) != 0 {
+			t.Fatalf("%s certificate: %v", name, err)
+		}
+	}
+
+	// TLS 1.1 is refused
+	clientCert, clientKey := ca.issue(t, dir, "old", false, "operator")
+	pair, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", server.Connection.Port), &tls.Config{
+		RootCAs: pool, Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS10, MaxVersion: tls.VersionTLS11})
+	if err == nil {
+		conn.Close()
+		t.Fatal("TLS 1.1 handshake succeeded")
+	}
+}
+
+func TestTLSConformance(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, dir, "server", true, "")
+	clientCert, clientKey := ca.issue(t, dir, "client", false, "operator")
+
+	cfg, err := (&collector.TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: ca.file}).ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := modbus.NewServer()
+	port := freePort(t)
+	if err := s.ListenTLS(fmt.Sprintf("127.0.0.1:%d", port), cfg, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	target, err := repl.ParseTarget([]string{fmt.Sprintf("tls://localhost:%d?cert=%s&key=%s&ca=%s", port, clientCert, clientKey, ca.file)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target.Protocol != "modbus-tls" || target.Connection.TLS == nil || target.Connection.TLS.KeyFile != clientKey {
+		t.Fatalf("target %+v", target)
+	}
+	r := conformance.Test(t, target, conformance.Options{Address: 100, Timeout: 300 * time.Millisecond})
+	if r.Passed != len(conformance.Catalog)-1 {
+		t.Errorf("%d passed of %d", r.Passed, len(conformance.Catalog))
+	}
+}